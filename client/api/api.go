@@ -0,0 +1,160 @@
+// Package api is a local JSON API to control a peer, for bots and tests.
+//
+// Every path is under /api/v1. Requests and responses are JSON, errors are
+// {"error": "..."} with a 4xx or 5xx status. Requests with a body must have
+// an "application/json" content type. If the peer is started with an api
+// token, requests must have an "Authorization: Bearer <token>" header,
+// otherwise only clients on the loopback interface are served.
+//
+//	GET  /api/v1/self                 the peer itself
+//	GET  /api/v1/peers                known peers with their statuses
+//	GET  /api/v1/groups               groups self is a member of
+//	POST /api/v1/messages             send a text: {"to": peer id, "text": "..."},
+//	                                  {"group": group id, "text": "..."} or
+//	                                  {"reply_to": message id, "text": "..."}
+//	GET  /api/v1/history?chat=<peer or group id>&before=<message id>&limit=<n>
+//	                                  messages of a chat from the oldest to the newest
+//	GET  /api/v1/settings             settings of the peer
+//	PUT  /api/v1/settings             change settings: {"presence": "active|idle|dnd"}
+//	GET  /api/v1/events               server-sent events, see Event types
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/ngalayko/p2p/instance"
+	"github.com/ngalayko/p2p/logger"
+)
+
+// Prefix is a path prefix of the current version of the api.
+const Prefix = "/api/v1/"
+
+// maxBodySize limits request bodies.
+const maxBodySize = 1 << 20
+
+// API serves the local api.
+type API struct {
+	log      *logger.Logger
+	instance *instance.Instance
+	token    string
+	routes   map[string]http.HandlerFunc
+}
+
+// New is an api constructor. If token is not empty, requests must present it,
+// otherwise only local clients are served.
+func New(
+	log *logger.Logger,
+	instance *instance.Instance,
+	token string,
+) *API {
+	a := &API{
+		log:      log.Prefix("api"),
+		instance: instance,
+		token:    token,
+	}
+	a.routes = map[string]http.HandlerFunc{
+		"self":     a.self,
+		"peers":    a.peers,
+		"groups":   a.groups,
+		"messages": a.messages,
+		"history":  a.history,
+		"settings": a.settings,
+		"events":   a.events,
+	}
+	return a
+}
+
+// ServeHTTP implements http.Handler.
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := a.authorize(r); err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+
+	// a browser can't send json to another origin without a preflight, so
+	// pages can't make requests on behalf of the user.
+	if r.ContentLength != 0 && !isJSON(r) {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("content type must be application/json"))
+		return
+	}
+
+	route, ok := a.routes[strings.TrimPrefix(r.URL.Path, Prefix)]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path %s", r.URL.Path))
+		return
+	}
+
+	route(w, r)
+}
+
+func (a *API) authorize(r *http.Request) error {
+	if a.token == "" {
+		if !isLoopback(r.RemoteAddr) {
+			return fmt.Errorf("a token is required for remote clients")
+		}
+		return nil
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		return fmt.Errorf("invalid token")
+	}
+	return nil
+}
+
+// isLoopback returns true if the address is on the loopback interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isJSON returns true if the request body is json.
+func isJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// allow writes an error and returns false if the request method is not allowed.
+func allow(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	return false
+}
+
+func readJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodySize)).Decode(v); err != nil {
+		return fmt.Errorf("invalid json: %s", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &apiError{Error: err.Error()})
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
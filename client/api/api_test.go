@@ -0,0 +1,232 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ngalayko/p2p/instance"
+	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/logger"
+)
+
+func Test_API__should_require_a_token(t *testing.T) {
+	a := testAPI(t, "secret")
+
+	for header, status := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer other":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		req := testRequest(http.MethodGet, Prefix+"self", "")
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("Authorization", header)
+
+		assert.Equal(t, status, serve(a, req).Code, header)
+	}
+}
+
+func Test_API__should_serve_only_local_clients_without_a_token(t *testing.T) {
+	a := testAPI(t, "")
+
+	for addr, status := range map[string]int{
+		"192.0.2.1:1234": http.StatusUnauthorized,
+		"127.0.0.1:1234": http.StatusOK,
+		"[::1]:1234":     http.StatusOK,
+	} {
+		req := testRequest(http.MethodGet, Prefix+"self", "")
+		req.RemoteAddr = addr
+
+		assert.Equal(t, status, serve(a, req).Code, addr)
+	}
+}
+
+func Test_API__should_reject_bodies_that_are_not_json(t *testing.T) {
+	a := testAPI(t, "")
+
+	req := testRequest(http.MethodPost, Prefix+"messages", `{"to":"`+a.instance.Peer.ID+`","text":"hello"}`)
+	req.Header.Set("Content-Type", "text/plain")
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve(a, req).Code)
+}
+
+func Test_API__should_validate_messages(t *testing.T) {
+	a := testAPI(t, "")
+
+	for _, body := range []string{
+		`not json`,
+		`{"to":"` + a.instance.Peer.ID + `"}`,
+		`{"text":"hello"}`,
+		`{"to":"unknown","text":"hello"}`,
+		`{"reply_to":"unknown","text":"hello"}`,
+	} {
+		w := serve(a, testRequest(http.MethodPost, Prefix+"messages", body))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+		assert.Contains(t, w.Body.String(), `"error"`, body)
+	}
+
+	w := serve(a, testRequest(http.MethodGet, Prefix+"messages", ""))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, http.MethodPost, w.Header().Get("Allow"))
+}
+
+func Test_API__should_respond_with_a_sent_message(t *testing.T) {
+	a := testAPI(t, "")
+
+	w := serve(a, testRequest(http.MethodPost, Prefix+"messages", `{"to":"`+a.instance.Peer.ID+`","text":"hello"}`))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	sent := &Message{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(sent))
+	assert.NotEmpty(t, sent.ID)
+	assert.Equal(t, "hello", sent.Text)
+	assert.Equal(t, a.instance.Peer.ID, sent.To.ID)
+
+	w = serve(a, testRequest(http.MethodPost, Prefix+"messages", `{"reply_to":"`+sent.ID+`","text":"again"}`))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	reply := &Message{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(reply))
+	assert.NotEqual(t, sent.ID, reply.ID)
+	if assert.NotNil(t, reply.ReplyTo) {
+		assert.Equal(t, sent.ID, reply.ReplyTo.MessageID)
+	}
+}
+
+func Test_API__should_return_history(t *testing.T) {
+	a := testAPI(t, "")
+
+	ids := []string{}
+	for _, text := range []string{"first", "second", "third"} {
+		m, err := a.instance.SendText(context.Background(), text, a.instance.Peer.ID)
+		assert.NoError(t, err)
+		ids = append(ids, m.ID)
+	}
+
+	history := []*Message{}
+	w := serve(a, testRequest(http.MethodGet, Prefix+"history?chat="+a.instance.Peer.ID+"&limit=2", ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, ids[1], history[0].ID)
+		assert.Equal(t, ids[2], history[1].ID)
+	}
+
+	w = serve(a, testRequest(http.MethodGet, Prefix+"history?chat="+a.instance.Peer.ID+"&before="+ids[1], ""))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, ids[0], history[0].ID)
+	}
+
+	for _, query := range []string{"", "?chat=" + a.instance.Peer.ID + "&limit=0", "?chat=" + a.instance.Peer.ID + "&limit=x"} {
+		w := serve(a, testRequest(http.MethodGet, Prefix+"history"+query, ""))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func Test_API__should_stream_events(t *testing.T) {
+	a := testAPI(t, "")
+
+	srv := httptest.NewServer(a)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+Prefix+"events", nil)
+	assert.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	sent, err := a.instance.SendText(ctx, "hello", a.instance.Peer.ID)
+	assert.NoError(t, err)
+
+	data, err := readEvent(resp.Body, EventMessageSent)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	m := &Message{}
+	assert.NoError(t, json.Unmarshal([]byte(data), m))
+	assert.Equal(t, sent.ID, m.ID)
+	assert.Equal(t, "hello", m.Text)
+}
+
+//
+// helpers
+//
+
+func testAPI(t *testing.T, token string) *API {
+	inst := instance.New(
+		logger.New(logger.LevelDebug),
+		"",
+		"",
+		"",
+		"",
+		nil,
+		time.Minute,
+		"0",
+		0,
+		0,
+		0,
+		time.Second,
+		1024,
+		"",
+		peers.Timeouts{
+			Away:    time.Minute,
+			Offline: time.Minute,
+			Remove:  time.Minute,
+		},
+	)
+	return New(logger.New(logger.LevelDebug), inst, token)
+}
+
+// testRequest makes a request from a local client.
+func testRequest(method string, path string, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.RemoteAddr = "127.0.0.1:1234"
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req
+}
+
+func serve(a *API, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, req)
+	return w
+}
+
+// readEvent returns data of the first event of the type in the stream.
+func readEvent(r io.Reader, t EventType) (string, error) {
+	scanner := bufio.NewScanner(r)
+
+	eventType := ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			eventType = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: ") && eventType == string(t):
+			return strings.TrimPrefix(line, "data: "), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", io.EOF
+}
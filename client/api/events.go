@@ -0,0 +1,171 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/ngalayko/p2p/instance/messages"
+	"github.com/ngalayko/p2p/instance/peers"
+)
+
+const (
+	eventsBuffer     = 256
+	peerEventsBuffer = 64
+
+	// heartbeatInterval keeps idle streams open through proxies.
+	heartbeatInterval = 30 * time.Second
+)
+
+// EventType is a type of a server-sent event.
+type EventType string
+
+// Event types, data of every event is a json object.
+const (
+	// EventMessageReceived and EventMessageSent data is a Message.
+	EventMessageReceived EventType = "message_received"
+	EventMessageSent     EventType = "message_sent"
+	// EventMessageUpdated data is an edited, deleted or reacted Message.
+	EventMessageUpdated EventType = "message_updated"
+	// EventMessageStatus data is a messages.StatusUpdate.
+	EventMessageStatus EventType = "message_status"
+	// EventGroupUpdated data is a Group.
+	EventGroupUpdated EventType = "group_updated"
+	// EventFileProgress data is a messages.FileProgress.
+	EventFileProgress EventType = "file_progress"
+	// EventKeyChanged data is a messages.Key.
+	EventKeyChanged EventType = "key_changed"
+	// EventPeer data is a PeerStatus, it is sent when a peer is discovered
+	// or any of its statuses changes.
+	EventPeer EventType = "peer"
+	// EventPeerRemoved data is a Peer.
+	EventPeerRemoved EventType = "peer_removed"
+	// EventTyping data is a Typing.
+	EventTyping EventType = "typing"
+)
+
+// Typing is sent when a peer starts or stops typing.
+type Typing struct {
+	PeerID  string `json:"peer_id"`
+	GroupID string `json:"group_id,omitempty"`
+	Typing  bool   `json:"typing"`
+}
+
+// events streams events as server-sent events until the client disconnects.
+// The stream ends if the client is too slow, it should reconnect and load the
+// current state again.
+func (a *API) events(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+
+	peerEvents := a.instance.KnownPeers.Subscribe(peerEventsBuffer)
+	defer peerEvents.Close()
+
+	events := a.instance.Subscribe(eventsBuffer)
+	defer events.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case e, ok := <-peerEvents.Events():
+			if !ok {
+				a.log.Error("events client is too slow, closing the stream")
+				return
+			}
+			err = a.writePeerEvent(w, e)
+		case e, ok := <-events.Events():
+			if !ok {
+				a.log.Error("events client is too slow, closing the stream")
+				return
+			}
+			err = a.writeEvent(w, e)
+		}
+		if err != nil {
+			a.log.Error("can't write an event: %s", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func (a *API) writeEvent(w http.ResponseWriter, e messages.Event) error {
+	switch e := e.(type) {
+	case *messages.MessageReceived:
+		return writeEvent(w, EventMessageReceived, newMessage(e.Message))
+	case *messages.MessageSent:
+		return writeEvent(w, EventMessageSent, newMessage(e.Message))
+	case *messages.MessageUpdated:
+		return writeEvent(w, EventMessageUpdated, newMessage(e.Message))
+	case *messages.StatusUpdate:
+		return writeEvent(w, EventMessageStatus, e)
+	case *messages.GroupUpdated:
+		return writeEvent(w, EventGroupUpdated, newGroup(e.Group))
+	case *messages.FileProgress:
+		return writeEvent(w, EventFileProgress, e)
+	case *messages.KeyChanged:
+		return writeEvent(w, EventKeyChanged, e.Key)
+	case *messages.TypingChanged:
+		return writeEvent(w, EventTyping, &Typing{
+			PeerID:  e.PeerID,
+			GroupID: e.GroupID,
+			Typing:  e.Typing,
+		})
+	case *messages.ConnectionChanged:
+		return a.writePeerStatus(w, e.PeerID)
+	case *messages.PresenceChanged:
+		return a.writePeerStatus(w, e.PeerID)
+	}
+	return nil
+}
+
+func (a *API) writePeerEvent(w http.ResponseWriter, e peers.Event) error {
+	switch e := e.(type) {
+	case *peers.PeerAdded:
+		return a.writePeerStatus(w, e.Peer.ID)
+	case *peers.PeerChanged:
+		return a.writePeerStatus(w, e.Peer.ID)
+	case *peers.PeerRemoved:
+		return writeEvent(w, EventPeerRemoved, newPeer(e.Peer))
+	}
+	return nil
+}
+
+// writePeerStatus writes the current statuses of the peer.
+func (a *API) writePeerStatus(w http.ResponseWriter, peerID string) error {
+	p, ok := a.peerStatus(peerID)
+	if !ok {
+		return nil
+	}
+
+	return writeEvent(w, EventPeer, p)
+}
+
+func writeEvent(w http.ResponseWriter, t EventType, data interface{}) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("can't marshal %s: %s", t, err)
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", t, jsonData)
+	return err
+}
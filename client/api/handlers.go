@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/ngalayko/p2p/instance/messages"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+func (a *API) self(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newPeer(a.instance.Peer))
+}
+
+func (a *API) peers(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	pp := make([]*PeerStatus, 0)
+	for id := range a.instance.KnownPeers.Map() {
+		if p, ok := a.peerStatus(id); ok {
+			pp = append(pp, p)
+		}
+	}
+	sort.Slice(pp, func(i, j int) bool {
+		return pp[i].ID < pp[j].ID
+	})
+
+	writeJSON(w, http.StatusOK, pp)
+}
+
+// peerStatus returns the current statuses of a known peer.
+func (a *API) peerStatus(peerID string) (*PeerStatus, bool) {
+	p, ok := a.instance.KnownPeers.Map()[peerID]
+	if !ok {
+		return nil, false
+	}
+
+	status, lastSeen, ok := a.instance.KnownPeers.Status(peerID)
+	if !ok {
+		return nil, false
+	}
+
+	return &PeerStatus{
+		Peer:      *newPeer(p),
+		Status:    status,
+		LastSeen:  lastSeen,
+		Connected: a.instance.Connected(peerID),
+		Presence:  a.instance.Presence(peerID),
+	}, true
+}
+
+func (a *API) groups(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	gg := make([]*Group, 0)
+	for _, g := range a.instance.Groups() {
+		gg = append(gg, newGroup(g))
+	}
+	sort.Slice(gg, func(i, j int) bool {
+		return gg[i].ID < gg[j].ID
+	})
+
+	writeJSON(w, http.StatusOK, gg)
+}
+
+// messages sends a text and responds with the sent message.
+func (a *API) messages(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodPost) {
+		return
+	}
+
+	req := &SendRequest{}
+	if err := readJSON(r, req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if req.Text == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("text is empty"))
+		return
+	}
+
+	// streams to peers outlive the request, so they are not opened with its
+	// context.
+	var (
+		sent *messages.Message
+		err  error
+	)
+	switch {
+	case req.ReplyTo != "":
+		sent, err = a.instance.ReplyText(context.Background(), req.Text, req.ReplyTo)
+	case req.Group != "":
+		sent, err = a.instance.SendGroupText(context.Background(), req.Text, req.Group)
+	case req.To != "":
+		sent, err = a.instance.SendText(context.Background(), req.Text, req.To)
+	default:
+		err = fmt.Errorf("one of to, group or reply_to is required")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newMessage(sent))
+}
+
+func (a *API) history(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+
+	query := r.URL.Query()
+
+	chatID := query.Get("chat")
+	if chatID == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("chat is required"))
+		return
+	}
+
+	limit := defaultHistoryLimit
+	if l := query.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 || limit > maxHistoryLimit {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("limit must be from 1 to %d", maxHistoryLimit))
+			return
+		}
+	}
+
+	mm, err := a.instance.History(chatID, query.Get("before"), limit)
+	if err != nil {
+		a.log.Error("can't get history: %s", err)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	history := make([]*Message, 0, len(mm))
+	for _, m := range mm {
+		history = append(history, newMessage(m))
+	}
+
+	writeJSON(w, http.StatusOK, history)
+}
+
+func (a *API) settings(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet, http.MethodPut) {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		s := &Settings{}
+		if err := readJSON(r, s); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		if s.Presence != "" {
+			if err := a.instance.SetPresence(context.Background(), s.Presence); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+	}
+
+	writeJSON(w, http.StatusOK, &Settings{
+		Presence: a.instance.Presence(a.instance.Peer.ID),
+	})
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"time"
+
+	"github.com/ngalayko/p2p/instance/messages"
+	"github.com/ngalayko/p2p/instance/peers"
+)
+
+// Peer is a peer.
+type Peer struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// PeerStatus is a known peer with its statuses.
+type PeerStatus struct {
+	Peer
+	// Status is a liveness status: online, away or offline.
+	Status   peers.Status `json:"status"`
+	LastSeen time.Time    `json:"last_seen"`
+	// Connected is true if there is a live connection with the peer.
+	Connected bool `json:"connected"`
+	// Presence is a status the peer set: active, idle or dnd.
+	Presence messages.Presence `json:"presence"`
+}
+
+// Group is a group chat.
+type Group struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	Members []*Peer `json:"members"`
+}
+
+// Message is a sent or received message.
+type Message struct {
+	ID        string          `json:"id"`
+	From      *Peer           `json:"from"`
+	To        *Peer           `json:"to,omitempty"`
+	GroupID   string          `json:"group_id,omitempty"`
+	Timestamp time.Time       `json:"timestamp"`
+	Type      messages.Type   `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	Status    messages.Status `json:"status,omitempty"`
+	File      *messages.File  `json:"file,omitempty"`
+
+	ReplyTo   *messages.ReplyTo    `json:"reply_to,omitempty"`
+	EditedAt  *time.Time           `json:"edited_at,omitempty"`
+	Deleted   bool                 `json:"deleted,omitempty"`
+	Reactions []*messages.Reaction `json:"reactions,omitempty"`
+}
+
+// SendRequest is a text to send to a peer, a group, or as a reply to a
+// message in the same chat.
+type SendRequest struct {
+	To      string `json:"to,omitempty"`
+	Group   string `json:"group,omitempty"`
+	ReplyTo string `json:"reply_to,omitempty"`
+	Text    string `json:"text"`
+}
+
+// Settings are settings of the peer.
+type Settings struct {
+	Presence messages.Presence `json:"presence"`
+}
+
+func newPeer(p *peers.Peer) *Peer {
+	if p == nil {
+		return nil
+	}
+	return &Peer{
+		ID:   p.ID,
+		Name: p.Name,
+	}
+}
+
+func newGroup(g *messages.Group) *Group {
+	members := make([]*Peer, 0, len(g.Members))
+	for _, m := range g.Members {
+		members = append(members, &Peer{
+			ID:   m.ID,
+			Name: m.Name,
+		})
+	}
+	return &Group{
+		ID:      g.ID,
+		Name:    g.Name,
+		Members: members,
+	}
+}
+
+func newMessage(m *messages.Message) *Message {
+	msg := &Message{
+		ID:        m.ID,
+		From:      newPeer(m.From),
+		To:        newPeer(m.To),
+		Timestamp: m.Timestamp,
+		Type:      m.Type,
+		Text:      m.Text,
+		Status:    m.Status,
+		File:      m.File,
+		ReplyTo:   m.ReplyTo,
+		EditedAt:  m.EditedAt,
+		Deleted:   m.Deleted,
+		Reactions: m.Reactions,
+	}
+	if m.Group != nil {
+		msg.GroupID = m.Group.ID
+		msg.To = nil
+	}
+	return msg
+}
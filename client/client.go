@@ -4,6 +4,8 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/ngalayko/p2p/client/api"
+	"github.com/ngalayko/p2p/client/webhooks"
 	"github.com/ngalayko/p2p/client/ws"
 	"github.com/ngalayko/p2p/instance"
 	"github.com/ngalayko/p2p/logger"
@@ -14,6 +16,8 @@ type UI struct {
 	logger   *logger.Logger
 	server   *http.Server
 	ws       *ws.WebSocket
+	api      *api.API
+	webhooks *webhooks.Webhooks
 	instance *instance.Instance
 }
 
@@ -23,6 +27,10 @@ func New(
 	addr string,
 	instance *instance.Instance,
 	staticPath string,
+	apiToken string,
+	webhookURLs []string,
+	webhookSecret string,
+	botSecret string,
 ) *UI {
 	log = log.Prefix("ui")
 
@@ -32,6 +40,8 @@ func New(
 			Addr: addr,
 		},
 		ws:       ws.New(log, instance),
+		api:      api.New(log, instance, apiToken),
+		webhooks: webhooks.New(log, instance, webhookURLs, webhookSecret, botSecret),
 		instance: instance,
 	}
 	u.server.Handler = u.handler(staticPath)
@@ -43,6 +53,10 @@ func (u *UI) handler(staticPath string) http.Handler {
 	m.Handle("/", http.FileServer(http.Dir(staticPath)))
 	m.Handle("/healthcheck", healthcheckHandler(u.instance.Peer))
 	m.Handle("/ws", u.ws)
+	m.Handle(api.Prefix, u.api)
+	m.Handle(webhooks.Path, u.webhooks)
+	m.Handle("/files", filesHandler(u.logger, u.instance))
+	m.Handle("/files/", filesHandler(u.logger, u.instance))
 	return m
 }
 
@@ -56,6 +70,12 @@ func (u *UI) Start(ctx context.Context) error {
 		}
 	}()
 
+	go func() {
+		if err := u.webhooks.Start(ctx); err != nil {
+			u.logger.Error("error posting webhooks: %s", err)
+		}
+	}()
+
 	<-ctx.Done()
 
 	u.server.Shutdown(ctx)
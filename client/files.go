@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/ngalayko/p2p/instance"
+	"github.com/ngalayko/p2p/logger"
+)
+
+const maxMemory = 10 << 20
+
+// inlineTypes are shown in the browser, everything else is downloaded.
+var inlineTypes = map[string]bool{
+	"image/gif":  true,
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// filesHandler sends uploaded files to peers and serves sent and received files.
+//
+// POST /files with a multipart form of "to" peer id and "file" sends a file.
+// GET /files/{message id} downloads a file.
+func filesHandler(log *logger.Logger, i *instance.Instance) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			upload(log, i, w, r)
+		case http.MethodGet:
+			download(log, i, w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func upload(log *logger.Logger, i *instance.Instance, w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(maxMemory); err != nil {
+		http.Error(w, fmt.Sprintf("invalid form: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	f, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid file: %s", err), http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+
+	if err := i.SendFile(context.Background(), r.FormValue("to"), header.Filename, header.Header.Get("Content-Type"), f); err != nil {
+		log.Error("can't send file: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func download(log *logger.Logger, i *instance.Instance, w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/files/")
+
+	file, f, err := i.OpenFile(id)
+	if err != nil {
+		log.Error("can't open file: %s", err)
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		log.Error("can't stat file: %s", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// files come from other peers, so only known image types are shown
+	// inline, everything else is downloaded.
+	disposition := "attachment"
+	contentType := "application/octet-stream"
+	if inlineTypes[file.MIME] {
+		disposition = "inline"
+		contentType = file.MIME
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{
+		"filename": file.Name,
+	}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	http.ServeContent(w, r, "", stat.ModTime(), f)
+}
@@ -1,3 +1,18 @@
+var connection
+
+// typingTimeout is how long the user is typing after the last input.
+const typingTimeout = 3000
+// peerTypingTimeout hides the indicator of a peer that stopped typing without
+// telling about it.
+const peerTypingTimeout = 10000
+
+// replyTo is the message the user replies to.
+var replyTo = null
+
+// typing is the contact the user is typing to.
+var typing = null
+var typingTimer
+
 function handleMessage(conn, msg) {
   console.log('received', msg)
 
@@ -14,7 +29,7 @@ function handleMessage(conn, msg) {
       known['peer-'+msg.peer.id] = true
 
       document.querySelectorAll('.peer').forEach(e => {
-        if (known[e.id]) {
+        if (known[e.id] || e.peer.group) {
           return
         }
 
@@ -25,14 +40,64 @@ function handleMessage(conn, msg) {
     case 'peer_added':
       addPeer(msg.peer, false)
       return
+    case 'peer_status':
+      updatePresence(msg.peer, msg.presence)
+      return
+    case 'peer_removed':
+      removePeer(msg.peer)
+      return
+    case 'peer_connected':
+    case 'peer_disconnected':
+      updateConnection(msg.peer, msg.type === 'peer_connected')
+      return
+    case 'typing':
+      updateTyping(msg.group ? msg.group : msg.peer, msg.typing)
+      return
+    case 'presence':
+      updatePresenceStatus(msg.peer, msg.presence_status)
+      return
     case 'text_sent':
-      addPeer(msg.message.to, false)
+      if (!msg.message.group) {
+        addPeer(msg.message.to, false)
+      }
       addMessage(msg.message)
       return
     case 'text_received':
       addPeer(msg.message.from, false)
       addMessage(msg.message)
       return
+    case 'file_sent':
+      addPeer(msg.message.to, false)
+      addMessage(msg.message)
+      return
+    case 'file_received':
+      addPeer(msg.message.from, false)
+      removeProgress(msg.message.id)
+      addMessage(msg.message)
+      return
+    case 'file_progress':
+      updateProgress(msg.progress)
+      return
+    case 'key_info':
+      updateKey(msg.key)
+      return
+    case 'key_changed':
+      keyChanged(msg.key)
+      return
+    case 'group_updated':
+      updateGroup(msg.group)
+      return
+    case 'message_updated':
+      updateMessage(msg.message)
+      return
+    case 'message_status':
+    case 'message_delivered':
+    case 'message_read':
+      updateStatus(msg.status.message_id, msg.status.status)
+      return
+    case 'history':
+      addHistory(msg.group ? groupContact(msg.group) : msg.peer, msg.messages || [])
+      return
     default:
       console.error('unknown message type', msg.type)
   }
@@ -51,13 +116,138 @@ function sendMessage(conn) {
     type: 'text_sent',
     message: {
       text: msg.value,
-      to: recipient.peer,
     },
   }
 
+  if (recipient.peer.group) {
+    message.message.group = {id: recipient.peer.id}
+  } else {
+    message.message.to = recipient.peer
+  }
+
+  if (replyTo !== null) {
+    message.message.reply_to = {message_id: replyTo.id}
+  }
+
   conn.send(JSON.stringify(message))
 
   msg.value = ''
+  stopTyping()
+  cancelReply()
+}
+
+// replyToMessage makes the next sent message a reply to the message.
+function replyToMessage(msg) {
+  replyTo = msg
+  document.getElementById('reply-to-quote').innerText = msg.type === 'file' ? msg.file.name : msg.text
+  document.getElementById('reply-to').classList.remove('d-none')
+  document.getElementById('message').focus()
+}
+
+function cancelReply() {
+  replyTo = null
+  document.getElementById('reply-to').classList.add('d-none')
+}
+
+// jumpToMessage scrolls to the message and highlights it for a moment.
+function jumpToMessage(messageID) {
+  var message = document.getElementById('message-'+messageID)
+  if (message === null) {
+    alert('The message is not loaded, scroll up to load older messages')
+    return
+  }
+
+  message.scrollIntoView({block: 'center'})
+  message.classList.add('highlight')
+  setTimeout(function() {
+    message.classList.remove('highlight')
+  }, 2000)
+}
+
+// startTyping tells the active contact that the user is typing, until there is
+// no input for a while.
+function startTyping() {
+  var recipient = document.querySelector('.peer.active')
+  if (recipient === null || recipient.peer.self) {
+    return
+  }
+
+  if (typing !== recipient.peer) {
+    stopTyping()
+    typing = recipient.peer
+    sendTyping(typing, true)
+  }
+
+  clearTimeout(typingTimer)
+  typingTimer = setTimeout(stopTyping, typingTimeout)
+}
+
+function stopTyping() {
+  clearTimeout(typingTimer)
+  if (typing === null) {
+    return
+  }
+
+  sendTyping(typing, false)
+  typing = null
+}
+
+function sendTyping(peer, active) {
+  if (connection === undefined || connection.readyState !== WebSocket.OPEN) {
+    return
+  }
+
+  var message = {
+    type: 'typing',
+    typing: active,
+  }
+
+  if (peer.group) {
+    message.group = {id: peer.id}
+  } else {
+    message.peer = {id: peer.id}
+  }
+
+  connection.send(JSON.stringify(message))
+}
+
+// updateTyping shows that someone types in a chat with the contact.
+function updateTyping(contact, active) {
+  var entry = document.getElementById('peer-'+contact.id)
+  if (entry === null) {
+    return
+  }
+
+  clearTimeout(entry.typingTimer)
+  entry.classList.toggle('peer-typing', active)
+  if (active) {
+    entry.typingTimer = setTimeout(function() {
+      entry.classList.remove('peer-typing')
+    }, peerTypingTimeout)
+  }
+}
+
+// updatePresenceStatus shows the status a peer set, or selects the status of
+// the user.
+function updatePresenceStatus(peer, status) {
+  var entry = document.getElementById('peer-'+peer.id)
+  if (entry === null) {
+    return
+  }
+
+  entry.classList.remove('presence-active', 'presence-idle', 'presence-dnd')
+  entry.classList.add('presence-' + status)
+
+  if (entry.peer.self) {
+    document.getElementById('presence').value = status
+  }
+}
+
+function sendPresence() {
+  connection.send(JSON.stringify({
+    type: 'presence',
+    presence_status: document.getElementById('presence').value,
+  }))
 }
 
 function addPeer(peer, isSelf) {
@@ -73,12 +263,174 @@ function addPeer(peer, isSelf) {
   appendPeer(peer)
 }
 
+function updatePresence(peer, presence) {
+  addPeer(peer, false)
+
+  var entry = document.getElementById('peer-'+peer.id)
+  entry.classList.remove('peer-online', 'peer-away', 'peer-offline')
+  entry.classList.add('peer-' + presence.status)
+  entry.lastSeen = presence.last_seen
+  entry.title = presence.status + ', last seen ' + new Date(presence.last_seen).toLocaleString()
+}
+
+// updateConnection marks peers with a live connection.
+function updateConnection(peer, connected) {
+  var entry = document.getElementById('peer-'+peer.id)
+  if (entry === null) {
+    return
+  }
+
+  entry.classList.toggle('peer-connected', connected)
+}
+
+// removePeer removes a departed peer from contacts, unless its chat is open.
+function removePeer(peer) {
+  var entry = document.getElementById('peer-'+peer.id)
+  if (entry === null) {
+    return
+  }
+
+  if (entry.classList.contains('active')) {
+    updatePresence(peer, {status: 'offline', last_seen: entry.lastSeen})
+    return
+  }
+
+  entry.parentNode.removeChild(entry)
+}
+
+// groupContact returns a group as an entry of the contacts list.
+function groupContact(group) {
+  return {
+    id: group.id,
+    name: '# ' + group.name,
+    group: true,
+    members: group.members || [],
+  }
+}
+
+function updateGroup(group) {
+  var entry = document.getElementById('peer-'+group.id)
+
+  if (group.left) {
+    if (entry !== null) {
+      entry.parentNode.removeChild(entry)
+    }
+    var chat = document.getElementById('peer-chat-'+group.id)
+    if (chat !== null) {
+      chat.parentNode.removeChild(chat)
+    }
+    updateGroupControls()
+    return
+  }
+
+  if (entry === null) {
+    appendPeer(groupContact(group))
+    return
+  }
+
+  entry.peer.members = group.members || []
+  entry.title = entry.peer.members.map(m => m.name).join(', ')
+}
+
+function createGroup() {
+  var name = prompt('Group name')
+  if (!name) {
+    return
+  }
+
+  var members = askMembers()
+  if (members === null) {
+    return
+  }
+
+  connection.send(JSON.stringify({
+    type: 'group_create',
+    group: {
+      name: name,
+      members: members,
+    },
+  }))
+}
+
+function inviteToGroup() {
+  var group = document.querySelector('.peer.active')
+  if (group === null || !group.peer.group) {
+    return
+  }
+
+  var members = askMembers()
+  if (members === null) {
+    return
+  }
+
+  connection.send(JSON.stringify({
+    type: 'group_invite',
+    group: {
+      id: group.peer.id,
+      members: members,
+    },
+  }))
+}
+
+function leaveGroup() {
+  var group = document.querySelector('.peer.active')
+  if (group === null || !group.peer.group) {
+    return
+  }
+
+  if (!confirm('Leave ' + group.peer.name + '?')) {
+    return
+  }
+
+  connection.send(JSON.stringify({
+    type: 'group_leave',
+    group: {id: group.peer.id},
+  }))
+}
+
+// askMembers asks for comma separated names of known peers.
+function askMembers() {
+  var byName = {}
+  document.querySelectorAll('.peer').forEach(e => {
+    if (e.peer.self || e.peer.group) {
+      return
+    }
+    byName[e.peer.name] = e.peer
+  })
+
+  var names = prompt('Members, comma separated: ' + Object.keys(byName).join(', '))
+  if (names === null) {
+    return null
+  }
+
+  var members = []
+  names.split(',').forEach(name => {
+    var peer = byName[name.trim()]
+    if (peer !== undefined) {
+      members.push({id: peer.id})
+    }
+  })
+  return members
+}
+
+function updateGroupControls() {
+  var active = document.querySelector('.peer.active')
+  var isGroup = active !== null && active.peer.group === true
+
+  document.querySelectorAll('.group-control').forEach(e => {
+    e.classList.toggle('d-none', !isGroup)
+  })
+}
+
 function appendPeer(peer) {
   var peerEntry = document.createElement('li')
   peerEntry.className = 'list-group-item d-flex justify-content-between align-items-center peer'
   peerEntry.id = 'peer-' + peer.id
-  peerEntry.innerHTML = peer.name
+  peerEntry.innerText = peer.name
   peerEntry.peer = peer
+  if (peer.group) {
+    peerEntry.title = peer.members.map(m => m.name).join(', ')
+  }
   peerEntry.onclick = function() {
     selectPeer(peer)
   }
@@ -87,8 +439,15 @@ function appendPeer(peer) {
 }
 
 function selectPeer(peer) {
+  stopTyping()
+  cancelReply()
   selectPeerContact(peer)
   selectPeerChat(peer)
+
+  hideKey()
+  if (!peer.self && !peer.group) {
+    requestKey(peer)
+  }
 }
 
 function getPeerChat(peer) {
@@ -101,12 +460,56 @@ function getPeerChat(peer) {
   chat = document.createElement('div')
   chat.className = 'chat collapse' // d-flex flex-column flex-grow-1 justify-content-end collapse'
   chat.id = 'peer-chat-'+peer.id
+  chat.onscroll = function() {
+    if (chat.scrollTop !== 0 || chat.historyLoaded) {
+      return
+    }
+
+    var first = chat.querySelector('.message')
+    requestHistory(peer, first === null ? '' : first.id.replace('message-', ''))
+  }
 
   document.getElementById('chats').appendChild(chat)
 
+  requestHistory(peer, '')
+
   return chat
 }
 
+function requestHistory(peer, before) {
+  if (connection === undefined || connection.readyState !== WebSocket.OPEN) {
+    return
+  }
+
+  var request = {
+    type: 'history',
+    before: before,
+  }
+
+  if (peer.group) {
+    request.group = {id: peer.id}
+  } else {
+    request.peer = {id: peer.id}
+  }
+
+  connection.send(JSON.stringify(request))
+}
+
+function addHistory(peer, messages) {
+  var chat = getPeerChat(peer)
+
+  if (messages.length === 0) {
+    chat.historyLoaded = true
+    return
+  }
+
+  var height = chat.scrollHeight
+  for (var i = messages.length - 1; i >= 0; i--) {
+    addMessage(messages[i], true)
+  }
+  chat.scrollTop = chat.scrollHeight - height
+}
+
 function selectPeerChat(peer) {
   var chat = getPeerChat(peer)
 
@@ -117,33 +520,60 @@ function selectPeerChat(peer) {
   chat.classList.remove('collapse')
 }
 
-function addMessage(msg) {
+function addMessage(msg, fromHistory) {
   var message = document.getElementById('message-'+msg.id)
   if (message !== null) {
     return 
   }
 
-  var self
-  document.querySelectorAll('.peer').forEach(e => {
-    if (e.peer.self) {
-      self = e.peer
-    }
-  })
-
+  var self = selfPeer()
 
   message = document.createElement('div')
   message.id = 'message-'+msg.id
-  message.className = 'm-2'
-  message.innerHTML = msg.text
+  message.className = 'm-2 message'
+
+  var text = document.createElement('span')
+  text.className = 'text'
+  message.appendChild(text)
+  fillMessage(message, msg)
+
+  // messages in a group chat are signed with the sender name.
+  if (msg.group && msg.from.id !== self.id) {
+    var from = document.createElement('small')
+    from.className = 'from text-muted mr-1'
+    from.innerText = msg.from.name + ':'
+    message.insertBefore(from, text)
+  }
 
   var chat
   if (msg.from.id !== self.id) {
+    var contact = msg.group ? groupContact(msg.group) : msg.from
     message.className += ' text-left'
-    chat = getPeerChat(msg.from)
-    updateUnread(msg.from, 1)
+    chat = getPeerChat(contact)
+    if (!fromHistory) {
+      message.message = msg
+      message.classList.add('unread')
+      updateUnread(contact, 1)
+      if (document.getElementById('peer-'+contact.id).classList.contains('active')) {
+        markRead(message)
+      }
+    }
   } else {
-    chat = getPeerChat(msg.to)
+    chat = getPeerChat(msg.group ? groupContact(msg.group) : msg.to)
     message.className += ' text-right'
+
+    var status = document.createElement('small')
+    status.className = 'status text-muted ml-1'
+    status.id = 'message-status-'+msg.id
+    status.innerText = statusMark(msg.status)
+    message.appendChild(status)
+  }
+
+  message.appendChild(messageActions(msg, msg.from.id === self.id))
+
+  if (fromHistory) {
+    chat.insertBefore(message, chat.firstChild)
+    return
   }
 
   chat.appendChild(message)
@@ -151,6 +581,374 @@ function addMessage(msg) {
   chat.scrollTop = chat.scrollHeight - chat.clientHeight
 }
 
+function peerName(peerID) {
+  var entry = document.getElementById('peer-'+peerID)
+  return entry === null ? peerID.substring(0, 8) : entry.peer.name
+}
+
+function selfPeer() {
+  var self
+  document.querySelectorAll('.peer').forEach(e => {
+    if (e.peer.self) {
+      self = e.peer
+    }
+  })
+  return self
+}
+
+// fillMessage shows the content of the message, with marks of changes and
+// reactions.
+function fillMessage(message, msg) {
+  message.msg = msg
+  message.classList.toggle('deleted', !!msg.deleted)
+
+  var text = message.querySelector('.text')
+  text.innerHTML = ''
+  if (msg.deleted) {
+    text.innerText = 'message deleted'
+  } else if (msg.type === 'file') {
+    text.appendChild(fileContent(msg))
+  } else {
+    text.innerText = msg.text
+  }
+
+  var quote = message.querySelector('.quote')
+  if (msg.reply_to && !msg.deleted) {
+    if (quote === null) {
+      quote = document.createElement('div')
+      quote.className = 'quote small text-muted'
+      quote.innerText = peerName(msg.reply_to.from_id) + ': ' + msg.reply_to.quote
+      quote.onclick = function() {
+        jumpToMessage(msg.reply_to.message_id)
+      }
+      message.insertBefore(quote, message.firstChild)
+    }
+  } else if (quote !== null) {
+    quote.parentNode.removeChild(quote)
+  }
+
+  var edited = message.querySelector('.edited')
+  if (msg.edited_at && !msg.deleted) {
+    if (edited === null) {
+      edited = document.createElement('small')
+      edited.className = 'edited text-muted ml-1'
+      edited.innerText = '(edited)'
+      text.parentNode.insertBefore(edited, text.nextSibling)
+    }
+    edited.title = new Date(msg.edited_at).toLocaleString()
+  } else if (edited !== null) {
+    edited.parentNode.removeChild(edited)
+  }
+
+  var reactions = message.querySelector('.reactions')
+  if (reactions === null) {
+    reactions = document.createElement('span')
+    reactions.className = 'reactions ml-1'
+    message.appendChild(reactions)
+  }
+  reactions.innerHTML = ''
+
+  var self = selfPeer()
+  var byEmoji = {}
+  var list = msg.reactions || []
+  list.forEach(r => {
+    if (byEmoji[r.emoji] === undefined) {
+      byEmoji[r.emoji] = {count: 0, own: false}
+    }
+    byEmoji[r.emoji].count++
+    byEmoji[r.emoji].own = byEmoji[r.emoji].own || r.peer_id === self.id
+  })
+
+  Object.keys(byEmoji).forEach(emoji => {
+    var reaction = document.createElement('span')
+    reaction.className = 'badge badge-light reaction mr-1'
+    reaction.classList.toggle('reaction-own', byEmoji[emoji].own)
+    reaction.innerText = emoji + ' ' + byEmoji[emoji].count
+    reaction.onclick = function() {
+      sendReaction(msg, emoji, byEmoji[emoji].own)
+    }
+    reactions.appendChild(reaction)
+  })
+}
+
+// updateMessage shows an edited, deleted or reacted message in place.
+function updateMessage(msg) {
+  var message = document.getElementById('message-'+msg.id)
+  if (message === null) {
+    return
+  }
+
+  fillMessage(message, msg)
+
+  if (msg.deleted) {
+    var actions = message.querySelector('.message-actions')
+    if (actions !== null) {
+      actions.parentNode.removeChild(actions)
+    }
+  }
+}
+
+// messageActions returns controls to react to the message, and to edit and
+// delete own messages.
+function messageActions(msg, own) {
+  var actions = document.createElement('span')
+  actions.className = 'message-actions ml-1'
+
+  if (msg.deleted) {
+    return actions
+  }
+
+  actions.appendChild(messageAction('reply', function() {
+    replyToMessage(document.getElementById('message-'+msg.id).msg)
+  }))
+
+  actions.appendChild(messageAction('react', function() {
+    var emoji = prompt('Reaction', '\uD83D\uDC4D')
+    if (emoji) {
+      sendReaction(msg, emoji, false)
+    }
+  }))
+
+  if (!own) {
+    return actions
+  }
+
+  if (msg.type === 'text') {
+    actions.appendChild(messageAction('edit', function() {
+      var message = document.getElementById('message-'+msg.id)
+      var text = prompt('Edit message', message.msg.text)
+      if (!text || text === message.msg.text) {
+        return
+      }
+      connection.send(JSON.stringify({
+        type: 'message_edit',
+        message: {id: msg.id, text: text},
+      }))
+    }))
+  }
+
+  actions.appendChild(messageAction('delete', function() {
+    if (!confirm('Delete the message for everyone?')) {
+      return
+    }
+    connection.send(JSON.stringify({
+      type: 'message_delete',
+      message: {id: msg.id},
+    }))
+  }))
+
+  return actions
+}
+
+function messageAction(name, onclick) {
+  var action = document.createElement('a')
+  action.className = 'message-action small text-muted ml-1'
+  action.href = '#'
+  action.innerText = name
+  action.onclick = function(e) {
+    e.preventDefault()
+    onclick()
+  }
+  return action
+}
+
+function sendReaction(msg, emoji, remove) {
+  connection.send(JSON.stringify({
+    type: remove ? 'message_unreact' : 'message_react',
+    message: {id: msg.id},
+    emoji: emoji,
+  }))
+}
+
+function fileContent(msg) {
+  var link = document.createElement('a')
+  link.href = '/files/' + msg.id
+  link.target = '_blank'
+
+  if (msg.file.mime.startsWith('image/')) {
+    var img = document.createElement('img')
+    img.className = 'file-preview'
+    img.src = link.href
+    img.alt = msg.file.name
+    link.appendChild(img)
+    return link
+  }
+
+  link.innerText = msg.file.name + ' (' + fileSize(msg.file.size) + ')'
+  return link
+}
+
+function fileSize(size) {
+  var units = ['B', 'KB', 'MB', 'GB']
+  var i = 0
+  while (size >= 1024 && i < units.length - 1) {
+    size /= 1024
+    i++
+  }
+  return Math.round(size * 10) / 10 + ' ' + units[i]
+}
+
+function sendFile() {
+  var input = document.getElementById('file')
+  var recipient = document.querySelector('.peer.active')
+
+  if (input.files.length === 0 || recipient === null) {
+    return
+  }
+
+  if (recipient.peer.group) {
+    alert('Files can only be sent to a single peer')
+    input.value = ''
+    return
+  }
+
+  var form = new FormData()
+  form.append('to', recipient.peer.id)
+  form.append('file', input.files[0])
+  input.value = ''
+
+  fetch('/files', {method: 'POST', body: form}).then(resp => {
+    if (!resp.ok) {
+      resp.text().then(text => alert('Can\'t send the file: ' + text))
+    }
+  })
+}
+
+// updateProgress shows progress of a file transfer. Sent files already have
+// a message, received ones get a placeholder until the whole file is received.
+function updateProgress(progress) {
+  var percent = progress.size === 0 ? 100 : Math.floor(100 * progress.offset / progress.size)
+
+  var e = document.getElementById('message-status-'+progress.message_id)
+  if (e !== null) {
+    e.innerText = percent < 100 ? percent + '%' : e.innerText
+    return
+  }
+
+  e = document.getElementById('file-progress-'+progress.message_id)
+  if (e === null) {
+    if (document.getElementById('message-'+progress.message_id) !== null) {
+      return
+    }
+
+    e = document.createElement('div')
+    e.id = 'file-progress-'+progress.message_id
+    e.className = 'm-2 text-left text-muted'
+    getPeerChat({id: progress.peer_id}).appendChild(e)
+  }
+
+  e.innerText = 'receiving a file: ' + percent + '%'
+}
+
+function removeProgress(messageID) {
+  var e = document.getElementById('file-progress-'+messageID)
+  if (e !== null) {
+    e.parentNode.removeChild(e)
+  }
+}
+
+function requestKey(peer) {
+  if (connection === undefined || connection.readyState !== WebSocket.OPEN) {
+    return
+  }
+
+  connection.send(JSON.stringify({
+    type: 'key_info',
+    peer: {id: peer.id},
+  }))
+}
+
+function sendKeyAction(type) {
+  var active = document.querySelector('.peer.active')
+  if (active === null) {
+    return
+  }
+
+  connection.send(JSON.stringify({
+    type: type,
+    peer: {id: active.peer.id},
+  }))
+}
+
+// updateKey shows the safety number of the active peer. Users compare it
+// out of band, and mark the key verified if it matches.
+function updateKey(key) {
+  var entry = document.getElementById('peer-'+key.peer_id)
+  if (entry !== null) {
+    entry.key = key
+    entry.classList.toggle('key-verified', key.verified)
+    entry.classList.toggle('key-changed', !!key.changed_fingerprint)
+  }
+
+  var active = document.querySelector('.peer.active')
+  if (active === null || active.peer.id !== key.peer_id) {
+    return
+  }
+
+  document.getElementById('key-info').classList.remove('d-none')
+  document.getElementById('key-fingerprint').innerText = key.fingerprint.substring(0, 16).match(/.{4}/g).join(' ')
+  document.getElementById('key-safety-number').innerText = key.safety_number
+  document.getElementById('key-verified').classList.toggle('d-none', !key.verified)
+  document.getElementById('key-verify').classList.toggle('d-none', key.verified)
+
+  var changed = document.getElementById('key-changed')
+  changed.classList.toggle('d-none', !key.changed_fingerprint)
+}
+
+function keyChanged(key) {
+  updateKey(key)
+
+  var entry = document.getElementById('peer-'+key.peer_id)
+  var name = entry === null ? key.peer_id : entry.peer.name
+  alert('The key of ' + name + ' has changed. Messages are not sent until you trust the new key.')
+}
+
+function hideKey() {
+  document.getElementById('key-info').classList.add('d-none')
+}
+
+function updateStatus(messageID, status) {
+  var e = document.getElementById('message-status-'+messageID)
+  if (e === null) {
+    return
+  }
+
+  e.innerText = statusMark(status)
+}
+
+function statusMark(status) {
+  switch (status) {
+    case 'queued':
+      return '⌛'
+    case 'sent':
+      return '✓'
+    case 'delivered':
+      return '✓✓'
+    case 'read':
+      return '✓✓ read'
+    case 'failed':
+      return 'not delivered'
+    default:
+      return ''
+  }
+}
+
+function markRead(message) {
+  if (connection === undefined || connection.readyState !== WebSocket.OPEN) {
+    return
+  }
+
+  message.classList.remove('unread')
+
+  connection.send(JSON.stringify({
+    type: 'message_read',
+    message: {
+      id: message.message.id,
+      from: {id: message.message.from.id},
+    },
+  }))
+}
+
 function selectPeerContact(peer) {
   document.querySelectorAll('.peer.active').forEach(p => {
     p.classList.remove('active')
@@ -158,6 +956,9 @@ function selectPeerContact(peer) {
 
   document.getElementById('peer-'+peer.id).className += ' active'
   updateUnread(peer, -10000)
+  updateGroupControls()
+
+  getPeerChat(peer).querySelectorAll('.message.unread').forEach(markRead)
 }
 
 function updateUnread(peer, diff) {
@@ -186,8 +987,13 @@ function updateUnread(peer, diff) {
 function connectWs() {
     let wsAddr = 'ws' + document.location.protocol.replace("http", '') + '//' + document.location.host + '/ws'
     let conn = new ReconnectingWebSocket(wsAddr);
+    connection = conn
     conn.onopen = function () {
       console.log('connected')
+
+      document.querySelectorAll('.chat').forEach(e => {
+        e.parentNode.removeChild(e)
+      })
     }
     conn.onclose = function (evt) {
       console.log('connection closed')
@@ -207,6 +1013,26 @@ function connectWs() {
       sendMessage(conn)
     }
 
+    document.getElementById('key-verify').onclick = function() {
+      sendKeyAction('key_verify')
+    }
+    document.getElementById('key-trust').onclick = function() {
+      sendKeyAction('key_trust')
+    }
+
+    document.getElementById('file').onchange = sendFile
+    document.getElementById('attach').onclick = function() {
+      document.getElementById('file').click()
+    }
+
+    document.getElementById('group-create').onclick = createGroup
+    document.getElementById('group-invite').onclick = inviteToGroup
+    document.getElementById('group-leave').onclick = leaveGroup
+
+    document.getElementById('message').oninput = startTyping
+    document.getElementById('reply-cancel').onclick = cancelReply
+    document.getElementById('presence').onchange = sendPresence
+
     document.onkeypress = function(e) {
       if (e.key !== 'Enter') {
         return
@@ -0,0 +1,144 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Path is a path of the endpoint to send messages.
+const Path = "/webhooks/messages"
+
+const maxBodySize = 1 << 20
+
+// Send is a text to send to a peer. To is an id or a name of a known peer.
+type Send struct {
+	To   string `json:"to"`
+	Text string `json:"text"`
+}
+
+// ServeHTTP sends a text from a bot.
+//
+// POST Path with a json of Send, signed with the bot secret in the
+// SignatureHeader, sends the text and responds with 202 Accepted. Requests
+// with a timestamp that is off by more than maxClockSkew, and repeated
+// requests are rejected.
+func (wh *Webhooks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	if len(wh.botSecret) == 0 {
+		http.Error(w, "bot secret is not set", http.StatusForbidden)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't read body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := wh.verify(time.Now(), r.Header.Get(TimestampHeader), body, r.Header.Get(SignatureHeader)); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	s := &Send{}
+	if err := json.Unmarshal(body, s); err != nil {
+		http.Error(w, fmt.Sprintf("invalid json: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if s.Text == "" {
+		http.Error(w, "text is empty", http.StatusBadRequest)
+		return
+	}
+
+	to, err := wh.peerID(s.To)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// streams to peers outlive the request, so they are not opened with its
+	// context.
+	if _, err := wh.instance.SendText(context.Background(), s.Text, to); err != nil {
+		wh.log.Error("can't send a message from a bot: %s", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// verify checks that the request is signed with the bot secret, is recent,
+// and is not seen before.
+func (wh *Webhooks) verify(now time.Time, timestamp string, body []byte, signature string) error {
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp")
+	}
+
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-maxClockSkew)) || signedAt.After(now.Add(maxClockSkew)) {
+		return fmt.Errorf("timestamp is too far from now")
+	}
+
+	if !Verify(wh.botSecret, timestamp, body, signature) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	wh.signaturesGuard.Lock()
+	defer wh.signaturesGuard.Unlock()
+
+	if now.Sub(wh.signaturesPruned) > maxClockSkew {
+		for s, expiresAt := range wh.signatures {
+			if now.After(expiresAt) {
+				delete(wh.signatures, s)
+			}
+		}
+		wh.signaturesPruned = now
+	}
+
+	// hex is case insensitive, so the signature is kept as it is signed here.
+	signed := Sign(wh.botSecret, timestamp, body)
+	if _, ok := wh.signatures[signed]; ok {
+		return fmt.Errorf("request is repeated")
+	}
+	wh.signatures[signed] = signedAt.Add(maxClockSkew)
+
+	return nil
+}
+
+// peerID returns an id of a known peer with the name if there is one,
+// otherwise the name is taken as an id.
+func (wh *Webhooks) peerID(idOrName string) (string, error) {
+	if idOrName == "" {
+		return "", fmt.Errorf("to is empty")
+	}
+
+	known := wh.instance.KnownPeers.Map()
+	if _, ok := known[idOrName]; ok {
+		return idOrName, nil
+	}
+
+	id := idOrName
+	found := false
+	for _, p := range known {
+		if p.Name != idOrName {
+			continue
+		}
+		if found {
+			return "", fmt.Errorf("more than one peer is named %s, use an id", idOrName)
+		}
+		id, found = p.ID, true
+	}
+	return id, nil
+}
@@ -0,0 +1,267 @@
+// Package webhooks connects bots to the messenger.
+//
+// Every received message is posted as json of messages.Message to each
+// configured url. Failed posts are retried with a backoff. If a secret is set,
+// requests have a TimestampHeader and a SignatureHeader with an HMAC-SHA256 of
+// the timestamp and the body, so bots can check where they come from.
+//
+// Bots send messages with a POST to Path, signed the same way with a separate
+// secret, see ServeHTTP.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ngalayko/p2p/instance"
+	"github.com/ngalayko/p2p/instance/messages"
+	"github.com/ngalayko/p2p/logger"
+)
+
+// SignatureHeader is a header with a signature of a request: "sha256="
+// followed by a hex encoded HMAC-SHA256 with the secret of the value of the
+// TimestampHeader, a dot and the body.
+const SignatureHeader = "X-P2P-Signature"
+
+// TimestampHeader is a header with the unix time of a request in seconds.
+const TimestampHeader = "X-P2P-Timestamp"
+
+const (
+	signaturePrefix = "sha256="
+
+	eventsBuffer = 256
+	// queueSize is how many messages wait to be posted to a url, newer
+	// messages are dropped when it is full.
+	queueSize = 256
+
+	// maxClockSkew is how old or new a signed request can be, a signature
+	// can't be used again during twice this time.
+	maxClockSkew = 5 * time.Minute
+
+	maxAttempts      = 5
+	minRetryInterval = time.Second
+	maxRetryInterval = time.Minute
+	postTimeout      = 10 * time.Second
+)
+
+// Webhooks posts received messages to bots and sends messages from them.
+type Webhooks struct {
+	log      *logger.Logger
+	instance *instance.Instance
+	urls     []string
+	client   *http.Client
+
+	// secret signs posted messages.
+	secret []byte
+	// botSecret checks messages from bots.
+	botSecret []byte
+
+	// signatures are signatures of accepted requests from bots, until they
+	// expire.
+	signatures       map[string]time.Time
+	signaturesPruned time.Time
+	signaturesGuard  *sync.Mutex
+
+	minRetryInterval time.Duration
+}
+
+// New is a webhooks constructor. Without a secret, posted messages are not
+// signed. Without a bot secret, bots can't send messages.
+func New(
+	log *logger.Logger,
+	instance *instance.Instance,
+	urls []string,
+	secret string,
+	botSecret string,
+) *Webhooks {
+	return &Webhooks{
+		log:       log.Prefix("webhooks"),
+		instance:  instance,
+		urls:      urls,
+		secret:    []byte(secret),
+		botSecret: []byte(botSecret),
+		client: &http.Client{
+			Timeout: postTimeout,
+		},
+		signatures:       map[string]time.Time{},
+		signaturesGuard:  &sync.Mutex{},
+		minRetryInterval: minRetryInterval,
+	}
+}
+
+// Start posts received messages to the urls until the context is done.
+func (wh *Webhooks) Start(ctx context.Context) error {
+	if len(wh.urls) == 0 {
+		return nil
+	}
+
+	queues := make(map[string]chan []byte, len(wh.urls))
+	for _, url := range wh.urls {
+		queue := make(chan []byte, queueSize)
+		queues[url] = queue
+
+		go wh.watchQueue(ctx, url, queue)
+	}
+
+	events := wh.instance.Subscribe(eventsBuffer)
+	defer func() {
+		events.Close()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-events.Events():
+			if !ok {
+				wh.log.Error("webhooks fell behind, some messages are not posted")
+				events = wh.instance.Subscribe(eventsBuffer)
+				continue
+			}
+
+			received, ok := e.(*messages.MessageReceived)
+			if !ok {
+				continue
+			}
+
+			body, err := json.Marshal(received.Message)
+			if err != nil {
+				wh.log.Error("can't marshal message %s: %s", received.Message.ID, err)
+				continue
+			}
+
+			for url, queue := range queues {
+				select {
+				case queue <- body:
+				default:
+					wh.log.Error("too many messages to %s, message %s is dropped", url, received.Message.ID)
+				}
+			}
+		}
+	}
+}
+
+// watchQueue posts messages to the url one by one, so a slow bot doesn't
+// delay the others.
+func (wh *Webhooks) watchQueue(ctx context.Context, url string, queue <-chan []byte) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case body := <-queue:
+			if err := wh.post(ctx, url, body); err != nil {
+				wh.log.Error("can't post a message to %s: %s", url, err)
+			}
+		}
+	}
+}
+
+// post posts the body to the url, and retries with a backoff until it's
+// accepted. Requests rejected by the bot are not retried.
+func (wh *Webhooks) post(ctx context.Context, url string, body []byte) error {
+	for attempt := 1; ; attempt++ {
+		retry, err := wh.postOnce(ctx, url, body)
+		if err == nil {
+			return nil
+		}
+
+		if !retry {
+			return err
+		}
+
+		if attempt == maxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %s", attempt, err)
+		}
+
+		wh.log.Debug("attempt %d to post to %s failed: %s", attempt, url, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wh.backoff(attempt)):
+		}
+	}
+}
+
+// postOnce posts the body, and returns true if it's worth to try again.
+func (wh *Webhooks) postOnce(ctx context.Context, url string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("can't create a request: %s", err)
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/json")
+	if len(wh.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, Sign(wh.secret, timestamp, body))
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	// the body is drained to reuse the connection.
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+}
+
+func (wh *Webhooks) backoff(attempt int) time.Duration {
+	interval := wh.minRetryInterval << uint(attempt-1)
+	if interval > maxRetryInterval || interval <= 0 {
+		interval = maxRetryInterval
+	}
+	return interval/2 + time.Duration(rand.Int63n(int64(interval/2)+1))
+}
+
+// Sign returns a value of the SignatureHeader for the timestamp and the body.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	return signaturePrefix + hex.EncodeToString(signature(secret, timestamp, body))
+}
+
+// Verify returns true if the signature is a valid signature of the timestamp
+// and the body.
+func Verify(secret []byte, timestamp string, body []byte, sig string) bool {
+	if !strings.HasPrefix(sig, signaturePrefix) {
+		return false
+	}
+
+	mac, err := hex.DecodeString(strings.TrimPrefix(sig, signaturePrefix))
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(mac, signature(secret, timestamp, body))
+}
+
+func signature(secret []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	_, _ = mac.Write([]byte(timestamp))
+	_, _ = mac.Write([]byte("."))
+	_, _ = mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,180 @@
+package webhooks
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ngalayko/p2p/logger"
+)
+
+func Test_Webhooks__should_post_signed_body(t *testing.T) {
+	secret := "secret"
+	body := []byte(`{"text":"hello"}`)
+
+	var received []byte
+	var timestamp, signature string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = ioutil.ReadAll(r.Body)
+		timestamp = r.Header.Get(TimestampHeader)
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer srv.Close()
+
+	wh := testWebhooks(srv.URL, secret, "")
+
+	assert.NoError(t, wh.post(context.Background(), srv.URL, body))
+	assert.Equal(t, body, received)
+	assert.NotEmpty(t, timestamp)
+	assert.True(t, Verify([]byte(secret), timestamp, received, signature))
+	assert.False(t, Verify([]byte(secret), "0", received, signature))
+}
+
+func Test_Webhooks__should_retry_failed_posts(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	wh := testWebhooks(srv.URL, "secret", "")
+
+	assert.NoError(t, wh.post(context.Background(), srv.URL, []byte(`{}`)))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+}
+
+func Test_Webhooks__should_give_up_after_max_attempts(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	wh := testWebhooks(srv.URL, "secret", "")
+
+	assert.Error(t, wh.post(context.Background(), srv.URL, []byte(`{}`)))
+	assert.Equal(t, int32(maxAttempts), atomic.LoadInt32(&attempts))
+}
+
+func Test_Webhooks__should_not_retry_rejected_posts(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	wh := testWebhooks(srv.URL, "secret", "")
+
+	assert.Error(t, wh.post(context.Background(), srv.URL, []byte(`{}`)))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts))
+}
+
+func Test_Webhooks__should_reject_unsigned_messages(t *testing.T) {
+	wh := testWebhooks("", "secret", "bot secret")
+
+	body := `{"to":"peer","text":"hello"}`
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	for _, signature := range []string{
+		"",
+		"sha256=00",
+		Sign([]byte("other secret"), timestamp, []byte(body)),
+		// posts to bots are signed with another secret.
+		Sign([]byte("secret"), timestamp, []byte(body)),
+	} {
+		w := testSend(wh, timestamp, signature, body)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code, signature)
+	}
+}
+
+func Test_Webhooks__should_reject_stale_messages(t *testing.T) {
+	wh := testWebhooks("", "", "bot secret")
+
+	body := `{"to":"peer","text":""}`
+
+	for _, signedAt := range []time.Time{
+		time.Now().Add(-maxClockSkew - time.Minute),
+		time.Now().Add(maxClockSkew + time.Minute),
+	} {
+		timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+		w := testSend(wh, timestamp, Sign([]byte("bot secret"), timestamp, []byte(body)), body)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code, timestamp)
+	}
+
+	w := testSend(wh, "", Sign([]byte("bot secret"), "", []byte(body)), body)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func Test_Webhooks__should_reject_repeated_messages(t *testing.T) {
+	wh := testWebhooks("", "", "bot secret")
+
+	// the text is empty, so the message is rejected after the signature is checked.
+	body := `{"to":"peer","text":""}`
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := Sign([]byte("bot secret"), timestamp, []byte(body))
+
+	assert.Equal(t, http.StatusBadRequest, testSend(wh, timestamp, signature, body).Code)
+	assert.Equal(t, http.StatusUnauthorized, testSend(wh, timestamp, signature, body).Code)
+	assert.Equal(t, http.StatusUnauthorized, testSend(wh, timestamp, signature[:7]+strings.ToUpper(signature[7:]), body).Code)
+}
+
+func Test_Webhooks__should_forget_expired_signatures(t *testing.T) {
+	wh := testWebhooks("", "", "bot secret")
+
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := Sign([]byte("bot secret"), timestamp, nil)
+
+	assert.NoError(t, wh.verify(now, timestamp, nil, signature))
+	assert.Len(t, wh.signatures, 1)
+
+	other := strconv.FormatInt(now.Add(2*maxClockSkew).Unix(), 10)
+	assert.NoError(t, wh.verify(now.Add(2*maxClockSkew), other, nil, Sign([]byte("bot secret"), other, nil)))
+	assert.Len(t, wh.signatures, 1)
+}
+
+func Test_Webhooks__should_not_accept_messages_without_secret(t *testing.T) {
+	wh := testWebhooks("", "secret", "")
+
+	body := `{"to":"peer","text":"hello"}`
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	w := testSend(wh, timestamp, Sign(nil, timestamp, []byte(body)), body)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+//
+// helpers
+//
+
+func testWebhooks(url string, secret string, botSecret string) *Webhooks {
+	wh := New(logger.New(logger.LevelDebug), nil, []string{url}, secret, botSecret)
+	wh.minRetryInterval = time.Millisecond
+	return wh
+}
+
+func testSend(wh *Webhooks, timestamp string, signature string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, Path, strings.NewReader(body))
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, signature)
+	w := httptest.NewRecorder()
+
+	wh.ServeHTTP(w, req)
+
+	return w
+}
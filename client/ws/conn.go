@@ -0,0 +1,28 @@
+package ws
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// conn is a websocket connection safe for concurrent writes.
+type conn struct {
+	*websocket.Conn
+
+	writeGuard *sync.Mutex
+}
+
+func newConn(c *websocket.Conn) *conn {
+	return &conn{
+		Conn:       c,
+		writeGuard: &sync.Mutex{},
+	}
+}
+
+// WriteJSON writes the JSON encoding of v as a message.
+func (c *conn) WriteJSON(v interface{}) error {
+	c.writeGuard.Lock()
+	defer c.writeGuard.Unlock()
+	return c.Conn.WriteJSON(v)
+}
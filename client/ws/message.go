@@ -1,6 +1,8 @@
 package ws
 
 import (
+	"time"
+
 	"github.com/ngalayko/p2p/instance/messages"
 	"github.com/ngalayko/p2p/instance/peers"
 )
@@ -12,16 +14,75 @@ type messageType string
 const (
 	messageTypeInvalid      messageType = ""
 	messageTypeInit         messageType = "init"
+	messageTypePing         messageType = "ping"
+	messageTypePong         messageType = "pong"
 	messageTypePeersAdded   messageType = "peer_added"
+	messageTypePeerRemoved  messageType = "peer_removed"
+	messageTypePeerStatus   messageType = "peer_status"
+	messageTypeConnected    messageType = "peer_connected"
+	messageTypeDisconnected messageType = "peer_disconnected"
 	messageTypeTextSent     messageType = "text_sent"
 	messageTypeTextReceived messageType = "text_received"
+	messageTypeHistory      messageType = "history"
+	messageTypeStatus       messageType = "message_status"
+	messageTypeDelivered    messageType = "message_delivered"
+	messageTypeRead         messageType = "message_read"
+	messageTypeEdit         messageType = "message_edit"
+	messageTypeDelete       messageType = "message_delete"
+	messageTypeReact        messageType = "message_react"
+	messageTypeUnreact      messageType = "message_unreact"
+	messageTypeUpdated      messageType = "message_updated"
+	messageTypeGroupCreate  messageType = "group_create"
+	messageTypeGroupInvite  messageType = "group_invite"
+	messageTypeGroupLeave   messageType = "group_leave"
+	messageTypeGroupUpdated messageType = "group_updated"
+	messageTypeFileSent     messageType = "file_sent"
+	messageTypeFileReceived messageType = "file_received"
+	messageTypeFileProgress messageType = "file_progress"
+	messageTypeKeyInfo      messageType = "key_info"
+	messageTypeKeyVerify    messageType = "key_verify"
+	messageTypeKeyTrust     messageType = "key_trust"
+	messageTypeKeyChanged   messageType = "key_changed"
+	messageTypeTyping       messageType = "typing"
+	messageTypePresence     messageType = "presence"
 )
 
 // message is a structure for client-server communication.
 type message struct {
 	Type    messageType       `json:"type"`
 	Peer    *peers.Peer       `json:"peer,omitempty"`
+	Group   *messages.Group   `json:"group,omitempty"`
 	Message *messages.Message `json:"message,omitempty"`
+
+	// Messages is a page of history with a Peer or in a Group, that goes
+	// before the message with Before id.
+	Messages []*messages.Message `json:"messages,omitempty"`
+	Before   string              `json:"before,omitempty"`
+
+	Status   *messages.StatusUpdate `json:"status,omitempty"`
+	Progress *messages.FileProgress `json:"progress,omitempty"`
+	Key      *messages.Key          `json:"key,omitempty"`
+	Presence *presence              `json:"presence,omitempty"`
+
+	// Typing is set if the Peer types to self or in the Group.
+	Typing bool `json:"typing,omitempty"`
+	// PresenceStatus is a status the Peer set.
+	PresenceStatus messages.Presence `json:"presence_status,omitempty"`
+
+	// Emoji is a reaction to add to the Message or remove from it.
+	Emoji string `json:"emoji,omitempty"`
+
+	// RequestID is set by a client on a text it sends. The text is answered
+	// with a text_sent message with the same RequestID, and the sent Message
+	// or an Error.
+	RequestID string `json:"request_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// presence is a liveness status of a peer.
+type presence struct {
+	Status   peers.Status `json:"status"`
+	LastSeen time.Time    `json:"last_seen"`
 }
 
 func newInitMessage(p *peers.Peer) *message {
@@ -31,6 +92,14 @@ func newInitMessage(p *peers.Peer) *message {
 	}
 }
 
+// newPongMessage answers a ping. Answers are written in order, so a client
+// that got a pong has received everything written before, e.g. the initial state.
+func newPongMessage() *message {
+	return &message{
+		Type: messageTypePong,
+	}
+}
+
 func newPeerAddedMessage(p *peers.Peer) *message {
 	return &message{
 		Type: messageTypePeersAdded,
@@ -38,6 +107,52 @@ func newPeerAddedMessage(p *peers.Peer) *message {
 	}
 }
 
+func newPeerRemovedMessage(p *peers.Peer) *message {
+	return &message{
+		Type: messageTypePeerRemoved,
+		Peer: p,
+	}
+}
+
+func newPeerStatusMessage(p *peers.Peer, status peers.Status, lastSeen time.Time) *message {
+	return &message{
+		Type: messageTypePeerStatus,
+		Peer: p,
+		Presence: &presence{
+			Status:   status,
+			LastSeen: lastSeen,
+		},
+	}
+}
+
+func newConnectionMessage(p *peers.Peer, connected bool) *message {
+	t := messageTypeDisconnected
+	if connected {
+		t = messageTypeConnected
+	}
+	return &message{
+		Type: t,
+		Peer: p,
+	}
+}
+
+func newTypingMessage(p *peers.Peer, g *messages.Group, typing bool) *message {
+	return &message{
+		Type:   messageTypeTyping,
+		Peer:   p,
+		Group:  g,
+		Typing: typing,
+	}
+}
+
+func newPresenceMessage(p *peers.Peer, status messages.Presence) *message {
+	return &message{
+		Type:           messageTypePresence,
+		Peer:           p,
+		PresenceStatus: status,
+	}
+}
+
 func newTextMessageSent(msg *messages.Message) *message {
 	return &message{
 		Type:    messageTypeTextSent,
@@ -45,9 +160,94 @@ func newTextMessageSent(msg *messages.Message) *message {
 	}
 }
 
+func newTextSentAnswer(requestID string, msg *messages.Message, err error) *message {
+	m := &message{
+		Type:      messageTypeTextSent,
+		Message:   msg,
+		RequestID: requestID,
+	}
+	if err != nil {
+		m.Error = err.Error()
+	}
+	return m
+}
+
 func newTextMessageReceived(msg *messages.Message) *message {
 	return &message{
 		Type:    messageTypeTextReceived,
 		Message: msg,
 	}
 }
+
+func newMessageUpdated(msg *messages.Message) *message {
+	return &message{
+		Type:    messageTypeUpdated,
+		Message: msg,
+	}
+}
+
+func newHistoryMessage(p *peers.Peer, g *messages.Group, before string, mm []*messages.Message) *message {
+	return &message{
+		Type:     messageTypeHistory,
+		Peer:     p,
+		Group:    g,
+		Before:   before,
+		Messages: mm,
+	}
+}
+
+func newStatusMessage(s *messages.StatusUpdate) *message {
+	t := messageTypeStatus
+	switch s.Status {
+	case messages.StatusDelivered:
+		t = messageTypeDelivered
+	case messages.StatusRead:
+		t = messageTypeRead
+	}
+	return &message{
+		Type:   t,
+		Status: s,
+	}
+}
+
+func newGroupUpdatedMessage(g *messages.Group) *message {
+	return &message{
+		Type:  messageTypeGroupUpdated,
+		Group: g,
+	}
+}
+
+func newFileMessageSent(msg *messages.Message) *message {
+	return &message{
+		Type:    messageTypeFileSent,
+		Message: msg,
+	}
+}
+
+func newFileMessageReceived(msg *messages.Message) *message {
+	return &message{
+		Type:    messageTypeFileReceived,
+		Message: msg,
+	}
+}
+
+func newFileProgressMessage(p *messages.FileProgress) *message {
+	return &message{
+		Type:     messageTypeFileProgress,
+		Progress: p,
+	}
+}
+
+func newKeyInfoMessage(k *messages.Key) *message {
+	return &message{
+		Type: messageTypeKeyInfo,
+		Key:  k,
+	}
+}
+
+func newKeyChangedMessage(k *messages.Key) *message {
+	return &message{
+		Type: messageTypeKeyChanged,
+		Key:  k,
+	}
+}
@@ -3,15 +3,23 @@ package ws
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/gorilla/websocket"
 
 	"github.com/ngalayko/p2p/instance"
 	"github.com/ngalayko/p2p/instance/messages"
+	"github.com/ngalayko/p2p/instance/peers"
 	"github.com/ngalayko/p2p/logger"
 )
 
+const (
+	historyPageSize  = 50
+	peerEventsBuffer = 64
+	eventsBuffer     = 256
+)
+
 // WebSocket serves data to the ui.
 type WebSocket struct {
 	log      *logger.Logger
@@ -38,77 +46,363 @@ func (ws *WebSocket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ws.log.Info("new connection from %s", origin)
 	defer ws.log.Info("connection from %s closed", origin)
 
-	conn, err := ws.upgrader.Upgrade(w, r, nil)
+	wsConn, err := ws.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		ws.log.Error("error upgrading a connection from %s: %s", origin, err)
 		return
 	}
+	conn := newConn(wsConn)
+	defer conn.Close()
 
-	go ws.watchUpdates(r.Context(), conn)
+	// subscribed before the init message, so no change is missed.
+	peerEvents := ws.instance.KnownPeers.Subscribe(peerEventsBuffer)
+	events := ws.instance.Subscribe(eventsBuffer)
+
+	go ws.watchUpdates(r.Context(), conn, peerEvents, events)
 
 	if err := conn.WriteJSON(newInitMessage(ws.instance.Peer)); err != nil {
 		ws.log.Error("error writing init message to %s: %s", origin, err)
 		return
 	}
 
+	if err := conn.WriteJSON(newPresenceMessage(ws.instance.Peer, ws.instance.Presence(ws.instance.Peer.ID))); err != nil {
+		ws.log.Error("error writing presence message to %s: %s", origin, err)
+		return
+	}
+
+	if err := ws.writePeers(conn); err != nil {
+		ws.log.Error("error writing peers to %s: %s", origin, err)
+		return
+	}
+
+	for _, g := range ws.instance.Groups() {
+		if err := conn.WriteJSON(newGroupUpdatedMessage(g)); err != nil {
+			ws.log.Error("error writing group message to %s: %s", origin, err)
+			return
+		}
+	}
+
 	for {
-		select {
-		case <-r.Context().Done():
-			conn.Close()
-			break
-		default:
-			_, data, err := conn.ReadMessage()
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					ws.log.Error("error reading message from %s: %s", origin, err)
-				}
-				break
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				ws.log.Error("error reading message from %s: %s", origin, err)
 			}
+			return
+		}
+
+		m := &message{}
+		if err := json.Unmarshal(data, m); err != nil {
+			continue
+		}
 
-			m := &message{}
-			if err := json.Unmarshal(data, m); err != nil {
+		switch m.Type {
+		case messageTypePing:
+			if err := conn.WriteJSON(newPongMessage()); err != nil {
+				ws.log.Error("error writing pong message to %s: %s", origin, err)
 				continue
 			}
-
-			switch m.Type {
-			case messageTypeTextSent:
-				if err := ws.instance.SendText(context.Background(), m.Message.Text, m.Message.To.ID); err != nil {
-					ws.log.Error("can't send message: %s", err)
+		case messageTypeTextSent:
+			if m.Message == nil {
+				continue
+			}
+			sent, err := ws.sendText(m.Message)
+			if err != nil {
+				ws.log.Error("can't send message: %s", err)
+			}
+			if m.RequestID == "" {
+				continue
+			}
+			if err := conn.WriteJSON(newTextSentAnswer(m.RequestID, sent, err)); err != nil {
+				ws.log.Error("error writing text sent message to %s: %s", origin, err)
+				continue
+			}
+		case messageTypeGroupCreate:
+			if m.Group == nil {
+				continue
+			}
+			if _, err := ws.instance.CreateGroup(context.Background(), m.Group.Name, memberIDs(m.Group)); err != nil {
+				ws.log.Error("can't create group: %s", err)
+				continue
+			}
+		case messageTypeGroupInvite:
+			if m.Group == nil {
+				continue
+			}
+			if _, err := ws.instance.InviteToGroup(context.Background(), m.Group.ID, memberIDs(m.Group)); err != nil {
+				ws.log.Error("can't invite to group: %s", err)
+				continue
+			}
+		case messageTypeGroupLeave:
+			if m.Group == nil {
+				continue
+			}
+			if err := ws.instance.LeaveGroup(context.Background(), m.Group.ID); err != nil {
+				ws.log.Error("can't leave group: %s", err)
+				continue
+			}
+		case messageTypeRead:
+			if m.Message == nil || m.Message.From == nil {
+				continue
+			}
+			if err := ws.instance.SendRead(context.Background(), m.Message.From.ID, m.Message.ID); err != nil {
+				ws.log.Error("can't send read receipt: %s", err)
+				continue
+			}
+		case messageTypeEdit:
+			if m.Message == nil {
+				continue
+			}
+			if err := ws.instance.EditMessage(context.Background(), m.Message.ID, m.Message.Text); err != nil {
+				ws.log.Error("can't edit message: %s", err)
+				continue
+			}
+		case messageTypeDelete:
+			if m.Message == nil {
+				continue
+			}
+			if err := ws.instance.DeleteMessage(context.Background(), m.Message.ID); err != nil {
+				ws.log.Error("can't delete message: %s", err)
+				continue
+			}
+		case messageTypeReact:
+			if m.Message == nil {
+				continue
+			}
+			if err := ws.instance.React(context.Background(), m.Message.ID, m.Emoji); err != nil {
+				ws.log.Error("can't react to message: %s", err)
+				continue
+			}
+		case messageTypeUnreact:
+			if m.Message == nil {
+				continue
+			}
+			if err := ws.instance.Unreact(context.Background(), m.Message.ID, m.Emoji); err != nil {
+				ws.log.Error("can't remove reaction: %s", err)
+				continue
+			}
+		case messageTypeKeyInfo, messageTypeKeyVerify, messageTypeKeyTrust:
+			if m.Peer == nil {
+				continue
+			}
+			k, err := ws.key(m.Type, m.Peer.ID)
+			if err != nil {
+				ws.log.Error("can't get key: %s", err)
+				continue
+			}
+			if err := conn.WriteJSON(newKeyInfoMessage(k)); err != nil {
+				ws.log.Error("error writing key message to %s: %s", origin, err)
+				continue
+			}
+		case messageTypeTyping:
+			switch {
+			case m.Group != nil:
+				if err := ws.instance.SendGroupTyping(context.Background(), m.Group.ID, m.Typing); err != nil {
+					ws.log.Debug("can't send typing to group: %s", err)
+					continue
+				}
+			case m.Peer != nil:
+				if err := ws.instance.SendTyping(context.Background(), m.Peer.ID, m.Typing); err != nil {
+					ws.log.Debug("can't send typing: %s", err)
 					continue
 				}
 			}
+		case messageTypePresence:
+			if err := ws.instance.SetPresence(context.Background(), m.PresenceStatus); err != nil {
+				ws.log.Error("can't set presence: %s", err)
+				continue
+			}
+			if err := conn.WriteJSON(newPresenceMessage(ws.instance.Peer, ws.instance.Presence(ws.instance.Peer.ID))); err != nil {
+				ws.log.Error("error writing presence message to %s: %s", origin, err)
+				continue
+			}
+		case messageTypeHistory:
+			var chatID string
+			switch {
+			case m.Group != nil:
+				chatID = m.Group.ID
+			case m.Peer != nil:
+				chatID = m.Peer.ID
+			default:
+				continue
+			}
+			mm, err := ws.instance.History(chatID, m.Before, historyPageSize)
+			if err != nil {
+				ws.log.Error("can't get history: %s", err)
+				continue
+			}
+			if err := conn.WriteJSON(newHistoryMessage(m.Peer, m.Group, m.Before, mm)); err != nil {
+				ws.log.Error("error writing history message to %s: %s", origin, err)
+				continue
+			}
 		}
 	}
 }
 
-func (ws *WebSocket) watchUpdates(ctx context.Context, conn *websocket.Conn) {
+// sendText sends a text to a peer, a group or as a reply.
+func (ws *WebSocket) sendText(m *messages.Message) (*messages.Message, error) {
+	// streams to peers outlive the connection, so they are not opened with
+	// its context.
+	switch {
+	case m.ReplyTo != nil:
+		return ws.instance.ReplyText(context.Background(), m.Text, m.ReplyTo.MessageID)
+	case m.Group != nil:
+		return ws.instance.SendGroupText(context.Background(), m.Text, m.Group.ID)
+	case m.To != nil:
+		return ws.instance.SendText(context.Background(), m.Text, m.To.ID)
+	default:
+		return nil, fmt.Errorf("the message has no recipient")
+	}
+}
+
+func (ws *WebSocket) watchUpdates(
+	ctx context.Context,
+	conn *conn,
+	peerEvents *peers.Subscription,
+	events *messages.Subscription,
+) {
+	defer func() {
+		peerEvents.Close()
+		events.Close()
+	}()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ws.instance.KnownPeers.Updated():
-			for _, peer := range ws.instance.KnownPeers.Map() {
-				if err := conn.WriteJSON(newPeerAddedMessage(peer)); err != nil {
-					ws.log.Error("error writing new peer message: %s", err)
+		case e, ok := <-peerEvents.Events():
+			if !ok {
+				// the ui fell behind, the current peers are sent again.
+				peerEvents = ws.instance.KnownPeers.Subscribe(peerEventsBuffer)
+				if err := ws.writePeers(conn); err != nil {
+					ws.log.Error("error writing peers: %s", err)
 					return
 				}
+				continue
 			}
-		case msg := <-ws.instance.Sent():
-			switch msg.Type {
-			case messages.TypeText:
-				if err := conn.WriteJSON(newTextMessageSent(msg)); err != nil {
-					ws.log.Error("error writing sent message: %s", err)
-					return
-				}
+			if err := ws.writePeerEvent(conn, e); err != nil {
+				ws.log.Error("error writing peer message: %s", err)
+				return
 			}
-		case msg := <-ws.instance.Received():
-			switch msg.Type {
-			case messages.TypeText:
-				if err := conn.WriteJSON(newTextMessageReceived(msg)); err != nil {
-					ws.log.Error("error writing received message: %s", err)
-					return
-				}
+		case e, ok := <-events.Events():
+			if !ok {
+				// the ui fell behind and missed messages, it loads everything
+				// again after reconnecting.
+				ws.log.Error("ui is too slow, closing the connection")
+				conn.Close()
+				return
 			}
+			if err := ws.writeEvent(conn, e); err != nil {
+				ws.log.Error("error writing message: %s", err)
+				return
+			}
+		}
+	}
+}
+
+func (ws *WebSocket) writeEvent(conn *conn, e messages.Event) error {
+	switch e := e.(type) {
+	case *messages.MessageSent:
+		switch e.Message.Type {
+		case messages.TypeText:
+			return conn.WriteJSON(newTextMessageSent(e.Message))
+		case messages.TypeFile:
+			return conn.WriteJSON(newFileMessageSent(e.Message))
+		}
+	case *messages.MessageReceived:
+		switch e.Message.Type {
+		case messages.TypeText:
+			return conn.WriteJSON(newTextMessageReceived(e.Message))
+		case messages.TypeFile:
+			return conn.WriteJSON(newFileMessageReceived(e.Message))
 		}
+	case *messages.MessageUpdated:
+		return conn.WriteJSON(newMessageUpdated(e.Message))
+	case *messages.GroupUpdated:
+		return conn.WriteJSON(newGroupUpdatedMessage(e.Group))
+	case *messages.StatusUpdate:
+		return conn.WriteJSON(newStatusMessage(e))
+	case *messages.KeyChanged:
+		return conn.WriteJSON(newKeyChangedMessage(e.Key))
+	case *messages.FileProgress:
+		return conn.WriteJSON(newFileProgressMessage(e))
+	case *messages.ConnectionChanged:
+		p, ok := ws.instance.KnownPeers.Map()[e.PeerID]
+		if !ok {
+			return nil
+		}
+		return conn.WriteJSON(newConnectionMessage(p, e.Connected))
+	case *messages.TypingChanged:
+		p, ok := ws.instance.KnownPeers.Map()[e.PeerID]
+		if !ok {
+			return nil
+		}
+		var g *messages.Group
+		if e.GroupID != "" {
+			g = &messages.Group{ID: e.GroupID}
+		}
+		return conn.WriteJSON(newTypingMessage(p, g, e.Typing))
+	case *messages.PresenceChanged:
+		p, ok := ws.instance.KnownPeers.Map()[e.PeerID]
+		if !ok {
+			return nil
+		}
+		return conn.WriteJSON(newPresenceMessage(p, e.Presence))
+	}
+	return nil
+}
+
+func (ws *WebSocket) writePeerEvent(conn *conn, e peers.Event) error {
+	switch e := e.(type) {
+	case *peers.PeerAdded:
+		if err := conn.WriteJSON(newPeerAddedMessage(e.Peer)); err != nil {
+			return err
+		}
+		return conn.WriteJSON(newPeerStatusMessage(e.Peer, e.Status, e.LastSeen))
+	case *peers.PeerChanged:
+		return conn.WriteJSON(newPeerStatusMessage(e.Peer, e.Status, e.LastSeen))
+	case *peers.PeerRemoved:
+		return conn.WriteJSON(newPeerRemovedMessage(e.Peer))
+	}
+	return nil
+}
+
+// writePeers writes statuses, connections and presences of all known peers.
+func (ws *WebSocket) writePeers(conn *conn) error {
+	for id, p := range ws.instance.KnownPeers.Map() {
+		status, lastSeen, ok := ws.instance.KnownPeers.Status(id)
+		if !ok {
+			continue
+		}
+		if err := conn.WriteJSON(newPeerStatusMessage(p, status, lastSeen)); err != nil {
+			return err
+		}
+		if err := conn.WriteJSON(newConnectionMessage(p, ws.instance.Connected(id))); err != nil {
+			return err
+		}
+		if err := conn.WriteJSON(newPresenceMessage(p, ws.instance.Presence(id))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// key returns a key of the peer after the requested action.
+func (ws *WebSocket) key(t messageType, peerID string) (*messages.Key, error) {
+	switch t {
+	case messageTypeKeyVerify:
+		return ws.instance.VerifyKey(peerID)
+	case messageTypeKeyTrust:
+		return ws.instance.TrustKey(peerID)
+	default:
+		return ws.instance.Key(peerID)
+	}
+}
+
+func memberIDs(g *messages.Group) []string {
+	ids := make([]string, 0, len(g.Members))
+	for _, m := range g.Members {
+		ids = append(ids, m.ID)
 	}
+	return ids
 }
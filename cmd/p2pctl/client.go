@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// peer is a peer as the ui websocket sends it.
+type peer struct {
+	ID         string           `json:"id"`
+	Name       string           `json:"name"`
+	KnownPeers map[string]*peer `json:"known_peers,omitempty"`
+}
+
+// short returns the peer without known peers.
+func (p *peer) short() *peer {
+	if p == nil {
+		return nil
+	}
+	return &peer{ID: p.ID, Name: p.Name}
+}
+
+type group struct {
+	ID      string  `json:"id"`
+	Name    string  `json:"name"`
+	Members []*peer `json:"members,omitempty"`
+	Left    bool    `json:"left,omitempty"`
+}
+
+type replyTo struct {
+	MessageID string `json:"message_id"`
+	FromID    string `json:"from_id,omitempty"`
+	Quote     string `json:"quote,omitempty"`
+}
+
+type file struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	MIME string `json:"mime"`
+}
+
+type chatMessage struct {
+	ID        string    `json:"id,omitempty"`
+	From      *peer     `json:"from,omitempty"`
+	To        *peer     `json:"to,omitempty"`
+	Group     *group    `json:"group,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type,omitempty"`
+	Text      string    `json:"text"`
+	Status    string    `json:"status,omitempty"`
+	File      *file     `json:"file,omitempty"`
+	ReplyTo   *replyTo  `json:"reply_to,omitempty"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+type liveness struct {
+	Status   string    `json:"status"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// message is a message of the ui websocket protocol, see client/ws.
+type message struct {
+	Type     string         `json:"type"`
+	Peer     *peer          `json:"peer,omitempty"`
+	Group    *group         `json:"group,omitempty"`
+	Message  *chatMessage   `json:"message,omitempty"`
+	Messages []*chatMessage `json:"messages,omitempty"`
+	Before   string         `json:"before,omitempty"`
+	Presence *liveness      `json:"presence,omitempty"`
+
+	PresenceStatus string `json:"presence_status,omitempty"`
+
+	// RequestID matches a sent text with its answer.
+	RequestID string `json:"request_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// peerState is what is known about a peer.
+type peerState struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Status    string    `json:"status,omitempty"`
+	LastSeen  time.Time `json:"last_seen"`
+	Connected bool      `json:"connected"`
+	Presence  string    `json:"presence,omitempty"`
+}
+
+// client is a connection to the ui websocket of a running peer.
+type client struct {
+	conn *websocket.Conn
+
+	writeGuard *sync.Mutex
+
+	self   *peer
+	peers  map[string]*peerState
+	groups map[string]*group
+
+	messages chan *message
+	err      error
+}
+
+// dial connects to the peer and waits for its current state.
+func dial(ctx context.Context, addr string) (*client, error) {
+	u := url.URL{Scheme: "ws", Host: addr, Path: "/ws"}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to %s: %s", addr, err)
+	}
+
+	c := &client{
+		conn:       conn,
+		writeGuard: &sync.Mutex{},
+		peers:      map[string]*peerState{},
+		groups:     map[string]*group{},
+		messages:   make(chan *message, 64),
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(deadline)
+	}
+
+	// the peer answers after it sends the state.
+	if err := c.send(&message{Type: "ping"}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	for {
+		m := &message{}
+		if err := conn.ReadJSON(m); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("can't read the state of %s: %s", addr, err)
+		}
+		if m.Type == "pong" {
+			break
+		}
+		c.update(m)
+	}
+
+	_ = conn.SetReadDeadline(time.Time{})
+
+	go c.read()
+
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *client) Close() error {
+	return c.conn.Close()
+}
+
+// next returns the next message from the peer and updates the state with it.
+// State is only updated by next, so it must be called from one goroutine.
+func (c *client) next(ctx context.Context) (*message, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case m, ok := <-c.messages:
+		if !ok {
+			return nil, fmt.Errorf("connection closed: %s", c.err)
+		}
+		c.update(m)
+		return m, nil
+	}
+}
+
+func (c *client) read() {
+	defer close(c.messages)
+
+	for {
+		m := &message{}
+		if err := c.conn.ReadJSON(m); err != nil {
+			c.err = err
+			return
+		}
+		c.messages <- m
+	}
+}
+
+// newRequestID returns a random id to match a request with its answer.
+func newRequestID() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("can't make a request id: %s", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func (c *client) send(m *message) error {
+	c.writeGuard.Lock()
+	defer c.writeGuard.Unlock()
+
+	if err := c.conn.WriteJSON(m); err != nil {
+		return fmt.Errorf("can't send %s: %s", m.Type, err)
+	}
+	return nil
+}
+
+// update keeps the state of peers and groups.
+func (c *client) update(m *message) {
+	switch m.Type {
+	case "init":
+		c.self = m.Peer
+		for _, p := range m.Peer.KnownPeers {
+			c.peer(p)
+		}
+	case "peer_added":
+		c.peer(m.Peer)
+	case "peer_removed":
+		delete(c.peers, m.Peer.ID)
+	case "peer_status":
+		s := c.peer(m.Peer)
+		s.Status = m.Presence.Status
+		s.LastSeen = m.Presence.LastSeen
+	case "peer_connected", "peer_disconnected":
+		c.peer(m.Peer).Connected = m.Type == "peer_connected"
+	case "presence":
+		if c.self == nil || m.Peer.ID != c.self.ID {
+			c.peer(m.Peer).Presence = m.PresenceStatus
+		}
+	case "group_updated":
+		if m.Group.Left {
+			delete(c.groups, m.Group.ID)
+			return
+		}
+		c.groups[m.Group.ID] = m.Group
+	}
+}
+
+func (c *client) peer(p *peer) *peerState {
+	s, ok := c.peers[p.ID]
+	if !ok {
+		s = &peerState{ID: p.ID}
+		c.peers[p.ID] = s
+	}
+	if p.Name != "" {
+		s.Name = p.Name
+	}
+	return s
+}
+
+// sortedPeers returns known peers ordered by name.
+func (c *client) sortedPeers() []*peerState {
+	pp := make([]*peerState, 0, len(c.peers))
+	for _, p := range c.peers {
+		pp = append(pp, p)
+	}
+	sort.Slice(pp, func(i, j int) bool {
+		if pp[i].Name == pp[j].Name {
+			return pp[i].ID < pp[j].ID
+		}
+		return pp[i].Name < pp[j].Name
+	})
+	return pp
+}
+
+// sortedGroups returns groups ordered by name.
+func (c *client) sortedGroups() []*group {
+	gg := make([]*group, 0, len(c.groups))
+	for _, g := range c.groups {
+		gg = append(gg, g)
+	}
+	sort.Slice(gg, func(i, j int) bool {
+		if gg[i].Name == gg[j].Name {
+			return gg[i].ID < gg[j].ID
+		}
+		return gg[i].Name < gg[j].Name
+	})
+	return gg
+}
+
+// chat finds a peer or a group by id or name.
+func (c *client) chat(idOrName string) (*peerState, *group, error) {
+	if c.self != nil && (idOrName == c.self.ID || idOrName == c.self.Name) {
+		return &peerState{ID: c.self.ID, Name: c.self.Name}, nil, nil
+	}
+	if p, ok := c.peers[idOrName]; ok {
+		return p, nil, nil
+	}
+	if g, ok := c.groups[idOrName]; ok {
+		return nil, g, nil
+	}
+
+	var (
+		foundPeer  *peerState
+		foundGroup *group
+		found      int
+	)
+	for _, p := range c.peers {
+		if p.Name == idOrName {
+			foundPeer = p
+			found++
+		}
+	}
+	for _, g := range c.groups {
+		if g.Name == idOrName {
+			foundGroup = g
+			found++
+		}
+	}
+
+	switch found {
+	case 0:
+		return nil, nil, fmt.Errorf("unknown peer or group: %s", idOrName)
+	case 1:
+		return foundPeer, foundGroup, nil
+	default:
+		return nil, nil, fmt.Errorf("%s is ambiguous, use an id", idOrName)
+	}
+}
+
+// name returns a name of the peer.
+func (c *client) name(p *peer) string {
+	if p == nil {
+		return ""
+	}
+	if c.self != nil && p.ID == c.self.ID {
+		return "you"
+	}
+	if s, ok := c.peers[p.ID]; ok && s.Name != "" {
+		return s.Name
+	}
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.ID
+}
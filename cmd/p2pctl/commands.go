@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// stdin is where send and chat read texts from.
+var stdin io.Reader = os.Stdin
+
+type command struct {
+	minArgs int
+	run     func(ctx context.Context, c *client, out *output, args []string) error
+}
+
+var commands = map[string]*command{
+	"peers":   {run: listPeers},
+	"groups":  {run: listGroups},
+	"send":    {minArgs: 1, run: send},
+	"history": {minArgs: 1, run: history},
+	"tail":    {run: tail},
+	"chat":    {minArgs: 1, run: chat},
+}
+
+func listPeers(ctx context.Context, c *client, out *output, args []string) error {
+	for _, p := range c.sortedPeers() {
+		if err := out.peer(p); err != nil {
+			return err
+		}
+	}
+	return out.flush()
+}
+
+func listGroups(ctx context.Context, c *client, out *output, args []string) error {
+	for _, g := range c.sortedGroups() {
+		if err := out.group(g); err != nil {
+			return err
+		}
+	}
+	return out.flush()
+}
+
+// send sends a text and waits until the peer confirms it.
+func send(ctx context.Context, c *client, out *output, args []string) error {
+	p, g, err := c.chat(args[0])
+	if err != nil {
+		return err
+	}
+
+	text := strings.Join(args[1:], " ")
+	if len(args) == 1 {
+		data, err := ioutil.ReadAll(stdin)
+		if err != nil {
+			return fmt.Errorf("can't read stdin: %s", err)
+		}
+		text = strings.TrimRight(string(data), "\n")
+	}
+	if text == "" {
+		return fmt.Errorf("empty text")
+	}
+
+	request, err := newText(p, g, text)
+	if err != nil {
+		return err
+	}
+	if err := c.send(request); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	for {
+		m, err := c.next(ctx)
+		if err != nil {
+			return fmt.Errorf("the message is not confirmed: %s", err)
+		}
+		if m.Type != "text_sent" || m.RequestID != request.RequestID {
+			continue
+		}
+		if m.Error != "" {
+			return fmt.Errorf("the message is not sent: %s", m.Error)
+		}
+		return out.message(c, m.Message)
+	}
+}
+
+// history prints the latest page of messages of the chat.
+func history(ctx context.Context, c *client, out *output, args []string) error {
+	p, g, err := c.chat(args[0])
+	if err != nil {
+		return err
+	}
+
+	request := &message{Type: "history"}
+	if g != nil {
+		request.Group = &group{ID: g.ID}
+	} else {
+		request.Peer = &peer{ID: p.ID}
+	}
+	if err := c.send(request); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	for {
+		m, err := c.next(ctx)
+		if err != nil {
+			return fmt.Errorf("no history: %s", err)
+		}
+		if m.Type != "history" || m.Before != "" {
+			continue
+		}
+		if (g != nil && m.Group != nil && m.Group.ID == g.ID) || (g == nil && m.Peer != nil && m.Peer.ID == p.ID) {
+			for _, msg := range m.Messages {
+				if err := out.message(c, msg); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// tail prints incoming messages until interrupted.
+func tail(ctx context.Context, c *client, out *output, args []string) error {
+	for {
+		m, err := c.next(ctx)
+		if err == context.Canceled {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch m.Type {
+		case "text_received", "file_received":
+			if err := out.message(c, m.Message); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// chat sends lines from stdin to the chat and prints its messages. It stops
+// when interrupted, or when stdin is closed and every line is confirmed.
+func chat(ctx context.Context, c *client, out *output, args []string) error {
+	p, g, err := c.chat(args[0])
+	if err != nil {
+		return err
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(stdin)
+		for scanner.Scan() {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		// pending are request ids of texts that are not confirmed yet.
+		pending = map[string]bool{}
+		// printed are ids of sent texts that are printed once.
+		printed = map[string]bool{}
+		input   = lines
+		// expired is set when stdin is closed, to stop waiting for confirmations.
+		expired <-chan time.Time
+	)
+	for input != nil || len(pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-expired:
+			return fmt.Errorf("%d messages are not confirmed", len(pending))
+		case text, ok := <-input:
+			if !ok {
+				input = nil
+				expired = time.After(*timeout)
+				continue
+			}
+
+			request, err := newText(p, g, text)
+			if err != nil {
+				return err
+			}
+			if err := c.send(request); err != nil {
+				fmt.Fprintf(os.Stderr, "p2pctl: %s\n", err)
+				continue
+			}
+			pending[request.RequestID] = true
+		case m, ok := <-c.messages:
+			if !ok {
+				return fmt.Errorf("connection closed: %s", c.err)
+			}
+			c.update(m)
+
+			switch m.Type {
+			case "text_sent", "text_received", "file_sent", "file_received":
+				if m.RequestID != "" {
+					if !pending[m.RequestID] {
+						continue
+					}
+					delete(pending, m.RequestID)
+					if m.Error != "" {
+						fmt.Fprintf(os.Stderr, "p2pctl: the message is not sent: %s\n", m.Error)
+						continue
+					}
+				}
+				if m.Message == nil || !inChat(m.Message, p, g) {
+					continue
+				}
+				// a sent text comes both as an answer and as an update, it
+				// is printed from the first one.
+				if m.Type == "text_sent" {
+					if printed[m.Message.ID] {
+						delete(printed, m.Message.ID)
+						continue
+					}
+					printed[m.Message.ID] = true
+				}
+				if err := out.message(c, m.Message); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// newText returns a text to send to the peer or the group.
+func newText(p *peerState, g *group, text string) (*message, error) {
+	requestID, err := newRequestID()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &message{
+		Type:      "text_sent",
+		RequestID: requestID,
+		Message: &chatMessage{
+			Text: text,
+		},
+	}
+	if g != nil {
+		m.Message.Group = &group{ID: g.ID}
+	} else {
+		m.Message.To = &peer{ID: p.ID}
+	}
+	return m, nil
+}
+
+// inChat returns true if the message is in the chat with the peer or the group.
+func inChat(m *chatMessage, p *peerState, g *group) bool {
+	if g != nil {
+		return m.Group != nil && m.Group.ID == g.ID
+	}
+	if m.Group != nil {
+		return false
+	}
+	return (m.To != nil && m.To.ID == p.ID) || (m.From != nil && m.From.ID == p.ID)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testSelf  = &peer{ID: "self", Name: "me"}
+	testAlice = &peer{ID: "alice-id", Name: "alice"}
+	testBob   = &peer{ID: "bob-id", Name: "bob"}
+	testGroup = &group{ID: "group-id", Name: "devs", Members: []*peer{testSelf, testAlice}}
+)
+
+func Test_send__should_print_the_message_answering_its_request(t *testing.T) {
+	addr, received := testServer(t, func(m *message) []*message {
+		if m.Type != "text_sent" {
+			return nil
+		}
+		return []*message{
+			// the same text sent to the same chat by another client.
+			textSent("other", "other-request", m.Message),
+			textSent("sent", "", m.Message),
+			textSent("sent", m.RequestID, m.Message),
+		}
+	})
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, testRun(t, addr, out, "send", "alice", "hello", "world"))
+
+	m := <-received
+	assert.NotEmpty(t, m.RequestID)
+	assert.Equal(t, "hello world", m.Message.Text)
+	assert.Equal(t, testAlice.ID, m.Message.To.ID)
+
+	printed := decodeMessages(t, out)
+	if assert.Len(t, printed, 1) {
+		assert.Equal(t, "sent", printed[0].ID)
+	}
+}
+
+func Test_send__should_fail_if_the_message_is_not_sent(t *testing.T) {
+	addr, _ := testServer(t, func(m *message) []*message {
+		if m.Type != "text_sent" {
+			return nil
+		}
+		return []*message{{Type: "text_sent", RequestID: m.RequestID, Error: "unknown peer"}}
+	})
+
+	err := testRun(t, addr, &bytes.Buffer{}, "send", "devs", "hello")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown peer")
+	}
+}
+
+func Test_chat__should_send_lines_and_wait_until_they_are_confirmed(t *testing.T) {
+	addr, received := testServer(t, func(m *message) []*message {
+		if m.Type != "text_sent" {
+			return nil
+		}
+		id := "sent-" + m.Message.Text
+		return []*message{
+			textSent(id, m.RequestID, m.Message),
+			textSent(id, "", m.Message),
+		}
+	})
+
+	defer func(r io.Reader) { stdin = r }(stdin)
+	stdin = strings.NewReader("one\n\n  \ntwo\n")
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, testRun(t, addr, out, "chat", "devs"))
+
+	for _, text := range []string{"one", "two"} {
+		m := <-received
+		assert.Equal(t, text, m.Message.Text)
+		assert.Equal(t, testGroup.ID, m.Message.Group.ID)
+	}
+
+	printed := decodeMessages(t, out)
+	if assert.Len(t, printed, 2) {
+		assert.Equal(t, "sent-one", printed[0].ID)
+		assert.Equal(t, "sent-two", printed[1].ID)
+	}
+}
+
+func Test_chat__should_fail_if_lines_are_not_confirmed(t *testing.T) {
+	addr, _ := testServer(t, func(m *message) []*message {
+		return nil
+	})
+
+	defer func(r io.Reader) { stdin = r }(stdin)
+	stdin = strings.NewReader("one\n")
+
+	defer func(d time.Duration) { *timeout = d }(*timeout)
+	*timeout = 100 * time.Millisecond
+
+	err := testRun(t, addr, &bytes.Buffer{}, "chat", "alice")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "1 messages are not confirmed")
+	}
+}
+
+func Test_history__should_print_the_history_of_the_resolved_chat(t *testing.T) {
+	addr, received := testServer(t, func(m *message) []*message {
+		if m.Type != "history" {
+			return nil
+		}
+		return []*message{
+			{Type: "history", Peer: testBob, Messages: []*chatMessage{{ID: "bob-message"}}},
+			{Type: "history", Peer: m.Peer, Group: m.Group, Before: "older", Messages: []*chatMessage{{ID: "older-message"}}},
+			{Type: "history", Peer: m.Peer, Group: m.Group, Messages: []*chatMessage{{ID: "first"}, {ID: "second"}}},
+		}
+	})
+
+	for chat, expected := range map[string]*message{
+		"alice":    {Peer: &peer{ID: testAlice.ID}},
+		"alice-id": {Peer: &peer{ID: testAlice.ID}},
+		"devs":     {Group: &group{ID: testGroup.ID}},
+		"me":       {Peer: &peer{ID: testSelf.ID}},
+	} {
+		out := &bytes.Buffer{}
+		assert.NoError(t, testRun(t, addr, out, "history", chat), chat)
+
+		m := <-received
+		assert.Equal(t, expected.Peer, m.Peer, chat)
+		assert.Equal(t, expected.Group, m.Group, chat)
+
+		printed := decodeMessages(t, out)
+		if assert.Len(t, printed, 2, chat) {
+			assert.Equal(t, "first", printed[0].ID)
+			assert.Equal(t, "second", printed[1].ID)
+		}
+	}
+}
+
+func Test_history__should_not_resolve_unknown_or_ambiguous_chats(t *testing.T) {
+	addr, _ := testServer(t, func(m *message) []*message {
+		return nil
+	}, &peer{ID: "other-bob-id", Name: "bob"})
+
+	for chat, reason := range map[string]string{
+		"carol": "unknown peer or group",
+		"bob":   "ambiguous",
+	} {
+		err := testRun(t, addr, &bytes.Buffer{}, "history", chat)
+		if assert.Error(t, err, chat) {
+			assert.Contains(t, err.Error(), reason, chat)
+		}
+	}
+}
+
+//
+// helpers
+//
+
+// testServer starts a fake ui websocket of a peer that knows alice, bob and
+// the others, and is a member of the group. Messages from clients are sent to
+// the channel, and answered with what handle returns.
+func testServer(t *testing.T, handle func(m *message) []*message, others ...*peer) (string, <-chan *message) {
+	received := make(chan *message, 64)
+	upgrader := &websocket.Upgrader{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("can't upgrade a connection: %s", err)
+			return
+		}
+		defer conn.Close()
+
+		self := &peer{
+			ID:   testSelf.ID,
+			Name: testSelf.Name,
+			KnownPeers: map[string]*peer{
+				testAlice.ID: testAlice,
+				testBob.ID:   testBob,
+			},
+		}
+		for _, p := range others {
+			self.KnownPeers[p.ID] = p
+		}
+
+		for _, m := range []*message{
+			{Type: "init", Peer: self},
+			{Type: "group_updated", Group: testGroup},
+		} {
+			if err := conn.WriteJSON(m); err != nil {
+				return
+			}
+		}
+
+		for {
+			m := &message{}
+			if err := conn.ReadJSON(m); err != nil {
+				return
+			}
+
+			answers := []*message{{Type: "pong"}}
+			if m.Type != "ping" {
+				received <- m
+				answers = handle(m)
+			}
+			for _, a := range answers {
+				if err := conn.WriteJSON(a); err != nil {
+					return
+				}
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv.Listener.Addr().String(), received
+}
+
+func testRun(t *testing.T, addr string, out *bytes.Buffer, command string, args ...string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	c, err := dial(ctx, addr)
+	if err != nil {
+		t.Fatalf("can't dial a test server: %s", err)
+	}
+	defer c.Close()
+
+	return commands[command].run(ctx, c, newOutput(out, true), args)
+}
+
+func textSent(id string, requestID string, m *chatMessage) *message {
+	return &message{
+		Type:      "text_sent",
+		RequestID: requestID,
+		Message: &chatMessage{
+			ID:    id,
+			From:  testSelf,
+			To:    m.To,
+			Group: m.Group,
+			Text:  m.Text,
+		},
+	}
+}
+
+func decodeMessages(t *testing.T, out *bytes.Buffer) []*chatMessage {
+	mm := []*chatMessage{}
+	decoder := json.NewDecoder(out)
+	for decoder.More() {
+		m := &chatMessage{}
+		if err := decoder.Decode(m); err != nil {
+			t.Fatalf("can't decode output: %s", err)
+		}
+		mm = append(mm, m)
+	}
+	return mm
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+var (
+	addr       = flag.String("addr", "localhost:30003", "address of the ui of a running peer")
+	jsonOutput = flag.Bool("json", false, "print json, one object per line")
+	timeout    = flag.Duration("timeout", 10*time.Second, "time to wait for the peer")
+)
+
+const usage = `p2pctl is a command line client of a running peer.
+
+Usage:
+
+	p2pctl [flags] <command> [arguments]
+
+Commands:
+
+	peers                  list known peers
+	groups                 list groups
+	send <chat> [text]     send a text to a peer or a group, by id or name,
+	                       the text is read from stdin if it is not given
+	history <chat>         print the latest messages of a chat
+	tail                   print incoming messages until interrupted
+	chat <chat>            send lines from stdin and print messages of the chat
+
+Flags:
+
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		<-sigs
+		cancel()
+	}()
+
+	if err := run(ctx, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "p2pctl: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, command string, args []string) error {
+	cmd, ok := commands[command]
+	if !ok {
+		return fmt.Errorf("unknown command %s, see p2pctl -help", command)
+	}
+
+	if len(args) < cmd.minArgs {
+		return fmt.Errorf("not enough arguments for %s, see p2pctl -help", command)
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	c, err := dial(dialCtx, *addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	out := newOutput(os.Stdout, *jsonOutput)
+
+	return cmd.run(ctx, c, out, args)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+)
+
+const timeFormat = "2006-01-02 15:04:05"
+
+// output prints results either for humans, or as json objects one per line
+// for scripts.
+type output struct {
+	json    bool
+	encoder *json.Encoder
+	table   *tabwriter.Writer
+	w       io.Writer
+}
+
+func newOutput(w io.Writer, asJSON bool) *output {
+	return &output{
+		json:    asJSON,
+		encoder: json.NewEncoder(w),
+		table:   tabwriter.NewWriter(w, 0, 4, 2, ' ', 0),
+		w:       w,
+	}
+}
+
+func (o *output) peer(p *peerState) error {
+	if o.json {
+		return o.encoder.Encode(p)
+	}
+
+	status := p.Status
+	if p.Presence != "" && p.Presence != "active" {
+		status += ", " + p.Presence
+	}
+	connected := ""
+	if p.Connected {
+		connected = "connected"
+	}
+	_, err := fmt.Fprintf(o.table, "%s\t%s\t%s\t%s\n", p.ID, p.Name, status, connected)
+	return err
+}
+
+func (o *output) group(g *group) error {
+	if o.json {
+		return o.encoder.Encode(g)
+	}
+
+	names := make([]string, 0, len(g.Members))
+	for _, m := range g.Members {
+		names = append(names, m.Name)
+	}
+	_, err := fmt.Fprintf(o.table, "%s\t%s\t%s\n", g.ID, g.Name, strings.Join(names, ", "))
+	return err
+}
+
+func (o *output) message(c *client, m *chatMessage) error {
+	if o.json {
+		// known peers of peers are of no use to scripts.
+		copied := *m
+		copied.From = m.From.short()
+		copied.To = m.To.short()
+		return o.encoder.Encode(&copied)
+	}
+
+	chat := c.name(m.To)
+	switch {
+	case m.Group != nil:
+		chat = "#" + m.Group.Name
+		if g, ok := c.groups[m.Group.ID]; ok {
+			chat = "#" + g.Name
+		}
+	case c.self != nil && m.To != nil && m.To.ID == c.self.ID:
+		chat = c.name(m.From)
+	}
+
+	text := m.Text
+	switch {
+	case m.Deleted:
+		text = "(deleted)"
+	case m.File != nil:
+		text = fmt.Sprintf("[%s, %d bytes]", m.File.Name, m.File.Size)
+	}
+	if m.ReplyTo != nil {
+		text = fmt.Sprintf("(reply to %q) %s", m.ReplyTo.Quote, text)
+	}
+
+	_, err := fmt.Fprintf(o.w, "%s %s %s: %s\n", m.Timestamp.Local().Format(timeFormat), chat, c.name(m.From), text)
+	return err
+}
+
+// flush writes tables.
+func (o *output) flush() error {
+	return o.table.Flush()
+}
@@ -4,30 +4,45 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/ngalayko/p2p/client"
 	"github.com/ngalayko/p2p/instance"
+	"github.com/ngalayko/p2p/instance/peers"
 	"github.com/ngalayko/p2p/logger"
 )
 
 var (
-	logLevel          = flag.String("log_level", "info", "logging level [debug|info|warning|error|panic]")
-	udp6Multicast     = flag.String("udp6_multicast", "[ff02::114]", "multicast addr for udp6 discrvery")
-	udp4Multicast     = flag.String("udp4_multicast", "239.255.255.250", "multicast addr for udp4 discrvery")
-	consulAddr        = flag.String("consul", "consul:8500", "consul address")
-	port              = flag.Int("port", 30000, "port to listen for messages")
-	insecurePort      = flag.Int("insecure_port", 30001, "port to listen for greetings")
-	discoveryPort     = flag.String("discovery_port", "30002", "port to discover other peers")
-	uiPort            = flag.Int("ui_port", 30003, "port to serve ui interface")
-	discoveryInterval = flag.Duration("discovery_interval", 1*time.Second, "interval to send discovery broadcast")
-	statisPath        = flag.String("static_path", "./client/public", "path to static files for ui")
-	keySize           = flag.Int("key_size", 1024, "private key size")
-	delay             = flag.Duration("delay", time.Second, "max delay before start")
+	logLevel           = flag.String("log_level", "info", "logging level [debug|info|warning|error|panic]")
+	udp6Multicast      = flag.String("udp6_multicast", "[ff02::114]", "multicast addr for udp6 discrvery")
+	udp4Multicast      = flag.String("udp4_multicast", "239.255.255.250", "multicast addr for udp4 discrvery")
+	consulAddr         = flag.String("consul", "consul:8500", "consul address")
+	seedAddrs          = flag.String("seeds", "", "comma separated host:insecure_port addresses of peers to greet")
+	seedsFile          = flag.String("seeds_file", "", "path to a file with host:insecure_port addresses of peers to greet, one per line")
+	seedsInterval      = flag.Duration("seeds_interval", 30*time.Second, "interval to greet seeds")
+	mdnsAddr           = flag.String("mdns", "", "mdns multicast addr, e.g. 224.0.0.251:5353, empty to disable mdns discovery")
+	port               = flag.Int("port", 30000, "port to listen for messages")
+	insecurePort       = flag.Int("insecure_port", 30001, "port to listen for greetings")
+	discoveryPort      = flag.String("discovery_port", "30002", "port to discover other peers")
+	uiPort             = flag.Int("ui_port", 30003, "port to serve ui interface")
+	discoveryInterval  = flag.Duration("discovery_interval", 1*time.Second, "interval to send discovery broadcast")
+	statisPath         = flag.String("static_path", "./client/public", "path to static files for ui")
+	apiToken           = flag.String("api_token", "", "token to require from api clients, empty to allow only clients on the loopback interface")
+	webhookURLs        = flag.String("webhooks", "", "comma separated urls to post received messages to")
+	webhookSecret      = flag.String("webhook_secret", "", "secret to sign webhooks with, empty to post them unsigned")
+	botSecret          = flag.String("bot_secret", "", "secret to check messages from bots, empty to disable messages from bots")
+	keySize            = flag.Int("key_size", 1024, "private key size")
+	delay              = flag.Duration("delay", time.Second, "max delay before start")
+	dataDir            = flag.String("data_dir", "./data", "path to store peer identity, empty to start with a new one every time")
+	peerAwayTimeout    = flag.Duration("peer_away_timeout", 10*time.Second, "time since a peer was seen the last time, after which it is away")
+	peerOfflineTimeout = flag.Duration("peer_offline_timeout", time.Minute, "time since a peer was seen the last time, after which it is offline")
+	peerRemoveTimeout  = flag.Duration("peer_remove_timeout", time.Hour, "time since a peer was seen the last time, after which it is removed")
 )
 
 func main() {
@@ -42,17 +57,35 @@ func main() {
 	log.Info("waiting for %s", d)
 	time.Sleep(d)
 
+	seeds := splitList(*seedAddrs)
+	if *seedsFile != "" {
+		fileSeeds, err := readSeeds(*seedsFile)
+		if err != nil {
+			log.Panic("can't read seeds: %s", err)
+		}
+		seeds = append(seeds, fileSeeds...)
+	}
+
 	inst := instance.New(
 		log,
 		*udp4Multicast,
 		*udp6Multicast,
 		*consulAddr,
+		*mdnsAddr,
+		seeds,
+		*seedsInterval,
 		*discoveryPort,
 		*uiPort,
 		*port,
 		*insecurePort,
 		*discoveryInterval,
 		*keySize,
+		*dataDir,
+		peers.Timeouts{
+			Away:    *peerAwayTimeout,
+			Offline: *peerOfflineTimeout,
+			Remove:  *peerRemoveTimeout,
+		},
 	)
 
 	client := client.New(
@@ -60,6 +93,10 @@ func main() {
 		fmt.Sprintf("0.0.0.0:%d", *uiPort),
 		inst,
 		*statisPath,
+		*apiToken,
+		splitList(*webhookURLs),
+		*webhookSecret,
+		*botSecret,
 	)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -96,3 +133,32 @@ func main() {
 
 	time.Sleep(5 * time.Second)
 }
+
+func splitList(s string) []string {
+	items := []string{}
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// readSeeds reads seed addresses from the file, skipping empty lines and
+// lines starting with #.
+func readSeeds(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	seeds := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		seeds = append(seeds, line)
+	}
+	return seeds, nil
+}
@@ -20,5 +20,6 @@ require (
 	github.com/square/certstrap v1.1.1
 	github.com/stretchr/testify v1.3.0
 	golang.org/x/net v0.0.0-20190228165749-92fc7df08ae7
+	golang.org/x/sys v0.0.0-20181026203630-95b1ffbd15a5
 	google.golang.org/grpc v1.19.0
 )
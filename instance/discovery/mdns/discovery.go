@@ -0,0 +1,408 @@
+package mdns
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/net/ipv4"
+
+	"github.com/ngalayko/p2p/instance/discovery/multicast"
+	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/logger"
+)
+
+const (
+	// DefaultAddr is the mdns multicast address.
+	DefaultAddr = "224.0.0.251:5353"
+
+	// service is a DNS-SD service type of peers.
+	service = "_p2pchat._tcp.local."
+
+	ttl             = 120
+	maxDatagramSize = 9000
+
+	// beaconChunkSize fits a chunk of a beacon with its key into a txt
+	// string, which is at most 255 bytes.
+	beaconChunkSize = 240
+)
+
+// txt record keys.
+const (
+	keyID           = "id"
+	keyName         = "name"
+	keyPort         = "port"
+	keyInsecurePort = "insecure_port"
+	keyUIPort       = "ui_port"
+	// keyBeacon is a prefix of keys of chunks of a base64 encoded signed
+	// beacon: beacon0, beacon1 and so on.
+	keyBeacon = "beacon"
+)
+
+// Discovery is a mdns discovery. It advertises the peer as a DNS-SD service,
+// and browses for services of other peers. Txt records have a signed beacon
+// of the peer, and only peers with a valid beacon are found. Other records
+// are for DNS-SD browsers.
+type Discovery struct {
+	log      *logger.Logger
+	addr     *net.UDPAddr
+	interval time.Duration
+	self     *peers.Peer
+
+	announcements *peers.Announcements
+	limiter       *multicast.Limiter
+	answers       *throttle
+}
+
+// New returns a new discovery instance.
+func New(
+	log *logger.Logger,
+	addr string,
+	interval time.Duration,
+	self *peers.Peer,
+) *Discovery {
+
+	log = log.Prefix("mdns-discovery")
+
+	a, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		log.Panic("can't resolve %s: %s", addr, err)
+	}
+
+	return &Discovery{
+		log:      log,
+		addr:     a,
+		interval: interval,
+		self:     self,
+
+		announcements: peers.NewAnnouncements(),
+		limiter:       multicast.NewLimiter(),
+		answers:       newThrottle(),
+	}
+}
+
+// Discover implements Discovery interface. The channel is closed if the
+// discovery can't listen to the address.
+func (d *Discovery) Discover(ctx context.Context) <-chan *peers.Peer {
+	out := make(chan *peers.Peer)
+
+	conn, err := listenPacket(ctx, d.addr)
+	if err != nil {
+		d.log.Error("can't listen to %s: %s", d.addr, err)
+		close(out)
+		return out
+	}
+
+	pconn := ipv4.NewPacketConn(conn)
+	d.joinGroup(pconn)
+
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	go d.advertise(ctx, pconn)
+	go d.listen(ctx, pconn, out)
+	return out
+}
+
+// listenPacket listens to the address, sharing the port with other mdns
+// responders on the host.
+func listenPacket(ctx context.Context, addr *net.UDPAddr) (net.PacketConn, error) {
+	lc := &net.ListenConfig{
+		Control: reuse,
+	}
+	return lc.ListenPacket(ctx, "udp4", addr.String())
+}
+
+// joinGroup joins the multicast group on every multicast interface.
+func (d *Discovery) joinGroup(pconn *ipv4.PacketConn) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		d.log.Error("can't list interfaces: %s", err)
+		return
+	}
+
+	for i := range ifaces {
+		if ifaces[i].Flags&net.FlagMulticast == 0 || ifaces[i].Flags&net.FlagUp == 0 {
+			continue
+		}
+		if err := pconn.JoinGroup(&ifaces[i], d.addr); err != nil {
+			d.log.Debug("can't join %s on %s: %s", d.addr, ifaces[i].Name, err)
+		}
+	}
+
+	if err := pconn.SetMulticastLoopback(true); err != nil {
+		d.log.Debug("can't enable multicast loopback: %s", err)
+	}
+}
+
+// advertise asks for other peers once, and announces self, so peers that
+// missed the answer still find it. The interval between announcements
+// doubles up to maxAnnounceInterval.
+func (d *Discovery) advertise(ctx context.Context, pconn *ipv4.PacketConn) {
+	d.log.Info("start advertising %s at %s", service, d.addr)
+
+	if err := d.send(pconn, d.query()); err != nil {
+		d.log.Error("can't send a query: %s", err)
+	}
+
+	interval := d.interval
+	for {
+		select {
+		case <-ctx.Done():
+			d.log.Info("stop advertising")
+			return
+		case <-time.After(interval):
+			if err := d.sendAnswer(pconn); err != nil {
+				d.log.Error("can't send an announcement: %s", err)
+			}
+		}
+
+		interval *= 2
+		if interval > maxAnnounceInterval {
+			interval = maxAnnounceInterval
+		}
+	}
+}
+
+func (d *Discovery) listen(ctx context.Context, pconn *ipv4.PacketConn, out chan<- *peers.Peer) {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, _, src, err := pconn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				d.log.Info("stop listening")
+				return
+			default:
+			}
+			d.log.Error("error reading from socket: %s", err)
+			continue
+		}
+
+		// anyone can send a datagram, so they are limited before answers
+		// and verification.
+		ip := src.(*net.UDPAddr).IP
+		if !d.limiter.Allow(ip.String(), time.Now()) {
+			d.log.Debug("dropped a message from %s over the limit", ip)
+			continue
+		}
+
+		m := &dnsmessage.Message{}
+		if err := m.Unpack(buf[:n]); err != nil {
+			// not every mdns message is supported, and other services use
+			// the same group.
+			continue
+		}
+
+		if !m.Header.Response {
+			if asksForService(m) {
+				if err := d.sendAnswer(pconn); err != nil {
+					d.log.Error("can't answer a query: %s", err)
+				}
+			}
+			continue
+		}
+
+		for _, peer := range d.parsePeers(m) {
+			if peer.ID == d.self.ID {
+				continue
+			}
+
+			peer.Addrs.Add(ip)
+
+			select {
+			case out <- peer:
+			case <-ctx.Done():
+				return
+			}
+
+			d.log.Debug("found a peer at %s", ip)
+		}
+	}
+}
+
+func (d *Discovery) send(pconn *ipv4.PacketConn, m *dnsmessage.Message) error {
+	data, err := m.Pack()
+	if err != nil {
+		return fmt.Errorf("can't pack message: %s", err)
+	}
+
+	_, err = pconn.WriteTo(data, nil, d.addr)
+	return err
+}
+
+// sendAnswer sends the answer with a new beacon, unless an answer was sent
+// recently.
+func (d *Discovery) sendAnswer(pconn *ipv4.PacketConn) error {
+	if !d.answers.allow(time.Now()) {
+		return nil
+	}
+
+	m, err := d.answer()
+	if err != nil {
+		return err
+	}
+	return d.send(pconn, m)
+}
+
+// query asks for instances of the service.
+func (d *Discovery) query() *dnsmessage.Message {
+	return &dnsmessage.Message{
+		Questions: []dnsmessage.Question{
+			{
+				Name:  dnsmessage.MustNewName(service),
+				Type:  dnsmessage.TypePTR,
+				Class: dnsmessage.ClassINET,
+			},
+		},
+	}
+}
+
+// answer is the service instance of the peer. There is no address record,
+// the address the answer is received from is used instead.
+func (d *Discovery) answer() (*dnsmessage.Message, error) {
+	signed, err := d.self.Announce()
+	if err != nil {
+		return nil, fmt.Errorf("can't create announcement: %s", err)
+	}
+
+	txt := []string{
+		keyID + "=" + d.self.ID,
+		keyName + "=" + d.self.Name,
+		keyPort + "=" + strconv.Itoa(d.self.Port),
+		keyInsecurePort + "=" + strconv.Itoa(d.self.InsecurePort),
+		keyUIPort + "=" + strconv.Itoa(d.self.UIPort),
+	}
+	encoded := base64.StdEncoding.EncodeToString(signed)
+	for i := 0; len(encoded) > 0; i++ {
+		n := beaconChunkSize
+		if n > len(encoded) {
+			n = len(encoded)
+		}
+		txt = append(txt, keyBeacon+strconv.Itoa(i)+"="+encoded[:n])
+		encoded = encoded[n:]
+	}
+
+	instance := dnsmessage.MustNewName(d.self.ID + "." + service)
+	return &dnsmessage.Message{
+		Header: dnsmessage.Header{
+			Response:      true,
+			Authoritative: true,
+		},
+		Answers: []dnsmessage.Resource{
+			{
+				Header: header(dnsmessage.MustNewName(service)),
+				Body: &dnsmessage.PTRResource{
+					PTR: instance,
+				},
+			},
+			{
+				Header: header(instance),
+				Body: &dnsmessage.SRVResource{
+					Target: dnsmessage.MustNewName(d.self.ID + ".local."),
+					Port:   uint16(d.self.Port),
+				},
+			},
+			{
+				Header: header(instance),
+				Body: &dnsmessage.TXTResource{
+					TXT: txt,
+				},
+			},
+		},
+	}, nil
+}
+
+func header(name dnsmessage.Name) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{
+		Name:  name,
+		Class: dnsmessage.ClassINET,
+		TTL:   ttl,
+	}
+}
+
+func asksForService(m *dnsmessage.Message) bool {
+	for _, q := range m.Questions {
+		if q.Name.String() != service {
+			continue
+		}
+		if q.Type == dnsmessage.TypePTR || q.Type == dnsmessage.TypeALL {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePeers returns peers with valid beacons from txt records of service
+// instances.
+func (d *Discovery) parsePeers(m *dnsmessage.Message) []*peers.Peer {
+	pp := []*peers.Peer{}
+
+	records := append(m.Answers, m.Additionals...)
+	for _, r := range records {
+		txt, ok := r.Body.(*dnsmessage.TXTResource)
+		if !ok || !strings.HasSuffix(r.Header.Name.String(), "."+service) {
+			continue
+		}
+
+		// records with zero ttl say the service is gone.
+		if r.Header.TTL == 0 {
+			continue
+		}
+
+		signed, err := parseBeacon(txt.TXT)
+		if err != nil {
+			d.log.Debug("dropped a record of %s: %s", r.Header.Name, err)
+			continue
+		}
+
+		// anyone can answer, so forgeries are expected and are not worth
+		// an error.
+		peer, err := d.announcements.Verify(signed)
+		if err != nil {
+			d.log.Debug("dropped an announcement of %s: %s", r.Header.Name, err)
+			continue
+		}
+
+		pp = append(pp, peer)
+	}
+	return pp
+}
+
+// parseBeacon returns a signed beacon from its chunks in txt strings.
+func parseBeacon(txt []string) ([]byte, error) {
+	values := map[string]string{}
+	for _, kv := range txt {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		values[parts[0]] = parts[1]
+	}
+
+	encoded := strings.Builder{}
+	for i := 0; ; i++ {
+		chunk, ok := values[keyBeacon+strconv.Itoa(i)]
+		if !ok {
+			break
+		}
+		encoded.WriteString(chunk)
+	}
+
+	if encoded.Len() == 0 {
+		return nil, fmt.Errorf("no beacon")
+	}
+
+	signed, err := base64.StdEncoding.DecodeString(encoded.String())
+	if err != nil {
+		return nil, fmt.Errorf("can't decode beacon: %s", err)
+	}
+	return signed, nil
+}
@@ -0,0 +1,181 @@
+package mdns
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+
+	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/logger"
+)
+
+const testAddr = "224.0.0.251:15353"
+
+func Test_Discover__should_find_each_other(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	first := testPeer(t, 1000)
+	second := testPeer(t, 2000)
+
+	firstFound := New(logger.New(logger.LevelDebug), testAddr, 100*time.Millisecond, first).Discover(ctx)
+	secondFound := New(logger.New(logger.LevelDebug), testAddr, 100*time.Millisecond, second).Discover(ctx)
+
+	found := waitPeer(ctx, firstFound, second.ID)
+	if assert.NotNil(t, found) {
+		assert.Equal(t, second.Name, found.Name)
+		assert.Equal(t, second.Port, found.Port)
+		assert.Equal(t, second.InsecurePort, found.InsecurePort)
+		assert.Equal(t, second.UIPort, found.UIPort)
+		assert.NotEmpty(t, found.Addrs.Map())
+	}
+
+	assert.NotNil(t, waitPeer(ctx, secondFound, first.ID))
+}
+
+func Test_Discover__should_not_find_self(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	self := testPeer(t, 3000)
+
+	found := New(logger.New(logger.LevelDebug), testAddr, 100*time.Millisecond, self).Discover(ctx)
+
+	assert.Nil(t, waitPeer(ctx, found, self.ID))
+}
+
+func Test_Discover__should_share_the_port_with_other_responders(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	a, err := net.ResolveUDPAddr("udp4", testAddr)
+	assert.NoError(t, err)
+
+	responder, err := listenPacket(ctx, a)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer responder.Close()
+
+	first := testPeer(t, 1000)
+	second := testPeer(t, 2000)
+
+	firstFound := New(logger.New(logger.LevelDebug), testAddr, 100*time.Millisecond, first).Discover(ctx)
+	New(logger.New(logger.LevelDebug), testAddr, 100*time.Millisecond, second).Discover(ctx)
+
+	assert.NotNil(t, waitPeer(ctx, firstFound, second.ID))
+}
+
+func Test_Discover__should_close_the_channel_if_it_can_not_listen(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the address is not local, so it can't be listened to.
+	found := New(logger.New(logger.LevelDebug), "192.0.2.1:15353", time.Second, testPeer(t, 1000)).Discover(ctx)
+
+	select {
+	case _, ok := <-found:
+		assert.False(t, ok)
+	case <-ctx.Done():
+		t.Fatal("the channel is not closed")
+	}
+}
+
+func Test_parsePeers__should_find_only_peers_with_valid_beacons(t *testing.T) {
+	sender := New(logger.New(logger.LevelDebug), testAddr, time.Second, testPeer(t, 4000))
+	receiver := New(logger.New(logger.LevelDebug), testAddr, time.Second, testPeer(t, 5000))
+
+	signed, err := sender.answer()
+	if err != nil {
+		t.Fatalf("can't create an answer: %s", err)
+	}
+
+	// the same records, but without the beacon.
+	unsigned := testRecords(signed, func(txt []string) []string {
+		plain := []string{}
+		for _, kv := range txt {
+			if !strings.HasPrefix(kv, keyBeacon) {
+				plain = append(plain, kv)
+			}
+		}
+		return plain
+	})
+
+	// the beacon of the sender with the name changed.
+	forged := testRecords(signed, func(txt []string) []string {
+		encoded := ""
+		for _, kv := range txt {
+			if strings.HasPrefix(kv, keyBeacon) {
+				encoded += strings.SplitN(kv, "=", 2)[1]
+			}
+		}
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatalf("can't decode a beacon: %s", err)
+		}
+		i := bytes.Index(data, []byte(sender.self.Name))
+		if i < 0 {
+			t.Fatal("no name in the beacon")
+		}
+		data[i] ^= 0x01
+		return []string{keyBeacon + "0=" + base64.StdEncoding.EncodeToString(data)}
+	})
+
+	assert.Empty(t, receiver.parsePeers(unsigned))
+	assert.Empty(t, receiver.parsePeers(forged))
+
+	found := receiver.parsePeers(signed)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, sender.self.ID, found[0].ID)
+		assert.Equal(t, sender.self.Name, found[0].Name)
+		assert.Equal(t, sender.self.Port, found[0].Port)
+	}
+
+	assert.Empty(t, receiver.parsePeers(signed), "a replayed beacon must be dropped")
+}
+
+//
+// helpers
+//
+
+// testRecords returns a copy of the message with txt records changed.
+func testRecords(m *dnsmessage.Message, change func(txt []string) []string) *dnsmessage.Message {
+	copied := &dnsmessage.Message{Header: m.Header}
+	for _, r := range m.Answers {
+		if txt, ok := r.Body.(*dnsmessage.TXTResource); ok {
+			r.Body = &dnsmessage.TXTResource{TXT: change(txt.TXT)}
+		}
+		copied.Answers = append(copied.Answers, r)
+	}
+	return copied
+}
+
+func testPeer(t *testing.T, port int) *peers.Peer {
+	p, err := peers.New(rand.New(rand.NewSource(time.Now().UnixNano())), port, port+1, port+2, 512)
+	if err != nil {
+		t.Fatalf("can't create a test peer: %s", err)
+	}
+	return p
+}
+
+// waitPeer returns the peer once it's found, or nil when the context is done.
+func waitPeer(ctx context.Context, found <-chan *peers.Peer, peerID string) *peers.Peer {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case p := <-found:
+			if p.ID == peerID {
+				return p
+			}
+		}
+	}
+}
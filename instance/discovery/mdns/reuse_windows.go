@@ -0,0 +1,17 @@
+package mdns
+
+import (
+	"syscall"
+)
+
+// reuse lets the socket share the mdns port with other responders, like
+// Bonjour.
+func reuse(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = syscall.SetsockoptInt(syscall.Handle(fd), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package mdns
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// minAnswerDelay is the least time between answers, a responder must not
+	// multicast a record more often. While queries keep coming, the delay
+	// doubles up to maxAnswerDelay.
+	minAnswerDelay = time.Second
+	maxAnswerDelay = 16 * time.Second
+
+	// maxAnnounceInterval is the longest time between announcements, it is
+	// less than the ttl of records, so they don't expire.
+	maxAnnounceInterval = time.Minute
+)
+
+// throttle limits answers, both to queries and announcements.
+type throttle struct {
+	guard *sync.Mutex
+	delay time.Duration
+	next  time.Time
+}
+
+func newThrottle() *throttle {
+	return &throttle{
+		guard: &sync.Mutex{},
+		delay: minAnswerDelay,
+	}
+}
+
+// allow returns true if an answer can be sent now.
+func (t *throttle) allow(now time.Time) bool {
+	t.guard.Lock()
+	defer t.guard.Unlock()
+
+	if now.Before(t.next) {
+		return false
+	}
+
+	if now.Sub(t.next) < t.delay {
+		t.delay *= 2
+		if t.delay > maxAnswerDelay {
+			t.delay = maxAnswerDelay
+		}
+	} else {
+		t.delay = minAnswerDelay
+	}
+	t.next = now.Add(t.delay)
+	return true
+}
@@ -0,0 +1,37 @@
+package mdns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_throttle__should_allow_an_answer_per_second(t *testing.T) {
+	th := newThrottle()
+	now := time.Now()
+
+	assert.True(t, th.allow(now))
+	assert.False(t, th.allow(now.Add(minAnswerDelay/2)))
+	assert.True(t, th.allow(now.Add(10*minAnswerDelay)))
+}
+
+func Test_throttle__should_back_off_while_queries_keep_coming(t *testing.T) {
+	th := newThrottle()
+	now := time.Now()
+
+	assert.True(t, th.allow(now))
+	assert.True(t, th.allow(now.Add(minAnswerDelay)))
+	assert.False(t, th.allow(now.Add(2*minAnswerDelay)))
+	assert.True(t, th.allow(now.Add(3*minAnswerDelay)))
+
+	for i := 0; i < 10; i++ {
+		now = th.next
+		assert.True(t, th.allow(now))
+	}
+	assert.Equal(t, maxAnswerDelay, th.delay)
+
+	// the delay is reset after a quiet period.
+	assert.True(t, th.allow(now.Add(time.Hour)))
+	assert.Equal(t, minAnswerDelay, th.delay)
+}
@@ -3,6 +3,7 @@ package merge
 import (
 	"context"
 	"fmt"
+	"net"
 	"testing"
 
 	"github.com/ngalayko/p2p/instance/discovery/mock"
@@ -39,3 +40,22 @@ func Test_Discover__should_merge_0_services(t *testing.T) {
 
 	assert.Equal(t, 0, cc)
 }
+
+func Test_Discover__should_not_limit_peers_from_the_same_address(t *testing.T) {
+	pp := []*peers.Peer{}
+	for i := 0; i < 100; i++ {
+		p := peers.NewBlank()
+		p.ID = fmt.Sprintf("%d", i)
+		p.Addrs.Add(net.ParseIP("10.0.0.1"))
+		pp = append(pp, p)
+	}
+
+	d := New(mock.New(pp...), mock.New(pp...))
+
+	cc := 0
+	for range d.Discover(context.Background()) {
+		cc++
+	}
+
+	assert.Equal(t, 2*len(pp), cc)
+}
@@ -0,0 +1,80 @@
+package multicast
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// maxPerSecond is how many datagrams are read from one address per
+	// second on average, and maxBurst is how many at once. Every peer on a
+	// host sends one datagram per interval.
+	maxPerSecond = 10
+	maxBurst     = 40
+
+	pruneInterval = time.Minute
+)
+
+// Limiter is a token bucket per source address of datagrams. Anyone can send
+// them, so they are limited before the signature is checked.
+type Limiter struct {
+	guard     *sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewLimiter is a limiter constructor.
+func NewLimiter() *Limiter {
+	return &Limiter{
+		guard:     &sync.Mutex{},
+		buckets:   map[string]*bucket{},
+		lastPrune: time.Now(),
+	}
+}
+
+// Allow takes a token of the address, and returns false if there are none.
+func (l *Limiter) Allow(addr string, now time.Time) bool {
+	l.guard.Lock()
+	defer l.guard.Unlock()
+
+	if now.Sub(l.lastPrune) > pruneInterval {
+		l.prune(now)
+	}
+
+	b, ok := l.buckets[addr]
+	if !ok {
+		b = &bucket{
+			tokens:  maxBurst,
+			updated: now,
+		}
+		l.buckets[addr] = b
+	}
+
+	b.tokens += now.Sub(b.updated).Seconds() * maxPerSecond
+	if b.tokens > maxBurst {
+		b.tokens = maxBurst
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
+
+// prune forgets addresses with full buckets, they are the same as new ones.
+func (l *Limiter) prune(now time.Time) {
+	for addr, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*maxPerSecond >= maxBurst {
+			delete(l.buckets, addr)
+		}
+	}
+	l.lastPrune = now
+}
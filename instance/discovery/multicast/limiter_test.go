@@ -0,0 +1,42 @@
+package multicast
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Limiter__should_limit_an_address(t *testing.T) {
+	l := NewLimiter()
+	now := time.Now()
+
+	for i := 0; i < maxBurst; i++ {
+		assert.True(t, l.Allow("10.0.0.1", now), i)
+	}
+	assert.False(t, l.Allow("10.0.0.1", now))
+
+	assert.True(t, l.Allow("10.0.0.1", now.Add(time.Second/maxPerSecond)))
+	assert.False(t, l.Allow("10.0.0.1", now.Add(time.Second/maxPerSecond)))
+}
+
+func Test_Limiter__should_not_limit_other_addresses(t *testing.T) {
+	l := NewLimiter()
+	now := time.Now()
+
+	for i := 0; i < maxBurst; i++ {
+		l.Allow("10.0.0.1", now)
+	}
+
+	assert.True(t, l.Allow("10.0.0.2", now))
+}
+
+func Test_Limiter__should_forget_full_buckets(t *testing.T) {
+	l := NewLimiter()
+	now := time.Now()
+
+	l.Allow("10.0.0.1", now)
+	l.Allow("10.0.0.2", now.Add(pruneInterval+time.Second))
+
+	assert.Len(t, l.buckets, 1)
+}
@@ -0,0 +1,88 @@
+// Package multicast has what multicast discoveries share.
+package multicast
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/logger"
+)
+
+// Group joins a multicast group on an interface, it is implemented by both
+// ipv4 and ipv6 packet connections.
+type Group interface {
+	JoinGroup(ifi *net.Interface, group net.Addr) error
+}
+
+// Listen reads announcements from the connection until the context is done,
+// and sends peers with a valid announcement to out. Datagrams from an address
+// that sends too many are dropped before they are verified. The connection joins the
+// group on every interface before each read, so new interfaces are listened
+// to as well.
+func Listen(
+	ctx context.Context,
+	log *logger.Logger,
+	conn *net.UDPConn,
+	group Group,
+	addr net.Addr,
+	maxDatagramSize int,
+	announcements *peers.Announcements,
+	out chan<- *peers.Peer,
+) {
+	limiter := NewLimiter()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("stop listening")
+			return
+
+		default:
+			ifaces, err := net.Interfaces()
+			if err != nil {
+				log.Error("can't list interfaces")
+				return
+			}
+
+			for i := range ifaces {
+				group.JoinGroup(&ifaces[i], addr)
+			}
+
+			n, src, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				log.Error("error reading from socket: %s", err)
+				continue
+			}
+
+			if n == 0 {
+				continue
+			}
+
+			if !limiter.Allow(src.IP.String(), time.Now()) {
+				log.Debug("dropped an announcement from %s over the limit", src.IP)
+				continue
+			}
+
+			// anyone can send a datagram, so forgeries are expected and
+			// are not worth an error.
+			peer, err := announcements.Verify(buf[:n])
+			if err != nil {
+				log.Debug("dropped an announcement from %s: %s", src.IP, err)
+				continue
+			}
+
+			peer.Addrs.Add(src.IP)
+
+			select {
+			case out <- peer:
+			case <-ctx.Done():
+				return
+			}
+
+			log.Debug("found a peer at %s", src.IP)
+		}
+	}
+}
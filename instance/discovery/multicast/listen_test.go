@@ -0,0 +1,113 @@
+package multicast
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/logger"
+)
+
+func Test_Listen__should_send_only_verified_peers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("can't listen: %s", err)
+	}
+	defer conn.Close()
+
+	out := make(chan *peers.Peer)
+	go Listen(ctx, logger.New(logger.LevelDebug), conn, &testGroup{}, conn.LocalAddr(), 65507, peers.NewAnnouncements(), out)
+
+	forged := testPeer(t)
+	forgedPayload, err := forged.Announce()
+	assert.NoError(t, err)
+	forgedPayload[len(forgedPayload)/2] ^= 0xff
+
+	sender := testPeer(t)
+	payload, err := sender.Announce()
+	assert.NoError(t, err)
+
+	for _, datagram := range [][]byte{[]byte("garbage"), forgedPayload, payload} {
+		if _, err := conn.WriteToUDP(datagram, conn.LocalAddr().(*net.UDPAddr)); err != nil {
+			t.Fatalf("can't send a datagram: %s", err)
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("the peer is not found")
+	case found := <-out:
+		assert.Equal(t, sender.ID, found.ID)
+		assert.Contains(t, found.Addrs.Map(), "127.0.0.1")
+	}
+
+	// a replayed announcement is dropped as well.
+	if _, err := conn.WriteToUDP(payload, conn.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatalf("can't send a datagram: %s", err)
+	}
+
+	select {
+	case found := <-out:
+		t.Fatalf("unexpected peer %s", found.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func Test_Listen__should_limit_datagrams_before_verifying_them(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("can't listen: %s", err)
+	}
+	defer conn.Close()
+
+	out := make(chan *peers.Peer)
+	go Listen(ctx, logger.New(logger.LevelDebug), conn, &testGroup{}, conn.LocalAddr(), 65507, peers.NewAnnouncements(), out)
+
+	sender := testPeer(t)
+	payload, err := sender.Announce()
+	assert.NoError(t, err)
+
+	for i := 0; i < 2*maxBurst; i++ {
+		if _, err := conn.WriteToUDP([]byte("garbage"), conn.LocalAddr().(*net.UDPAddr)); err != nil {
+			t.Fatalf("can't send a datagram: %s", err)
+		}
+	}
+	if _, err := conn.WriteToUDP(payload, conn.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatalf("can't send a datagram: %s", err)
+	}
+
+	select {
+	case found := <-out:
+		t.Fatalf("unexpected peer %s", found.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+//
+// helpers
+//
+
+type testGroup struct{}
+
+func (g *testGroup) JoinGroup(ifi *net.Interface, group net.Addr) error {
+	return nil
+}
+
+func testPeer(t *testing.T) *peers.Peer {
+	p, err := peers.New(rand.New(rand.NewSource(time.Now().UnixNano())), 1000, 1001, 1002, 512)
+	if err != nil {
+		t.Fatalf("can't create a test peer: %s", err)
+	}
+	return p
+}
@@ -0,0 +1,180 @@
+package seeds
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/ngalayko/p2p/instance/messages/proto/greeter"
+	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/logger"
+)
+
+const (
+	greetTimeout = 10 * time.Second
+
+	minRetryInterval = time.Second
+	maxRetryInterval = time.Minute
+)
+
+// Discovery greets seeds with known addresses, and finds them and the peers
+// they know.
+type Discovery struct {
+	log      *logger.Logger
+	seeds    []string
+	interval time.Duration
+	greeting func() (*greeter.Peer, error)
+
+	minRetryInterval time.Duration
+}
+
+// New returns a new discovery instance. Seeds are host:port addresses of
+// greeting servers, greeting returns self to greet them with.
+func New(
+	log *logger.Logger,
+	seeds []string,
+	interval time.Duration,
+	greeting func() (*greeter.Peer, error),
+) *Discovery {
+
+	log = log.Prefix("seeds-discovery")
+
+	for _, seed := range seeds {
+		if _, _, err := net.SplitHostPort(seed); err != nil {
+			log.Panic("invalid seed %s: %s", seed, err)
+		}
+	}
+
+	return &Discovery{
+		log:              log,
+		seeds:            seeds,
+		interval:         interval,
+		greeting:         greeting,
+		minRetryInterval: minRetryInterval,
+	}
+}
+
+// Discover implements Discovery interface.
+func (d *Discovery) Discover(ctx context.Context) <-chan *peers.Peer {
+	out := make(chan *peers.Peer)
+	for _, seed := range d.seeds {
+		go d.watchSeed(ctx, seed, out)
+	}
+	return out
+}
+
+// watchSeed greets the seed every interval, and retries with a backoff
+// while it's unreachable.
+func (d *Discovery) watchSeed(ctx context.Context, seed string, out chan<- *peers.Peer) {
+	attempts := 0
+	for {
+		wait := d.interval
+
+		found, err := d.greet(ctx, seed)
+		if err != nil {
+			attempts++
+			wait = d.backoff(attempts)
+
+			d.log.Debug("can't greet %s, retrying in %s: %s", seed, wait, err)
+		} else {
+			attempts = 0
+		}
+
+		for _, peer := range found {
+			select {
+			case out <- peer:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// greet returns the seed and peers it knows.
+func (d *Discovery) greet(ctx context.Context, seed string) ([]*peers.Peer, error) {
+	host, portString, err := net.SplitHostPort(seed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid seed: %s", err)
+	}
+
+	port, err := strconv.Atoi(portString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port: %s", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, greetTimeout)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve %s: %s", host, err)
+	}
+
+	self, err := d.greeting()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.DialContext(ctx, seed, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return nil, fmt.Errorf("can't connect: %s", err)
+	}
+	defer conn.Close()
+
+	grpcPeer, err := greeter.NewGreeterClient(conn).Greet(ctx, self)
+	if err != nil {
+		return nil, fmt.Errorf("greeting error: %s", err)
+	}
+
+	if err := peers.VerifyPublicCrt(grpcPeer.ID, grpcPeer.PublicKey); err != nil {
+		return nil, fmt.Errorf("invalid certificate: %s", err)
+	}
+
+	seedPeer, err := grpcPeer.MarshalPeer()
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer: %s", err)
+	}
+
+	// the seed is reachable at the address it's greeted at, whatever it
+	// thinks its addresses are.
+	seedPeer.InsecurePort = port
+	for _, ip := range ips {
+		seedPeer.Addrs.Add(ip.IP)
+	}
+
+	found := []*peers.Peer{seedPeer}
+	for _, known := range grpcPeer.KnownPeers {
+		if err := peers.VerifyPublicCrt(known.ID, known.PublicKey); err != nil {
+			d.log.Debug("%s knows %s with an invalid certificate: %s", seedPeer.ID, known.ID, err)
+			continue
+		}
+
+		peer, err := known.MarshalPeer()
+		if err != nil {
+			d.log.Debug("%s knows an invalid peer %s: %s", seedPeer.ID, known.ID, err)
+			continue
+		}
+
+		found = append(found, peer)
+	}
+	return found, nil
+}
+
+func (d *Discovery) backoff(attempts int) time.Duration {
+	interval := d.minRetryInterval << uint(attempts-1)
+	if interval > maxRetryInterval || interval <= 0 {
+		interval = maxRetryInterval
+	}
+	return interval/2 + time.Duration(rand.Int63n(int64(interval/2)+1))
+}
@@ -0,0 +1,150 @@
+package seeds
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	"github.com/ngalayko/p2p/instance/messages/proto/greeter"
+	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/logger"
+)
+
+func Test_Discover__should_find_seed_and_its_known_peers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	seed := testPeer(t)
+	known := testPeer(t)
+	known.Addrs.Add(net.ParseIP("10.0.0.1"))
+	seed.KnownPeers.Add(known)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer serve(t, lis, seed).Stop()
+
+	found := collect(ctx, testDiscovery(lis.Addr().String()).Discover(ctx), seed.ID, known.ID)
+
+	if assert.Contains(t, found, seed.ID) {
+		assert.Equal(t, seed.Name, found[seed.ID].Name)
+		assert.Equal(t, lis.Addr().(*net.TCPAddr).Port, found[seed.ID].InsecurePort)
+		assert.Contains(t, found[seed.ID].Addrs.Map(), "127.0.0.1")
+	}
+	if assert.Contains(t, found, known.ID) {
+		assert.Equal(t, known.Port, found[known.ID].Port)
+		assert.Equal(t, known.InsecurePort, found[known.ID].InsecurePort)
+		assert.Contains(t, found[known.ID].Addrs.Map(), "10.0.0.1")
+	}
+}
+
+func Test_Discover__should_skip_known_peers_with_invalid_certificates(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	seed := testPeer(t)
+	forged := testPeer(t)
+	forged.ID = testPeer(t).ID
+	seed.KnownPeers.Add(forged)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer serve(t, lis, seed).Stop()
+
+	found := collect(ctx, testDiscovery(lis.Addr().String()).Discover(ctx), seed.ID, forged.ID)
+
+	assert.Contains(t, found, seed.ID)
+	assert.NotContains(t, found, forged.ID)
+}
+
+func Test_Discover__should_retry_unreachable_seed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	seed := testPeer(t)
+
+	// the port is free until the seed starts.
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := lis.Addr().String()
+	assert.NoError(t, lis.Close())
+
+	peersFound := testDiscovery(addr).Discover(ctx)
+
+	time.Sleep(200 * time.Millisecond)
+
+	lis, err = net.Listen("tcp", addr)
+	assert.NoError(t, err)
+	defer serve(t, lis, seed).Stop()
+
+	assert.Contains(t, collect(ctx, peersFound, seed.ID), seed.ID)
+}
+
+//
+// helpers
+//
+
+type testGreeter struct {
+	self *peers.Peer
+}
+
+func (g *testGreeter) Greet(ctx context.Context, peer *greeter.Peer) (*greeter.Peer, error) {
+	selfProto := &greeter.Peer{}
+	if err := selfProto.UnmarshalPeer(g.self); err != nil {
+		return nil, err
+	}
+	return selfProto, nil
+}
+
+func serve(t *testing.T, lis net.Listener, self *peers.Peer) *grpc.Server {
+	s := grpc.NewServer()
+	greeter.RegisterGreeterServer(s, &testGreeter{self: self})
+	go func() {
+		_ = s.Serve(lis)
+	}()
+	return s
+}
+
+func testPeer(t *testing.T) *peers.Peer {
+	p, err := peers.New(rand.New(rand.NewSource(time.Now().UnixNano())), 1, 2, 3, 512)
+	if err != nil {
+		t.Fatalf("can't create a test peer: %s", err)
+	}
+	return p
+}
+
+func testDiscovery(seed string) *Discovery {
+	d := New(logger.New(logger.LevelDebug), []string{seed}, 50*time.Millisecond, func() (*greeter.Peer, error) {
+		return &greeter.Peer{ID: "test"}, nil
+	})
+	d.minRetryInterval = 10 * time.Millisecond
+	return d
+}
+
+// collect returns found peers by id once all of the ids are found, or when
+// the context is done.
+func collect(ctx context.Context, found <-chan *peers.Peer, ids ...string) map[string]*peers.Peer {
+	byID := map[string]*peers.Peer{}
+	for {
+		missing := false
+		for _, id := range ids {
+			if _, ok := byID[id]; !ok {
+				missing = true
+			}
+		}
+		if !missing {
+			return byID
+		}
+
+		select {
+		case <-ctx.Done():
+			return byID
+		case p := <-found:
+			byID[p.ID] = p
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"golang.org/x/net/ipv4"
 
+	"github.com/ngalayko/p2p/instance/discovery/multicast"
 	"github.com/ngalayko/p2p/instance/peers"
 	"github.com/ngalayko/p2p/logger"
 )
@@ -20,7 +21,9 @@ type Discovery struct {
 	log      *logger.Logger
 	addr     *net.UDPAddr
 	interval time.Duration
-	payload  []byte
+	self     *peers.Peer
+
+	announcements *peers.Announcements
 }
 
 // New returns a new discovery instance.
@@ -38,16 +41,13 @@ func New(
 		log.Panic("can't resolve %s: %s", addr, err)
 	}
 
-	payload, err := self.Marshal()
-	if err != nil {
-		log.Panic("can't marshal payload: %s", err)
-	}
-
 	return &Discovery{
 		log:      log,
 		addr:     a,
 		interval: interval,
-		payload:  payload,
+		self:     self,
+
+		announcements: peers.NewAnnouncements(),
 	}
 }
 
@@ -77,6 +77,12 @@ func (d *Discovery) broadcast(ctx context.Context, interval time.Duration) {
 			d.log.Info("stop broadcasting")
 			return
 		case <-time.Tick(interval):
+			payload, err := d.self.Announce()
+			if err != nil {
+				d.log.Error("can't create announcement: %s", err)
+				continue
+			}
+
 			ifaces, err := net.Interfaces()
 			if err != nil {
 				d.log.Error("can't list interfaces", err)
@@ -93,7 +99,7 @@ func (d *Discovery) broadcast(ctx context.Context, interval time.Duration) {
 
 				pconn.SetMulticastTTL(2)
 
-				if _, err := pconn.WriteTo(d.payload, nil, d.addr); err != nil {
+				if _, err := pconn.WriteTo(payload, nil, d.addr); err != nil {
 					// d.log.Error("can't send multicast message: %s", err)
 					continue
 				}
@@ -113,48 +119,5 @@ func (d *Discovery) listen(ctx context.Context, out chan *peers.Peer) {
 	}
 	defer conn.Close()
 
-	pconn := ipv4.NewPacketConn(conn)
-
-	buf := make([]byte, maxDatagramSize)
-	for {
-		select {
-		case <-ctx.Done():
-			d.log.Info("stop listening")
-			return
-
-		default:
-			ifaces, err := net.Interfaces()
-			if err != nil {
-				d.log.Error("can't list interfaces")
-				return
-			}
-
-			for i := range ifaces {
-				pconn.JoinGroup(&ifaces[i], d.addr)
-			}
-
-			n, _, src, err := pconn.ReadFrom(buf)
-			if err != nil {
-				d.log.Error("error reading from socket: %s", err)
-				continue
-			}
-
-			if n == 0 {
-				continue
-			}
-
-			peer := &peers.Peer{}
-			if err := peer.Unmarshal(buf[:n]); err != nil {
-				d.log.Error("can't unmarshal peer data: %s", err)
-				continue
-			}
-
-			ip := src.(*net.UDPAddr).IP
-			peer.Addrs.Add(ip)
-
-			out <- peer
-
-			d.log.Debug("found a peer at %s", ip)
-		}
-	}
+	multicast.Listen(ctx, d.log, conn, ipv4.NewPacketConn(conn), d.addr, maxDatagramSize, d.announcements, out)
 }
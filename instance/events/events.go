@@ -0,0 +1,83 @@
+// Package events delivers events to subscribers in the order they are
+// published, and never blocks publishers on slow subscribers.
+package events
+
+import (
+	"sync"
+)
+
+// Event is a published event. Publishers document types of their events.
+type Event interface{}
+
+// Subscription receives events in the order they are published.
+type Subscription struct {
+	b      *Broadcaster
+	events chan Event
+}
+
+// Events returns a channel with events. The channel is closed when the
+// subscription is closed, or when the subscriber falls behind by more than
+// the buffer size. After that the subscriber should subscribe again.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close unsubscribes from the broadcaster.
+func (s *Subscription) Close() {
+	s.b.guard.Lock()
+	defer s.b.guard.Unlock()
+
+	s.b.unsubscribe(s)
+}
+
+// Broadcaster delivers every event to all current subscribers.
+type Broadcaster struct {
+	guard         *sync.Mutex
+	subscriptions map[*Subscription]bool
+}
+
+// New is a broadcaster constructor.
+func New() *Broadcaster {
+	return &Broadcaster{
+		guard:         &sync.Mutex{},
+		subscriptions: map[*Subscription]bool{},
+	}
+}
+
+// Subscribe returns a subscription with a buffer of the size.
+func (b *Broadcaster) Subscribe(buffer int) *Subscription {
+	b.guard.Lock()
+	defer b.guard.Unlock()
+
+	s := &Subscription{
+		b:      b,
+		events: make(chan Event, buffer),
+	}
+	b.subscriptions[s] = true
+	return s
+}
+
+// Publish sends the event to every subscriber without blocking, subscribers
+// with full buffers are unsubscribed.
+func (b *Broadcaster) Publish(e Event) {
+	b.guard.Lock()
+	defer b.guard.Unlock()
+
+	for s := range b.subscriptions {
+		select {
+		case s.events <- e:
+		default:
+			b.unsubscribe(s)
+		}
+	}
+}
+
+// unsubscribe must be called under guard.
+func (b *Broadcaster) unsubscribe(s *Subscription) {
+	if !b.subscriptions[s] {
+		return
+	}
+
+	delete(b.subscriptions, s)
+	close(s.events)
+}
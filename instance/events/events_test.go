@@ -0,0 +1,34 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Broadcaster__should_close_a_slow_subscription(t *testing.T) {
+	b := New()
+
+	slow := b.Subscribe(1)
+	fast := b.Subscribe(2)
+
+	b.Publish("first")
+	b.Publish("second")
+
+	e := <-slow.Events()
+	assert.Equal(t, "first", e)
+	_, ok := <-slow.Events()
+	assert.False(t, ok, "subscription must be closed")
+
+	for _, want := range []string{"first", "second"} {
+		assert.Equal(t, want, <-fast.Events())
+	}
+
+	fast.Close()
+	slow.Close()
+
+	b.Publish("third")
+
+	_, ok = <-fast.Events()
+	assert.False(t, ok, "subscription must be closed")
+}
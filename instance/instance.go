@@ -3,27 +3,39 @@ package instance
 import (
 	"context"
 	"fmt"
+	"io"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/ngalayko/p2p/instance/discovery"
 	"github.com/ngalayko/p2p/instance/discovery/consul"
+	"github.com/ngalayko/p2p/instance/discovery/mdns"
 	"github.com/ngalayko/p2p/instance/discovery/merge"
+	"github.com/ngalayko/p2p/instance/discovery/seeds"
 	"github.com/ngalayko/p2p/instance/discovery/udp4"
 	"github.com/ngalayko/p2p/instance/discovery/udp6"
 	"github.com/ngalayko/p2p/instance/messages"
 	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/instance/store"
+	"github.com/ngalayko/p2p/instance/store/file"
+	"github.com/ngalayko/p2p/instance/store/memory"
 	"github.com/ngalayko/p2p/logger"
 )
 
+const livenessInterval = time.Second
+
 // Instance is a single instance of a p2p messenger.
 type Instance struct {
 	*messages.Handler
 
 	*peers.Peer
 
-	logger    *logger.Logger
-	discovery discovery.Discovery
+	logger       *logger.Logger
+	discovery    discovery.Discovery
+	store        store.Store
+	peerTimeouts peers.Timeouts
 }
 
 // New is a messenger constructor.
@@ -32,25 +44,49 @@ func New(
 	udp4Multicast string,
 	udp6Multicast string,
 	consulAddr string,
+	mdnsAddr string,
+	seedAddrs []string,
+	seedsInterval time.Duration,
 	discoveryPort string,
 	uiPort int,
 	port int,
 	insecurePort int,
 	discoveryInterval time.Duration,
 	keySize int,
+	dataDir string,
+	peerTimeouts peers.Timeouts,
 ) *Instance {
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 
-	self, err := peers.New(r, port, insecurePort, uiPort, keySize)
+	var (
+		self *peers.Peer
+		err  error
+	)
+	if dataDir != "" {
+		self, err = peers.Load(r, dataDir, port, insecurePort, uiPort, keySize)
+	} else {
+		self, err = peers.New(r, port, insecurePort, uiPort, keySize)
+	}
 	if err != nil {
 		log.Panic("can't initialize peer: %s", err)
 	}
 
 	log.Info("peer id: %s, name: %s", self.ID, self.Name)
 
-	msgHandler := messages.NewHandler(r, log, self)
+	var s store.Store = memory.New()
+	filesDir := filepath.Join(os.TempDir(), fmt.Sprintf("p2p-files-%s", self.ID))
+	if dataDir != "" {
+		s, err = file.New(dataDir)
+		if err != nil {
+			log.Panic("can't open messages store: %s", err)
+		}
+		filesDir = filepath.Join(dataDir, "files")
+	}
+
+	msgHandler := messages.NewHandler(r, log, self, s, filesDir)
 
-	dd := []discovery.Discovery{}
+	// peers learned from any discovery are spread by gossip.
+	dd := []discovery.Discovery{msgHandler}
 	if udp6Multicast != "" {
 		dd = append(dd, udp6.New(log, fmt.Sprintf("%s:%s", udp6Multicast, discoveryPort), discoveryInterval, self))
 	}
@@ -60,18 +96,36 @@ func New(
 	if consulAddr != "" {
 		dd = append(dd, consul.New(log, self, consulAddr, discoveryInterval))
 	}
+	if mdnsAddr != "" {
+		dd = append(dd, mdns.New(log, mdnsAddr, discoveryInterval, self))
+	}
+	if len(seedAddrs) > 0 {
+		dd = append(dd, seeds.New(log, seedAddrs, seedsInterval, msgHandler.Greeting))
+	}
 
 	return &Instance{
-		Handler:   msgHandler,
-		Peer:      self,
-		logger:    log,
-		discovery: merge.New(dd...),
+		Handler:      msgHandler,
+		Peer:         self,
+		logger:       log,
+		discovery:    merge.New(dd...),
+		store:        s,
+		peerTimeouts: peerTimeouts,
 	}
 }
 
 // Start starts a messanger instance.
 func (i *Instance) Start(ctx context.Context) error {
 	go i.watchPeers(ctx)
+	go i.watchLiveness(ctx)
+
+	defer func() {
+		if closer, ok := i.store.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				i.logger.Error("can't close messages store: %s", err)
+			}
+		}
+	}()
+
 	return i.Handler.Start(ctx)
 }
 
@@ -97,3 +151,18 @@ func (i *Instance) watchPeers(ctx context.Context) {
 		}
 	}
 }
+
+// watchLiveness updates statuses of known peers and removes departed ones.
+func (i *Instance) watchLiveness(ctx context.Context) {
+	ticker := time.NewTicker(livenessInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			i.KnownPeers.Expire(now, i.peerTimeouts)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package messages
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+
+	"github.com/ngalayko/p2p/instance/messages/proto/chat"
+	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/instance/store"
+)
+
+// maxEmojiLen limits the size of a reaction, an emoji can take a few code points.
+const maxEmojiLen = 32
+
+// EditMessage replaces the text of a message sent by self, for everyone in the chat.
+func (h *Handler) EditMessage(ctx context.Context, messageID string, text string) error {
+	editedAt := time.Unix(time.Now().Unix(), 0)
+
+	r, err := h.changeMessage(messageID, func(r *store.Message) error {
+		return edit(h.self.ID, r, text, editedAt)
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.sendChange(ctx, r, &chat.Message{
+		Payload: &chat.Message_Edit{
+			Edit: &chat.Edit{
+				MessageID: messageID,
+				Text:      text,
+				EditedAt: &timestamp.Timestamp{
+					Seconds: editedAt.Unix(),
+				},
+			},
+		},
+	})
+}
+
+// DeleteMessage deletes a message sent by self, for everyone in the chat.
+func (h *Handler) DeleteMessage(ctx context.Context, messageID string) error {
+	r, err := h.changeMessage(messageID, func(r *store.Message) error {
+		return h.deleteContent(h.self.ID, r)
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.sendChange(ctx, r, &chat.Message{
+		Payload: &chat.Message_Delete{
+			Delete: &chat.Delete{
+				MessageID: messageID,
+			},
+		},
+	})
+}
+
+// React adds an emoji reaction of self to a message.
+func (h *Handler) React(ctx context.Context, messageID string, emoji string) error {
+	return h.sendReaction(ctx, messageID, emoji, false)
+}
+
+// Unreact removes an emoji reaction of self from a message.
+func (h *Handler) Unreact(ctx context.Context, messageID string, emoji string) error {
+	return h.sendReaction(ctx, messageID, emoji, true)
+}
+
+func (h *Handler) sendReaction(ctx context.Context, messageID string, emoji string, removed bool) error {
+	r, err := h.changeMessage(messageID, func(r *store.Message) error {
+		if !h.inChat(h.self.ID, r) {
+			return fmt.Errorf("can't react to message %s", r.ID)
+		}
+		return react(h.self.ID, r, emoji, removed)
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.sendChange(ctx, r, &chat.Message{
+		Payload: &chat.Message_Reaction{
+			Reaction: &chat.Reaction{
+				MessageID: messageID,
+				Emoji:     emoji,
+				Removed:   removed,
+			},
+		},
+	})
+}
+
+// sendChange delivers a change of the message to everyone in its chat. Changes
+// are queued like messages, so they are applied after the message they change.
+func (h *Handler) sendChange(ctx context.Context, r *store.Message, change *chat.Message) error {
+	msg, err := h.makeMessage(change)
+	if err != nil {
+		return fmt.Errorf("error making message: %s", err)
+	}
+
+	if r.GroupID == "" {
+		if r.ChatID != h.self.ID {
+			h.deliver(ctx, r.ChatID, msg)
+		}
+		return nil
+	}
+
+	g, err := h.getGroup(r.GroupID)
+	if err != nil {
+		return err
+	}
+	msg.GroupID = g.ID
+
+	h.fanOut(ctx, g, msg)
+	return nil
+}
+
+func (h *Handler) handleEdit(from *peers.Peer, e *chat.Edit) {
+	editedAt := time.Now()
+	if e.EditedAt != nil {
+		editedAt = time.Unix(e.EditedAt.Seconds, 0)
+	}
+
+	if _, err := h.changeMessage(e.MessageID, func(r *store.Message) error {
+		return edit(from.ID, r, e.Text, editedAt)
+	}); err != nil {
+		h.logger.Error("can't apply an edit from %s: %s", from.ID, err)
+	}
+}
+
+func (h *Handler) handleDelete(from *peers.Peer, d *chat.Delete) {
+	if _, err := h.changeMessage(d.MessageID, func(r *store.Message) error {
+		return h.deleteContent(from.ID, r)
+	}); err != nil {
+		h.logger.Error("can't apply a delete from %s: %s", from.ID, err)
+	}
+}
+
+// handleReaction applies a reaction sent to the chat of the message by a
+// peer in it. groupID is the group the reaction is sent to.
+func (h *Handler) handleReaction(from *peers.Peer, groupID string, reaction *chat.Reaction) {
+	if _, err := h.changeMessage(reaction.MessageID, func(r *store.Message) error {
+		if r.GroupID != groupID || !h.inChat(from.ID, r) {
+			return fmt.Errorf("%s is not in the chat of message %s", from.ID, r.ID)
+		}
+		return react(from.ID, r, reaction.Emoji, reaction.Removed)
+	}); err != nil {
+		h.logger.Error("can't apply a reaction from %s: %s", from.ID, err)
+	}
+}
+
+// changeMessage applies the change to the stored message, stores and reports it.
+func (h *Handler) changeMessage(messageID string, change func(*store.Message) error) (*store.Message, error) {
+	h.statusGuard.Lock()
+	defer h.statusGuard.Unlock()
+
+	r, err := h.store.Get(messageID)
+	if err != nil {
+		return nil, fmt.Errorf("unknown message %s", messageID)
+	}
+
+	if r.Deleted {
+		return nil, fmt.Errorf("message %s is deleted", messageID)
+	}
+
+	if err := change(r); err != nil {
+		return nil, err
+	}
+
+	if err := h.store.Save(r); err != nil {
+		return nil, fmt.Errorf("can't store message %s: %s", messageID, err)
+	}
+
+	h.updates.Publish(&MessageUpdated{Message: h.fromRecord(r)})
+
+	return r, nil
+}
+
+// edit replaces the text of the message, only the sender can edit it.
+func edit(peerID string, r *store.Message, text string, editedAt time.Time) error {
+	if r.FromID != peerID {
+		return fmt.Errorf("%s can't edit message %s of %s", peerID, r.ID, r.FromID)
+	}
+
+	if r.Type != string(TypeText) {
+		return fmt.Errorf("message %s is not a text", r.ID)
+	}
+
+	if text == "" {
+		return fmt.Errorf("empty text")
+	}
+
+	r.Text = text
+	r.EditedAt = &editedAt
+	return nil
+}
+
+// deleteContent removes contents of the message, only the sender can delete it.
+func (h *Handler) deleteContent(peerID string, r *store.Message) error {
+	if r.FromID != peerID {
+		return fmt.Errorf("%s can't delete message %s of %s", peerID, r.ID, r.FromID)
+	}
+
+	if r.File != nil {
+		if err := os.Remove(h.filePath(r.ID)); err != nil && !os.IsNotExist(err) {
+			h.logger.Error("can't remove file %s: %s", r.ID, err)
+		}
+	}
+
+	r.Text = ""
+	r.File = nil
+	r.Reactions = nil
+	r.Deleted = true
+	return nil
+}
+
+// react adds or removes a reaction of the peer, callers check that the peer
+// is in the chat of the message.
+func react(peerID string, r *store.Message, emoji string, removed bool) error {
+	if emoji == "" || len(emoji) > maxEmojiLen {
+		return fmt.Errorf("invalid reaction")
+	}
+
+	reactions := make([]*store.Reaction, 0, len(r.Reactions)+1)
+	for _, reaction := range r.Reactions {
+		if reaction.PeerID == peerID && reaction.Emoji == emoji {
+			continue
+		}
+		reactions = append(reactions, reaction)
+	}
+	if !removed {
+		reactions = append(reactions, &store.Reaction{
+			PeerID: peerID,
+			Emoji:  emoji,
+		})
+	}
+
+	r.Reactions = reactions
+	return nil
+}
+
+// inChat returns true if the peer can see the message.
+func (h *Handler) inChat(peerID string, r *store.Message) bool {
+	switch {
+	case r.GroupID != "":
+		return h.isMember(r.GroupID, peerID)
+	case peerID == h.self.ID:
+		return true
+	default:
+		return r.ChatID == peerID
+	}
+}
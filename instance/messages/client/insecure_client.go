@@ -16,6 +16,7 @@ import (
 type InsecureClient struct {
 	greeter.GreeterClient
 
+	conn   *grpc.ClientConn
 	logger *logger.Logger
 	client *peers.Peer
 	r      *rand.Rand
@@ -39,7 +40,13 @@ func InsecureConnect(
 
 	return &InsecureClient{
 		GreeterClient: greeter.NewGreeterClient(insecureConn),
+		conn:          insecureConn,
 		logger:        log.Prefix("grpc-insecure-client-%s", client.ID),
 		client:        client,
 	}, nil
 }
+
+// Close closes the connection.
+func (c *InsecureClient) Close() error {
+	return c.conn.Close()
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"sync"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 
 	"github.com/ngalayko/p2p/instance/messages/proto/chat"
 	"github.com/ngalayko/p2p/instance/peers"
@@ -15,12 +18,21 @@ import (
 
 const (
 	idLen = 32
+
+	// keepaliveInterval is how often an idle connection is pinged to detect
+	// a peer that is gone without closing it.
+	keepaliveInterval = 30 * time.Second
+	keepaliveTimeout  = 10 * time.Second
 )
 
 // Client used to open a stream connection with another peer.
 type Client struct {
 	chat.Chat_StreamClient
 
+	conn      *grpc.ClientConn
+	closeOnce *sync.Once
+	sendGuard *sync.Mutex
+
 	logger *logger.Logger
 	client *peers.Peer
 	r      *rand.Rand
@@ -35,9 +47,11 @@ func Connect(
 	client *peers.Peer,
 ) (*Client, error) {
 	c := &Client{
-		logger: log.Prefix("grpc-client-%s", client.ID),
-		client: client,
-		r:      r,
+		closeOnce: &sync.Once{},
+		sendGuard: &sync.Mutex{},
+		logger:    log.Prefix("grpc-client-%s", client.ID),
+		client:    client,
+		r:         r,
 	}
 
 	conn, err := grpc.DialContext(
@@ -45,16 +59,45 @@ func Connect(
 		fmt.Sprintf("peer:///%s", client.ID),
 		grpc.WithTransportCredentials(creds),
 		grpc.WithBalancerName("pick_first"),
+		grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                keepaliveInterval,
+			Timeout:             keepaliveTimeout,
+			PermitWithoutStream: true,
+		}),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("unable to connect: %s", err)
 	}
+	c.conn = conn
 
 	streamClient, err := chat.NewChatClient(conn).Stream(ctx)
 	if err != nil {
+		conn.Close()
 		return nil, fmt.Errorf("can't open stream: %s", err)
 	}
 	c.Chat_StreamClient = streamClient
 
 	return c, nil
 }
+
+// Send sends a message to the stream. gRPC streams can't be sent to
+// concurrently.
+func (c *Client) Send(msg *chat.Message) error {
+	c.sendGuard.Lock()
+	defer c.sendGuard.Unlock()
+
+	return c.Chat_StreamClient.Send(msg)
+}
+
+// Close closes the stream and the connection.
+func (c *Client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		c.sendGuard.Lock()
+		_ = c.CloseSend()
+		c.sendGuard.Unlock()
+
+		err = c.conn.Close()
+	})
+	return err
+}
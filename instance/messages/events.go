@@ -0,0 +1,64 @@
+package messages
+
+import (
+	"github.com/ngalayko/p2p/instance/events"
+)
+
+// Event is an update of the handler: MessageReceived, MessageSent,
+// MessageUpdated, StatusUpdate, GroupUpdated, FileProgress, KeyChanged,
+// ConnectionChanged, TypingChanged or PresenceChanged.
+type Event = events.Event
+
+// Subscription receives events of the handler in the order they happen.
+type Subscription = events.Subscription
+
+// MessageReceived is published when a message is received.
+type MessageReceived struct {
+	Message *Message
+}
+
+// MessageSent is published when a message is sent or queued.
+type MessageSent struct {
+	Message *Message
+}
+
+// MessageUpdated is published when a message is edited, deleted or reacted to.
+type MessageUpdated struct {
+	Message *Message
+}
+
+// GroupUpdated is published when a group is created or changed.
+type GroupUpdated struct {
+	Group *Group
+}
+
+// KeyChanged is published when a peer presents a key different from the pinned one.
+type KeyChanged struct {
+	Key *Key
+}
+
+// ConnectionChanged is published when the first stream with a peer is opened,
+// or the last one is closed.
+type ConnectionChanged struct {
+	PeerID    string
+	Connected bool
+}
+
+// TypingChanged is published when a peer starts or stops typing.
+type TypingChanged struct {
+	PeerID string
+	// GroupID is set if the peer types in a group.
+	GroupID string
+	Typing  bool
+}
+
+// PresenceChanged is published when a peer sets its presence.
+type PresenceChanged struct {
+	PeerID   string
+	Presence Presence
+}
+
+// Subscribe returns a subscription to events of the handler with a buffer of the size.
+func (h *Handler) Subscribe(buffer int) *Subscription {
+	return h.updates.Subscribe(buffer)
+}
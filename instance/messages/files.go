@@ -0,0 +1,516 @@
+package messages
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ngalayko/p2p/instance/messages/proto/chat"
+	"github.com/ngalayko/p2p/instance/peers"
+)
+
+const (
+	fileChunkSize = 64 << 10
+	maxFileSize   = 100 << 20
+
+	partSuffix = ".part"
+
+	resumeInterval = 10 * time.Second
+
+	// partTimeout is how long a partial file is kept without new chunks.
+	partTimeout   = 24 * time.Hour
+	pruneInterval = time.Hour
+)
+
+// resume is a gap in a received file the sender is asked to fill.
+type resume struct {
+	offset  int64
+	askedAt time.Time
+}
+
+// FileProgress is a progress of a sent or received file.
+type FileProgress struct {
+	MessageID string `json:"message_id"`
+	PeerID    string `json:"peer_id"`
+	Offset    int64  `json:"offset"`
+	Size      int64  `json:"size"`
+}
+
+// SendFile stores a copy of the file and sends it to the peer in chunks.
+// If mimeType is empty, it is guessed from the name.
+func (h *Handler) SendFile(ctx context.Context, toID string, name string, mimeType string, r io.Reader) error {
+	to, err := h.getPeer(toID)
+	if err != nil {
+		return fmt.Errorf("error getting peer: %s", err)
+	}
+
+	// sent and received files share the same dir.
+	if to.ID == h.self.ID {
+		return fmt.Errorf("can't send a file to self")
+	}
+
+	id, err := h.newID()
+	if err != nil {
+		return fmt.Errorf("error making message: %s", err)
+	}
+
+	file, err := h.copyFile(id, r)
+	if err != nil {
+		return err
+	}
+
+	file.Name = filepath.Base(name)
+	file.MIME = mimeType
+	if file.MIME == "" {
+		file.MIME = mime.TypeByExtension(filepath.Ext(file.Name))
+	}
+	if file.MIME == "" {
+		file.MIME = "application/octet-stream"
+	}
+
+	sent := &Message{
+		ID:        id,
+		From:      h.self,
+		To:        to,
+		Timestamp: time.Unix(time.Now().Unix(), 0),
+		Type:      TypeFile,
+		File:      file,
+		Status:    StatusQueued,
+	}
+
+	if err := h.store.Save(sent.toRecord(h.self)); err != nil {
+		h.logger.Error("can't store sent message %s: %s", sent.ID, err)
+	}
+
+	h.updates.Publish(&MessageSent{Message: sent})
+
+	go h.sendFile(to.ID, id, file, 0)
+
+	return nil
+}
+
+// OpenFile returns metadata and contents of a sent or received file.
+func (h *Handler) OpenFile(messageID string) (*File, *os.File, error) {
+	if !validID(messageID) {
+		return nil, nil, fmt.Errorf("invalid file id: %s", messageID)
+	}
+
+	r, err := h.store.Get(messageID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't get message: %s", err)
+	}
+
+	if r.File == nil {
+		return nil, nil, fmt.Errorf("message %s is not a file", messageID)
+	}
+
+	f, err := os.Open(h.filePath(messageID))
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't open file: %s", err)
+	}
+
+	return fromRecord(nil, nil, nil, r).File, f, nil
+}
+
+// copyFile writes contents of the file to the files dir and returns its size and hash.
+func (h *Handler) copyFile(id string, r io.Reader) (*File, error) {
+	if err := os.MkdirAll(h.filesDir, 0700); err != nil {
+		return nil, fmt.Errorf("can't create %s: %s", h.filesDir, err)
+	}
+
+	f, err := os.Create(h.filePath(id) + partSuffix)
+	if err != nil {
+		return nil, fmt.Errorf("can't create file: %s", err)
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), io.LimitReader(r, maxFileSize+1))
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("can't write file: %s", err)
+	}
+
+	if size > maxFileSize {
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("file is larger than %d bytes", maxFileSize)
+	}
+
+	if err := os.Rename(f.Name(), h.filePath(id)); err != nil {
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("can't write file: %s", err)
+	}
+
+	return &File{
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// sendFile sends the file to the peer in chunks starting from the offset.
+// A previous transfer of the same file to the peer is cancelled.
+func (h *Handler) sendFile(peerID string, messageID string, file *File, offset int64) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	key := messageID + peerID
+
+	h.filesGuard.Lock()
+	if cancelPrevious, ok := h.transfers[key]; ok {
+		cancelPrevious()
+	}
+	h.transfers[key] = cancel
+	h.filesGuard.Unlock()
+
+	defer func() {
+		h.filesGuard.Lock()
+		defer h.filesGuard.Unlock()
+
+		if ctx.Err() == nil {
+			delete(h.transfers, key)
+		}
+	}()
+
+	if err := h.sendChunks(ctx, peerID, messageID, file, offset); err != nil {
+		h.logger.Error("can't send file %s to %s: %s", messageID, peerID, err)
+	}
+}
+
+func (h *Handler) sendChunks(ctx context.Context, peerID string, messageID string, file *File, offset int64) error {
+	f, err := os.Open(h.filePath(messageID))
+	if err != nil {
+		return fmt.Errorf("can't open file: %s", err)
+	}
+	defer f.Close()
+
+	sum, err := hex.DecodeString(file.SHA256)
+	if err != nil {
+		return fmt.Errorf("invalid file hash: %s", err)
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		// every chunk has its own buffer, as it may stay in the queue.
+		buf := make([]byte, fileChunkSize)
+		n, err := f.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("can't read file: %s", err)
+		}
+
+		last := offset+int64(n) >= file.Size
+		if n == 0 && !last {
+			return fmt.Errorf("file is shorter than %d bytes", file.Size)
+		}
+
+		msg, err := h.makeMessage(&chat.Message{
+			Payload: &chat.Message_File{
+				File: &chat.FileChunk{
+					FileID: messageID,
+					Name:   file.Name,
+					Size:   file.Size,
+					MIME:   file.MIME,
+					SHA256: sum,
+					Offset: offset,
+					Data:   buf[:n],
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("error making message: %s", err)
+		}
+
+		if last {
+			// the last chunk carries the id of the file message, so the queue
+			// marks the message sent once the whole file is sent.
+			msg.ID = messageID
+		}
+
+		// a stream opened here outlives the transfer, so it is not bound to ctx.
+		if !h.deliver(context.Background(), peerID, msg) {
+			// only one chunk is queued, the queue continues the transfer from
+			// the file once the chunk is delivered.
+			return nil
+		}
+
+		offset += int64(n)
+
+		h.updates.Publish(&FileProgress{
+			MessageID: messageID,
+			PeerID:    peerID,
+			Offset:    offset,
+			Size:      file.Size,
+		})
+
+		if last {
+			h.setStatus(messageID, StatusSent)
+			return nil
+		}
+	}
+}
+
+// handleFileChunk writes a received chunk. When the whole file is received
+// and its hash matches, the file message is received.
+func (h *Handler) handleFileChunk(from *peers.Peer, msg *chat.Message, chunk *chat.FileChunk) {
+	received, err := h.writeChunk(from.ID, chunk)
+	if err != nil {
+		h.logger.Error("can't receive file %s from %s: %s", chunk.FileID, from.ID, err)
+		return
+	}
+
+	if received < chunk.Offset {
+		// a chunk was lost, ask to send the file again from the first missing byte.
+		if !h.askResume(from.ID+chunk.FileID, received) {
+			return
+		}
+
+		h.logger.Info("file %s from %s: expected offset %d, got %d", chunk.FileID, from.ID, received, chunk.Offset)
+		if err := h.sendFileResume(from, chunk.FileID, received); err != nil {
+			h.logger.Error("can't resume file %s from %s: %s", chunk.FileID, from.ID, err)
+		}
+		return
+	}
+
+	if received < chunk.Offset+int64(len(chunk.Data)) {
+		return
+	}
+
+	h.updates.Publish(&FileProgress{
+		MessageID: chunk.FileID,
+		PeerID:    from.ID,
+		Offset:    received,
+		Size:      chunk.Size,
+	})
+
+	if received < chunk.Size {
+		return
+	}
+
+	h.filesGuard.Lock()
+	delete(h.resumes, from.ID+chunk.FileID)
+	h.filesGuard.Unlock()
+
+	h.receive(from, msg)
+}
+
+// askResume returns true if the gap at the offset is not asked to be resent
+// yet. Chunks after a gap keep coming until the sender gets the request, so
+// it is sent once per gap, and again only if it seems to be lost.
+func (h *Handler) askResume(key string, offset int64) bool {
+	h.filesGuard.Lock()
+	defer h.filesGuard.Unlock()
+
+	now := time.Now()
+	if r, ok := h.resumes[key]; ok && r.offset == offset && now.Sub(r.askedAt) < resumeInterval {
+		return false
+	}
+
+	h.resumes[key] = &resume{
+		offset:  offset,
+		askedAt: now,
+	}
+	return true
+}
+
+// writeChunk writes the chunk to a partial file of the sender and returns how
+// many bytes of the file are received. Chunks must have the size and the hash
+// of the first one. The file is verified once it is complete.
+func (h *Handler) writeChunk(fromID string, chunk *chat.FileChunk) (int64, error) {
+	if !validID(chunk.FileID) {
+		return 0, fmt.Errorf("invalid file id")
+	}
+
+	end := chunk.Offset + int64(len(chunk.Data))
+	if chunk.Size < 0 || chunk.Size > maxFileSize || chunk.Offset < 0 || end > chunk.Size {
+		return 0, fmt.Errorf("invalid chunk %d-%d of %d bytes", chunk.Offset, end, chunk.Size)
+	}
+
+	h.filesGuard.Lock()
+	defer h.filesGuard.Unlock()
+
+	now := time.Now()
+	if now.Sub(h.prunedAt) > pruneInterval {
+		h.prunePartials(now)
+	}
+
+	if _, err := os.Stat(h.filePath(chunk.FileID)); err == nil {
+		// already received, the end of a retransmission.
+		return 0, fmt.Errorf("file is already received")
+	}
+
+	if err := os.MkdirAll(h.filesDir, 0700); err != nil {
+		return 0, fmt.Errorf("can't create %s: %s", h.filesDir, err)
+	}
+
+	path := h.partialPath(fromID, chunk.FileID)
+	if first, ok := h.partials[path]; !ok {
+		h.partials[path] = &chat.FileChunk{
+			Size:   chunk.Size,
+			SHA256: chunk.SHA256,
+		}
+	} else if first.Size != chunk.Size || !bytes.Equal(first.SHA256, chunk.SHA256) {
+		return 0, fmt.Errorf("chunk doesn't match the file")
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("can't open file: %s", err)
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("can't stat file: %s", err)
+	}
+
+	received := stat.Size()
+	if chunk.Offset > received || end <= received && end != chunk.Size {
+		return received, nil
+	}
+
+	if _, err := f.WriteAt(chunk.Data, chunk.Offset); err != nil {
+		return 0, fmt.Errorf("can't write file: %s", err)
+	}
+
+	if end > received {
+		received = end
+	}
+
+	if received < chunk.Size {
+		return received, nil
+	}
+
+	delete(h.partials, path)
+
+	if err := verifyFile(f, chunk.SHA256); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return 0, err
+	}
+
+	if err := os.Rename(f.Name(), h.filePath(chunk.FileID)); err != nil {
+		return 0, fmt.Errorf("can't write file: %s", err)
+	}
+
+	return received, nil
+}
+
+// prunePartials removes partial files that got no chunks for partTimeout.
+// Must be called under filesGuard.
+func (h *Handler) prunePartials(now time.Time) {
+	h.prunedAt = now
+
+	paths, err := filepath.Glob(filepath.Join(h.filesDir, "*"+partSuffix))
+	if err != nil {
+		h.logger.Error("can't list partial files: %s", err)
+		return
+	}
+
+	for _, path := range paths {
+		stat, err := os.Stat(path)
+		if err != nil || now.Sub(stat.ModTime()) < partTimeout {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil {
+			h.logger.Error("can't remove partial file %s: %s", path, err)
+			continue
+		}
+		delete(h.partials, path)
+	}
+}
+
+func verifyFile(f *os.File, sum []byte) error {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("can't read file: %s", err)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return fmt.Errorf("can't read file: %s", err)
+	}
+
+	if !bytes.Equal(hash.Sum(nil), sum) {
+		return fmt.Errorf("sha256 mismatch")
+	}
+	return nil
+}
+
+func (h *Handler) sendFileResume(to *peers.Peer, fileID string, offset int64) error {
+	msg, err := h.makeMessage(&chat.Message{
+		Payload: &chat.Message_FileResume{
+			FileResume: &chat.FileResume{
+				FileID: fileID,
+				Offset: offset,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error making message: %s", err)
+	}
+
+	return h.sendMessage(context.Background(), to, msg)
+}
+
+// handleFileResume sends the file to the peer again from the requested offset.
+func (h *Handler) handleFileResume(from *peers.Peer, resume *chat.FileResume) {
+	if err := h.resumeFile(from.ID, resume.FileID, resume.Offset); err != nil {
+		h.logger.Error("%s asked to resume file %s: %s", from.ID, resume.FileID, err)
+		return
+	}
+
+	h.logger.Info("resuming file %s to %s from %d", resume.FileID, from.ID, resume.Offset)
+}
+
+// resumeFile sends the stored file to the peer from the offset.
+func (h *Handler) resumeFile(peerID string, fileID string, offset int64) error {
+	r, err := h.store.Get(fileID)
+	if err != nil || r.File == nil || r.FromID != h.self.ID || r.ToID != peerID {
+		return fmt.Errorf("unknown file")
+	}
+
+	if offset < 0 || offset > r.File.Size {
+		return fmt.Errorf("invalid offset %d", offset)
+	}
+
+	go h.sendFile(peerID, r.ID, fromRecord(nil, nil, nil, r).File, offset)
+	return nil
+}
+
+func (h *Handler) filePath(id string) string {
+	return filepath.Join(h.filesDir, id)
+}
+
+// partialPath is a path of a file being received from the peer, so peers
+// can't write to files of each other.
+func (h *Handler) partialPath(fromID string, fileID string) string {
+	return filepath.Join(h.filesDir, fromID+"-"+fileID+partSuffix)
+}
+
+func fileFromProto(chunk *chat.FileChunk) *File {
+	return &File{
+		Name:   filepath.Base(chunk.Name),
+		Size:   chunk.Size,
+		MIME:   chunk.MIME,
+		SHA256: hex.EncodeToString(chunk.SHA256),
+	}
+}
+
+// validID returns true if the id looks like one made by newID, so it is safe
+// to use it as a file name.
+func validID(id string) bool {
+	if len(id) != 2*idLen {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
@@ -0,0 +1,209 @@
+package messages
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/ngalayko/p2p/instance/messages/proto/chat"
+	"github.com/ngalayko/p2p/instance/peers"
+)
+
+const (
+	defaultGossipInterval = 5 * time.Second
+
+	// gossipFanout is how many connected peers are asked every interval.
+	gossipFanout = 3
+
+	// gossipBuffer is how many learned peers wait for the discovery. Peers
+	// that don't fit are learned again from the next exchange.
+	gossipBuffer = 64
+)
+
+// Discover implements Discovery interface. Every interval the handler sends
+// a digest of known peers to a few random connected peers, and finds peers
+// from their answers.
+func (h *Handler) Discover(ctx context.Context) <-chan *peers.Peer {
+	go h.watchGossip(ctx)
+	return h.gossiped
+}
+
+func (h *Handler) watchGossip(ctx context.Context) {
+	ticker := time.NewTicker(h.gossipInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.gossip(ctx)
+		}
+	}
+}
+
+// gossip sends the digest to random connected peers.
+func (h *Handler) gossip(ctx context.Context) {
+	for _, peerID := range h.gossipTargets() {
+		to, err := h.getPeer(peerID)
+		if err != nil {
+			continue
+		}
+
+		if err := h.sendGossip(ctx, to, &chat.Gossip{
+			Digest: h.digest(),
+		}); err != nil {
+			h.logger.Debug("can't gossip with %s: %s", peerID, err)
+		}
+	}
+}
+
+// gossipTargets returns up to gossipFanout random connected peers.
+func (h *Handler) gossipTargets() []string {
+	h.streamsGuard.RLock()
+	connected := make([]string, 0, len(h.streams))
+	for peerID := range h.streams {
+		if peerID != h.self.ID {
+			connected = append(connected, peerID)
+		}
+	}
+	h.streamsGuard.RUnlock()
+
+	h.rGuard.Lock()
+	h.r.Shuffle(len(connected), func(i, j int) {
+		connected[i], connected[j] = connected[j], connected[i]
+	})
+	h.rGuard.Unlock()
+
+	if len(connected) > gossipFanout {
+		connected = connected[:gossipFanout]
+	}
+	return connected
+}
+
+// digest returns ids of self and every known peer.
+func (h *Handler) digest() []string {
+	known := h.self.KnownPeers.Map()
+	digest := make([]string, 0, len(known)+1)
+	digest = append(digest, h.self.ID)
+	for id := range known {
+		digest = append(digest, id)
+	}
+	return digest
+}
+
+// missing returns online peers that are not in the digest. Peers that are
+// away or offline are not spread, so departed peers are not learned again.
+func (h *Handler) missing(digest []string) []*chat.GossipPeer {
+	inDigest := make(map[string]bool, len(digest))
+	for _, id := range digest {
+		inDigest[id] = true
+	}
+
+	pp := []*chat.GossipPeer{}
+	for id, peer := range h.self.KnownPeers.Map() {
+		if inDigest[id] {
+			continue
+		}
+		if status, _, ok := h.self.KnownPeers.Status(id); !ok || status != peers.StatusOnline {
+			continue
+		}
+		pp = append(pp, gossipPeer(peer))
+	}
+	return pp
+}
+
+// sendGossip sends gossip only through a live stream, gossip is not worth
+// connecting or relaying.
+func (h *Handler) sendGossip(ctx context.Context, to *peers.Peer, g *chat.Gossip) error {
+	msg, err := h.makeMessage(&chat.Message{
+		Payload: &chat.Message_Gossip{
+			Gossip: g,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return h.sendDirect(ctx, to, msg)
+}
+
+// handleGossip learns peers from the gossip, and answers a digest with peers
+// the sender doesn't know. The first answer has a digest too, so the sender
+// answers it with peers this handler doesn't know.
+func (h *Handler) handleGossip(from *peers.Peer, g *chat.Gossip) {
+	for _, gp := range g.Peers {
+		h.learn(from, gp)
+	}
+
+	if len(g.Digest) == 0 {
+		return
+	}
+
+	answer := &chat.Gossip{
+		Peers: h.missing(g.Digest),
+		Reply: true,
+	}
+	if !g.Reply {
+		answer.Digest = h.digest()
+	} else if len(answer.Peers) == 0 {
+		return
+	}
+
+	if err := h.sendGossip(context.Background(), from, answer); err != nil {
+		h.logger.Debug("can't answer gossip of %s: %s", from.ID, err)
+	}
+}
+
+// learn passes a verified peer from the gossip to the discovery.
+func (h *Handler) learn(from *peers.Peer, gp *chat.GossipPeer) {
+	if gp.ID == h.self.ID {
+		return
+	}
+	if _, known := h.self.KnownPeers.Map()[gp.ID]; known {
+		return
+	}
+
+	if err := h.verifyPublicCrt(gp.ID, gp.PublicKey); err != nil {
+		h.logger.Debug("%s gossips %s with an invalid certificate: %s", from.ID, gp.ID, err)
+		return
+	}
+
+	select {
+	case h.gossiped <- marshalGossipPeer(gp):
+		h.logger.Debug("learned %s from %s", gp.ID, from.ID)
+	default:
+		h.logger.Debug("gossip buffer is full, dropping %s", gp.ID)
+	}
+}
+
+func gossipPeer(p *peers.Peer) *chat.GossipPeer {
+	gp := &chat.GossipPeer{
+		ID:           p.ID,
+		Name:         p.Name,
+		PublicKey:    p.PublicCrt,
+		Port:         int32(p.Port),
+		InsecurePort: int32(p.InsecurePort),
+		UIPort:       int32(p.UIPort),
+	}
+	for _, ip := range p.Addrs.Map() {
+		gp.IPs = append(gp.IPs, ip.String())
+	}
+	return gp
+}
+
+func marshalGossipPeer(gp *chat.GossipPeer) *peers.Peer {
+	peer := peers.NewBlank()
+	peer.ID = gp.ID
+	peer.Name = gp.Name
+	peer.PublicCrt = gp.PublicKey
+	peer.Port = int(gp.Port)
+	peer.InsecurePort = int(gp.InsecurePort)
+	peer.UIPort = int(gp.UIPort)
+	for _, ip := range gp.IPs {
+		if parsed := net.ParseIP(ip); parsed != nil {
+			peer.Addrs.Add(parsed)
+		}
+	}
+	return peer
+}
@@ -0,0 +1,105 @@
+package messages
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ngalayko/p2p/instance/peers"
+)
+
+func Test_Handler__should_converge_membership_with_gossip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hh := make([]*Handler, 8)
+	for i := range hh {
+		hh[i] = testHandler(t)
+		hh[i].gossipInterval = 50 * time.Millisecond
+		go run(ctx, t, hh[i])
+	}
+	waitStarted(t, hh...)
+
+	// every handler knows and is connected only to the next one.
+	for i := 0; i < len(hh)-1; i++ {
+		hh[i].self.KnownPeers.Add(hh[i+1].self)
+		_, err := hh[i].SendText(ctx, "hello", hh[i+1].self.ID)
+		assert.NoError(t, err)
+	}
+
+	for _, h := range hh {
+		go testDiscover(ctx, h)
+	}
+
+	deadline := time.Now().Add(20 * time.Second)
+	for !converged(hh) {
+		if time.Now().After(deadline) {
+			t.Fatal("membership has not converged")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// a peer learned from gossip is reachable.
+	received := testEvents(hh[len(hh)-1])
+	_, err := hh[0].SendText(ctx, "hello gossip", hh[len(hh)-1].self.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello gossip", waitReceived(t, received).Text)
+}
+
+func Test_Handler__should_not_gossip_offline_peers(t *testing.T) {
+	h := testHandler(t)
+
+	online := testPeer(t)
+	offline := testPeer(t)
+	known := testPeer(t)
+	h.self.KnownPeers.Add(online)
+	h.self.KnownPeers.Add(offline)
+	h.self.KnownPeers.Add(known)
+
+	h.self.KnownPeers.Expire(time.Now().Add(2*time.Minute), peers.Timeouts{
+		Away:    time.Minute,
+		Offline: time.Minute,
+		Remove:  time.Hour,
+	})
+	h.self.KnownPeers.Add(online)
+
+	missing := h.missing([]string{known.ID})
+	if assert.Len(t, missing, 1) {
+		assert.Equal(t, online.ID, missing[0].ID)
+	}
+}
+
+//
+// helpers
+//
+
+// testDiscover adds peers the handler learns from gossip to known peers.
+func testDiscover(ctx context.Context, h *Handler) {
+	found := h.Discover(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case peer := <-found:
+			h.self.KnownPeers.Add(peer)
+		}
+	}
+}
+
+// converged returns true if every handler knows every other one.
+func converged(hh []*Handler) bool {
+	for _, h := range hh {
+		known := h.self.KnownPeers.Map()
+		for _, other := range hh {
+			if other == h {
+				continue
+			}
+			if _, ok := known[other.self.ID]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
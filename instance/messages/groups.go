@@ -0,0 +1,378 @@
+package messages
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ngalayko/p2p/instance/messages/proto/chat"
+	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/instance/store"
+)
+
+// Group is a named group of peers. Every member keeps a copy of the roster.
+type Group struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Members []*Member `json:"members"`
+	Left    bool      `json:"left,omitempty"`
+}
+
+// Member is a member of a group.
+type Member struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func (g *Group) has(peerID string) bool {
+	for _, m := range g.Members {
+		if m.ID == peerID {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Group) copy() *Group {
+	copied := *g
+	copied.Members = make([]*Member, 0, len(g.Members))
+	for _, m := range g.Members {
+		member := *m
+		copied.Members = append(copied.Members, &member)
+	}
+	return &copied
+}
+
+func (g *Group) toProto() *chat.Group {
+	p := &chat.Group{
+		ID:   g.ID,
+		Name: g.Name,
+	}
+	for _, m := range g.Members {
+		p.Members = append(p.Members, &chat.Member{
+			ID:   m.ID,
+			Name: m.Name,
+		})
+	}
+	return p
+}
+
+func groupFromProto(p *chat.Group) *Group {
+	g := &Group{
+		ID:   p.ID,
+		Name: p.Name,
+	}
+	for _, m := range p.Members {
+		g.Members = append(g.Members, &Member{
+			ID:   m.ID,
+			Name: m.Name,
+		})
+	}
+	return g
+}
+
+func (g *Group) toRecord() *store.Group {
+	r := &store.Group{
+		ID:   g.ID,
+		Name: g.Name,
+		Left: g.Left,
+	}
+	for _, m := range g.Members {
+		r.Members = append(r.Members, &store.Member{
+			ID:   m.ID,
+			Name: m.Name,
+		})
+	}
+	return r
+}
+
+func groupFromRecord(r *store.Group) *Group {
+	g := &Group{
+		ID:   r.ID,
+		Name: r.Name,
+		Left: r.Left,
+	}
+	for _, m := range r.Members {
+		g.Members = append(g.Members, &Member{
+			ID:   m.ID,
+			Name: m.Name,
+		})
+	}
+	return g
+}
+
+// Groups returns groups self is a member of.
+func (h *Handler) Groups() []*Group {
+	h.groupsGuard.RLock()
+	defer h.groupsGuard.RUnlock()
+
+	gg := make([]*Group, 0, len(h.groups))
+	for _, g := range h.groups {
+		if g.Left {
+			continue
+		}
+		gg = append(gg, g.copy())
+	}
+	return gg
+}
+
+// CreateGroup creates a new group with the peers and sends the roster to them.
+func (h *Handler) CreateGroup(ctx context.Context, name string, memberIDs []string) (*Group, error) {
+	id, err := h.newID()
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Group{
+		ID:   id,
+		Name: name,
+		Members: []*Member{
+			{ID: h.self.ID, Name: h.self.Name},
+		},
+	}
+
+	return h.inviteToGroup(ctx, g, memberIDs)
+}
+
+// InviteToGroup adds the peers to the group and sends them to every member.
+func (h *Handler) InviteToGroup(ctx context.Context, groupID string, memberIDs []string) (*Group, error) {
+	g, err := h.getGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.inviteToGroup(ctx, g, memberIDs)
+}
+
+func (h *Handler) inviteToGroup(ctx context.Context, g *Group, memberIDs []string) (*Group, error) {
+	added := []*chat.Member{}
+	for _, id := range memberIDs {
+		if g.has(id) {
+			continue
+		}
+
+		peer, err := h.getPeer(id)
+		if err != nil {
+			return nil, fmt.Errorf("error getting peer: %s", err)
+		}
+
+		g.Members = append(g.Members, &Member{
+			ID:   peer.ID,
+			Name: peer.Name,
+		})
+		added = append(added, &chat.Member{
+			ID:   peer.ID,
+			Name: peer.Name,
+		})
+	}
+
+	update := g.toProto()
+	update.Invited = added
+
+	h.saveGroup(g)
+
+	msg, err := h.makeMessage(&chat.Message{
+		Payload: &chat.Message_GroupUpdate{
+			GroupUpdate: update,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error making message: %s", err)
+	}
+
+	h.fanOut(ctx, g, msg)
+
+	return g.copy(), nil
+}
+
+// LeaveGroup removes self from the group and notifies other members.
+func (h *Handler) LeaveGroup(ctx context.Context, groupID string) error {
+	g, err := h.getGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	msg, err := h.makeMessage(&chat.Message{
+		Payload: &chat.Message_GroupLeave{
+			GroupLeave: &chat.GroupLeave{
+				GroupID: g.ID,
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error making message: %s", err)
+	}
+
+	h.fanOut(ctx, g, msg)
+
+	g.Left = true
+	h.saveGroup(g)
+
+	return nil
+}
+
+// SendGroupText sends a text message to every member of the group.
+// Returns the sent message.
+func (h *Handler) SendGroupText(ctx context.Context, text string, groupID string) (*Message, error) {
+	msg, err := h.makeText(text)
+	if err != nil {
+		return nil, fmt.Errorf("error making message: %s", err)
+	}
+
+	return h.sendGroupText(ctx, msg, groupID)
+}
+
+// sendGroupText stores the text message and delivers it to every member of the group.
+func (h *Handler) sendGroupText(ctx context.Context, msg *chat.Message, groupID string) (*Message, error) {
+	g, err := h.getGroup(groupID)
+	if err != nil {
+		return nil, err
+	}
+	msg.GroupID = g.ID
+
+	sent := fromProto(h.self, nil, msg)
+	sent.Group = g
+	sent.Status = StatusQueued
+
+	if err := h.store.Save(sent.toRecord(h.self)); err != nil {
+		h.logger.Error("can't store sent message %s: %s", sent.ID, err)
+	}
+
+	if h.fanOut(ctx, g, msg) {
+		if r, _ := h.saveStatus(sent.ID, StatusSent); r != nil {
+			sent.Status = Status(r.Status)
+		}
+	}
+
+	h.updates.Publish(&MessageSent{Message: sent})
+
+	return sent, nil
+}
+
+// fanOut delivers the message to every group member except self.
+// Returns true if it was sent right away to at least one member.
+func (h *Handler) fanOut(ctx context.Context, g *Group, msg *chat.Message) bool {
+	sent := false
+	for _, m := range g.Members {
+		if m.ID == h.self.ID {
+			continue
+		}
+		if h.deliver(ctx, m.ID, msg) {
+			sent = true
+		}
+	}
+	return sent
+}
+
+// handleGroupUpdate adds the invited peers to a known group, or joins the
+// group if self is invited. Only members can invite.
+func (h *Handler) handleGroupUpdate(from *peers.Peer, p *chat.Group) {
+	h.groupsGuard.RLock()
+	known, ok := h.groups[p.ID]
+	if ok {
+		known = known.copy()
+	}
+	h.groupsGuard.RUnlock()
+
+	switch {
+	case ok && known.Left:
+		h.logger.Debug("group %s update from %s, which self left", p.ID, from.ID)
+	case ok && !known.has(from.ID):
+		h.logger.Error("group %s update from %s, who is not a member", p.ID, from.ID)
+	case ok:
+		for _, m := range p.Invited {
+			if known.has(m.ID) {
+				continue
+			}
+			known.Members = append(known.Members, &Member{
+				ID:   m.ID,
+				Name: m.Name,
+			})
+		}
+		h.saveGroup(known)
+	default:
+		g := groupFromProto(p)
+		if !g.has(from.ID) || !invited(p, h.self.ID) {
+			h.logger.Error("invalid group %s update from %s", g.ID, from.ID)
+			return
+		}
+		h.saveGroup(g)
+	}
+}
+
+// invited returns true if the peer is invited by the group update.
+func invited(p *chat.Group, peerID string) bool {
+	for _, m := range p.Invited {
+		if m.ID == peerID {
+			return true
+		}
+	}
+	return false
+}
+
+// handleGroupLeave removes the peer from the group.
+func (h *Handler) handleGroupLeave(from *peers.Peer, groupID string) {
+	g, err := h.getGroup(groupID)
+	if err != nil {
+		h.logger.Error("%s left unknown group %s", from.ID, groupID)
+		return
+	}
+
+	members := make([]*Member, 0, len(g.Members))
+	for _, m := range g.Members {
+		if m.ID == from.ID {
+			continue
+		}
+		members = append(members, m)
+	}
+	g.Members = members
+
+	h.saveGroup(g)
+}
+
+// isMember returns true if the peer is a member of the group.
+func (h *Handler) isMember(groupID string, peerID string) bool {
+	g, err := h.getGroup(groupID)
+	if err != nil {
+		return false
+	}
+	return g.has(peerID)
+}
+
+// getGroup returns a copy of the group self is a member of.
+func (h *Handler) getGroup(groupID string) (*Group, error) {
+	h.groupsGuard.RLock()
+	defer h.groupsGuard.RUnlock()
+
+	g, ok := h.groups[groupID]
+	if !ok || g.Left {
+		return nil, fmt.Errorf("unknown group: %s", groupID)
+	}
+	return g.copy(), nil
+}
+
+func (h *Handler) saveGroup(g *Group) {
+	h.groupsGuard.Lock()
+	h.groups[g.ID] = g.copy()
+	h.groupsGuard.Unlock()
+
+	if err := h.store.SaveGroup(g.toRecord()); err != nil {
+		h.logger.Error("can't store group %s: %s", g.ID, err)
+	}
+
+	h.updates.Publish(&GroupUpdated{Group: g.copy()})
+}
+
+func (h *Handler) loadGroups() {
+	gg, err := h.store.Groups()
+	if err != nil {
+		h.logger.Error("can't load groups: %s", err)
+		return
+	}
+
+	h.groupsGuard.Lock()
+	defer h.groupsGuard.Unlock()
+
+	for _, r := range gg {
+		h.groups[r.ID] = groupFromRecord(r)
+	}
+}
@@ -2,23 +2,26 @@ package messages
 
 import (
 	"context"
-	"crypto/x509"
 	"fmt"
 	"math/rand"
 	"net"
 	"sync"
+	"time"
 
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
 	grpc_resolver "google.golang.org/grpc/resolver"
 
+	"github.com/ngalayko/p2p/instance/events"
 	"github.com/ngalayko/p2p/instance/messages/client"
 	"github.com/ngalayko/p2p/instance/messages/client/resolver"
 	"github.com/ngalayko/p2p/instance/messages/proto/chat"
 	"github.com/ngalayko/p2p/instance/messages/proto/greeter"
+	"github.com/ngalayko/p2p/instance/messages/ratchet"
 	"github.com/ngalayko/p2p/instance/messages/server"
 	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/instance/store"
 	"github.com/ngalayko/p2p/logger"
 )
 
@@ -38,6 +41,9 @@ const idLen = 32
 type Handler struct {
 	logger *logger.Logger
 	self   *peers.Peer
+	store  store.Store
+
+	rGuard *sync.Mutex
 	r      *rand.Rand
 
 	secureServer   *grpc.Server
@@ -47,9 +53,48 @@ type Handler struct {
 
 	streamsGuard *sync.RWMutex
 	streams      map[string]stream
+	reconnects   map[string]*reconnect
+	stopped      bool
+
+	queue    *queue
+	queueTTL time.Duration
+
+	// statusGuard serializes changes of stored messages.
+	statusGuard *sync.Mutex
+
+	groupsGuard *sync.RWMutex
+	groups      map[string]*Group
+
+	filesDir   string
+	filesGuard *sync.Mutex
+	transfers  map[string]context.CancelFunc
+	resumes    map[string]*resume
+	partials   map[string]*chat.FileChunk
+	prunedAt   time.Time
+
+	selfFingerprint string
+	keysGuard       *sync.Mutex
+	keys            map[string]*store.Key
+	changedKeys     map[string]string
+
+	preKey        *ratchet.KeyPair
+	signedPreKey  *greeter.PreKey
+	sessionsGuard *sync.Mutex
+	preKeys       map[string]*greeter.PreKey
+	sessions      map[string]*peerSessions
+
+	relayGuard    *sync.Mutex
+	routes        map[string]string
+	seenEnvelopes map[string]time.Time
+	seenPruned    time.Time
 
-	received chan *Message
-	sent     chan *Message
+	presenceGuard *sync.Mutex
+	presences     map[string]Presence
+
+	gossipInterval time.Duration
+	gossiped       chan *peers.Peer
+
+	updates *events.Broadcaster
 }
 
 // NewHandler returns new messages handler.
@@ -57,33 +102,96 @@ func NewHandler(
 	r *rand.Rand,
 	log *logger.Logger,
 	self *peers.Peer,
+	s store.Store,
+	filesDir string,
 ) *Handler {
-	messagesServer := server.New(log, self)
-
-	secureGRPCserver := grpc.NewServer(
-		grpc.Creds(credentials.NewServerTLSFromCert(self.Certificate)),
-	)
-	chat.RegisterChatServer(secureGRPCserver, messagesServer)
-
-	insecureGRPCServer := grpc.NewServer()
-	greeter.RegisterGreeterServer(insecureGRPCServer, messagesServer)
-
-	return &Handler{
+	h := &Handler{
+		rGuard: &sync.Mutex{},
 		r:      r,
 		logger: log.Prefix("messages"),
 		self:   self,
-
-		secureServer:   secureGRPCserver,
-		insecureServer: insecureGRPCServer,
-
-		messagesServer: messagesServer,
+		store:  s,
 
 		streamsGuard: &sync.RWMutex{},
 		streams:      map[string]stream{},
+		reconnects:   map[string]*reconnect{},
 
-		received: make(chan *Message),
-		sent:     make(chan *Message),
+		queue:    newQueue(),
+		queueTTL: defaultQueueTTL,
+
+		statusGuard: &sync.Mutex{},
+
+		groupsGuard: &sync.RWMutex{},
+		groups:      map[string]*Group{},
+
+		filesDir:   filesDir,
+		filesGuard: &sync.Mutex{},
+		transfers:  map[string]context.CancelFunc{},
+		resumes:    map[string]*resume{},
+		partials:   map[string]*chat.FileChunk{},
+
+		keysGuard:   &sync.Mutex{},
+		keys:        map[string]*store.Key{},
+		changedKeys: map[string]string{},
+
+		sessionsGuard: &sync.Mutex{},
+		preKeys:       map[string]*greeter.PreKey{},
+		sessions:      map[string]*peerSessions{},
+
+		relayGuard:    &sync.Mutex{},
+		routes:        map[string]string{},
+		seenEnvelopes: map[string]time.Time{},
+		seenPruned:    time.Now(),
+
+		presenceGuard: &sync.Mutex{},
+		presences:     map[string]Presence{},
+
+		gossipInterval: defaultGossipInterval,
+		gossiped:       make(chan *peers.Peer, gossipBuffer),
+
+		updates: events.New(),
+	}
+
+	selfCrt, err := peers.ParsePublicCrt(self.PublicCrt)
+	if err != nil {
+		log.Panic("can't parse self certificate: %s", err)
+	}
+
+	h.selfFingerprint, err = peers.KeyFingerprint(selfCrt.PublicKey)
+	if err != nil {
+		log.Panic("can't make self fingerprint: %s", err)
 	}
+
+	preKey, signedPreKey, err := newPreKey(self, time.Now())
+	if err != nil {
+		log.Panic("can't make prekey: %s", err)
+	}
+	h.preKey = preKey
+	h.signedPreKey = signedPreKey
+
+	h.messagesServer = server.New(log, self, signedPreKey, h.checkKey, h.savePreKey)
+
+	h.secureServer = grpc.NewServer(
+		grpc.Creds(serverCredentials(self)),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    keepaliveInterval,
+			Timeout: keepaliveTimeout,
+		}),
+		// peers ping as often as this server does.
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             keepaliveInterval / 2,
+			PermitWithoutStream: true,
+		}),
+	)
+	chat.RegisterChatServer(h.secureServer, h.messagesServer)
+
+	h.insecureServer = grpc.NewServer()
+	greeter.RegisterGreeterServer(h.insecureServer, h.messagesServer)
+
+	h.loadGroups()
+	h.loadKeys()
+
+	return h
 }
 
 // Start starts the server.
@@ -115,10 +223,17 @@ func (h *Handler) Start(ctx context.Context) error {
 		}
 	}()
 
+	h.requeue()
+
 	go h.watchStreamsFromServer()
+	go h.watchStreams(ctx)
+	go h.watchQueue(ctx)
 
 	<-ctx.Done()
 
+	// servers wait for open streams to finish.
+	h.closeStreams()
+
 	h.secureServer.GracefulStop()
 	h.insecureServer.GracefulStop()
 
@@ -127,11 +242,7 @@ func (h *Handler) Start(ctx context.Context) error {
 
 func (h *Handler) watchStreamsFromServer() {
 	for stream := range h.messagesServer.Streams() {
-		h.streamsGuard.Lock()
-		h.streams[stream.PeerID] = stream.Chat_StreamServer
-		h.streamsGuard.Unlock()
-
-		go h.listenStream(stream.Chat_StreamServer, stream.PeerID)
+		h.addStream(stream.PeerID, stream)
 	}
 }
 
@@ -144,6 +255,11 @@ func (h *Handler) getStream(ctx context.Context, peer *peers.Peer) (stream, erro
 		return stream, nil
 	}
 
+	// peers greeted through relays have no address.
+	if peer.Port == 0 || len(peer.Addrs.Map()) == 0 {
+		return nil, fmt.Errorf("address of %s is unknown", peer.ID)
+	}
+
 	insecureResolver.Add(peer)
 	secureResolver.Add(peer)
 
@@ -155,15 +271,26 @@ func (h *Handler) getStream(ctx context.Context, peer *peers.Peer) (stream, erro
 	return h.openStream(ctx, knownPeer)
 }
 
+// Greeting returns self with the prekey to greet other peers with.
+func (h *Handler) Greeting() (*greeter.Peer, error) {
+	selfProto := &greeter.Peer{}
+	if err := selfProto.UnmarshalPeer(h.self); err != nil {
+		return nil, fmt.Errorf("can't unmarshal self")
+	}
+	selfProto.PreKey = h.signedPreKey
+	return selfProto, nil
+}
+
 func (h *Handler) greet(ctx context.Context, peer *peers.Peer) (*peers.Peer, error) {
 	grpcClient, err := client.InsecureConnect(ctx, h.logger, peer)
 	if err != nil {
 		return nil, fmt.Errorf("can't connect: %s", err)
 	}
+	defer grpcClient.Close()
 
-	selfProto := &greeter.Peer{}
-	if err := selfProto.UnmarshalPeer(h.self); err != nil {
-		return nil, fmt.Errorf("can't unmarshal self")
+	selfProto, err := h.Greeting()
+	if err != nil {
+		return nil, err
 	}
 
 	h.logger.Info("greeting %s", peer.ID)
@@ -173,15 +300,26 @@ func (h *Handler) greet(ctx context.Context, peer *peers.Peer) (*peers.Peer, err
 		return nil, fmt.Errorf("greeting error: %s", err)
 	}
 
+	if grpcPeer.ID != peer.ID {
+		h.logger.Error("%s answered greeting as %s", peer.ID, grpcPeer.ID)
+		return nil, fmt.Errorf("unexpected peer id: %s", grpcPeer.ID)
+	}
+
+	if err := h.verifyPublicCrt(grpcPeer.ID, grpcPeer.PublicKey); err != nil {
+		h.logger.Error("invalid certificate of %s: %s", peer.ID, err)
+		return nil, fmt.Errorf("invalid certificate: %s", err)
+	}
+
+	if err := h.savePreKey(grpcPeer.ID, grpcPeer.PublicKey, grpcPeer.PreKey); err != nil {
+		h.logger.Error("invalid prekey of %s: %s", peer.ID, err)
+		return nil, fmt.Errorf("invalid prekey: %s", err)
+	}
+
 	return grpcPeer.MarshalPeer()
 }
 
 func (h *Handler) openStream(ctx context.Context, peer *peers.Peer) (stream, error) {
-	cp := x509.NewCertPool()
-	if !cp.AppendCertsFromPEM(peer.PublicCrt) {
-		return nil, fmt.Errorf("failed to append certificates")
-	}
-	creds := credentials.NewClientTLSFromCert(cp, "")
+	creds := clientCredentials(h.self, peer.ID, h.checkKey)
 
 	md := metadata.New(map[string]string{chat.HeaderPeerID: h.self.ID})
 	ctx = metadata.NewOutgoingContext(ctx, md)
@@ -191,11 +329,7 @@ func (h *Handler) openStream(ctx context.Context, peer *peers.Peer) (stream, err
 		return nil, fmt.Errorf("can't create client: %s", err)
 	}
 
-	h.streamsGuard.Lock()
-	h.streams[peer.ID] = grpcClient
-	h.streamsGuard.Unlock()
-
-	go h.listenStream(grpcClient, peer.ID)
+	h.addStream(peer.ID, grpcClient)
 
 	h.logger.Info("connected to %s", peer.ID)
 
@@ -1,17 +1,29 @@
 package messages
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"io/ioutil"
 	"math/rand"
 	"net"
+	"os"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
-	"github.com/ngalayko/p2p/instance/logger"
+	"github.com/ngalayko/p2p/instance/messages/proto/chat"
 	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/instance/store"
+	"github.com/ngalayko/p2p/instance/store/memory"
+	"github.com/ngalayko/p2p/logger"
 )
 
 func Test_Handler__should_shotdown_on_done(t *testing.T) {
@@ -22,7 +34,7 @@ func Test_Handler__should_shotdown_on_done(t *testing.T) {
 	done := make(chan bool)
 	go func() {
 		if err := h.Start(ctx); err != nil {
-			t.Fatal(err)
+			t.Error(err)
 		}
 
 		close(done)
@@ -43,16 +55,1193 @@ func Test_Handler__should_not_sent_a_message_to_unknown_peer(t *testing.T) {
 	hReceiver := testHandler(t)
 	go run(ctx, t, hReceiver)
 
-	sent := make(chan *Message)
+	_, err := hSender.SendText(ctx, "test", hReceiver.self.ID)
+	assert.Error(t, err)
+}
+
+func Test_Handler__should_send_a_message_to_another_peer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	waitStarted(t, hSender, hReceiver)
+
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	sent := testEvents(hSender)
+	received := testEvents(hReceiver)
+
+	_, err := hSender.SendText(ctx, "test", hReceiver.self.ID)
+	assert.NoError(t, err, "can't send a message")
+
+	sentMsg := waitSent(t, sent)
+	receivedMsg := waitReceived(t, received)
+
+	assert.Equal(t, sentMsg.ID, receivedMsg.ID)
+	assert.Equal(t, sentMsg.Text, receivedMsg.Text)
+}
+
+func Test_Handler__should_send_a_message_to_self(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	waitStarted(t, hSender)
+
+	sent := testEvents(hSender)
+	received := testEvents(hSender)
+
+	_, err := hSender.SendText(ctx, "test", hSender.self.ID)
+	assert.NoError(t, err, "can't send a message")
+
+	sentMsg := waitSent(t, sent)
+	receivedMsg := waitReceived(t, received)
+
+	assert.Equal(t, sentMsg.ID, receivedMsg.ID)
+	assert.Equal(t, sentMsg.Text, receivedMsg.Text)
+}
+
+func Test_Handler__should_store_history(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	waitStarted(t, hSender, hReceiver)
+
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	received := testEvents(hReceiver)
+
+	_, err := hSender.SendText(ctx, "test", hReceiver.self.ID)
+	assert.NoError(t, err, "can't send a message")
+
+	receivedMsg := waitReceived(t, received)
+
+	sentHistory, err := hSender.History(hReceiver.self.ID, "", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, sentHistory, 1) {
+		assert.Equal(t, receivedMsg.ID, sentHistory[0].ID)
+		assert.Equal(t, "test", sentHistory[0].Text)
+	}
+
+	receivedHistory, err := hReceiver.History(hSender.self.ID, "", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, receivedHistory, 1) {
+		assert.Equal(t, receivedMsg.ID, receivedHistory[0].ID)
+		assert.Equal(t, hSender.self.ID, receivedHistory[0].From.ID)
+	}
+}
+
+func Test_Handler__should_queue_a_message_to_unreachable_peer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	waitStarted(t, hSender)
+
+	hReceiver := testHandler(t)
+
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	sent := testEvents(hSender)
+	statuses := testEvents(hSender)
+	received := testEvents(hReceiver)
+
+	_, err := hSender.SendText(ctx, "test", hReceiver.self.ID)
+	assert.NoError(t, err, "can't queue a message")
+
+	sentMsg := waitSent(t, sent)
+	assert.Equal(t, StatusQueued, sentMsg.Status)
+
+	go run(ctx, t, hReceiver)
+
+	receivedMsg := waitReceived(t, received)
+	assert.Equal(t, sentMsg.ID, receivedMsg.ID)
+
+	waitStatus(t, statuses, sentMsg.ID, StatusDelivered)
+}
+
+func Test_Handler__should_receive_receipts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	waitStarted(t, hSender, hReceiver)
+
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	statuses := testEvents(hSender)
+	received := testEvents(hReceiver)
+
+	_, err := hSender.SendText(ctx, "test", hReceiver.self.ID)
+	assert.NoError(t, err, "can't send a message")
+
+	receivedMsg := waitReceived(t, received)
+
+	waitStatus(t, statuses, receivedMsg.ID, StatusDelivered)
+
+	err = hReceiver.SendRead(ctx, hSender.self.ID, receivedMsg.ID)
+	assert.NoError(t, err, "can't send a read receipt")
+
+	waitStatus(t, statuses, receivedMsg.ID, StatusRead)
+
+	history, err := hSender.History(hReceiver.self.ID, "", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, StatusRead, history[0].Status)
+	}
+}
+
+func Test_Handler__should_fail_expired_message(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	hSender.queueTTL = 0
+	go run(ctx, t, hSender)
+
+	waitStarted(t, hSender)
+
+	hReceiver := testHandler(t)
+
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	sent := testEvents(hSender)
+	statuses := testEvents(hSender)
+
+	_, err := hSender.SendText(ctx, "test", hReceiver.self.ID)
+	assert.NoError(t, err, "can't queue a message")
+
+	sentMsg := waitSent(t, sent)
+
+	waitStatus(t, statuses, sentMsg.ID, StatusFailed)
+
+	history, err := hSender.History(hReceiver.self.ID, "", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 1) {
+		assert.Equal(t, StatusFailed, history[0].Status)
+	}
+}
+
+func Test_Handler__should_requeue_stored_queued_messages_on_start(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	waitStarted(t, hReceiver)
+
+	hSender := testHandler(t)
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	// messages were queued before the sender restarted.
+	expired := testQueued(t, hSender, hReceiver.self.ID, "expired", time.Now().Add(-hSender.queueTTL-time.Minute))
+	queued := testQueued(t, hSender, hReceiver.self.ID, "queued", time.Now())
+
+	statuses := testEvents(hSender)
+	received := testEvents(hReceiver)
+
+	go run(ctx, t, hSender)
+
+	waitStatus(t, statuses, expired.ID, StatusFailed)
+
+	msg := waitReceived(t, received)
+	assert.Equal(t, queued.ID, msg.ID)
+	assert.Equal(t, "queued", msg.Text)
+
+	waitStatus(t, statuses, queued.ID, StatusDelivered)
+}
+
+func Test_Handler__should_fail_a_file_with_an_expired_chunk(t *testing.T) {
+	h := testHandler(t)
+	h.queueTTL = 0
+
+	to := testPeer(t)
+	h.self.KnownPeers.Add(to)
+
+	file := &store.Message{ID: "file", ChatID: to.ID, FromID: h.self.ID, ToID: to.ID, Status: string(StatusQueued)}
+	assert.NoError(t, h.store.Save(file))
+
+	chunk, err := h.makeMessage(&chat.Message{
+		Payload: &chat.Message_File{
+			File: &chat.FileChunk{FileID: file.ID},
+		},
+	})
+	assert.NoError(t, err)
+
+	h.queue.push(to.ID, chunk)
+	h.flushOutbox(context.Background(), to.ID)
+
+	stored, err := h.store.Get(file.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, string(StatusFailed), stored.Status)
+	}
+	assert.False(t, h.queue.has(to.ID))
+}
+
+func Test_Handler__should_send_a_message_to_group(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hOwner := testHandler(t)
+	go run(ctx, t, hOwner)
+
+	hFirst := testHandler(t)
+	go run(ctx, t, hFirst)
+
+	hSecond := testHandler(t)
+	go run(ctx, t, hSecond)
+
+	waitStarted(t, hOwner, hFirst, hSecond)
+
+	hOwner.self.KnownPeers.Add(hFirst.self)
+	hOwner.self.KnownPeers.Add(hSecond.self)
+
+	ownerGroups := testEvents(hOwner)
+	memberEvents := []*Subscription{testEvents(hFirst), testEvents(hSecond)}
+
+	group, err := hOwner.CreateGroup(ctx, "test", []string{hFirst.self.ID, hSecond.self.ID})
+	assert.NoError(t, err, "can't create a group")
+	assert.Len(t, group.Members, 3)
+
+	for _, events := range memberEvents {
+		g := waitGroup(t, events)
+		assert.Equal(t, group.ID, g.ID)
+		assert.Equal(t, "test", g.Name)
+		assert.Len(t, g.Members, 3)
+	}
+
+	_, err = hOwner.SendGroupText(ctx, "test", group.ID)
+	assert.NoError(t, err, "can't send a message")
+
+	for _, events := range memberEvents {
+		msg := waitReceived(t, events)
+		assert.Equal(t, "test", msg.Text)
+		assert.Equal(t, hOwner.self.ID, msg.From.ID)
+		if assert.NotNil(t, msg.Group) {
+			assert.Equal(t, group.ID, msg.Group.ID)
+		}
+	}
+
+	history, err := hFirst.History(group.ID, "", 10)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	err = hSecond.LeaveGroup(ctx, group.ID)
+	assert.NoError(t, err, "can't leave a group")
+
+	for {
+		g := waitGroup(t, ownerGroups)
+		if len(g.Members) == 2 {
+			assert.False(t, g.has(hSecond.self.ID))
+			assert.Len(t, hSecond.Groups(), 0)
+			return
+		}
+	}
+}
+
+func Test_Handler__should_send_a_file(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	waitStarted(t, hSender, hReceiver)
+
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	sent := testEvents(hSender)
+	received := testEvents(hReceiver)
+
+	data := make([]byte, 3*fileChunkSize+42)
+	rand.Read(data)
+
+	err := hSender.SendFile(ctx, hReceiver.self.ID, "test.bin", "", bytes.NewReader(data))
+	assert.NoError(t, err, "can't send a file")
+
+	sentMsg := waitSent(t, sent)
+
+	msg := waitReceived(t, received)
+	assert.Equal(t, sentMsg.ID, msg.ID)
+	assert.Equal(t, TypeFile, msg.Type)
+	if assert.NotNil(t, msg.File) {
+		assert.Equal(t, "test.bin", msg.File.Name)
+		assert.Equal(t, int64(len(data)), msg.File.Size)
+		assert.Equal(t, sentMsg.File.SHA256, msg.File.SHA256)
+	}
+
+	file, f, err := hReceiver.OpenFile(sentMsg.ID)
+	if assert.NoError(t, err) {
+		defer f.Close()
+
+		received, err := ioutil.ReadAll(f)
+		assert.NoError(t, err)
+		assert.Equal(t, data, received)
+		assert.Equal(t, "application/octet-stream", file.MIME)
+	}
+}
+
+func Test_Handler__should_queue_one_chunk_of_a_file_to_unreachable_peer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	waitStarted(t, hSender)
+
+	hReceiver := testHandler(t)
+
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	statuses := testEvents(hSender)
+	received := testEvents(hReceiver)
+
+	data := make([]byte, 5*fileChunkSize+42)
+	rand.Read(data)
+
+	err := hSender.SendFile(ctx, hReceiver.self.ID, "test.bin", "", bytes.NewReader(data))
+	assert.NoError(t, err, "can't send a file")
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !hSender.queue.has(hReceiver.self.ID) {
+		if time.Now().After(deadline) {
+			t.Fatal("file is not queued")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	hSender.queue.guard.Lock()
+	assert.Len(t, hSender.queue.byPeer[hReceiver.self.ID].messages, 1)
+	hSender.queue.guard.Unlock()
+
+	go run(ctx, t, hReceiver)
+
+	msg := waitReceived(t, received)
+	assert.Equal(t, TypeFile, msg.Type)
+
+	waitStatus(t, statuses, msg.ID, StatusDelivered)
+
+	_, f, err := hReceiver.OpenFile(msg.ID)
+	if assert.NoError(t, err) {
+		defer f.Close()
+
+		receivedData, err := ioutil.ReadAll(f)
+		assert.NoError(t, err)
+		assert.Equal(t, data, receivedData)
+	}
+}
+
+func Test_Handler__should_ask_to_resume_once_per_gap(t *testing.T) {
+	h := testHandler(t)
+
+	assert.True(t, h.askResume("file", 10))
+	assert.False(t, h.askResume("file", 10))
+	assert.True(t, h.askResume("file", 20))
+	assert.True(t, h.askResume("other", 10))
+}
+
+func Test_Handler__should_not_write_a_chunk_after_a_gap(t *testing.T) {
+	h := testHandler(t)
+
+	chunk := testChunk(t, h, []byte("test"))
+	chunk.Offset = 2
+	chunk.Data = chunk.Data[2:]
+
+	received, err := h.writeChunk(testPeer(t).ID, chunk)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), received)
+}
+
+func Test_Handler__should_verify_a_received_file(t *testing.T) {
+	h := testHandler(t)
+	from := testPeer(t)
+
+	chunk := testChunk(t, h, []byte("test"))
+	chunk.SHA256[0]++
+
+	_, err := h.writeChunk(from.ID, chunk)
+	assert.Error(t, err)
+
+	_, err = os.Stat(h.filePath(chunk.FileID))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(h.partialPath(from.ID, chunk.FileID))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func Test_Handler__should_not_write_a_chunk_of_another_file(t *testing.T) {
+	h := testHandler(t)
+	from := testPeer(t)
+
+	chunk := testChunk(t, h, []byte("test"))
+	first := *chunk
+	first.Data = chunk.Data[:2]
+
+	received, err := h.writeChunk(from.ID, &first)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), received)
+
+	for _, change := range []func(c *chat.FileChunk){
+		func(c *chat.FileChunk) { c.Size++ },
+		func(c *chat.FileChunk) { c.SHA256 = append([]byte{}, c.SHA256...); c.SHA256[0]++ },
+	} {
+		second := *chunk
+		second.Offset = 2
+		second.Data = chunk.Data[2:]
+		change(&second)
+
+		_, err := h.writeChunk(from.ID, &second)
+		assert.Error(t, err)
+	}
+
+	second := *chunk
+	second.Offset = 2
+	second.Data = chunk.Data[2:]
+
+	received, err = h.writeChunk(from.ID, &second)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), received)
+}
+
+func Test_Handler__should_keep_partial_files_of_every_sender(t *testing.T) {
+	h := testHandler(t)
+	from, other := testPeer(t), testPeer(t)
+
+	chunk := testChunk(t, h, []byte("test"))
+	first := *chunk
+	first.Data = chunk.Data[:2]
+
+	_, err := h.writeChunk(from.ID, &first)
+	assert.NoError(t, err)
+
+	// another peer sends other data with the same file id.
+	forged := testChunk(t, h, []byte("fake"))
+	forged.FileID = chunk.FileID
+	forged.Data = forged.Data[:2]
+
+	_, err = h.writeChunk(other.ID, forged)
+	assert.NoError(t, err)
+
+	second := *chunk
+	second.Offset = 2
+	second.Data = chunk.Data[2:]
+
+	_, err = h.writeChunk(from.ID, &second)
+	assert.NoError(t, err)
+
+	data, err := ioutil.ReadFile(h.filePath(chunk.FileID))
+	assert.NoError(t, err)
+	assert.Equal(t, "test", string(data))
+}
+
+func Test_Handler__should_remove_stale_partial_files(t *testing.T) {
+	h := testHandler(t)
+	from := testPeer(t)
+
+	stale := testChunk(t, h, []byte("stale"))
+	stale.Data = stale.Data[:1]
+	fresh := testChunk(t, h, []byte("fresh"))
+	fresh.Data = fresh.Data[:1]
+
+	for _, chunk := range []*chat.FileChunk{stale, fresh} {
+		_, err := h.writeChunk(from.ID, chunk)
+		assert.NoError(t, err)
+	}
+
+	past := time.Now().Add(-partTimeout - time.Minute)
+	assert.NoError(t, os.Chtimes(h.partialPath(from.ID, stale.FileID), past, past))
+
+	h.filesGuard.Lock()
+	h.prunePartials(time.Now())
+	h.filesGuard.Unlock()
+
+	_, err := os.Stat(h.partialPath(from.ID, stale.FileID))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(h.partialPath(from.ID, fresh.FileID))
+	assert.NoError(t, err)
+}
+
+func Test_Handler__should_not_send_a_message_to_impostor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	hReceiver := testHandler(t)
+
+	// impostor claims the receiver id, but has a different key.
+	hImpostor := testHandler(t)
+	hImpostor.self.ID = hReceiver.self.ID
+	go run(ctx, t, hImpostor)
+
+	waitStarted(t, hSender, hImpostor)
+
+	hSender.self.KnownPeers.Add(hImpostor.self)
+
+	sent := testEvents(hSender)
+	received := testEvents(hImpostor)
+
+	_, err := hSender.SendText(ctx, "test", hReceiver.self.ID)
+	assert.NoError(t, err, "can't queue a message")
+
+	sentMsg := waitSent(t, sent)
+	assert.Equal(t, StatusQueued, sentMsg.Status)
+
+	assertNotReceived(t, received, "impostor received a message")
+}
+
+func Test_Handler__should_not_accept_a_stream_from_impostor(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	hVictim := testHandler(t)
+
+	// impostor claims the victim id, but has a different key.
+	hImpostor := testHandler(t)
+	hImpostor.self.ID = hVictim.self.ID
+	hReceiver.self.KnownPeers.Add(hVictim.self)
+
+	waitStarted(t, hReceiver)
+
+	secureResolver.Add(hReceiver.self)
+
+	received := testEvents(hReceiver)
+
+	s, err := hImpostor.openStream(ctx, hReceiver.self)
+	assert.NoError(t, err, "can't open a stream")
+
+	msg, err := hImpostor.makeText("test")
+	assert.NoError(t, err)
+
+	_ = s.Send(msg)
+
+	assertNotReceived(t, received, "message from impostor received")
+}
+
+func Test_Handler__should_pin_keys(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	waitStarted(t, hSender, hReceiver)
+
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	received := testEvents(hReceiver)
+
+	_, err := hSender.SendText(ctx, "test", hReceiver.self.ID)
+	assert.NoError(t, err, "can't send a message")
+
+	waitReceived(t, received)
+
+	senderKey, err := hSender.Key(hReceiver.self.ID)
+	assert.NoError(t, err)
+	assert.False(t, senderKey.Verified)
+
+	receiverKey, err := hReceiver.Key(hSender.self.ID)
+	assert.NoError(t, err)
+
+	assert.Equal(t, senderKey.SafetyNumber, receiverKey.SafetyNumber)
+
+	verified, err := hSender.VerifyKey(hReceiver.self.ID)
+	assert.NoError(t, err)
+	assert.True(t, verified.Verified)
+}
+
+func Test_Handler__should_reject_a_changed_key(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	waitStarted(t, hSender, hReceiver)
+
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	// the receiver was known with another key before.
+	assert.NoError(t, hSender.store.SaveKey(&store.Key{
+		PeerID:      hReceiver.self.ID,
+		Fingerprint: "previous",
+	}))
+	hSender.loadKeys()
+
+	keyChanges := testEvents(hSender)
+	received := testEvents(hReceiver)
+
+	_, err := hSender.SendText(ctx, "test", hReceiver.self.ID)
+	assert.NoError(t, err, "can't queue a message")
+
+	k := waitKeyChanged(t, keyChanges)
+	assert.Equal(t, hReceiver.self.ID, k.PeerID)
+	assert.Equal(t, "previous", k.Fingerprint)
+	assert.NotEmpty(t, k.ChangedFingerprint)
+
+	assertNotReceived(t, received, "message was sent to a changed key")
+
+	_, err = hSender.TrustKey(hReceiver.self.ID)
+	assert.NoError(t, err)
+
+	msg := waitReceived(t, received)
+	assert.Equal(t, "test", msg.Text)
+}
+
+func Test_Handler__should_report_a_second_key_of_a_peer(t *testing.T) {
+	h := testHandler(t)
+	first, second := testPeer(t), testPeer(t)
+
+	keyChanges := testEvents(h)
+
+	firstCrt, err := peers.ParsePublicCrt(first.PublicCrt)
+	assert.NoError(t, err)
+	secondCrt, err := peers.ParsePublicCrt(second.PublicCrt)
+	assert.NoError(t, err)
+
+	assert.NoError(t, h.checkKey(first.ID, firstCrt))
+	assert.Error(t, h.checkKey(first.ID, secondCrt))
+
+	secondFingerprint, err := peers.KeyFingerprint(secondCrt.PublicKey)
+	assert.NoError(t, err)
+
+	k := waitKeyChanged(t, keyChanges)
+	assert.Equal(t, first.ID, k.PeerID)
+	assert.Equal(t, secondFingerprint, k.ChangedFingerprint)
+
+	assert.NoError(t, h.checkKey(first.ID, firstCrt))
+}
+
+func Test_Handler__should_encrypt_every_message_with_a_new_key(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	waitStarted(t, hSender, hReceiver)
+
+	testGreet(ctx, t, hSender, hReceiver)
+
+	msg, err := hSender.makeText("secret")
+	assert.NoError(t, err)
+
+	first, err := hSender.seal(hReceiver.self, msg)
+	assert.NoError(t, err)
+	second, err := hSender.seal(hReceiver.self, msg)
+	assert.NoError(t, err)
+
+	firstEncrypted := first.GetEncrypted()
+	secondEncrypted := second.GetEncrypted()
+	if !assert.NotNil(t, firstEncrypted) || !assert.NotNil(t, secondEncrypted) {
+		return
+	}
+	assert.NotContains(t, string(firstEncrypted.Ciphertext), "secret")
+	assert.NotEqual(t, firstEncrypted.Ciphertext, secondEncrypted.Ciphertext)
+	assert.Equal(t, firstEncrypted.Count+1, secondEncrypted.Count)
+	assert.NotNil(t, firstEncrypted.Init, "session must be started in the first message")
+
+	for _, sealed := range []*chat.Message{second, first} {
+		opened, err := hReceiver.open(hSender.self, sealed)
+		if assert.NoError(t, err) {
+			assert.Equal(t, "secret", opened.GetText())
+		}
+	}
+
+	_, err = hReceiver.open(hSender.self, first)
+	assert.Error(t, err, "message key must be used only once")
+
+	// the answer uses a new ratchet key, after that the sender stops sending init.
+	answer, err := hReceiver.makeText("answer")
+	assert.NoError(t, err)
+
+	sealedAnswer, err := hReceiver.seal(hSender.self, answer)
+	assert.NoError(t, err)
+	assert.Nil(t, sealedAnswer.GetEncrypted().Init)
+	assert.NotEqual(t, firstEncrypted.RatchetKey, sealedAnswer.GetEncrypted().RatchetKey)
+
+	opened, err := hSender.open(hReceiver.self, sealedAnswer)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "answer", opened.GetText())
+	}
+
+	third, err := hSender.seal(hReceiver.self, msg)
+	assert.NoError(t, err)
+	assert.Nil(t, third.GetEncrypted().Init)
+}
+
+func Test_Handler__should_agree_on_a_session_started_by_both_peers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hFirst := testHandler(t)
+	go run(ctx, t, hFirst)
+
+	hSecond := testHandler(t)
+	go run(ctx, t, hSecond)
+
+	waitStarted(t, hFirst, hSecond)
+
+	testGreet(ctx, t, hFirst, hSecond)
+
+	fromFirst, err := hFirst.makeText("from first")
+	assert.NoError(t, err)
+	fromSecond, err := hSecond.makeText("from second")
+	assert.NoError(t, err)
+
+	sealedFirst, err := hFirst.seal(hSecond.self, fromFirst)
+	assert.NoError(t, err)
+	sealedSecond, err := hSecond.seal(hFirst.self, fromSecond)
+	assert.NoError(t, err)
+
+	_, err = hSecond.open(hFirst.self, sealedFirst)
+	assert.NoError(t, err)
+	_, err = hFirst.open(hSecond.self, sealedSecond)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		sealed, err := hFirst.seal(hSecond.self, fromFirst)
+		assert.NoError(t, err)
+		_, err = hSecond.open(hFirst.self, sealed)
+		assert.NoError(t, err)
+
+		sealed, err = hSecond.seal(hFirst.self, fromSecond)
+		assert.NoError(t, err)
+		_, err = hFirst.open(hSecond.self, sealed)
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t,
+		hFirst.sessions[hSecond.self.ID].current.id,
+		hSecond.sessions[hFirst.self.ID].current.id,
+	)
+}
+
+func Test_Handler__should_not_replace_a_prekey_with_an_older_one(t *testing.T) {
+	h := testHandler(t)
+	hPeer := testHandler(t)
+
+	greeting, err := hPeer.Greeting()
+	if err != nil {
+		t.Fatalf("can't make a greeting: %s", err)
+	}
+	old := greeting.PreKey
+
+	_, newer, err := newPreKey(hPeer.self, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("can't make a prekey: %s", err)
+	}
+
+	assert.NoError(t, h.savePreKey(hPeer.self.ID, greeting.PublicKey, old))
+	assert.NoError(t, h.savePreKey(hPeer.self.ID, greeting.PublicKey, newer))
+	assert.Error(t, h.savePreKey(hPeer.self.ID, greeting.PublicKey, old), "a replayed prekey must be rejected")
+
+	forged := *old
+	forged.CreatedAt = newer.CreatedAt + 1
+	assert.Error(t, h.savePreKey(hPeer.self.ID, greeting.PublicKey, &forged), "the creation time must be signed")
+
+	assert.Equal(t, newer.Key, h.preKeys[hPeer.self.ID].Key)
+}
+
+func Test_Handler__should_not_accept_a_message_in_plaintext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	waitStarted(t, hSender, hReceiver)
+
+	received := testEvents(hReceiver)
+
+	s, err := hSender.getStream(ctx, hReceiver.self)
+	assert.NoError(t, err, "can't open a stream")
+
+	msg, err := hSender.makeText("test")
+	assert.NoError(t, err)
+
+	_ = s.Send(msg)
+
+	assertNotReceived(t, received, "message in plaintext received")
+}
+
+func Test_Handler__should_relay_a_message_to_unreachable_peer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	hRelay := testHandler(t)
+	go run(ctx, t, hRelay)
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	waitStarted(t, hSender, hRelay, hReceiver)
+
+	relayReceived := testEvents(hRelay)
+	statuses := testEvents(hSender)
+	received := testEvents(hReceiver)
+
+	// both peers have live streams to the relay.
+	hSender.self.KnownPeers.Add(hRelay.self)
+	hReceiver.self.KnownPeers.Add(hRelay.self)
+	_, err := hSender.SendText(ctx, "hello relay", hRelay.self.ID)
+	assert.NoError(t, err)
+	_, err = hReceiver.SendText(ctx, "hello relay", hRelay.self.ID)
+	assert.NoError(t, err)
+	waitReceived(t, relayReceived)
+	waitReceived(t, relayReceived)
+
+	// the receiver is known, but has no address.
+	unreachable := peers.NewBlank()
+	unreachable.ID = hReceiver.self.ID
+	hSender.self.KnownPeers.Add(unreachable)
+
+	msg, err := hSender.makeText("through relay")
+	assert.NoError(t, err)
+
+	sent := fromProto(hSender.self, unreachable, msg)
+	assert.NoError(t, hSender.store.Save(sent.toRecord(hSender.self)))
+	hSender.deliver(ctx, unreachable.ID, msg)
+
+	relayed := waitReceived(t, received)
+	assert.Equal(t, "through relay", relayed.Text)
+	assert.Equal(t, hSender.self.ID, relayed.From.ID)
+
+	waitStatus(t, statuses, msg.ID, StatusDelivered)
+
+	assertNotReceived(t, relayReceived, "relay received a relayed message")
+}
+
+func Test_Handler__should_not_forward_an_envelope_twice(t *testing.T) {
+	h := testHandler(t)
+
+	env := &chat.Envelope{
+		ID:       "envelope",
+		FromID:   "from",
+		ToID:     "to",
+		HopLimit: maxHops,
+	}
+
+	assert.False(t, h.seen(env.ID))
+	assert.True(t, h.seen(env.ID))
+}
+
+func Test_Handler__should_not_learn_a_route_from_a_forged_envelope(t *testing.T) {
+	h := testHandler(t)
+	hop := testPeer(t)
+	victim := testPeer(t)
+	h.self.KnownPeers.Add(victim)
+
+	h.handleEnvelope(hop, &chat.Envelope{
+		ID:       "envelope",
+		FromID:   victim.ID,
+		ToID:     h.self.ID,
+		HopLimit: maxHops,
+		Content: &chat.Envelope_Message{
+			Message: &chat.Message{ID: "forged"},
+		},
+	})
+
+	h.relayGuard.Lock()
+	_, hasRoute := h.routes[victim.ID]
+	h.relayGuard.Unlock()
+	assert.False(t, hasRoute)
+}
+
+func Test_Handler__should_not_accept_a_replayed_relayed_greeting(t *testing.T) {
+	h := testHandler(t)
+	hPeer := testHandler(t)
+	hop := testPeer(t)
+
+	greeting, err := hPeer.Greeting()
+	if err != nil {
+		t.Fatalf("can't make a greeting: %s", err)
+	}
+	greeting.KnownPeers = nil
+	old, err := proto.Marshal(greeting)
+	if err != nil {
+		t.Fatalf("can't marshal a greeting: %s", err)
+	}
+
+	// the peer restarted with a new prekey since the greeting was captured.
+	_, newer, err := newPreKey(hPeer.self, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatalf("can't make a prekey: %s", err)
+	}
+	assert.NoError(t, h.savePreKey(hPeer.self.ID, greeting.PublicKey, newer))
+
+	// ids of envelopes are forgotten after seenTTL, so it's a new one.
+	h.handleEnvelope(hop, &chat.Envelope{
+		ID:       "replayed",
+		FromID:   hPeer.self.ID,
+		ToID:     h.self.ID,
+		HopLimit: maxHops,
+		Content: &chat.Envelope_Greeting{
+			Greeting: old,
+		},
+	})
+
+	assert.Equal(t, newer.Key, h.preKeys[hPeer.self.ID].Key)
+}
+
+func Test_Handler__should_not_relay_over_the_hop_limit(t *testing.T) {
+	h := testHandler(t)
+	h.streams["relay"] = nil
+	h.self.KnownPeers.Add(&peers.Peer{ID: "relay"})
+
+	env := &chat.Envelope{
+		ID:     "envelope",
+		FromID: "from",
+		ToID:   "to",
+		Route:  []string{"from"},
+	}
+	assert.Empty(t, h.relays(env), "envelope at the hop limit must not be relayed")
+
+	env.HopLimit = 1
+	assert.Len(t, h.relays(env), 1)
+
+	env.Route = append(env.Route, "relay")
+	assert.Empty(t, h.relays(env), "envelope must not be relayed back")
+}
+
+func Test_Handler__should_deliver_a_message_to_every_subscriber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := testHandler(t)
+	go run(ctx, t, h)
+
+	waitStarted(t, h)
+
+	first := testEvents(h)
+	second := testEvents(h)
+	closed := testEvents(h)
+	closed.Close()
+
+	_, err := h.SendText(ctx, "test", h.self.ID)
+	assert.NoError(t, err, "can't send a message")
+
+	for _, s := range []*Subscription{first, second} {
+		assert.Equal(t, "test", waitReceived(t, s).Text)
+	}
+
+	_, ok := <-closed.Events()
+	assert.False(t, ok, "closed subscription must not receive messages")
+}
+
+func Test_Handler__should_not_block_without_subscribers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	waitStarted(t, hSender, hReceiver)
+
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	statuses := testEvents(hSender)
+
+	// nobody listens to the receiver, it must still acknowledge every message.
+	for i := 0; i < 3; i++ {
+		_, err := hSender.SendText(ctx, "test", hReceiver.self.ID)
+		assert.NoError(t, err, "can't send a message")
+
+		history, err := hSender.History(hReceiver.self.ID, "", 1)
+		if assert.NoError(t, err) && assert.Len(t, history, 1) {
+			waitStatus(t, statuses, history[0].ID, StatusDelivered)
+		}
+	}
+}
+
+func Test_Handler__should_drop_a_failed_stream(t *testing.T) {
+	h := testHandler(t)
+	h.self.KnownPeers.Add(&peers.Peer{ID: "peer"})
+
+	events := testEvents(h)
+
+	s := &testStream{
+		recvErr: status.Error(codes.Unavailable, "transport is closing"),
+	}
+	h.addStream("peer", s)
+
+	e := waitEvent(t, events, 10*time.Second, func(e Event) bool {
+		c, ok := e.(*ConnectionChanged)
+		return ok && !c.Connected
+	})
+	if !assert.NotNil(t, e, "disconnect was not reported") {
+		return
+	}
+	assert.Equal(t, "peer", e.(*ConnectionChanged).PeerID)
+
+	assert.False(t, h.Connected("peer"))
+	assert.True(t, s.isClosed(), "stream must be closed")
+
+	h.streamsGuard.RLock()
+	r, ok := h.reconnects["peer"]
+	h.streamsGuard.RUnlock()
+	if assert.True(t, ok, "reconnect must be scheduled") {
+		assert.True(t, r.nextAttempt.After(time.Now()))
+	}
+}
+
+func Test_Handler__should_reconnect_to_a_restarted_peer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	receiverCtx, stopReceiver := context.WithCancel(ctx)
+	hReceiver := testHandler(t)
+	receiverStopped := make(chan bool)
 	go func() {
-		sent <- <-hReceiver.Received()
+		run(receiverCtx, t, hReceiver)
+		close(receiverStopped)
 	}()
 
-	err := hSender.SendText(ctx, "test", hReceiver.self.ID)
+	waitStarted(t, hSender, hReceiver)
+
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	events := testEvents(hSender)
+
+	_, err := hSender.SendText(ctx, "test", hReceiver.self.ID)
+	assert.NoError(t, err, "can't send a message")
+
+	waitConnection(t, events, hReceiver.self.ID, true)
+
+	stopReceiver()
+	<-receiverStopped
+
+	waitConnection(t, events, hReceiver.self.ID, false)
+	assert.False(t, hSender.Connected(hReceiver.self.ID))
+
+	// the same peer starts again on other ports, and is discovered again.
+	restarted := *hReceiver.self
+	restarted.Port = getNextPort()
+	restarted.InsecurePort = getNextPort()
+	restarted.UIPort = getNextPort()
+	hRestarted := NewHandler(
+		rand.New(rand.NewSource(time.Now().UnixNano())),
+		logger.New(logger.LevelDebug),
+		&restarted,
+		memory.New(),
+		testDir(t),
+	)
+	go run(ctx, t, hRestarted)
+	waitStarted(t, hRestarted)
+
+	hSender.self.KnownPeers.Add(&restarted)
+
+	waitConnection(t, events, hReceiver.self.ID, true)
+	assert.Equal(t, restarted.Port, hSender.self.KnownPeers.Map()[restarted.ID].Port)
+}
+
+func Test_Handler__should_send_typing_without_storing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	waitStarted(t, hSender, hReceiver)
+
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	events := testEvents(hReceiver)
+
+	err := hSender.SendTyping(ctx, hReceiver.self.ID, true)
+	assert.NoError(t, err, "can't send typing")
+
+	typing := waitTyping(t, events)
+	assert.Equal(t, hSender.self.ID, typing.PeerID)
+	assert.Empty(t, typing.GroupID)
+	assert.True(t, typing.Typing)
+
+	err = hSender.SendTyping(ctx, hReceiver.self.ID, false)
+	assert.NoError(t, err, "can't send typing")
+
+	assert.False(t, waitTyping(t, events).Typing)
+
+	for _, h := range []*Handler{hSender, hReceiver} {
+		history, err := h.History(hSender.self.ID, "", 10)
+		assert.NoError(t, err)
+		assert.Empty(t, history)
+
+		history, err = h.History(hReceiver.self.ID, "", 10)
+		assert.NoError(t, err)
+		assert.Empty(t, history)
+	}
+}
+
+func Test_Handler__should_not_queue_typing_to_unreachable_peer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	h := testHandler(t)
+	go run(ctx, t, h)
+
+	waitStarted(t, h)
+
+	unreachable := testPeer(t)
+	h.self.KnownPeers.Add(unreachable)
+
+	err := h.SendTyping(ctx, unreachable.ID, true)
 	assert.Error(t, err)
+	assert.False(t, h.queue.has(unreachable.ID))
 }
 
-func Test_Handler__should_send_a_message_to_another_peer(t *testing.T) {
+func Test_Handler__should_send_presence_to_connected_peers(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -62,54 +1251,364 @@ func Test_Handler__should_send_a_message_to_another_peer(t *testing.T) {
 	hReceiver := testHandler(t)
 	go run(ctx, t, hReceiver)
 
+	waitStarted(t, hSender, hReceiver)
+
 	hSender.self.KnownPeers.Add(hReceiver.self)
 
-	received := make(chan *Message)
-	go func() {
-		received <- <-hSender.Sent()
-	}()
+	events := testEvents(hReceiver)
 
-	sent := make(chan *Message)
-	go func() {
-		sent <- <-hReceiver.Received()
-	}()
+	assert.Error(t, hSender.SetPresence(ctx, Presence("unknown")))
+
+	// opens a stream to the receiver.
+	err := hSender.SendTyping(ctx, hReceiver.self.ID, false)
+	assert.NoError(t, err, "can't send typing")
+
+	assert.Equal(t, PresenceActive, hReceiver.Presence(hSender.self.ID))
+
+	err = hSender.SetPresence(ctx, PresenceDoNotDisturb)
+	assert.NoError(t, err, "can't set presence")
+	assert.Equal(t, PresenceDoNotDisturb, hSender.Presence(hSender.self.ID))
+
+	e := waitEvent(t, events, 10*time.Second, func(e Event) bool {
+		_, ok := e.(*PresenceChanged)
+		return ok
+	})
+	if assert.NotNil(t, e, "presence was not received") {
+		presence := e.(*PresenceChanged)
+		assert.Equal(t, hSender.self.ID, presence.PeerID)
+		assert.Equal(t, PresenceDoNotDisturb, presence.Presence)
+	}
+	assert.Equal(t, PresenceDoNotDisturb, hReceiver.Presence(hSender.self.ID))
+}
+
+func Test_Handler__should_drop_group_typing_from_non_member(t *testing.T) {
+	h := testHandler(t)
+	events := testEvents(h)
+
+	h.handleTyping(testPeer(t), "group", &chat.Typing{Active: true})
+
+	select {
+	case e := <-events.Events():
+		t.Fatalf("unexpected event: %+v", e)
+	default:
+	}
+}
+
+func Test_Handler__should_merge_group_invites_instead_of_replacing_the_roster(t *testing.T) {
+	h := testHandler(t)
+	alice, bob, carol := testPeer(t), testPeer(t), testPeer(t)
 
-	err := hSender.SendText(ctx, "test", hReceiver.self.ID)
+	h.handleGroupUpdate(alice, &chat.Group{
+		ID:      "group",
+		Members: []*chat.Member{{ID: alice.ID}, {ID: h.self.ID}, {ID: bob.ID}},
+		Invited: []*chat.Member{{ID: h.self.ID}},
+	})
+
+	// bob invites carol, and tries to drop alice.
+	h.handleGroupUpdate(bob, &chat.Group{
+		ID:      "group",
+		Members: []*chat.Member{{ID: bob.ID}, {ID: h.self.ID}, {ID: carol.ID}},
+		Invited: []*chat.Member{{ID: carol.ID}},
+	})
+
+	g, err := h.getGroup("group")
+	if assert.NoError(t, err) {
+		assert.Len(t, g.Members, 4)
+		assert.True(t, g.has(alice.ID))
+		assert.True(t, g.has(carol.ID))
+	}
+}
+
+func Test_Handler__should_drop_updates_of_a_left_group(t *testing.T) {
+	h := testHandler(t)
+	alice, bob := testPeer(t), testPeer(t)
+
+	h.handleGroupUpdate(alice, &chat.Group{
+		ID:      "group",
+		Members: []*chat.Member{{ID: alice.ID}, {ID: h.self.ID}},
+		Invited: []*chat.Member{{ID: h.self.ID}},
+	})
+
+	g, err := h.getGroup("group")
+	if !assert.NoError(t, err) {
+		return
+	}
+	g.Left = true
+	h.saveGroup(g)
+
+	h.handleGroupUpdate(alice, &chat.Group{
+		ID:      "group",
+		Members: []*chat.Member{{ID: alice.ID}, {ID: h.self.ID}, {ID: bob.ID}},
+		Invited: []*chat.Member{{ID: bob.ID}},
+	})
+
+	assert.Len(t, h.Groups(), 0)
+	assert.True(t, h.groups["group"].Left)
+	assert.False(t, h.groups["group"].has(bob.ID))
+}
+
+func Test_Handler__should_edit_and_delete_a_message(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	waitStarted(t, hSender, hReceiver)
+
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	sent := testEvents(hSender)
+	received := testEvents(hReceiver)
+
+	_, err := hSender.SendText(ctx, "test", hReceiver.self.ID)
 	assert.NoError(t, err, "can't send a message")
 
-	sentMsg := <-sent
+	msg := waitSent(t, sent)
+	waitReceived(t, received)
 
-	receivedMsg := <-received
+	err = hReceiver.EditMessage(ctx, msg.ID, "impostor")
+	assert.Error(t, err, "only the sender can edit a message")
 
-	assert.Equal(t, sentMsg.ID, receivedMsg.ID)
-	assert.Equal(t, sentMsg.Text, receivedMsg.Text)
+	err = hSender.EditMessage(ctx, msg.ID, "edited")
+	assert.NoError(t, err, "can't edit a message")
+
+	for _, s := range []*Subscription{sent, received} {
+		updated := waitUpdated(t, s)
+		assert.Equal(t, msg.ID, updated.ID)
+		assert.Equal(t, "edited", updated.Text)
+		assert.NotNil(t, updated.EditedAt)
+	}
+
+	err = hSender.DeleteMessage(ctx, msg.ID)
+	assert.NoError(t, err, "can't delete a message")
+
+	for _, s := range []*Subscription{sent, received} {
+		updated := waitUpdated(t, s)
+		assert.True(t, updated.Deleted)
+		assert.Empty(t, updated.Text)
+	}
+
+	for _, h := range []*Handler{hSender, hReceiver} {
+		r, err := h.store.Get(msg.ID)
+		if assert.NoError(t, err) {
+			assert.True(t, r.Deleted)
+			assert.Empty(t, r.Text)
+		}
+	}
+
+	err = hSender.EditMessage(ctx, msg.ID, "edited again")
+	assert.Error(t, err, "a deleted message can't be edited")
 }
 
-func Test_Handler__should_send_a_message_to_self(t *testing.T) {
+func Test_Handler__should_react_to_a_message(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	hSender := testHandler(t)
 	go run(ctx, t, hSender)
 
-	received := make(chan *Message)
-	go func() {
-		received <- <-hSender.Sent()
-	}()
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
 
-	sent := make(chan *Message)
-	go func() {
-		sent <- <-hSender.Received()
-	}()
+	waitStarted(t, hSender, hReceiver)
 
-	err := hSender.SendText(ctx, "test", hSender.self.ID)
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	sent := testEvents(hSender)
+	received := testEvents(hReceiver)
+
+	_, err := hSender.SendText(ctx, "test", hReceiver.self.ID)
 	assert.NoError(t, err, "can't send a message")
 
-	sentMsg := <-sent
-	receivedMsg := <-received
+	msg := waitReceived(t, received)
 
-	assert.Equal(t, sentMsg.ID, receivedMsg.ID)
-	assert.Equal(t, sentMsg.Text, receivedMsg.Text)
+	err = hReceiver.React(ctx, msg.ID, "+1")
+	assert.NoError(t, err, "can't react to a message")
+
+	for _, s := range []*Subscription{sent, received} {
+		updated := waitUpdated(t, s)
+		if assert.Len(t, updated.Reactions, 1) {
+			assert.Equal(t, hReceiver.self.ID, updated.Reactions[0].PeerID)
+			assert.Equal(t, "+1", updated.Reactions[0].Emoji)
+		}
+	}
+
+	err = hReceiver.Unreact(ctx, msg.ID, "+1")
+	assert.NoError(t, err, "can't remove a reaction")
+
+	for _, s := range []*Subscription{sent, received} {
+		assert.Empty(t, waitUpdated(t, s).Reactions)
+	}
+}
+
+func Test_Handler__should_reply_to_a_message(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hSender := testHandler(t)
+	go run(ctx, t, hSender)
+
+	hReceiver := testHandler(t)
+	go run(ctx, t, hReceiver)
+
+	waitStarted(t, hSender, hReceiver)
+
+	hSender.self.KnownPeers.Add(hReceiver.self)
+
+	sent := testEvents(hSender)
+	received := testEvents(hReceiver)
+
+	_, err := hSender.SendText(ctx, "question", hReceiver.self.ID)
+	assert.NoError(t, err, "can't send a message")
+
+	question := waitReceived(t, received)
+
+	_, err = hReceiver.ReplyText(ctx, "answer", question.ID)
+	assert.NoError(t, err, "can't reply to a message")
+
+	answer := waitReceived(t, sent)
+	assert.Equal(t, "answer", answer.Text)
+	if assert.NotNil(t, answer.ReplyTo) {
+		assert.Equal(t, question.ID, answer.ReplyTo.MessageID)
+		assert.Equal(t, hSender.self.ID, answer.ReplyTo.FromID)
+		assert.Equal(t, "question", answer.ReplyTo.Quote)
+	}
+
+	history, err := hSender.History(hReceiver.self.ID, "", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, history, 2) && assert.NotNil(t, history[1].ReplyTo) {
+		assert.Equal(t, question.ID, history[1].ReplyTo.MessageID)
+	}
+
+	_, err = hReceiver.ReplyText(ctx, "answer", "unknown")
+	assert.Error(t, err, "can't reply to an unknown message")
+}
+
+func Test_Handler__should_quote_a_stored_parent_of_a_reply(t *testing.T) {
+	h := testHandler(t)
+	from := testPeer(t)
+	events := testEvents(h)
+
+	parent := &store.Message{ID: "parent", ChatID: from.ID, FromID: h.self.ID, ToID: from.ID, Type: string(TypeText), Text: "question"}
+	other := &store.Message{ID: "other", ChatID: "other", FromID: "other", ToID: h.self.ID, Type: string(TypeText), Text: "secret"}
+	for _, r := range []*store.Message{parent, other} {
+		assert.NoError(t, h.store.Save(r))
+	}
+
+	reply, err := h.makeText("answer")
+	assert.NoError(t, err)
+	reply.ReplyTo = &chat.ReplyTo{MessageID: parent.ID, FromID: from.ID, Quote: "forged"}
+
+	h.receive(from, reply)
+
+	msg := waitReceived(t, events)
+	if assert.NotNil(t, msg.ReplyTo) {
+		assert.Equal(t, h.self.ID, msg.ReplyTo.FromID)
+		assert.Equal(t, "question", msg.ReplyTo.Quote)
+	}
+	stored, err := h.store.Get(reply.ID)
+	if assert.NoError(t, err) && assert.NotNil(t, stored.ReplyTo) {
+		assert.Equal(t, h.self.ID, stored.ReplyTo.FromID)
+		assert.Equal(t, "question", stored.ReplyTo.Quote)
+	}
+
+	// a reply to a message of another chat would leak its quote.
+	leak, err := h.makeText("answer")
+	assert.NoError(t, err)
+	leak.ReplyTo = &chat.ReplyTo{MessageID: other.ID}
+
+	h.receive(from, leak)
+
+	_, err = h.store.Get(leak.ID)
+	assert.Error(t, err)
+}
+
+func Test_Handler__should_not_accept_a_reaction_from_outside_of_chat(t *testing.T) {
+	h := testHandler(t)
+
+	r := &store.Message{ID: "message", ChatID: "peer", FromID: "peer", ToID: h.self.ID}
+	assert.NoError(t, h.store.Save(r))
+
+	h.handleReaction(testPeer(t), "", &chat.Reaction{MessageID: r.ID, Emoji: "+1"})
+
+	stored, err := h.store.Get(r.ID)
+	if assert.NoError(t, err) {
+		assert.Empty(t, stored.Reactions)
+	}
+}
+
+func Test_Handler__should_not_accept_a_reaction_from_outside_of_group(t *testing.T) {
+	h := testHandler(t)
+	member := testPeer(t)
+	outsider := testPeer(t)
+
+	h.groups["group"] = &Group{
+		ID:      "group",
+		Members: []*Member{{ID: h.self.ID}, {ID: member.ID}},
+	}
+
+	r := &store.Message{ID: "message", ChatID: "group", GroupID: "group", FromID: member.ID}
+	assert.NoError(t, h.store.Save(r))
+
+	h.handleReaction(outsider, "group", &chat.Reaction{MessageID: r.ID, Emoji: "+1"})
+	// a member can react only in the group.
+	h.handleReaction(member, "", &chat.Reaction{MessageID: r.ID, Emoji: "+1"})
+
+	stored, err := h.store.Get(r.ID)
+	if assert.NoError(t, err) {
+		assert.Empty(t, stored.Reactions)
+	}
+
+	h.handleReaction(member, "group", &chat.Reaction{MessageID: r.ID, Emoji: "+1"})
+
+	stored, err = h.store.Get(r.ID)
+	if assert.NoError(t, err) {
+		assert.Len(t, stored.Reactions, 1)
+	}
+}
+
+func Test_Handler__should_not_overwrite_a_message_with_a_reused_id(t *testing.T) {
+	h := testHandler(t)
+	received := testEvents(h)
+
+	r := &store.Message{ID: "message", ChatID: "peer", FromID: "peer", ToID: h.self.ID, Text: "original"}
+	assert.NoError(t, h.store.Save(r))
+
+	from := testPeer(t)
+	h.handle(from, &chat.Message{
+		ID:        r.ID,
+		Timestamp: &timestamp.Timestamp{Seconds: time.Now().Unix()},
+		Payload:   &chat.Message_Text{Text: "forged"},
+	})
+
+	stored, err := h.store.Get(r.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "original", stored.Text)
+		assert.Equal(t, "peer", stored.FromID)
+	}
+	assertNotReceived(t, received, "a message with a reused id is received")
+}
+
+func Test_Handler__should_receive_a_retried_message_once(t *testing.T) {
+	h := testHandler(t)
+	received := testEvents(h)
+
+	from := testPeer(t)
+	msg := &chat.Message{
+		ID:        "message",
+		Timestamp: &timestamp.Timestamp{Seconds: time.Now().Unix()},
+		Payload:   &chat.Message_Text{Text: "hello"},
+	}
+
+	h.handle(from, msg)
+	waitReceived(t, received)
+
+	h.handle(from, msg)
+	assertNotReceived(t, received, "a retried message is received twice")
 }
 
 //
@@ -118,26 +1617,173 @@ func Test_Handler__should_send_a_message_to_self(t *testing.T) {
 
 func run(ctx context.Context, t *testing.T, h *Handler) {
 	if err := h.Start(ctx); err != nil {
-		t.Fatalf("failed to start a server: %s", err)
+		t.Errorf("failed to start a server: %s", err)
+	}
+}
+
+// testGreet exchanges prekeys of handlers.
+func testGreet(ctx context.Context, t *testing.T, from *Handler, to *Handler) {
+	insecureResolver.Add(to.self)
+	if _, err := from.greet(ctx, to.self); err != nil {
+		t.Fatalf("can't greet: %s", err)
+	}
+}
+
+// waitStarted waits until handlers accept connections.
+func waitStarted(t *testing.T, hh ...*Handler) {
+	for _, h := range hh {
+		for _, port := range []int{h.self.Port, h.self.InsecurePort} {
+			addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+			deadline := time.Now().Add(5 * time.Second)
+			for {
+				conn, err := net.Dial("tcp", addr)
+				if err == nil {
+					conn.Close()
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatalf("%s is not listening: %s", addr, err)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+}
+
+// testEvents subscribes to events of the handler.
+func testEvents(h *Handler) *Subscription {
+	return h.Subscribe(1000)
+}
+
+// waitEvent waits for an event that matches, other events are skipped.
+func waitEvent(t *testing.T, s *Subscription, timeout time.Duration, match func(Event) bool) Event {
+	deadline := time.After(timeout)
+	for {
+		select {
+		case e, ok := <-s.Events():
+			if !ok {
+				t.Fatal("subscription is closed")
+			}
+			if match(e) {
+				return e
+			}
+		case <-deadline:
+			return nil
+		}
+	}
+}
+
+func waitReceived(t *testing.T, s *Subscription) *Message {
+	e := waitEvent(t, s, 10*time.Second, func(e Event) bool {
+		_, ok := e.(*MessageReceived)
+		return ok
+	})
+	if e == nil {
+		t.Fatal("message was not received")
+	}
+	return e.(*MessageReceived).Message
+}
+
+func assertNotReceived(t *testing.T, s *Subscription, msg string) {
+	e := waitEvent(t, s, time.Second, func(e Event) bool {
+		_, ok := e.(*MessageReceived)
+		return ok
+	})
+	if e != nil {
+		t.Fatal(msg)
+	}
+}
+
+func waitSent(t *testing.T, s *Subscription) *Message {
+	e := waitEvent(t, s, 10*time.Second, func(e Event) bool {
+		_, ok := e.(*MessageSent)
+		return ok
+	})
+	if e == nil {
+		t.Fatal("message was not sent")
+	}
+	return e.(*MessageSent).Message
+}
+
+func waitGroup(t *testing.T, s *Subscription) *Group {
+	e := waitEvent(t, s, 10*time.Second, func(e Event) bool {
+		_, ok := e.(*GroupUpdated)
+		return ok
+	})
+	if e == nil {
+		t.Fatal("group was not updated")
+	}
+	return e.(*GroupUpdated).Group
+}
+
+func waitKeyChanged(t *testing.T, s *Subscription) *Key {
+	e := waitEvent(t, s, 10*time.Second, func(e Event) bool {
+		_, ok := e.(*KeyChanged)
+		return ok
+	})
+	if e == nil {
+		t.Fatal("key change was not reported")
+	}
+	return e.(*KeyChanged).Key
+}
+
+func waitUpdated(t *testing.T, s *Subscription) *Message {
+	e := waitEvent(t, s, 10*time.Second, func(e Event) bool {
+		_, ok := e.(*MessageUpdated)
+		return ok
+	})
+	if e == nil {
+		t.Fatal("message was not updated")
+	}
+	return e.(*MessageUpdated).Message
+}
+
+func waitTyping(t *testing.T, s *Subscription) *TypingChanged {
+	e := waitEvent(t, s, 10*time.Second, func(e Event) bool {
+		_, ok := e.(*TypingChanged)
+		return ok
+	})
+	if e == nil {
+		t.Fatal("typing was not received")
+	}
+	return e.(*TypingChanged)
+}
+
+func waitConnection(t *testing.T, s *Subscription, peerID string, connected bool) {
+	e := waitEvent(t, s, 10*time.Second, func(e Event) bool {
+		c, ok := e.(*ConnectionChanged)
+		return ok && c.PeerID == peerID && c.Connected == connected
+	})
+	if e == nil {
+		t.Fatalf("%s is not connected: %t", peerID, connected)
+	}
+}
+
+// waitStatus waits until the message gets the status.
+func waitStatus(t *testing.T, s *Subscription, messageID string, status Status) {
+	e := waitEvent(t, s, 10*time.Second, func(e Event) bool {
+		u, ok := e.(*StatusUpdate)
+		return ok && u.MessageID == messageID && u.Status == status
+	})
+	if e == nil {
+		t.Fatalf("message %s is not %s", messageID, status)
 	}
 }
 
 func testHandler(t *testing.T) *Handler {
-	r := rand.New(rand.NewSource(time.Now().Unix()))
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
 	return NewHandler(
 		r,
 		logger.New(logger.LevelDebug),
 		testPeer(t),
+		memory.New(),
+		testDir(t),
 	)
 }
 
 func testPeer(t *testing.T) *peers.Peer {
-	defer func() {
-		port += 2
-	}()
-
 	r := rand.New(rand.NewSource(int64(port)))
-	p, err := peers.New(r, getNextPort(), getNextPort(), 512)
+	p, err := peers.New(r, getNextPort(), getNextPort(), getNextPort(), 1024)
 	if err != nil {
 		t.Fatalf("can't create a test peer: %s", err)
 	}
@@ -145,9 +1791,80 @@ func testPeer(t *testing.T) *peers.Peer {
 	return p
 }
 
+func testDir(t *testing.T) string {
+	return t.TempDir()
+}
+
+// testQueued stores a text message from h, which is queued since the time.
+func testQueued(t *testing.T, h *Handler, toID string, text string, queuedAt time.Time) *store.Message {
+	id, err := h.newID()
+	if err != nil {
+		t.Fatalf("can't make an id: %s", err)
+	}
+
+	r := &store.Message{
+		ID:        id,
+		ChatID:    toID,
+		FromID:    h.self.ID,
+		ToID:      toID,
+		Timestamp: queuedAt,
+		Type:      string(TypeText),
+		Text:      text,
+		Status:    string(StatusQueued),
+	}
+	if err := h.store.Save(r); err != nil {
+		t.Fatalf("can't store a message: %s", err)
+	}
+	return r
+}
+
+func testChunk(t *testing.T, h *Handler, data []byte) *chat.FileChunk {
+	id, err := h.newID()
+	if err != nil {
+		t.Fatalf("can't make an id: %s", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return &chat.FileChunk{
+		FileID: id,
+		Name:   "test",
+		Size:   int64(len(data)),
+		SHA256: sum[:],
+		Data:   data,
+	}
+}
+
+// testStream is a stream that fails to receive.
+type testStream struct {
+	guard   sync.Mutex
+	recvErr error
+	closed  bool
+}
+
+func (s *testStream) Send(*chat.Message) error {
+	return nil
+}
+
+func (s *testStream) Recv() (*chat.Message, error) {
+	return nil, s.recvErr
+}
+
+func (s *testStream) Close() error {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *testStream) isClosed() bool {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+	return s.closed
+}
+
 var port = 1000
 
-func getNextPort() string {
+func getNextPort() int {
 	port++
-	return strconv.Itoa(port)
+	return port
 }
@@ -0,0 +1,46 @@
+package messages
+
+import (
+	"fmt"
+
+	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/instance/store"
+)
+
+// History returns up to limit messages in the chat sent before the message with beforeID.
+// Chat is either a peer or a group. If beforeID is empty, the latest messages are returned.
+func (h *Handler) History(chatID string, beforeID string, limit int) ([]*Message, error) {
+	records, err := h.store.History(chatID, beforeID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("can't query history: %s", err)
+	}
+
+	mm := make([]*Message, 0, len(records))
+	for _, r := range records {
+		mm = append(mm, h.fromRecord(r))
+	}
+	return mm, nil
+}
+
+// fromRecord returns a stored message with its peers and group.
+func (h *Handler) fromRecord(r *store.Message) *Message {
+	if r.GroupID == "" {
+		return fromRecord(h.knownPeer(r.FromID), h.knownPeer(r.ToID), nil, r)
+	}
+
+	group, err := h.getGroup(r.GroupID)
+	if err != nil {
+		group = &Group{ID: r.GroupID}
+	}
+	return fromRecord(h.knownPeer(r.FromID), nil, group, r)
+}
+
+// knownPeer returns a peer by id, or a blank one if the peer is not known anymore.
+func (h *Handler) knownPeer(peerID string) *peers.Peer {
+	peer, err := h.getPeer(peerID)
+	if err != nil {
+		peer = peers.NewBlank()
+		peer.ID = peerID
+	}
+	return peer
+}
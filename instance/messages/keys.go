@@ -0,0 +1,195 @@
+package messages
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/instance/store"
+)
+
+// Key is a key of a peer pinned on the first contact.
+type Key struct {
+	PeerID string `json:"peer_id"`
+	// Fingerprint is a SHA-256 fingerprint of the pinned key.
+	Fingerprint string `json:"fingerprint"`
+	// SafetyNumber is the same on both sides if both peers see the same keys,
+	// users compare it out of band to verify the key.
+	SafetyNumber string `json:"safety_number"`
+	Verified     bool   `json:"verified"`
+	// ChangedFingerprint is a fingerprint of a different key the peer
+	// presented after the key was pinned.
+	ChangedFingerprint string `json:"changed_fingerprint,omitempty"`
+}
+
+// Key returns a pinned key of the peer.
+func (h *Handler) Key(peerID string) (*Key, error) {
+	h.keysGuard.Lock()
+	defer h.keysGuard.Unlock()
+
+	k, ok := h.keys[peerID]
+	if !ok {
+		return nil, fmt.Errorf("no key pinned for %s", peerID)
+	}
+	return h.makeKey(k), nil
+}
+
+// VerifyKey marks a pinned key of the peer as verified by the user.
+func (h *Handler) VerifyKey(peerID string) (*Key, error) {
+	h.keysGuard.Lock()
+	defer h.keysGuard.Unlock()
+
+	k, ok := h.keys[peerID]
+	if !ok {
+		return nil, fmt.Errorf("no key pinned for %s", peerID)
+	}
+
+	k.Verified = true
+	if err := h.store.SaveKey(k); err != nil {
+		return nil, fmt.Errorf("can't store key: %s", err)
+	}
+	return h.makeKey(k), nil
+}
+
+// TrustKey pins a changed key of the peer instead of the previous one.
+// The new key is not verified.
+func (h *Handler) TrustKey(peerID string) (*Key, error) {
+	h.keysGuard.Lock()
+	defer h.keysGuard.Unlock()
+
+	changed, ok := h.changedKeys[peerID]
+	if !ok {
+		return nil, fmt.Errorf("key of %s has not changed", peerID)
+	}
+
+	k := &store.Key{
+		PeerID:      peerID,
+		Fingerprint: changed,
+		PinnedAt:    time.Now(),
+	}
+	if err := h.store.SaveKey(k); err != nil {
+		return nil, fmt.Errorf("can't store key: %s", err)
+	}
+
+	h.keys[peerID] = k
+	delete(h.changedKeys, peerID)
+
+	h.logger.Info("trusted a new key of %s", peerID)
+
+	return h.makeKey(k), nil
+}
+
+// checkKey pins the certificate key on the first contact with the peer, and
+// rejects a different key after that. Peer ids are derived from keys, and
+// certificates are verified against ids before, so a different key means the
+// pinned one is corrupted in the store, or the key has the same truncated id.
+func (h *Handler) checkKey(peerID string, crt *x509.Certificate) error {
+	fingerprint, err := peers.KeyFingerprint(crt.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	if peerID == h.self.ID {
+		if fingerprint != h.selfFingerprint {
+			return fmt.Errorf("certificate key does not match self key")
+		}
+		return nil
+	}
+
+	h.keysGuard.Lock()
+	defer h.keysGuard.Unlock()
+
+	k, ok := h.keys[peerID]
+	if !ok {
+		k = &store.Key{
+			PeerID:      peerID,
+			Fingerprint: fingerprint,
+			PinnedAt:    time.Now(),
+		}
+		if err := h.store.SaveKey(k); err != nil {
+			return fmt.Errorf("can't store key: %s", err)
+		}
+
+		h.keys[peerID] = k
+
+		h.logger.Info("pinned a key of %s", peerID)
+		return nil
+	}
+
+	if k.Fingerprint == fingerprint {
+		return nil
+	}
+
+	h.logger.Error("key of %s changed from %s to %s", peerID, k.Fingerprint, fingerprint)
+
+	// reported once per a new key, connection attempts are retried.
+	if h.changedKeys[peerID] != fingerprint {
+		h.changedKeys[peerID] = fingerprint
+
+		h.updates.Publish(&KeyChanged{Key: h.makeKey(k)})
+	}
+
+	return fmt.Errorf("key of %s has changed", peerID)
+}
+
+// verifyPublicCrt verifies a PEM encoded certificate of the peer and checks its key.
+func (h *Handler) verifyPublicCrt(peerID string, publicCrt []byte) error {
+	crt, err := peers.ParsePublicCrt(publicCrt)
+	if err != nil {
+		return err
+	}
+
+	if err := peers.VerifyCertificate(peerID, crt); err != nil {
+		return err
+	}
+
+	return h.checkKey(peerID, crt)
+}
+
+// makeKey must be called under keysGuard.
+func (h *Handler) makeKey(k *store.Key) *Key {
+	return &Key{
+		PeerID:             k.PeerID,
+		Fingerprint:        k.Fingerprint,
+		SafetyNumber:       safetyNumber(h.selfFingerprint, k.Fingerprint),
+		Verified:           k.Verified,
+		ChangedFingerprint: h.changedKeys[k.PeerID],
+	}
+}
+
+func (h *Handler) loadKeys() {
+	kk, err := h.store.Keys()
+	if err != nil {
+		h.logger.Error("can't load keys: %s", err)
+		return
+	}
+
+	h.keysGuard.Lock()
+	defer h.keysGuard.Unlock()
+
+	for _, k := range kk {
+		h.keys[k.PeerID] = k
+	}
+}
+
+// safetyNumber returns 30 digits derived from both fingerprints. The order
+// of fingerprints doesn't matter, so both peers see the same number.
+func safetyNumber(a, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+
+	sum := sha256.Sum256([]byte(a + b))
+
+	groups := make([]string, 0, 6)
+	for i := 0; i < 6; i++ {
+		chunk := make([]byte, 8)
+		copy(chunk[3:], sum[i*5:i*5+5])
+		groups = append(groups, fmt.Sprintf("%05d", binary.BigEndian.Uint64(chunk)%100000))
+	}
+	return strings.Join(groups, " ")
+}
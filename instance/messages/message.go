@@ -5,6 +5,7 @@ import (
 
 	"github.com/ngalayko/p2p/instance/messages/proto/chat"
 	"github.com/ngalayko/p2p/instance/peers"
+	"github.com/ngalayko/p2p/instance/store"
 )
 
 // Type is a message type.
@@ -14,6 +15,19 @@ type Type string
 var (
 	TypeInvalid Type
 	TypeText    Type = "text"
+	TypeFile    Type = "file"
+)
+
+// Status is a message delivery status.
+type Status string
+
+// Known statuses.
+const (
+	StatusQueued    Status = "queued"
+	StatusSent      Status = "sent"
+	StatusFailed    Status = "failed"
+	StatusDelivered Status = "delivered"
+	StatusRead      Status = "read"
 )
 
 // Message is a single message.
@@ -24,6 +38,45 @@ type Message struct {
 	Timestamp time.Time   `json:"timestamp"`
 	Type      Type        `json:"type"`
 	Text      string      `json:"text"`
+	Status    Status      `json:"status,omitempty"`
+	Group     *Group      `json:"group,omitempty"`
+	File      *File       `json:"file,omitempty"`
+
+	// ReplyTo is set if the message is a reply to another one.
+	ReplyTo *ReplyTo `json:"reply_to,omitempty"`
+	// EditedAt is set if the text was edited.
+	EditedAt *time.Time `json:"edited_at,omitempty"`
+	// Deleted is set if the sender deleted the message, its content is removed.
+	Deleted   bool        `json:"deleted,omitempty"`
+	Reactions []*Reaction `json:"reactions,omitempty"`
+}
+
+// ReplyTo references a message in the same chat, with a quote of it.
+type ReplyTo struct {
+	MessageID string `json:"message_id"`
+	FromID    string `json:"from_id"`
+	Quote     string `json:"quote"`
+}
+
+// Reaction is an emoji reaction of a peer to a message.
+type Reaction struct {
+	PeerID string `json:"peer_id"`
+	Emoji  string `json:"emoji"`
+}
+
+// File is metadata of a file message.
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	MIME   string `json:"mime"`
+	SHA256 string `json:"sha256"`
+}
+
+// StatusUpdate is a change of a sent message delivery status.
+type StatusUpdate struct {
+	MessageID string `json:"message_id"`
+	PeerID    string `json:"peer_id"`
+	Status    Status `json:"status"`
 }
 
 func fromProto(from, to *peers.Peer, m *chat.Message) *Message {
@@ -33,10 +86,115 @@ func fromProto(from, to *peers.Peer, m *chat.Message) *Message {
 		From:      from,
 		To:        to,
 	}
-	switch m.Payload.(type) {
+	switch payload := m.Payload.(type) {
 	case *chat.Message_Text:
 		msg.Type = TypeText
-		msg.Text = m.Payload.(*chat.Message_Text).Text
+		msg.Text = payload.Text
+	case *chat.Message_File:
+		msg.ID = payload.File.FileID
+		msg.Type = TypeFile
+		msg.File = fileFromProto(payload.File)
+	}
+	if m.ReplyTo != nil {
+		msg.ReplyTo = &ReplyTo{
+			MessageID: m.ReplyTo.MessageID,
+			FromID:    m.ReplyTo.FromID,
+			Quote:     quote(m.ReplyTo.Quote),
+		}
 	}
 	return msg
 }
+
+// toRecord returns message to store. Chat of a message is the group, or the
+// other peer from the self point of view.
+func (m *Message) toRecord(self *peers.Peer) *store.Message {
+	r := &store.Message{
+		ID:        m.ID,
+		FromID:    m.From.ID,
+		Timestamp: m.Timestamp,
+		Type:      string(m.Type),
+		Text:      m.Text,
+		Status:    string(m.Status),
+		EditedAt:  m.EditedAt,
+		Deleted:   m.Deleted,
+	}
+
+	if m.ReplyTo != nil {
+		r.ReplyTo = &store.ReplyTo{
+			MessageID: m.ReplyTo.MessageID,
+			FromID:    m.ReplyTo.FromID,
+			Quote:     m.ReplyTo.Quote,
+		}
+	}
+
+	for _, reaction := range m.Reactions {
+		r.Reactions = append(r.Reactions, &store.Reaction{
+			PeerID: reaction.PeerID,
+			Emoji:  reaction.Emoji,
+		})
+	}
+
+	if m.File != nil {
+		r.File = &store.File{
+			Name:   m.File.Name,
+			Size:   m.File.Size,
+			MIME:   m.File.MIME,
+			SHA256: m.File.SHA256,
+		}
+	}
+
+	switch {
+	case m.Group != nil:
+		r.ChatID = m.Group.ID
+		r.GroupID = m.Group.ID
+	case m.From.ID == self.ID:
+		r.ChatID = m.To.ID
+		r.ToID = m.To.ID
+	default:
+		r.ChatID = m.From.ID
+		r.ToID = m.To.ID
+	}
+	return r
+}
+
+func fromRecord(from, to *peers.Peer, group *Group, r *store.Message) *Message {
+	var file *File
+	if r.File != nil {
+		file = &File{
+			Name:   r.File.Name,
+			Size:   r.File.Size,
+			MIME:   r.File.MIME,
+			SHA256: r.File.SHA256,
+		}
+	}
+	var replyTo *ReplyTo
+	if r.ReplyTo != nil {
+		replyTo = &ReplyTo{
+			MessageID: r.ReplyTo.MessageID,
+			FromID:    r.ReplyTo.FromID,
+			Quote:     r.ReplyTo.Quote,
+		}
+	}
+	var reactions []*Reaction
+	for _, reaction := range r.Reactions {
+		reactions = append(reactions, &Reaction{
+			PeerID: reaction.PeerID,
+			Emoji:  reaction.Emoji,
+		})
+	}
+	return &Message{
+		ID:        r.ID,
+		From:      from,
+		To:        to,
+		Timestamp: r.Timestamp,
+		Type:      Type(r.Type),
+		Text:      r.Text,
+		Status:    Status(r.Status),
+		Group:     group,
+		File:      file,
+		ReplyTo:   replyTo,
+		EditedAt:  r.EditedAt,
+		Deleted:   r.Deleted,
+		Reactions: reactions,
+	}
+}
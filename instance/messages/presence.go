@@ -0,0 +1,176 @@
+package messages
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ngalayko/p2p/instance/messages/proto/chat"
+	"github.com/ngalayko/p2p/instance/peers"
+)
+
+// Presence is a status the user sets for contacts.
+type Presence string
+
+// Known presences.
+const (
+	PresenceActive       Presence = "active"
+	PresenceIdle         Presence = "idle"
+	PresenceDoNotDisturb Presence = "dnd"
+)
+
+var presenceToProto = map[Presence]chat.PresenceStatus{
+	PresenceActive:       chat.PresenceStatus_ACTIVE,
+	PresenceIdle:         chat.PresenceStatus_IDLE,
+	PresenceDoNotDisturb: chat.PresenceStatus_DO_NOT_DISTURB,
+}
+
+var presenceFromProto = map[chat.PresenceStatus]Presence{
+	chat.PresenceStatus_ACTIVE:         PresenceActive,
+	chat.PresenceStatus_IDLE:           PresenceIdle,
+	chat.PresenceStatus_DO_NOT_DISTURB: PresenceDoNotDisturb,
+}
+
+// SendTyping notifies the peer that the user started or stopped typing.
+func (h *Handler) SendTyping(ctx context.Context, peerID string, typing bool) error {
+	to, err := h.getPeer(peerID)
+	if err != nil {
+		return fmt.Errorf("error getting peer: %s", err)
+	}
+
+	return h.sendEphemeral(ctx, to, newTyping(typing))
+}
+
+// SendGroupTyping notifies members of the group that the user started or
+// stopped typing.
+func (h *Handler) SendGroupTyping(ctx context.Context, groupID string, typing bool) error {
+	g, err := h.getGroup(groupID)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range g.Members {
+		if m.ID == h.self.ID {
+			continue
+		}
+
+		to, err := h.getPeer(m.ID)
+		if err != nil {
+			continue
+		}
+
+		msg := newTyping(typing)
+		msg.GroupID = g.ID
+		if err := h.sendEphemeral(ctx, to, msg); err != nil {
+			h.logger.Debug("can't send typing to %s: %s", m.ID, err)
+		}
+	}
+
+	return nil
+}
+
+func newTyping(typing bool) *chat.Message {
+	return &chat.Message{
+		Payload: &chat.Message_Typing{
+			Typing: &chat.Typing{
+				Active: typing,
+			},
+		},
+	}
+}
+
+// SetPresence sets the presence of the user and sends it to connected peers.
+func (h *Handler) SetPresence(ctx context.Context, presence Presence) error {
+	if _, ok := presenceToProto[presence]; !ok {
+		return fmt.Errorf("unknown presence: %s", presence)
+	}
+
+	h.presenceGuard.Lock()
+	h.presences[h.self.ID] = presence
+	h.presenceGuard.Unlock()
+
+	h.streamsGuard.RLock()
+	ids := make([]string, 0, len(h.streams))
+	for id := range h.streams {
+		ids = append(ids, id)
+	}
+	h.streamsGuard.RUnlock()
+
+	for _, id := range ids {
+		if id == h.self.ID {
+			continue
+		}
+		if err := h.sendPresence(ctx, id); err != nil {
+			h.logger.Debug("can't send presence to %s: %s", id, err)
+		}
+	}
+
+	return nil
+}
+
+// Presence returns the last known presence of the peer or self.
+func (h *Handler) Presence(peerID string) Presence {
+	h.presenceGuard.Lock()
+	defer h.presenceGuard.Unlock()
+
+	if p, ok := h.presences[peerID]; ok {
+		return p
+	}
+	return PresenceActive
+}
+
+// sendPresence sends the presence of self to the peer.
+func (h *Handler) sendPresence(ctx context.Context, peerID string) error {
+	to, err := h.getPeer(peerID)
+	if err != nil {
+		return err
+	}
+
+	return h.sendEphemeral(ctx, to, &chat.Message{
+		Payload: &chat.Message_Presence{
+			Presence: &chat.Presence{
+				Status: presenceToProto[h.Presence(h.self.ID)],
+			},
+		},
+	})
+}
+
+// sendEphemeral sends a message that is not stored or queued, if the peer is
+// not reachable the message is lost.
+func (h *Handler) sendEphemeral(ctx context.Context, to *peers.Peer, ephemeral *chat.Message) error {
+	msg, err := h.makeMessage(ephemeral)
+	if err != nil {
+		return fmt.Errorf("error making message: %s", err)
+	}
+
+	return h.sendMessage(ctx, to, msg)
+}
+
+func (h *Handler) handleTyping(from *peers.Peer, groupID string, typing *chat.Typing) {
+	if groupID != "" && !h.isMember(groupID, from.ID) {
+		h.logger.Error("typing in group %s from %s, who is not a member", groupID, from.ID)
+		return
+	}
+
+	h.updates.Publish(&TypingChanged{
+		PeerID:  from.ID,
+		GroupID: groupID,
+		Typing:  typing.Active,
+	})
+}
+
+func (h *Handler) handlePresence(from *peers.Peer, p *chat.Presence) {
+	presence, ok := presenceFromProto[p.Status]
+	if !ok {
+		h.logger.Error("unknown presence %s from %s", p.Status, from.ID)
+		return
+	}
+
+	h.presenceGuard.Lock()
+	h.presences[from.ID] = presence
+	h.presenceGuard.Unlock()
+
+	h.updates.Publish(&PresenceChanged{
+		PeerID:   from.ID,
+		Presence: presence,
+	})
+}
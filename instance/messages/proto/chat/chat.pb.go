@@ -23,15 +23,61 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
+type PresenceStatus int32
+
+const (
+	PresenceStatus_ACTIVE         PresenceStatus = 0
+	PresenceStatus_IDLE           PresenceStatus = 1
+	PresenceStatus_DO_NOT_DISTURB PresenceStatus = 2
+)
+
+var PresenceStatus_name = map[int32]string{
+	0: "ACTIVE",
+	1: "IDLE",
+	2: "DO_NOT_DISTURB",
+}
+
+var PresenceStatus_value = map[string]int32{
+	"ACTIVE":         0,
+	"IDLE":           1,
+	"DO_NOT_DISTURB": 2,
+}
+
+func (x PresenceStatus) String() string {
+	return proto.EnumName(PresenceStatus_name, int32(x))
+}
+
+func (PresenceStatus) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{0}
+}
+
 type Message struct {
 	ID        string               `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
 	Timestamp *timestamp.Timestamp `protobuf:"bytes,2,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`
 	// Types that are valid to be assigned to Payload:
 	//	*Message_Text
-	Payload              isMessage_Payload `protobuf_oneof:"Payload"`
-	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
-	XXX_unrecognized     []byte            `json:"-"`
-	XXX_sizecache        int32             `json:"-"`
+	//	*Message_Delivered
+	//	*Message_Read
+	//	*Message_GroupUpdate
+	//	*Message_GroupLeave
+	//	*Message_File
+	//	*Message_FileResume
+	//	*Message_Encrypted
+	//	*Message_Envelope
+	//	*Message_Typing
+	//	*Message_Presence
+	//	*Message_Edit
+	//	*Message_Delete
+	//	*Message_Reaction
+	//	*Message_Gossip
+	Payload isMessage_Payload `protobuf_oneof:"Payload"`
+	// GroupID is set when a message is sent to a group.
+	GroupID string `protobuf:"bytes,8,opt,name=GroupID,proto3" json:"GroupID,omitempty"`
+	// ReplyTo is set when a message is a reply to another one.
+	ReplyTo              *ReplyTo `protobuf:"bytes,18,opt,name=ReplyTo,proto3" json:"ReplyTo,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
 }
 
 func (m *Message) Reset()         { *m = Message{} }
@@ -81,8 +127,92 @@ type Message_Text struct {
 	Text string `protobuf:"bytes,3,opt,name=Text,proto3,oneof"`
 }
 
+type Message_Delivered struct {
+	Delivered *Receipt `protobuf:"bytes,4,opt,name=Delivered,proto3,oneof"`
+}
+
+type Message_Read struct {
+	Read *Receipt `protobuf:"bytes,5,opt,name=Read,proto3,oneof"`
+}
+
+type Message_GroupUpdate struct {
+	GroupUpdate *Group `protobuf:"bytes,6,opt,name=GroupUpdate,proto3,oneof"`
+}
+
+type Message_GroupLeave struct {
+	GroupLeave *GroupLeave `protobuf:"bytes,7,opt,name=GroupLeave,proto3,oneof"`
+}
+
+type Message_File struct {
+	File *FileChunk `protobuf:"bytes,9,opt,name=File,proto3,oneof"`
+}
+
+type Message_FileResume struct {
+	FileResume *FileResume `protobuf:"bytes,10,opt,name=FileResume,proto3,oneof"`
+}
+
+type Message_Encrypted struct {
+	Encrypted *Encrypted `protobuf:"bytes,11,opt,name=Encrypted,proto3,oneof"`
+}
+
+type Message_Envelope struct {
+	Envelope *Envelope `protobuf:"bytes,12,opt,name=Envelope,proto3,oneof"`
+}
+
+type Message_Typing struct {
+	Typing *Typing `protobuf:"bytes,13,opt,name=Typing,proto3,oneof"`
+}
+
+type Message_Presence struct {
+	Presence *Presence `protobuf:"bytes,14,opt,name=Presence,proto3,oneof"`
+}
+
+type Message_Edit struct {
+	Edit *Edit `protobuf:"bytes,15,opt,name=Edit,proto3,oneof"`
+}
+
+type Message_Delete struct {
+	Delete *Delete `protobuf:"bytes,16,opt,name=Delete,proto3,oneof"`
+}
+
+type Message_Reaction struct {
+	Reaction *Reaction `protobuf:"bytes,17,opt,name=Reaction,proto3,oneof"`
+}
+
+type Message_Gossip struct {
+	Gossip *Gossip `protobuf:"bytes,19,opt,name=Gossip,proto3,oneof"`
+}
+
 func (*Message_Text) isMessage_Payload() {}
 
+func (*Message_Delivered) isMessage_Payload() {}
+
+func (*Message_Read) isMessage_Payload() {}
+
+func (*Message_GroupUpdate) isMessage_Payload() {}
+
+func (*Message_GroupLeave) isMessage_Payload() {}
+
+func (*Message_File) isMessage_Payload() {}
+
+func (*Message_FileResume) isMessage_Payload() {}
+
+func (*Message_Encrypted) isMessage_Payload() {}
+
+func (*Message_Envelope) isMessage_Payload() {}
+
+func (*Message_Typing) isMessage_Payload() {}
+
+func (*Message_Presence) isMessage_Payload() {}
+
+func (*Message_Edit) isMessage_Payload() {}
+
+func (*Message_Delete) isMessage_Payload() {}
+
+func (*Message_Reaction) isMessage_Payload() {}
+
+func (*Message_Gossip) isMessage_Payload() {}
+
 func (m *Message) GetPayload() isMessage_Payload {
 	if m != nil {
 		return m.Payload
@@ -97,33 +227,1276 @@ func (m *Message) GetText() string {
 	return ""
 }
 
+func (m *Message) GetDelivered() *Receipt {
+	if x, ok := m.GetPayload().(*Message_Delivered); ok {
+		return x.Delivered
+	}
+	return nil
+}
+
+func (m *Message) GetRead() *Receipt {
+	if x, ok := m.GetPayload().(*Message_Read); ok {
+		return x.Read
+	}
+	return nil
+}
+
+func (m *Message) GetGroupUpdate() *Group {
+	if x, ok := m.GetPayload().(*Message_GroupUpdate); ok {
+		return x.GroupUpdate
+	}
+	return nil
+}
+
+func (m *Message) GetGroupLeave() *GroupLeave {
+	if x, ok := m.GetPayload().(*Message_GroupLeave); ok {
+		return x.GroupLeave
+	}
+	return nil
+}
+
+func (m *Message) GetFile() *FileChunk {
+	if x, ok := m.GetPayload().(*Message_File); ok {
+		return x.File
+	}
+	return nil
+}
+
+func (m *Message) GetFileResume() *FileResume {
+	if x, ok := m.GetPayload().(*Message_FileResume); ok {
+		return x.FileResume
+	}
+	return nil
+}
+
+func (m *Message) GetEncrypted() *Encrypted {
+	if x, ok := m.GetPayload().(*Message_Encrypted); ok {
+		return x.Encrypted
+	}
+	return nil
+}
+
+func (m *Message) GetEnvelope() *Envelope {
+	if x, ok := m.GetPayload().(*Message_Envelope); ok {
+		return x.Envelope
+	}
+	return nil
+}
+
+func (m *Message) GetTyping() *Typing {
+	if x, ok := m.GetPayload().(*Message_Typing); ok {
+		return x.Typing
+	}
+	return nil
+}
+
+func (m *Message) GetPresence() *Presence {
+	if x, ok := m.GetPayload().(*Message_Presence); ok {
+		return x.Presence
+	}
+	return nil
+}
+
+func (m *Message) GetEdit() *Edit {
+	if x, ok := m.GetPayload().(*Message_Edit); ok {
+		return x.Edit
+	}
+	return nil
+}
+
+func (m *Message) GetDelete() *Delete {
+	if x, ok := m.GetPayload().(*Message_Delete); ok {
+		return x.Delete
+	}
+	return nil
+}
+
+func (m *Message) GetReaction() *Reaction {
+	if x, ok := m.GetPayload().(*Message_Reaction); ok {
+		return x.Reaction
+	}
+	return nil
+}
+
+func (m *Message) GetGossip() *Gossip {
+	if x, ok := m.GetPayload().(*Message_Gossip); ok {
+		return x.Gossip
+	}
+	return nil
+}
+
+func (m *Message) GetGroupID() string {
+	if m != nil {
+		return m.GroupID
+	}
+	return ""
+}
+
+func (m *Message) GetReplyTo() *ReplyTo {
+	if m != nil {
+		return m.ReplyTo
+	}
+	return nil
+}
+
 // XXX_OneofWrappers is for the internal use of the proto package.
 func (*Message) XXX_OneofWrappers() []interface{} {
 	return []interface{}{
 		(*Message_Text)(nil),
+		(*Message_Delivered)(nil),
+		(*Message_Read)(nil),
+		(*Message_GroupUpdate)(nil),
+		(*Message_GroupLeave)(nil),
+		(*Message_File)(nil),
+		(*Message_FileResume)(nil),
+		(*Message_Encrypted)(nil),
+		(*Message_Envelope)(nil),
+		(*Message_Typing)(nil),
+		(*Message_Presence)(nil),
+		(*Message_Edit)(nil),
+		(*Message_Delete)(nil),
+		(*Message_Reaction)(nil),
+		(*Message_Gossip)(nil),
+	}
+}
+
+// ReplyTo references a message in the same chat, with a quote of it as the
+// sender saw it. Receivers that have the message quote their own copy.
+type ReplyTo struct {
+	MessageID            string   `protobuf:"bytes,1,opt,name=MessageID,proto3" json:"MessageID,omitempty"`
+	FromID               string   `protobuf:"bytes,2,opt,name=FromID,proto3" json:"FromID,omitempty"`
+	Quote                string   `protobuf:"bytes,3,opt,name=Quote,proto3" json:"Quote,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReplyTo) Reset()         { *m = ReplyTo{} }
+func (m *ReplyTo) String() string { return proto.CompactTextString(m) }
+func (*ReplyTo) ProtoMessage()    {}
+func (*ReplyTo) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{1}
+}
+
+func (m *ReplyTo) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReplyTo.Unmarshal(m, b)
+}
+func (m *ReplyTo) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReplyTo.Marshal(b, m, deterministic)
+}
+func (m *ReplyTo) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReplyTo.Merge(m, src)
+}
+func (m *ReplyTo) XXX_Size() int {
+	return xxx_messageInfo_ReplyTo.Size(m)
+}
+func (m *ReplyTo) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReplyTo.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReplyTo proto.InternalMessageInfo
+
+func (m *ReplyTo) GetMessageID() string {
+	if m != nil {
+		return m.MessageID
+	}
+	return ""
+}
+
+func (m *ReplyTo) GetFromID() string {
+	if m != nil {
+		return m.FromID
+	}
+	return ""
+}
+
+func (m *ReplyTo) GetQuote() string {
+	if m != nil {
+		return m.Quote
+	}
+	return ""
+}
+
+type Receipt struct {
+	MessageID            string   `protobuf:"bytes,1,opt,name=MessageID,proto3" json:"MessageID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Receipt) Reset()         { *m = Receipt{} }
+func (m *Receipt) String() string { return proto.CompactTextString(m) }
+func (*Receipt) ProtoMessage()    {}
+func (*Receipt) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{2}
+}
+
+func (m *Receipt) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Receipt.Unmarshal(m, b)
+}
+func (m *Receipt) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Receipt.Marshal(b, m, deterministic)
+}
+func (m *Receipt) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Receipt.Merge(m, src)
+}
+func (m *Receipt) XXX_Size() int {
+	return xxx_messageInfo_Receipt.Size(m)
+}
+func (m *Receipt) XXX_DiscardUnknown() {
+	xxx_messageInfo_Receipt.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Receipt proto.InternalMessageInfo
+
+func (m *Receipt) GetMessageID() string {
+	if m != nil {
+		return m.MessageID
+	}
+	return ""
+}
+
+// Group is a full group roster, sent to every member when the group is
+// created or someone is invited. Members who already know the group only add
+// the invited ones, the roster is for the invited peers.
+type Group struct {
+	ID                   string    `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Name                 string    `protobuf:"bytes,2,opt,name=Name,proto3" json:"Name,omitempty"`
+	Members              []*Member `protobuf:"bytes,3,rep,name=Members,proto3" json:"Members,omitempty"`
+	Invited              []*Member `protobuf:"bytes,4,rep,name=Invited,proto3" json:"Invited,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
+	XXX_unrecognized     []byte    `json:"-"`
+	XXX_sizecache        int32     `json:"-"`
+}
+
+func (m *Group) Reset()         { *m = Group{} }
+func (m *Group) String() string { return proto.CompactTextString(m) }
+func (*Group) ProtoMessage()    {}
+func (*Group) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{3}
+}
+
+func (m *Group) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Group.Unmarshal(m, b)
+}
+func (m *Group) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Group.Marshal(b, m, deterministic)
+}
+func (m *Group) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Group.Merge(m, src)
+}
+func (m *Group) XXX_Size() int {
+	return xxx_messageInfo_Group.Size(m)
+}
+func (m *Group) XXX_DiscardUnknown() {
+	xxx_messageInfo_Group.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Group proto.InternalMessageInfo
+
+func (m *Group) GetID() string {
+	if m != nil {
+		return m.ID
+	}
+	return ""
+}
+
+func (m *Group) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Group) GetMembers() []*Member {
+	if m != nil {
+		return m.Members
+	}
+	return nil
+}
+
+func (m *Group) GetInvited() []*Member {
+	if m != nil {
+		return m.Invited
+	}
+	return nil
+}
+
+type Member struct {
+	ID                   string   `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Name                 string   `protobuf:"bytes,2,opt,name=Name,proto3" json:"Name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Member) Reset()         { *m = Member{} }
+func (m *Member) String() string { return proto.CompactTextString(m) }
+func (*Member) ProtoMessage()    {}
+func (*Member) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{4}
+}
+
+func (m *Member) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Member.Unmarshal(m, b)
+}
+func (m *Member) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Member.Marshal(b, m, deterministic)
+}
+func (m *Member) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Member.Merge(m, src)
+}
+func (m *Member) XXX_Size() int {
+	return xxx_messageInfo_Member.Size(m)
+}
+func (m *Member) XXX_DiscardUnknown() {
+	xxx_messageInfo_Member.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Member proto.InternalMessageInfo
+
+func (m *Member) GetID() string {
+	if m != nil {
+		return m.ID
+	}
+	return ""
+}
+
+func (m *Member) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+// GroupLeave is sent to every member when the sender leaves the group.
+type GroupLeave struct {
+	GroupID              string   `protobuf:"bytes,1,opt,name=GroupID,proto3" json:"GroupID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GroupLeave) Reset()         { *m = GroupLeave{} }
+func (m *GroupLeave) String() string { return proto.CompactTextString(m) }
+func (*GroupLeave) ProtoMessage()    {}
+func (*GroupLeave) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{5}
+}
+
+func (m *GroupLeave) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GroupLeave.Unmarshal(m, b)
+}
+func (m *GroupLeave) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GroupLeave.Marshal(b, m, deterministic)
+}
+func (m *GroupLeave) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GroupLeave.Merge(m, src)
+}
+func (m *GroupLeave) XXX_Size() int {
+	return xxx_messageInfo_GroupLeave.Size(m)
+}
+func (m *GroupLeave) XXX_DiscardUnknown() {
+	xxx_messageInfo_GroupLeave.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GroupLeave proto.InternalMessageInfo
+
+func (m *GroupLeave) GetGroupID() string {
+	if m != nil {
+		return m.GroupID
+	}
+	return ""
+}
+
+// FileChunk is a part of a file. Every chunk carries the file metadata, so
+// a transfer can be resumed from any offset.
+type FileChunk struct {
+	FileID               string   `protobuf:"bytes,1,opt,name=FileID,proto3" json:"FileID,omitempty"`
+	Name                 string   `protobuf:"bytes,2,opt,name=Name,proto3" json:"Name,omitempty"`
+	Size                 int64    `protobuf:"varint,3,opt,name=Size,proto3" json:"Size,omitempty"`
+	MIME                 string   `protobuf:"bytes,4,opt,name=MIME,proto3" json:"MIME,omitempty"`
+	SHA256               []byte   `protobuf:"bytes,5,opt,name=SHA256,proto3" json:"SHA256,omitempty"`
+	Offset               int64    `protobuf:"varint,6,opt,name=Offset,proto3" json:"Offset,omitempty"`
+	Data                 []byte   `protobuf:"bytes,7,opt,name=Data,proto3" json:"Data,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FileChunk) Reset()         { *m = FileChunk{} }
+func (m *FileChunk) String() string { return proto.CompactTextString(m) }
+func (*FileChunk) ProtoMessage()    {}
+func (*FileChunk) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{6}
+}
+
+func (m *FileChunk) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileChunk.Unmarshal(m, b)
+}
+func (m *FileChunk) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileChunk.Marshal(b, m, deterministic)
+}
+func (m *FileChunk) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileChunk.Merge(m, src)
+}
+func (m *FileChunk) XXX_Size() int {
+	return xxx_messageInfo_FileChunk.Size(m)
+}
+func (m *FileChunk) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileChunk.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileChunk proto.InternalMessageInfo
+
+func (m *FileChunk) GetFileID() string {
+	if m != nil {
+		return m.FileID
+	}
+	return ""
+}
+
+func (m *FileChunk) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *FileChunk) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *FileChunk) GetMIME() string {
+	if m != nil {
+		return m.MIME
+	}
+	return ""
+}
+
+func (m *FileChunk) GetSHA256() []byte {
+	if m != nil {
+		return m.SHA256
+	}
+	return nil
+}
+
+func (m *FileChunk) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *FileChunk) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+// FileResume asks the sender to send the file again starting from the offset.
+type FileResume struct {
+	FileID               string   `protobuf:"bytes,1,opt,name=FileID,proto3" json:"FileID,omitempty"`
+	Offset               int64    `protobuf:"varint,2,opt,name=Offset,proto3" json:"Offset,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FileResume) Reset()         { *m = FileResume{} }
+func (m *FileResume) String() string { return proto.CompactTextString(m) }
+func (*FileResume) ProtoMessage()    {}
+func (*FileResume) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{7}
+}
+
+func (m *FileResume) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FileResume.Unmarshal(m, b)
+}
+func (m *FileResume) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FileResume.Marshal(b, m, deterministic)
+}
+func (m *FileResume) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FileResume.Merge(m, src)
+}
+func (m *FileResume) XXX_Size() int {
+	return xxx_messageInfo_FileResume.Size(m)
+}
+func (m *FileResume) XXX_DiscardUnknown() {
+	xxx_messageInfo_FileResume.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FileResume proto.InternalMessageInfo
+
+func (m *FileResume) GetFileID() string {
+	if m != nil {
+		return m.FileID
+	}
+	return ""
+}
+
+func (m *FileResume) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+// Encrypted is a message encrypted for the recipient. Ciphertext is an
+// encrypted Message with the same ID and the actual payload.
+type Encrypted struct {
+	// SessionID is the ephemeral key of the peer that started the session.
+	SessionID []byte `protobuf:"bytes,1,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
+	// Init is set until the recipient answers in the session.
+	Init                 *SessionInit `protobuf:"bytes,2,opt,name=Init,proto3" json:"Init,omitempty"`
+	RatchetKey           []byte       `protobuf:"bytes,3,opt,name=RatchetKey,proto3" json:"RatchetKey,omitempty"`
+	PreviousCount        uint32       `protobuf:"varint,4,opt,name=PreviousCount,proto3" json:"PreviousCount,omitempty"`
+	Count                uint32       `protobuf:"varint,5,opt,name=Count,proto3" json:"Count,omitempty"`
+	Ciphertext           []byte       `protobuf:"bytes,6,opt,name=Ciphertext,proto3" json:"Ciphertext,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}     `json:"-"`
+	XXX_unrecognized     []byte       `json:"-"`
+	XXX_sizecache        int32        `json:"-"`
+}
+
+func (m *Encrypted) Reset()         { *m = Encrypted{} }
+func (m *Encrypted) String() string { return proto.CompactTextString(m) }
+func (*Encrypted) ProtoMessage()    {}
+func (*Encrypted) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{8}
+}
+
+func (m *Encrypted) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Encrypted.Unmarshal(m, b)
+}
+func (m *Encrypted) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Encrypted.Marshal(b, m, deterministic)
+}
+func (m *Encrypted) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Encrypted.Merge(m, src)
+}
+func (m *Encrypted) XXX_Size() int {
+	return xxx_messageInfo_Encrypted.Size(m)
+}
+func (m *Encrypted) XXX_DiscardUnknown() {
+	xxx_messageInfo_Encrypted.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Encrypted proto.InternalMessageInfo
+
+func (m *Encrypted) GetSessionID() []byte {
+	if m != nil {
+		return m.SessionID
+	}
+	return nil
+}
+
+func (m *Encrypted) GetInit() *SessionInit {
+	if m != nil {
+		return m.Init
+	}
+	return nil
+}
+
+func (m *Encrypted) GetRatchetKey() []byte {
+	if m != nil {
+		return m.RatchetKey
+	}
+	return nil
+}
+
+func (m *Encrypted) GetPreviousCount() uint32 {
+	if m != nil {
+		return m.PreviousCount
+	}
+	return 0
+}
+
+func (m *Encrypted) GetCount() uint32 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+func (m *Encrypted) GetCiphertext() []byte {
+	if m != nil {
+		return m.Ciphertext
+	}
+	return nil
+}
+
+// SessionInit has prekeys the session was started with.
+type SessionInit struct {
+	PreKey               []byte   `protobuf:"bytes,1,opt,name=PreKey,proto3" json:"PreKey,omitempty"`
+	RemotePreKey         []byte   `protobuf:"bytes,2,opt,name=RemotePreKey,proto3" json:"RemotePreKey,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *SessionInit) Reset()         { *m = SessionInit{} }
+func (m *SessionInit) String() string { return proto.CompactTextString(m) }
+func (*SessionInit) ProtoMessage()    {}
+func (*SessionInit) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{9}
+}
+
+func (m *SessionInit) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_SessionInit.Unmarshal(m, b)
+}
+func (m *SessionInit) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_SessionInit.Marshal(b, m, deterministic)
+}
+func (m *SessionInit) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_SessionInit.Merge(m, src)
+}
+func (m *SessionInit) XXX_Size() int {
+	return xxx_messageInfo_SessionInit.Size(m)
+}
+func (m *SessionInit) XXX_DiscardUnknown() {
+	xxx_messageInfo_SessionInit.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_SessionInit proto.InternalMessageInfo
+
+func (m *SessionInit) GetPreKey() []byte {
+	if m != nil {
+		return m.PreKey
+	}
+	return nil
+}
+
+func (m *SessionInit) GetRemotePreKey() []byte {
+	if m != nil {
+		return m.RemotePreKey
+	}
+	return nil
+}
+
+// Envelope is a message to a peer that is not reachable directly, sent
+// through other peers. Relays can't read or change the content.
+type Envelope struct {
+	ID     string `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	FromID string `protobuf:"bytes,2,opt,name=FromID,proto3" json:"FromID,omitempty"`
+	ToID   string `protobuf:"bytes,3,opt,name=ToID,proto3" json:"ToID,omitempty"`
+	// HopLimit is decremented by every relay, the envelope is dropped
+	// when it reaches zero.
+	HopLimit uint32 `protobuf:"varint,4,opt,name=HopLimit,proto3" json:"HopLimit,omitempty"`
+	// Route is ids of peers the envelope has passed.
+	Route []string `protobuf:"bytes,5,rep,name=Route,proto3" json:"Route,omitempty"`
+	// Types that are valid to be assigned to Content:
+	//	*Envelope_Message
+	//	*Envelope_Greeting
+	//	*Envelope_GreetingReply
+	Content              isEnvelope_Content `protobuf_oneof:"Content"`
+	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
+	XXX_unrecognized     []byte             `json:"-"`
+	XXX_sizecache        int32              `json:"-"`
+}
+
+func (m *Envelope) Reset()         { *m = Envelope{} }
+func (m *Envelope) String() string { return proto.CompactTextString(m) }
+func (*Envelope) ProtoMessage()    {}
+func (*Envelope) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{10}
+}
+
+func (m *Envelope) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Envelope.Unmarshal(m, b)
+}
+func (m *Envelope) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Envelope.Marshal(b, m, deterministic)
+}
+func (m *Envelope) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Envelope.Merge(m, src)
+}
+func (m *Envelope) XXX_Size() int {
+	return xxx_messageInfo_Envelope.Size(m)
+}
+func (m *Envelope) XXX_DiscardUnknown() {
+	xxx_messageInfo_Envelope.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Envelope proto.InternalMessageInfo
+
+func (m *Envelope) GetID() string {
+	if m != nil {
+		return m.ID
+	}
+	return ""
+}
+
+func (m *Envelope) GetFromID() string {
+	if m != nil {
+		return m.FromID
+	}
+	return ""
+}
+
+func (m *Envelope) GetToID() string {
+	if m != nil {
+		return m.ToID
+	}
+	return ""
+}
+
+func (m *Envelope) GetHopLimit() uint32 {
+	if m != nil {
+		return m.HopLimit
+	}
+	return 0
+}
+
+func (m *Envelope) GetRoute() []string {
+	if m != nil {
+		return m.Route
+	}
+	return nil
+}
+
+type isEnvelope_Content interface {
+	isEnvelope_Content()
+}
+
+type Envelope_Message struct {
+	Message *Message `protobuf:"bytes,6,opt,name=Message,proto3,oneof"`
+}
+
+type Envelope_Greeting struct {
+	Greeting []byte `protobuf:"bytes,7,opt,name=Greeting,proto3,oneof"`
+}
+
+type Envelope_GreetingReply struct {
+	GreetingReply []byte `protobuf:"bytes,8,opt,name=GreetingReply,proto3,oneof"`
+}
+
+func (*Envelope_Message) isEnvelope_Content() {}
+
+func (*Envelope_Greeting) isEnvelope_Content() {}
+
+func (*Envelope_GreetingReply) isEnvelope_Content() {}
+
+func (m *Envelope) GetContent() isEnvelope_Content {
+	if m != nil {
+		return m.Content
+	}
+	return nil
+}
+
+func (m *Envelope) GetMessage() *Message {
+	if x, ok := m.GetContent().(*Envelope_Message); ok {
+		return x.Message
+	}
+	return nil
+}
+
+func (m *Envelope) GetGreeting() []byte {
+	if x, ok := m.GetContent().(*Envelope_Greeting); ok {
+		return x.Greeting
+	}
+	return nil
+}
+
+func (m *Envelope) GetGreetingReply() []byte {
+	if x, ok := m.GetContent().(*Envelope_GreetingReply); ok {
+		return x.GreetingReply
+	}
+	return nil
+}
+
+// XXX_OneofWrappers is for the internal use of the proto package.
+func (*Envelope) XXX_OneofWrappers() []interface{} {
+	return []interface{}{
+		(*Envelope_Message)(nil),
+		(*Envelope_Greeting)(nil),
+		(*Envelope_GreetingReply)(nil),
+	}
+}
+
+// Typing is sent when the sender starts or stops typing to the recipient or
+// to the group. It is not stored or queued.
+type Typing struct {
+	// Active is false when the sender stops typing.
+	Active               bool     `protobuf:"varint,1,opt,name=Active,proto3" json:"Active,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Typing) Reset()         { *m = Typing{} }
+func (m *Typing) String() string { return proto.CompactTextString(m) }
+func (*Typing) ProtoMessage()    {}
+func (*Typing) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{11}
+}
+
+func (m *Typing) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Typing.Unmarshal(m, b)
+}
+func (m *Typing) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Typing.Marshal(b, m, deterministic)
+}
+func (m *Typing) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Typing.Merge(m, src)
+}
+func (m *Typing) XXX_Size() int {
+	return xxx_messageInfo_Typing.Size(m)
+}
+func (m *Typing) XXX_DiscardUnknown() {
+	xxx_messageInfo_Typing.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Typing proto.InternalMessageInfo
+
+func (m *Typing) GetActive() bool {
+	if m != nil {
+		return m.Active
+	}
+	return false
+}
+
+// Presence is a status the sender set for contacts. It is not stored or queued.
+type Presence struct {
+	Status               PresenceStatus `protobuf:"varint,1,opt,name=Status,proto3,enum=chat.PresenceStatus" json:"Status,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *Presence) Reset()         { *m = Presence{} }
+func (m *Presence) String() string { return proto.CompactTextString(m) }
+func (*Presence) ProtoMessage()    {}
+func (*Presence) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{12}
+}
+
+func (m *Presence) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Presence.Unmarshal(m, b)
+}
+func (m *Presence) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Presence.Marshal(b, m, deterministic)
+}
+func (m *Presence) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Presence.Merge(m, src)
+}
+func (m *Presence) XXX_Size() int {
+	return xxx_messageInfo_Presence.Size(m)
+}
+func (m *Presence) XXX_DiscardUnknown() {
+	xxx_messageInfo_Presence.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Presence proto.InternalMessageInfo
+
+func (m *Presence) GetStatus() PresenceStatus {
+	if m != nil {
+		return m.Status
+	}
+	return PresenceStatus_ACTIVE
+}
+
+// Edit replaces the text of an earlier message of the sender.
+type Edit struct {
+	MessageID            string               `protobuf:"bytes,1,opt,name=MessageID,proto3" json:"MessageID,omitempty"`
+	Text                 string               `protobuf:"bytes,2,opt,name=Text,proto3" json:"Text,omitempty"`
+	EditedAt             *timestamp.Timestamp `protobuf:"bytes,3,opt,name=EditedAt,proto3" json:"EditedAt,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}             `json:"-"`
+	XXX_unrecognized     []byte               `json:"-"`
+	XXX_sizecache        int32                `json:"-"`
+}
+
+func (m *Edit) Reset()         { *m = Edit{} }
+func (m *Edit) String() string { return proto.CompactTextString(m) }
+func (*Edit) ProtoMessage()    {}
+func (*Edit) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{13}
+}
+
+func (m *Edit) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Edit.Unmarshal(m, b)
+}
+func (m *Edit) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Edit.Marshal(b, m, deterministic)
+}
+func (m *Edit) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Edit.Merge(m, src)
+}
+func (m *Edit) XXX_Size() int {
+	return xxx_messageInfo_Edit.Size(m)
+}
+func (m *Edit) XXX_DiscardUnknown() {
+	xxx_messageInfo_Edit.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Edit proto.InternalMessageInfo
+
+func (m *Edit) GetMessageID() string {
+	if m != nil {
+		return m.MessageID
+	}
+	return ""
+}
+
+func (m *Edit) GetText() string {
+	if m != nil {
+		return m.Text
+	}
+	return ""
+}
+
+func (m *Edit) GetEditedAt() *timestamp.Timestamp {
+	if m != nil {
+		return m.EditedAt
+	}
+	return nil
+}
+
+// Delete deletes an earlier message of the sender for everyone.
+type Delete struct {
+	MessageID            string   `protobuf:"bytes,1,opt,name=MessageID,proto3" json:"MessageID,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Delete) Reset()         { *m = Delete{} }
+func (m *Delete) String() string { return proto.CompactTextString(m) }
+func (*Delete) ProtoMessage()    {}
+func (*Delete) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{14}
+}
+
+func (m *Delete) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Delete.Unmarshal(m, b)
+}
+func (m *Delete) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Delete.Marshal(b, m, deterministic)
+}
+func (m *Delete) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Delete.Merge(m, src)
+}
+func (m *Delete) XXX_Size() int {
+	return xxx_messageInfo_Delete.Size(m)
+}
+func (m *Delete) XXX_DiscardUnknown() {
+	xxx_messageInfo_Delete.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Delete proto.InternalMessageInfo
+
+func (m *Delete) GetMessageID() string {
+	if m != nil {
+		return m.MessageID
+	}
+	return ""
+}
+
+// Reaction adds an emoji reaction of the sender to a message, or removes it.
+type Reaction struct {
+	MessageID            string   `protobuf:"bytes,1,opt,name=MessageID,proto3" json:"MessageID,omitempty"`
+	Emoji                string   `protobuf:"bytes,2,opt,name=Emoji,proto3" json:"Emoji,omitempty"`
+	Removed              bool     `protobuf:"varint,3,opt,name=Removed,proto3" json:"Removed,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Reaction) Reset()         { *m = Reaction{} }
+func (m *Reaction) String() string { return proto.CompactTextString(m) }
+func (*Reaction) ProtoMessage()    {}
+func (*Reaction) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{15}
+}
+
+func (m *Reaction) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Reaction.Unmarshal(m, b)
+}
+func (m *Reaction) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Reaction.Marshal(b, m, deterministic)
+}
+func (m *Reaction) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Reaction.Merge(m, src)
+}
+func (m *Reaction) XXX_Size() int {
+	return xxx_messageInfo_Reaction.Size(m)
+}
+func (m *Reaction) XXX_DiscardUnknown() {
+	xxx_messageInfo_Reaction.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Reaction proto.InternalMessageInfo
+
+func (m *Reaction) GetMessageID() string {
+	if m != nil {
+		return m.MessageID
+	}
+	return ""
+}
+
+func (m *Reaction) GetEmoji() string {
+	if m != nil {
+		return m.Emoji
+	}
+	return ""
+}
+
+func (m *Reaction) GetRemoved() bool {
+	if m != nil {
+		return m.Removed
+	}
+	return false
+}
+
+// Gossip exchanges known peers with a connected peer. It is not stored or
+// queued.
+type Gossip struct {
+	// Digest is ids of peers the sender knows, the recipient answers with
+	// peers missing from it.
+	Digest []string `protobuf:"bytes,1,rep,name=Digest,proto3" json:"Digest,omitempty"`
+	// Peers are online peers the recipient doesn't know.
+	Peers []*GossipPeer `protobuf:"bytes,2,rep,name=Peers,proto3" json:"Peers,omitempty"`
+	// Reply is set on answers, so they are not answered with a digest again.
+	Reply                bool     `protobuf:"varint,3,opt,name=Reply,proto3" json:"Reply,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Gossip) Reset()         { *m = Gossip{} }
+func (m *Gossip) String() string { return proto.CompactTextString(m) }
+func (*Gossip) ProtoMessage()    {}
+func (*Gossip) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{16}
+}
+
+func (m *Gossip) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Gossip.Unmarshal(m, b)
+}
+func (m *Gossip) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Gossip.Marshal(b, m, deterministic)
+}
+func (m *Gossip) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Gossip.Merge(m, src)
+}
+func (m *Gossip) XXX_Size() int {
+	return xxx_messageInfo_Gossip.Size(m)
+}
+func (m *Gossip) XXX_DiscardUnknown() {
+	xxx_messageInfo_Gossip.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Gossip proto.InternalMessageInfo
+
+func (m *Gossip) GetDigest() []string {
+	if m != nil {
+		return m.Digest
+	}
+	return nil
+}
+
+func (m *Gossip) GetPeers() []*GossipPeer {
+	if m != nil {
+		return m.Peers
+	}
+	return nil
+}
+
+func (m *Gossip) GetReply() bool {
+	if m != nil {
+		return m.Reply
+	}
+	return false
+}
+
+// GossipPeer is a peer learned from gossip.
+type GossipPeer struct {
+	ID                   string   `protobuf:"bytes,1,opt,name=ID,proto3" json:"ID,omitempty"`
+	Name                 string   `protobuf:"bytes,2,opt,name=Name,proto3" json:"Name,omitempty"`
+	PublicKey            []byte   `protobuf:"bytes,3,opt,name=PublicKey,proto3" json:"PublicKey,omitempty"`
+	IPs                  []string `protobuf:"bytes,4,rep,name=IPs,proto3" json:"IPs,omitempty"`
+	Port                 int32    `protobuf:"varint,5,opt,name=Port,proto3" json:"Port,omitempty"`
+	InsecurePort         int32    `protobuf:"varint,6,opt,name=InsecurePort,proto3" json:"InsecurePort,omitempty"`
+	UIPort               int32    `protobuf:"varint,7,opt,name=UIPort,proto3" json:"UIPort,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *GossipPeer) Reset()         { *m = GossipPeer{} }
+func (m *GossipPeer) String() string { return proto.CompactTextString(m) }
+func (*GossipPeer) ProtoMessage()    {}
+func (*GossipPeer) Descriptor() ([]byte, []int) {
+	return fileDescriptor_8c585a45e2093e54, []int{17}
+}
+
+func (m *GossipPeer) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_GossipPeer.Unmarshal(m, b)
+}
+func (m *GossipPeer) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_GossipPeer.Marshal(b, m, deterministic)
+}
+func (m *GossipPeer) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_GossipPeer.Merge(m, src)
+}
+func (m *GossipPeer) XXX_Size() int {
+	return xxx_messageInfo_GossipPeer.Size(m)
+}
+func (m *GossipPeer) XXX_DiscardUnknown() {
+	xxx_messageInfo_GossipPeer.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_GossipPeer proto.InternalMessageInfo
+
+func (m *GossipPeer) GetID() string {
+	if m != nil {
+		return m.ID
+	}
+	return ""
+}
+
+func (m *GossipPeer) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *GossipPeer) GetPublicKey() []byte {
+	if m != nil {
+		return m.PublicKey
+	}
+	return nil
+}
+
+func (m *GossipPeer) GetIPs() []string {
+	if m != nil {
+		return m.IPs
+	}
+	return nil
+}
+
+func (m *GossipPeer) GetPort() int32 {
+	if m != nil {
+		return m.Port
+	}
+	return 0
+}
+
+func (m *GossipPeer) GetInsecurePort() int32 {
+	if m != nil {
+		return m.InsecurePort
+	}
+	return 0
+}
+
+func (m *GossipPeer) GetUIPort() int32 {
+	if m != nil {
+		return m.UIPort
 	}
+	return 0
 }
 
 func init() {
+	proto.RegisterEnum("chat.PresenceStatus", PresenceStatus_name, PresenceStatus_value)
 	proto.RegisterType((*Message)(nil), "chat.Message")
+	proto.RegisterType((*ReplyTo)(nil), "chat.ReplyTo")
+	proto.RegisterType((*Receipt)(nil), "chat.Receipt")
+	proto.RegisterType((*Group)(nil), "chat.Group")
+	proto.RegisterType((*Member)(nil), "chat.Member")
+	proto.RegisterType((*GroupLeave)(nil), "chat.GroupLeave")
+	proto.RegisterType((*FileChunk)(nil), "chat.FileChunk")
+	proto.RegisterType((*FileResume)(nil), "chat.FileResume")
+	proto.RegisterType((*Encrypted)(nil), "chat.Encrypted")
+	proto.RegisterType((*SessionInit)(nil), "chat.SessionInit")
+	proto.RegisterType((*Envelope)(nil), "chat.Envelope")
+	proto.RegisterType((*Typing)(nil), "chat.Typing")
+	proto.RegisterType((*Presence)(nil), "chat.Presence")
+	proto.RegisterType((*Edit)(nil), "chat.Edit")
+	proto.RegisterType((*Delete)(nil), "chat.Delete")
+	proto.RegisterType((*Reaction)(nil), "chat.Reaction")
+	proto.RegisterType((*Gossip)(nil), "chat.Gossip")
+	proto.RegisterType((*GossipPeer)(nil), "chat.GossipPeer")
 }
 
 func init() { proto.RegisterFile("chat.proto", fileDescriptor_8c585a45e2093e54) }
 
 var fileDescriptor_8c585a45e2093e54 = []byte{
-	// 187 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x4a, 0xce, 0x48, 0x2c,
-	0xd1, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x01, 0xb1, 0xa5, 0xe4, 0xd3, 0xf3, 0xf3, 0xd3,
-	0x73, 0x52, 0xf5, 0xc1, 0x62, 0x49, 0xa5, 0x69, 0xfa, 0x25, 0x99, 0xb9, 0xa9, 0xc5, 0x25, 0x89,
-	0xb9, 0x05, 0x10, 0x65, 0x4a, 0x25, 0x5c, 0xec, 0xbe, 0xa9, 0xc5, 0xc5, 0x89, 0xe9, 0xa9, 0x42,
-	0x7c, 0x5c, 0x4c, 0x9e, 0x2e, 0x12, 0x8c, 0x0a, 0x8c, 0x1a, 0x9c, 0x41, 0x4c, 0x9e, 0x2e, 0x42,
-	0x16, 0x5c, 0x9c, 0x21, 0x30, 0xd5, 0x12, 0x4c, 0x0a, 0x8c, 0x1a, 0xdc, 0x46, 0x52, 0x7a, 0x10,
-	0xf3, 0xf4, 0x60, 0xe6, 0xe9, 0xc1, 0x55, 0x04, 0x21, 0x14, 0x0b, 0x89, 0x70, 0xb1, 0x84, 0xa4,
-	0x56, 0x94, 0x48, 0x30, 0x83, 0xcc, 0xf2, 0x60, 0x08, 0x02, 0xf3, 0x9c, 0x38, 0xb9, 0xd8, 0x03,
-	0x12, 0x2b, 0x73, 0xf2, 0x13, 0x53, 0x8c, 0x4c, 0xb8, 0x58, 0x9c, 0x33, 0x12, 0x4b, 0x84, 0x74,
-	0xb8, 0xd8, 0x82, 0x4b, 0x8a, 0x52, 0x13, 0x73, 0x85, 0x78, 0xf5, 0xc0, 0x6e, 0x87, 0xba, 0x45,
-	0x0a, 0x95, 0xab, 0xc4, 0xa0, 0xc1, 0x68, 0xc0, 0x98, 0xc4, 0x06, 0xb6, 0xd5, 0x18, 0x10, 0x00,
-	0x00, 0xff, 0xff, 0x03, 0x34, 0x99, 0x2f, 0xe7, 0x00, 0x00, 0x00,
+	// 1131 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x55, 0xed, 0x6e, 0xe3, 0x54,
+	0x10, 0x8d, 0xf3, 0xed, 0x69, 0x9a, 0xcd, 0x5e, 0x2a, 0x74, 0x55, 0xad, 0x20, 0x32, 0x50, 0x16,
+	0xb4, 0xb4, 0xa8, 0xc0, 0x6a, 0x85, 0xf8, 0xd3, 0x8d, 0xb3, 0x8d, 0x45, 0xbb, 0x0d, 0xb7, 0x29,
+	0xe2, 0x17, 0x2b, 0x37, 0x99, 0xb6, 0x86, 0xc4, 0xb6, 0xec, 0x9b, 0x68, 0x0b, 0x2f, 0x83, 0xc4,
+	0x0b, 0xf0, 0x20, 0x3c, 0x0e, 0x0f, 0x80, 0x66, 0xae, 0x1d, 0x3b, 0xda, 0xee, 0x76, 0x7f, 0x65,
+	0xe6, 0xcc, 0xc9, 0xb9, 0x5f, 0x33, 0xc7, 0x00, 0xd3, 0x1b, 0x5f, 0xef, 0xc7, 0x49, 0xa4, 0x23,
+	0x51, 0xa7, 0x78, 0xf7, 0xe3, 0xeb, 0x28, 0xba, 0x9e, 0xe3, 0x01, 0x63, 0x97, 0xcb, 0xab, 0x03,
+	0x1d, 0x2c, 0x30, 0xd5, 0xfe, 0x22, 0x36, 0x34, 0xe7, 0xaf, 0x26, 0xb4, 0x4e, 0x31, 0x4d, 0xfd,
+	0x6b, 0x14, 0x5d, 0xa8, 0x7a, 0xae, 0xb4, 0xfa, 0xd6, 0x63, 0x5b, 0x55, 0x3d, 0x57, 0x3c, 0x03,
+	0x7b, 0x92, 0xd3, 0x65, 0xb5, 0x6f, 0x3d, 0xde, 0x3a, 0xdc, 0xdd, 0x37, 0x82, 0xfb, 0xb9, 0xe0,
+	0xfe, 0x9a, 0xa1, 0x0a, 0xb2, 0xd8, 0x81, 0xfa, 0x04, 0x5f, 0x6b, 0x59, 0x23, 0xad, 0x51, 0x45,
+	0x71, 0x26, 0xbe, 0x02, 0xdb, 0xc5, 0x79, 0xb0, 0xc2, 0x04, 0x67, 0xb2, 0xce, 0x7a, 0xdb, 0xfb,
+	0xbc, 0x65, 0x85, 0x53, 0x0c, 0x62, 0x3d, 0xaa, 0xa8, 0x82, 0x21, 0x3e, 0x81, 0xba, 0x42, 0x7f,
+	0x26, 0x1b, 0x77, 0x33, 0xb9, 0x28, 0x0e, 0x60, 0xeb, 0x38, 0x89, 0x96, 0xf1, 0x45, 0x3c, 0xf3,
+	0x35, 0xca, 0x26, 0x73, 0xb7, 0x0c, 0x97, 0x0b, 0xa3, 0x8a, 0x2a, 0x33, 0xc4, 0x21, 0x00, 0xa7,
+	0x27, 0xe8, 0xaf, 0x50, 0xb6, 0x98, 0xdf, 0x2b, 0xf1, 0x19, 0x1f, 0x55, 0x54, 0x89, 0x25, 0x3e,
+	0x83, 0xfa, 0x8b, 0x60, 0x8e, 0xd2, 0x66, 0xf6, 0x03, 0xc3, 0x26, 0x64, 0x70, 0xb3, 0x0c, 0x7f,
+	0xa7, 0xbd, 0x50, 0x42, 0xd2, 0xf4, 0xab, 0x30, 0x5d, 0x2e, 0x50, 0x42, 0x59, 0xba, 0xc0, 0x49,
+	0xba, 0xc8, 0xc4, 0x01, 0xd8, 0xc3, 0x70, 0x9a, 0xdc, 0xc6, 0x1a, 0x67, 0x72, 0xab, 0xac, 0xbf,
+	0x86, 0xe9, 0x56, 0xd6, 0x89, 0x78, 0x02, 0xed, 0x61, 0xb8, 0xc2, 0x79, 0x14, 0xa3, 0xec, 0x30,
+	0xbf, 0x9b, 0xf3, 0x0d, 0x3a, 0xaa, 0xa8, 0x35, 0x43, 0xec, 0x41, 0x73, 0x72, 0x1b, 0x07, 0xe1,
+	0xb5, 0xdc, 0x66, 0x6e, 0xc7, 0x70, 0x0d, 0x36, 0xaa, 0xa8, 0xac, 0x4a, 0xaa, 0xe3, 0x04, 0x53,
+	0x0c, 0xa7, 0x28, 0xbb, 0x65, 0xd5, 0x1c, 0x25, 0xd5, 0x3c, 0x16, 0x7d, 0xa8, 0x0f, 0x67, 0x81,
+	0x96, 0x0f, 0x98, 0x09, 0xd9, 0xfa, 0xb3, 0x80, 0x9f, 0x85, 0x7e, 0x69, 0x5d, 0x17, 0xe7, 0xa8,
+	0x51, 0xf6, 0xca, 0xeb, 0x1a, 0x8c, 0xd6, 0x35, 0x11, 0xad, 0xab, 0xd0, 0x9f, 0xea, 0x20, 0x0a,
+	0xe5, 0xc3, 0xf2, 0xba, 0x39, 0x4a, 0xeb, 0xe6, 0x31, 0xa9, 0x1e, 0x47, 0x69, 0x1a, 0xc4, 0xf2,
+	0x83, 0xb2, 0xaa, 0xc1, 0x48, 0xd5, 0x44, 0x42, 0x42, 0x8b, 0x5f, 0xcf, 0x73, 0x65, 0x9b, 0xbb,
+	0x39, 0x4f, 0xc5, 0xe7, 0xd0, 0x52, 0x18, 0xcf, 0x6f, 0x27, 0x91, 0x14, 0x9b, 0x6d, 0xc5, 0xa0,
+	0xca, 0xab, 0xcf, 0x6d, 0x68, 0x8d, 0xfd, 0xdb, 0x79, 0xe4, 0xcf, 0x9c, 0x8b, 0xf5, 0x7f, 0xc4,
+	0x23, 0xb0, 0xb3, 0x61, 0x59, 0x0f, 0x4a, 0x01, 0x88, 0x0f, 0xa1, 0xf9, 0x22, 0x89, 0x16, 0x9e,
+	0xcb, 0xc3, 0x62, 0xab, 0x2c, 0x13, 0x3b, 0xd0, 0xf8, 0x69, 0x19, 0x69, 0x34, 0xe3, 0xa0, 0x4c,
+	0xe2, 0xf0, 0x56, 0xb8, 0x99, 0xdf, 0x2d, 0xeb, 0xfc, 0x09, 0x0d, 0xde, 0xfe, 0x1b, 0xf3, 0x29,
+	0xa0, 0xfe, 0xd2, 0x5f, 0x60, 0xb6, 0x1a, 0xc7, 0x62, 0x8f, 0xc6, 0x79, 0x71, 0x89, 0x49, 0x2a,
+	0x6b, 0xfd, 0x5a, 0x71, 0x47, 0x06, 0x54, 0x79, 0x91, 0x78, 0x5e, 0xb8, 0x0a, 0x34, 0x4f, 0xe2,
+	0x1d, 0xbc, 0xac, 0xe8, 0x3c, 0x81, 0xa6, 0x81, 0xde, 0x67, 0x75, 0x67, 0xaf, 0x3c, 0x5c, 0xe5,
+	0x67, 0xb0, 0x36, 0x9e, 0xc1, 0xf9, 0xdb, 0x02, 0x7b, 0x3d, 0x3f, 0x7c, 0x6f, 0xc1, 0xbc, 0x38,
+	0x7b, 0x96, 0xdd, 0x79, 0x3e, 0x01, 0xf5, 0xf3, 0xe0, 0x0f, 0x73, 0x95, 0x35, 0xc5, 0x31, 0x61,
+	0xa7, 0xde, 0xe9, 0x90, 0x2d, 0xc5, 0x56, 0x1c, 0x93, 0xe6, 0xf9, 0xe8, 0xe8, 0xf0, 0xbb, 0xa7,
+	0x6c, 0x1f, 0x1d, 0x95, 0x65, 0x84, 0x9f, 0x5d, 0x5d, 0xa5, 0xa8, 0xd9, 0x2a, 0x6a, 0x2a, 0xcb,
+	0x48, 0xc3, 0xf5, 0xb5, 0xcf, 0x86, 0xd0, 0x51, 0x1c, 0x3b, 0x3f, 0x94, 0xe7, 0xf9, 0xad, 0xbb,
+	0x2c, 0x14, 0xab, 0x65, 0x45, 0xe7, 0x5f, 0xab, 0x34, 0xda, 0xf4, 0xc4, 0xe7, 0x98, 0xa6, 0x41,
+	0x14, 0x66, 0x02, 0x1d, 0x55, 0x00, 0x64, 0x30, 0x5e, 0x18, 0xe8, 0xcc, 0x64, 0x1f, 0x9a, 0xa7,
+	0xc8, 0xcb, 0x61, 0xa0, 0x15, 0x97, 0xc5, 0x47, 0x00, 0xca, 0xd7, 0xd3, 0x1b, 0xd4, 0x3f, 0xe2,
+	0x2d, 0x5f, 0x41, 0x47, 0x95, 0x10, 0xf1, 0x29, 0x6c, 0x8f, 0x13, 0x5c, 0x05, 0xd1, 0x32, 0x1d,
+	0x44, 0xcb, 0x50, 0xf3, 0x8d, 0x6c, 0xab, 0x4d, 0x90, 0xda, 0xd1, 0x54, 0x1b, 0x5c, 0x35, 0x09,
+	0x69, 0x0f, 0x82, 0xf8, 0x06, 0x13, 0x8d, 0xaf, 0xcd, 0xe5, 0x74, 0x54, 0x09, 0x71, 0x3c, 0xd8,
+	0x2a, 0x6d, 0x88, 0x4e, 0x3d, 0x4e, 0x90, 0xb6, 0x61, 0x0e, 0x93, 0x65, 0xc2, 0x81, 0x8e, 0xc2,
+	0x45, 0xa4, 0x31, 0xab, 0x56, 0xb9, 0xba, 0x81, 0x39, 0xff, 0x59, 0x85, 0x87, 0xbd, 0xd1, 0x56,
+	0x6f, 0x1b, 0x22, 0x01, 0xf5, 0x49, 0xe4, 0xb9, 0xd9, 0x0c, 0x71, 0x2c, 0x76, 0xa1, 0x3d, 0x8a,
+	0xe2, 0x93, 0x60, 0x11, 0xe4, 0x47, 0x5d, 0xe7, 0x74, 0x4a, 0x15, 0x2d, 0x35, 0xca, 0x46, 0xbf,
+	0x46, 0x43, 0xc7, 0x89, 0xf8, 0x62, 0xfd, 0xb5, 0x93, 0xcd, 0xf2, 0xfc, 0x67, 0xe0, 0xa8, 0xa2,
+	0xf2, 0xba, 0x78, 0x04, 0xed, 0xe3, 0x04, 0x51, 0x93, 0x79, 0x72, 0x57, 0x90, 0x15, 0xe5, 0x88,
+	0xd8, 0x83, 0xed, 0x3c, 0x66, 0x73, 0x90, 0xed, 0x8c, 0xb2, 0x09, 0x93, 0x8f, 0x0c, 0xa2, 0x50,
+	0x63, 0xa8, 0x9d, 0x7e, 0xee, 0xc5, 0x74, 0xc6, 0xa3, 0xa9, 0x0e, 0x56, 0xc8, 0xe7, 0x6e, 0xab,
+	0x2c, 0x73, 0x9e, 0x15, 0x2e, 0x2c, 0x9e, 0x40, 0xf3, 0x5c, 0xfb, 0x7a, 0x99, 0x32, 0xa7, 0x7b,
+	0xb8, 0xb3, 0xe9, 0xc7, 0xa6, 0xa6, 0x32, 0x8e, 0x13, 0x1b, 0x47, 0xbe, 0xc7, 0xa0, 0x44, 0xf6,
+	0x59, 0xce, 0x06, 0x8a, 0x62, 0xf1, 0x14, 0xda, 0xf4, 0x4f, 0x9c, 0x1d, 0x99, 0xcf, 0xf5, 0xbb,
+	0xbf, 0xf1, 0x6b, 0xae, 0xb3, 0x76, 0xf8, 0x7b, 0xdc, 0xeb, 0x97, 0xc2, 0xe1, 0xef, 0xd9, 0xdd,
+	0x0e, 0x34, 0x86, 0x8b, 0xe8, 0xb7, 0x20, 0xdb, 0x9e, 0x49, 0xc8, 0x44, 0xa8, 0x79, 0x56, 0x38,
+	0xe3, 0xed, 0xb5, 0x55, 0x9e, 0x3a, 0xbf, 0xe6, 0x5f, 0x03, 0xba, 0x4f, 0x37, 0xb8, 0xc6, 0x54,
+	0x4b, 0x8b, 0x1f, 0x3b, 0xcb, 0xc4, 0x1e, 0x34, 0xc6, 0x48, 0x56, 0x58, 0xed, 0xd7, 0x8a, 0x6f,
+	0xb1, 0xf9, 0x13, 0x15, 0x94, 0x29, 0x73, 0xaf, 0xf0, 0x23, 0x9a, 0x15, 0x4c, 0xe2, 0xfc, 0x63,
+	0x01, 0x14, 0xdc, 0xf7, 0x72, 0xdf, 0x47, 0x60, 0x8f, 0x97, 0x97, 0xf3, 0x60, 0x5a, 0xcc, 0x67,
+	0x01, 0x88, 0x1e, 0xd4, 0xbc, 0x71, 0xca, 0x7e, 0x6b, 0x2b, 0x0a, 0x49, 0x63, 0x1c, 0x25, 0x66,
+	0x12, 0x1b, 0x8a, 0x63, 0x9a, 0x20, 0x2f, 0x4c, 0x71, 0xba, 0x4c, 0x90, 0x6b, 0x4d, 0xae, 0x6d,
+	0x60, 0x74, 0xe0, 0x0b, 0x8f, 0xab, 0x2d, 0xae, 0x66, 0xd9, 0x97, 0xdf, 0x43, 0x77, 0xb3, 0x41,
+	0x04, 0x40, 0xf3, 0x68, 0x30, 0xf1, 0x7e, 0x1e, 0xf6, 0x2a, 0xa2, 0x0d, 0x75, 0xcf, 0x3d, 0x19,
+	0xf6, 0x2c, 0x21, 0xa0, 0xeb, 0x9e, 0xbd, 0x7a, 0x79, 0x36, 0x79, 0xe5, 0x7a, 0xe7, 0x93, 0x0b,
+	0xf5, 0xbc, 0x57, 0x3d, 0xfc, 0x16, 0xea, 0x83, 0x1b, 0x5f, 0x9b, 0xc6, 0x4b, 0xd0, 0x5f, 0x88,
+	0xcd, 0xd9, 0xd8, 0xdd, 0x4c, 0x9d, 0xca, 0x63, 0xeb, 0x6b, 0xeb, 0xb2, 0xc9, 0x4d, 0xf2, 0xcd,
+	0xff, 0x03, 0x00, 0xeb, 0xf6, 0xf5, 0x90, 0x7b, 0x0a, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -7,23 +7,29 @@ import (
 	"github.com/ngalayko/p2p/instance/peers"
 )
 
-// UnmarshalPeer unmarshals gRPC peer from peer.
+// UnmarshalPeer unmarshals gRPC peer from peer. Known peers are unmarshaled
+// without their own known peers.
 func (g *Peer) UnmarshalPeer(p *peers.Peer) error {
+	g.unmarshalPeer(p)
+	for _, p := range p.KnownPeers.Map() {
+		peer := &Peer{}
+		peer.unmarshalPeer(p)
+		g.KnownPeers = append(g.KnownPeers, peer)
+	}
+	return nil
+}
+
+func (g *Peer) unmarshalPeer(p *peers.Peer) {
 	g.ID = p.ID
 	g.Name = p.Name
 	g.PublicKey = p.PublicCrt
+	g.Port = int32(p.Port)
+	g.InsecurePort = int32(p.InsecurePort)
+	g.UIPort = int32(p.UIPort)
 
 	for _, ip := range p.Addrs.Map() {
 		g.IPs = append(g.IPs, ip.String())
 	}
-	for _, p := range p.KnownPeers.Map() {
-		peer := &Peer{}
-		if err := peer.UnmarshalPeer(p); err != nil {
-			return fmt.Errorf("error unmarshaling known peer: %s", err)
-		}
-		g.KnownPeers = append(g.KnownPeers, peer)
-	}
-	return nil
 }
 
 // MarshalPeer returns peer from gRPC peer.
@@ -32,16 +38,19 @@ func (g Peer) MarshalPeer() (*peers.Peer, error) {
 	peer.ID = g.ID
 	peer.Name = g.Name
 	peer.PublicCrt = g.PublicKey
+	peer.Port = int(g.Port)
+	peer.InsecurePort = int(g.InsecurePort)
+	peer.UIPort = int(g.UIPort)
 	for _, ip := range g.IPs {
 		peer.Addrs.Add(net.ParseIP(ip))
 	}
 	for _, p := range g.KnownPeers {
-		peer, err := p.MarshalPeer()
+		known, err := p.MarshalPeer()
 		if err != nil {
 			return nil, fmt.Errorf("error marshaling known peer %s", p.ID)
 		}
 
-		peer.KnownPeers.Add(peer)
+		peer.KnownPeers.Add(known)
 	}
 	return peer, nil
 }
@@ -28,6 +28,10 @@ type Peer struct {
 	PublicKey            []byte   `protobuf:"bytes,3,opt,name=PublicKey,proto3" json:"PublicKey,omitempty"`
 	IPs                  []string `protobuf:"bytes,4,rep,name=IPs,proto3" json:"IPs,omitempty"`
 	KnownPeers           []*Peer  `protobuf:"bytes,5,rep,name=KnownPeers,proto3" json:"KnownPeers,omitempty"`
+	PreKey               *PreKey  `protobuf:"bytes,6,opt,name=PreKey,proto3" json:"PreKey,omitempty"`
+	Port                 int32    `protobuf:"varint,7,opt,name=Port,proto3" json:"Port,omitempty"`
+	InsecurePort         int32    `protobuf:"varint,8,opt,name=InsecurePort,proto3" json:"InsecurePort,omitempty"`
+	UIPort               int32    `protobuf:"varint,9,opt,name=UIPort,proto3" json:"UIPort,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -93,25 +97,119 @@ func (m *Peer) GetKnownPeers() []*Peer {
 	return nil
 }
 
+func (m *Peer) GetPreKey() *PreKey {
+	if m != nil {
+		return m.PreKey
+	}
+	return nil
+}
+
+func (m *Peer) GetPort() int32 {
+	if m != nil {
+		return m.Port
+	}
+	return 0
+}
+
+func (m *Peer) GetInsecurePort() int32 {
+	if m != nil {
+		return m.InsecurePort
+	}
+	return 0
+}
+
+func (m *Peer) GetUIPort() int32 {
+	if m != nil {
+		return m.UIPort
+	}
+	return 0
+}
+
+// PreKey is a key used to start encrypted sessions with the peer,
+// signed with the peer certificate key together with the time it is
+// created at, so an old prekey can't replace a newer one.
+type PreKey struct {
+	Key       []byte `protobuf:"bytes,1,opt,name=Key,proto3" json:"Key,omitempty"`
+	Signature []byte `protobuf:"bytes,2,opt,name=Signature,proto3" json:"Signature,omitempty"`
+	// CreatedAt is unix time in nanoseconds.
+	CreatedAt            int64    `protobuf:"varint,3,opt,name=CreatedAt,proto3" json:"CreatedAt,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *PreKey) Reset()         { *m = PreKey{} }
+func (m *PreKey) String() string { return proto.CompactTextString(m) }
+func (*PreKey) ProtoMessage()    {}
+func (*PreKey) Descriptor() ([]byte, []int) {
+	return fileDescriptor_e585294ab3f34af5, []int{1}
+}
+
+func (m *PreKey) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_PreKey.Unmarshal(m, b)
+}
+func (m *PreKey) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_PreKey.Marshal(b, m, deterministic)
+}
+func (m *PreKey) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_PreKey.Merge(m, src)
+}
+func (m *PreKey) XXX_Size() int {
+	return xxx_messageInfo_PreKey.Size(m)
+}
+func (m *PreKey) XXX_DiscardUnknown() {
+	xxx_messageInfo_PreKey.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_PreKey proto.InternalMessageInfo
+
+func (m *PreKey) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *PreKey) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func (m *PreKey) GetCreatedAt() int64 {
+	if m != nil {
+		return m.CreatedAt
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*Peer)(nil), "greeter.Peer")
+	proto.RegisterType((*PreKey)(nil), "greeter.PreKey")
 }
 
 func init() { proto.RegisterFile("greeter.proto", fileDescriptor_e585294ab3f34af5) }
 
 var fileDescriptor_e585294ab3f34af5 = []byte{
-	// 173 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x4d, 0x2f, 0x4a, 0x4d,
-	0x2d, 0x49, 0x2d, 0xd2, 0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x87, 0x72, 0x95, 0x3a, 0x19,
-	0xb9, 0x58, 0x02, 0x52, 0x53, 0x8b, 0x84, 0xf8, 0xb8, 0x98, 0x3c, 0x5d, 0x24, 0x18, 0x15, 0x18,
-	0x35, 0x38, 0x83, 0x98, 0x3c, 0x5d, 0x84, 0x84, 0xb8, 0x58, 0xfc, 0x12, 0x73, 0x53, 0x25, 0x98,
-	0xc0, 0x22, 0x60, 0xb6, 0x90, 0x0c, 0x17, 0x67, 0x40, 0x69, 0x52, 0x4e, 0x66, 0xb2, 0x77, 0x6a,
-	0xa5, 0x04, 0xb3, 0x02, 0xa3, 0x06, 0x4f, 0x10, 0x42, 0x40, 0x48, 0x80, 0x8b, 0xd9, 0x33, 0xa0,
-	0x58, 0x82, 0x45, 0x81, 0x59, 0x83, 0x33, 0x08, 0xc4, 0x14, 0xd2, 0xe5, 0xe2, 0xf2, 0xce, 0xcb,
-	0x2f, 0xcf, 0x03, 0x59, 0x50, 0x2c, 0xc1, 0xaa, 0xc0, 0xac, 0xc1, 0x6d, 0xc4, 0xab, 0x07, 0x73,
-	0x09, 0x48, 0x34, 0x08, 0x49, 0x81, 0x91, 0x11, 0x17, 0xbb, 0x3b, 0x44, 0x4e, 0x48, 0x9d, 0x8b,
-	0x15, 0xcc, 0x14, 0x42, 0x55, 0x2e, 0x85, 0xca, 0x55, 0x62, 0x48, 0x62, 0x03, 0xfb, 0xc7, 0x18,
-	0x10, 0x00, 0x00, 0xff, 0xff, 0x4a, 0xb7, 0x2a, 0x04, 0xe0, 0x00, 0x00, 0x00,
+	// 272 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x91, 0x41, 0x4b, 0xc3, 0x40,
+	0x10, 0x85, 0xdd, 0xa4, 0x49, 0xcc, 0x34, 0x55, 0x99, 0x83, 0x2c, 0xe2, 0x61, 0xc9, 0xa5, 0x7b,
+	0xb1, 0x87, 0xf8, 0x0b, 0xc4, 0x82, 0x84, 0x82, 0x84, 0x15, 0xbd, 0xa7, 0x75, 0x28, 0x05, 0x4d,
+	0x64, 0xb3, 0x41, 0xbc, 0xfa, 0xcb, 0x65, 0xa7, 0x29, 0x31, 0xb7, 0x37, 0xdf, 0x1b, 0x76, 0xe6,
+	0xcd, 0xc2, 0x62, 0x6f, 0x89, 0x1c, 0xd9, 0xd5, 0x97, 0x6d, 0x5d, 0x8b, 0xc9, 0x50, 0xe6, 0xbf,
+	0x01, 0xcc, 0x2a, 0x22, 0x8b, 0x17, 0x10, 0x94, 0x6b, 0x29, 0x94, 0xd0, 0xa9, 0x09, 0xca, 0x35,
+	0x22, 0xcc, 0x9e, 0xeb, 0x4f, 0x92, 0x01, 0x13, 0xd6, 0x78, 0x0b, 0x69, 0xd5, 0x6f, 0x3f, 0x0e,
+	0xbb, 0x0d, 0xfd, 0xc8, 0x50, 0x09, 0x9d, 0x99, 0x11, 0xe0, 0x15, 0x84, 0x65, 0xd5, 0xc9, 0x99,
+	0x0a, 0x75, 0x6a, 0xbc, 0xc4, 0x3b, 0x80, 0x4d, 0xd3, 0x7e, 0x37, 0x7e, 0x40, 0x27, 0x23, 0x15,
+	0xea, 0x79, 0xb1, 0x58, 0x9d, 0x36, 0xf1, 0xd4, 0xfc, 0x6b, 0xc0, 0x25, 0xc4, 0x95, 0x25, 0xff,
+	0x76, 0xac, 0x84, 0x9e, 0x17, 0x97, 0x63, 0x2b, 0x63, 0x33, 0xd8, 0x7e, 0xb7, 0xaa, 0xb5, 0x4e,
+	0x26, 0x4a, 0xe8, 0xc8, 0xb0, 0xc6, 0x1c, 0xb2, 0xb2, 0xe9, 0x68, 0xd7, 0x5b, 0x62, 0xef, 0x9c,
+	0xbd, 0x09, 0xc3, 0x6b, 0x88, 0x5f, 0x4b, 0x76, 0x53, 0x76, 0x87, 0x2a, 0x7f, 0x3b, 0x0d, 0xf6,
+	0x19, 0xfc, 0x7c, 0xc1, 0xd9, 0xbc, 0xf4, 0x99, 0x5f, 0x0e, 0xfb, 0xa6, 0x76, 0xbd, 0x3d, 0x1e,
+	0x23, 0x33, 0x23, 0xf0, 0xee, 0xa3, 0xa5, 0xda, 0xd1, 0xfb, 0x83, 0xe3, 0x8b, 0x84, 0x66, 0x04,
+	0x45, 0x01, 0xc9, 0xd3, 0x31, 0x01, 0x2e, 0x21, 0x62, 0x89, 0xd3, 0xfc, 0x37, 0xd3, 0x32, 0x3f,
+	0xdb, 0xc6, 0xfc, 0x41, 0xf7, 0x7f, 0x03, 0x00, 0xee, 0x4d, 0x40, 0x24, 0xb1, 0x01, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -0,0 +1,311 @@
+package messages
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+
+	"github.com/ngalayko/p2p/instance/messages/proto/chat"
+	"github.com/ngalayko/p2p/instance/peers"
+)
+
+const (
+	peerEventsBuffer = 64
+
+	defaultQueueTTL  = 24 * time.Hour
+	minRetryInterval = time.Second
+	maxRetryInterval = time.Minute
+)
+
+// queue keeps messages that were not delivered yet, per recipient.
+type queue struct {
+	guard  *sync.Mutex
+	byPeer map[string]*outbox
+}
+
+// outbox is a list of undelivered messages to a single peer.
+type outbox struct {
+	messages    []*queued
+	attempts    int
+	nextAttempt time.Time
+}
+
+type queued struct {
+	proto    *chat.Message
+	queuedAt time.Time
+}
+
+func newQueue() *queue {
+	return &queue{
+		guard:  &sync.Mutex{},
+		byPeer: map[string]*outbox{},
+	}
+}
+
+// push adds a message to the end of the peer's outbox.
+func (q *queue) push(peerID string, msg *chat.Message) {
+	q.pushAt(peerID, msg, time.Now())
+}
+
+// pushAt adds a message queued at the time to the end of the peer's outbox.
+func (q *queue) pushAt(peerID string, msg *chat.Message, queuedAt time.Time) {
+	q.guard.Lock()
+	defer q.guard.Unlock()
+
+	o, ok := q.byPeer[peerID]
+	if !ok {
+		o = &outbox{}
+		q.byPeer[peerID] = o
+	}
+
+	o.messages = append(o.messages, &queued{
+		proto:    msg,
+		queuedAt: queuedAt,
+	})
+}
+
+// has returns true if there are undelivered messages to the peer.
+func (q *queue) has(peerID string) bool {
+	q.guard.Lock()
+	defer q.guard.Unlock()
+
+	_, ok := q.byPeer[peerID]
+	return ok
+}
+
+// due returns ids of peers that should be retried now.
+func (q *queue) due(now time.Time) []string {
+	q.guard.Lock()
+	defer q.guard.Unlock()
+
+	ids := make([]string, 0, len(q.byPeer))
+	for id, o := range q.byPeer {
+		if now.Before(o.nextAttempt) {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// reset makes every outbox due for a retry.
+func (q *queue) reset() {
+	q.guard.Lock()
+	defer q.guard.Unlock()
+
+	for _, o := range q.byPeer {
+		o.nextAttempt = time.Time{}
+	}
+}
+
+// peek returns the oldest message to the peer.
+func (q *queue) peek(peerID string) *queued {
+	q.guard.Lock()
+	defer q.guard.Unlock()
+
+	o, ok := q.byPeer[peerID]
+	if !ok {
+		return nil
+	}
+	return o.messages[0]
+}
+
+// pop removes the oldest message to the peer.
+func (q *queue) pop(peerID string) {
+	q.guard.Lock()
+	defer q.guard.Unlock()
+
+	o, ok := q.byPeer[peerID]
+	if !ok {
+		return
+	}
+
+	o.messages = o.messages[1:]
+	o.attempts = 0
+	if len(o.messages) == 0 {
+		delete(q.byPeer, peerID)
+	}
+}
+
+// backoff schedules the next attempt to deliver messages to the peer.
+func (q *queue) backoff(peerID string) {
+	q.guard.Lock()
+	defer q.guard.Unlock()
+
+	o, ok := q.byPeer[peerID]
+	if !ok {
+		return
+	}
+
+	interval := minRetryInterval << uint(o.attempts)
+	if interval > maxRetryInterval || interval <= 0 {
+		interval = maxRetryInterval
+	}
+	interval = interval/2 + time.Duration(rand.Int63n(int64(interval/2)))
+
+	o.attempts++
+	o.nextAttempt = time.Now().Add(interval)
+}
+
+// requeue queues sent messages that were still queued when self stopped, as
+// the queue is kept in memory. They expire counting from when they were sent.
+func (h *Handler) requeue() {
+	rr, err := h.store.WithStatus(string(StatusQueued))
+	if err != nil {
+		h.logger.Error("can't load queued messages: %s", err)
+		return
+	}
+
+	for _, r := range rr {
+		if r.FromID != h.self.ID {
+			continue
+		}
+
+		if r.Deleted {
+			h.setStatus(r.ID, StatusFailed)
+			continue
+		}
+
+		if r.File != nil {
+			if err := h.resumeFile(r.ToID, r.ID, 0); err != nil {
+				h.logger.Error("can't requeue file %s: %s", r.ID, err)
+				h.setStatus(r.ID, StatusFailed)
+			}
+			continue
+		}
+
+		msg := &chat.Message{
+			ID: r.ID,
+			Timestamp: &timestamp.Timestamp{
+				Seconds: r.Timestamp.Unix(),
+			},
+			Payload: &chat.Message_Text{
+				Text: r.Text,
+			},
+			GroupID: r.GroupID,
+		}
+		if r.ReplyTo != nil {
+			msg.ReplyTo = &chat.ReplyTo{
+				MessageID: r.ReplyTo.MessageID,
+				FromID:    r.ReplyTo.FromID,
+				Quote:     r.ReplyTo.Quote,
+			}
+		}
+
+		if r.GroupID == "" {
+			h.queue.pushAt(r.ToID, msg, r.Timestamp)
+			continue
+		}
+
+		g, err := h.getGroup(r.GroupID)
+		if err != nil {
+			h.logger.Error("can't requeue message %s: %s", r.ID, err)
+			h.setStatus(r.ID, StatusFailed)
+			continue
+		}
+		for _, m := range g.Members {
+			if m.ID != h.self.ID {
+				h.queue.pushAt(m.ID, msg, r.Timestamp)
+			}
+		}
+	}
+}
+
+// watchQueue retries to deliver queued messages when a peer is discovered
+// or comes back online, and with a backoff.
+func (h *Handler) watchQueue(ctx context.Context) {
+	ticker := time.NewTicker(minRetryInterval)
+	defer ticker.Stop()
+
+	peerEvents := h.self.KnownPeers.Subscribe(peerEventsBuffer)
+	defer func() {
+		peerEvents.Close()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-peerEvents.Events():
+			if !ok {
+				// fell behind, some peers could be added.
+				peerEvents = h.self.KnownPeers.Subscribe(peerEventsBuffer)
+			}
+			switch e := e.(type) {
+			case *peers.PeerRemoved:
+				continue
+			case *peers.PeerChanged:
+				if e.Status != peers.StatusOnline {
+					continue
+				}
+			}
+			h.queue.reset()
+			h.flushQueue(ctx)
+		case <-ticker.C:
+			h.flushQueue(ctx)
+		}
+	}
+}
+
+func (h *Handler) flushQueue(ctx context.Context) {
+	for _, peerID := range h.queue.due(time.Now()) {
+		h.flushOutbox(ctx, peerID)
+	}
+}
+
+// flushOutbox delivers messages to the peer in order until the first failure.
+func (h *Handler) flushOutbox(ctx context.Context, peerID string) {
+	for q := h.queue.peek(peerID); q != nil; q = h.queue.peek(peerID) {
+		if time.Since(q.queuedAt) > h.queueTTL {
+			h.logger.Error("message %s to %s expired", q.proto.ID, peerID)
+			h.queue.pop(peerID)
+			if id := storedID(q.proto); id != "" {
+				h.setStatus(id, StatusFailed)
+			}
+			continue
+		}
+
+		to, err := h.getPeer(peerID)
+		if err != nil {
+			h.queue.backoff(peerID)
+			return
+		}
+
+		if err := h.sendMessage(ctx, to, q.proto); err != nil {
+			h.logger.Debug("can't deliver queued message %s to %s: %s", q.proto.ID, peerID, err)
+			h.queue.backoff(peerID)
+			return
+		}
+
+		h.queue.pop(peerID)
+
+		// a file is sent with its last chunk, which has the id of the file.
+		if id := storedID(q.proto); id != "" && id == q.proto.ID {
+			h.setStatus(id, StatusSent)
+		}
+
+		// only one chunk of a file is queued, the rest is sent from the file.
+		if chunk, ok := q.proto.Payload.(*chat.Message_File); ok && q.proto.ID != chunk.File.FileID {
+			next := chunk.File.Offset + int64(len(chunk.File.Data))
+			if err := h.resumeFile(peerID, chunk.File.FileID, next); err != nil {
+				h.logger.Error("can't continue file %s to %s: %s", chunk.File.FileID, peerID, err)
+			}
+		}
+	}
+}
+
+// storedID returns an id of the stored message the queued one delivers, or
+// an empty string if it is not stored. File chunks deliver the file message.
+func storedID(msg *chat.Message) string {
+	switch payload := msg.Payload.(type) {
+	case *chat.Message_Text:
+		return msg.ID
+	case *chat.Message_File:
+		return payload.File.FileID
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,393 @@
+// Package ratchet implements a session with a key agreement similar to X3DH
+// and the Double Ratchet algorithm: every message is encrypted with its own key,
+// and keys of already received messages can't be derived from the session state.
+package ratchet
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// maxSkip is the maximum number of messages that can be skipped in a single chain.
+	maxSkip = 1000
+	// maxSkipped is the maximum number of stored keys of skipped messages.
+	maxSkipped = 2000
+)
+
+var (
+	curve = ecdh.P256()
+
+	infoAgreement = []byte("p2p agreement")
+	infoRatchet   = []byte("p2p ratchet")
+	infoMessage   = []byte("p2p message")
+)
+
+// KeyPair is a Diffie-Hellman key pair.
+type KeyPair struct {
+	private *ecdh.PrivateKey
+	// Public is an uncompressed P-256 point.
+	Public []byte
+}
+
+// NewKeyPair generates a new key pair.
+func NewKeyPair() (*KeyPair, error) {
+	private, err := curve.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("can't generate key: %s", err)
+	}
+	return &KeyPair{
+		private: private,
+		Public:  private.PublicKey().Bytes(),
+	}, nil
+}
+
+// Header is sent along with every encrypted message.
+type Header struct {
+	// RatchetKey is the current ratchet public key of the sender.
+	RatchetKey []byte
+	// PreviousCount is the number of messages in the previous sending chain.
+	PreviousCount uint32
+	// Count is the number of the message in the current sending chain.
+	Count uint32
+}
+
+func (h *Header) bytes() []byte {
+	b := make([]byte, len(h.RatchetKey)+8)
+	copy(b, h.RatchetKey)
+	binary.BigEndian.PutUint32(b[len(h.RatchetKey):], h.PreviousCount)
+	binary.BigEndian.PutUint32(b[len(h.RatchetKey)+4:], h.Count)
+	return b
+}
+
+type skippedKey struct {
+	ratchetKey string
+	count      uint32
+}
+
+// Session is one side of an encrypted session between two peers.
+// Session is not safe for concurrent use.
+type Session struct {
+	// ad is bound to every message of the session.
+	ad []byte
+
+	rootKey []byte
+
+	ratchet       *KeyPair
+	remoteRatchet []byte
+
+	sendChain     []byte
+	sendCount     uint32
+	previousCount uint32
+
+	receiveChain []byte
+	receiveCount uint32
+
+	skipped      map[skippedKey][]byte
+	skippedOrder []skippedKey
+}
+
+// Initiate starts a session with a peer. Both prekeys must be authenticated
+// by the identities of the peers. Returns the session and an ephemeral key
+// the peer needs to respond.
+func Initiate(preKey *KeyPair, remotePreKey []byte) (*Session, []byte, error) {
+	ephemeral, err := NewKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dh1, err := dh(preKey, remotePreKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	dh2, err := dh(ephemeral, remotePreKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s := newSession(agree(dh1, dh2), preKey.Public, remotePreKey)
+
+	s.ratchet, err = NewKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+	s.remoteRatchet = remotePreKey
+
+	out, err := dh(s.ratchet, s.remoteRatchet)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.rootKey, s.sendChain = kdfRoot(s.rootKey, out)
+
+	return s, ephemeral.Public, nil
+}
+
+// Respond accepts a session started by a peer with the prekey.
+// The session can send messages only after the first message is decrypted.
+func Respond(preKey *KeyPair, remotePreKey []byte, ephemeral []byte) (*Session, error) {
+	dh1, err := dh(preKey, remotePreKey)
+	if err != nil {
+		return nil, err
+	}
+	dh2, err := dh(preKey, ephemeral)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSession(agree(dh1, dh2), remotePreKey, preKey.Public)
+	s.ratchet = preKey
+	return s, nil
+}
+
+func newSession(rootKey []byte, initiatorPreKey []byte, responderPreKey []byte) *Session {
+	return &Session{
+		ad:      append(append([]byte{}, initiatorPreKey...), responderPreKey...),
+		rootKey: rootKey,
+		skipped: map[skippedKey][]byte{},
+	}
+}
+
+// Encrypt encrypts a message with the next message key. ad is authenticated,
+// but not encrypted.
+func (s *Session) Encrypt(plaintext []byte, ad []byte) (*Header, []byte, error) {
+	if s.sendChain == nil {
+		return nil, nil, fmt.Errorf("session is not established yet")
+	}
+
+	var messageKey []byte
+	s.sendChain, messageKey = kdfChain(s.sendChain)
+
+	header := &Header{
+		RatchetKey:    s.ratchet.Public,
+		PreviousCount: s.previousCount,
+		Count:         s.sendCount,
+	}
+	s.sendCount++
+
+	ciphertext, err := seal(messageKey, plaintext, s.associatedData(header, ad))
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, ciphertext, nil
+}
+
+// Decrypt decrypts a message. The session is not changed if the message
+// can't be decrypted.
+func (s *Session) Decrypt(header *Header, ciphertext []byte, ad []byte) ([]byte, error) {
+	next := s.copy()
+
+	plaintext, err := next.decrypt(header, ciphertext, ad)
+	if err != nil {
+		return nil, err
+	}
+
+	*s = *next
+	return plaintext, nil
+}
+
+func (s *Session) decrypt(header *Header, ciphertext []byte, ad []byte) ([]byte, error) {
+	key := skippedKey{ratchetKey: string(header.RatchetKey), count: header.Count}
+	if messageKey, ok := s.skipped[key]; ok {
+		s.forget(key)
+		return open(messageKey, ciphertext, s.associatedData(header, ad))
+	}
+
+	if !bytes.Equal(header.RatchetKey, s.remoteRatchet) {
+		if err := s.skip(header.PreviousCount); err != nil {
+			return nil, err
+		}
+		if err := s.step(header.RatchetKey); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.skip(header.Count); err != nil {
+		return nil, err
+	}
+
+	var messageKey []byte
+	s.receiveChain, messageKey = kdfChain(s.receiveChain)
+	s.receiveCount++
+
+	return open(messageKey, ciphertext, s.associatedData(header, ad))
+}
+
+// skip stores keys of messages of the current receiving chain up to count.
+func (s *Session) skip(count uint32) error {
+	if s.receiveChain == nil {
+		return nil
+	}
+	if count < s.receiveCount {
+		return fmt.Errorf("message key is already used")
+	}
+	if count-s.receiveCount > maxSkip {
+		return fmt.Errorf("too many skipped messages")
+	}
+
+	for s.receiveCount < count {
+		var messageKey []byte
+		s.receiveChain, messageKey = kdfChain(s.receiveChain)
+
+		key := skippedKey{ratchetKey: string(s.remoteRatchet), count: s.receiveCount}
+		s.skipped[key] = messageKey
+		s.skippedOrder = append(s.skippedOrder, key)
+		s.receiveCount++
+	}
+
+	// the oldest skipped messages are not expected to arrive anymore.
+	for len(s.skippedOrder) > maxSkipped {
+		delete(s.skipped, s.skippedOrder[0])
+		s.skippedOrder = s.skippedOrder[1:]
+	}
+	return nil
+}
+
+// forget removes a used key of a skipped message.
+func (s *Session) forget(key skippedKey) {
+	delete(s.skipped, key)
+	for i := range s.skippedOrder {
+		if s.skippedOrder[i] == key {
+			s.skippedOrder = append(s.skippedOrder[:i], s.skippedOrder[i+1:]...)
+			return
+		}
+	}
+}
+
+// step performs a Diffie-Hellman ratchet step with a new ratchet key of the peer.
+func (s *Session) step(remoteRatchet []byte) error {
+	out, err := dh(s.ratchet, remoteRatchet)
+	if err != nil {
+		return err
+	}
+
+	ratchet, err := NewKeyPair()
+	if err != nil {
+		return err
+	}
+
+	s.previousCount = s.sendCount
+	s.sendCount = 0
+	s.receiveCount = 0
+	s.remoteRatchet = remoteRatchet
+	s.rootKey, s.receiveChain = kdfRoot(s.rootKey, out)
+
+	out, err = dh(ratchet, remoteRatchet)
+	if err != nil {
+		return err
+	}
+
+	s.ratchet = ratchet
+	s.rootKey, s.sendChain = kdfRoot(s.rootKey, out)
+	return nil
+}
+
+func (s *Session) copy() *Session {
+	c := *s
+	c.skipped = make(map[skippedKey][]byte, len(s.skipped))
+	for k, v := range s.skipped {
+		c.skipped[k] = v
+	}
+	c.skippedOrder = append([]skippedKey{}, s.skippedOrder...)
+	return &c
+}
+
+func (s *Session) associatedData(header *Header, ad []byte) []byte {
+	b := append([]byte{}, s.ad...)
+	b = append(b, header.bytes()...)
+	return append(b, ad...)
+}
+
+func dh(self *KeyPair, remote []byte) ([]byte, error) {
+	public, err := curve.NewPublicKey(remote)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %s", err)
+	}
+
+	out, err := self.private.ECDH(public)
+	if err != nil {
+		return nil, fmt.Errorf("can't agree on a key: %s", err)
+	}
+	return out, nil
+}
+
+// agree derives a shared secret from the key agreement outputs.
+func agree(outputs ...[]byte) []byte {
+	ikm := bytes.Repeat([]byte{0xff}, 32)
+	for _, out := range outputs {
+		ikm = append(ikm, out...)
+	}
+	return hkdf(make([]byte, sha256.Size), ikm, infoAgreement, 32)
+}
+
+// kdfRoot returns a new root key and a chain key.
+func kdfRoot(rootKey []byte, out []byte) ([]byte, []byte) {
+	keys := hkdf(rootKey, out, infoRatchet, 64)
+	return keys[:32], keys[32:]
+}
+
+// kdfChain returns the next chain key and a message key.
+func kdfChain(chainKey []byte) ([]byte, []byte) {
+	return mac(chainKey, []byte{0x02}), mac(chainKey, []byte{0x01})
+}
+
+func hkdf(salt []byte, ikm []byte, info []byte, length int) []byte {
+	prk := mac(salt, ikm)
+
+	var out, t []byte
+	for i := byte(1); len(out) < length; i++ {
+		t = mac(prk, append(append(t, info...), i))
+		out = append(out, t...)
+	}
+	return out[:length]
+}
+
+func mac(key []byte, data []byte) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write(data)
+	return m.Sum(nil)
+}
+
+func seal(messageKey []byte, plaintext []byte, ad []byte) ([]byte, error) {
+	aead, nonce, err := messageCipher(messageKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, nonce, plaintext, ad), nil
+}
+
+func open(messageKey []byte, ciphertext []byte, ad []byte) ([]byte, error) {
+	aead, nonce, err := messageCipher(messageKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt message: %s", err)
+	}
+	return plaintext, nil
+}
+
+// messageCipher returns AES-256-GCM and a nonce, both derived from the message key.
+// Every message key is used only once, so the nonce is never reused.
+func messageCipher(messageKey []byte) (cipher.AEAD, []byte, error) {
+	keys := hkdf(make([]byte, sha256.Size), messageKey, infoMessage, 32+12)
+
+	block, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, keys[32:], nil
+}
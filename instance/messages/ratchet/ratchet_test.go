@@ -0,0 +1,150 @@
+package ratchet
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Session__should_exchange_messages(t *testing.T) {
+	alice, bob := testSessions(t)
+
+	for i := 0; i < 3; i++ {
+		assertSent(t, alice, bob, "hello bob")
+		assertSent(t, alice, bob, "how are you?")
+		assertSent(t, bob, alice, "hello alice")
+	}
+}
+
+func Test_Session__should_use_a_key_per_message(t *testing.T) {
+	alice, bob := testSessions(t)
+
+	h1, c1, err := alice.Encrypt([]byte("same"), nil)
+	assert.NoError(t, err)
+	h2, c2, err := alice.Encrypt([]byte("same"), nil)
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, c1, c2)
+	assert.Equal(t, h1.Count+1, h2.Count)
+
+	_, err = bob.Decrypt(h1, c1, nil)
+	assert.NoError(t, err)
+
+	_, err = bob.Decrypt(h1, c1, nil)
+	assert.Error(t, err, "message key must not be reused")
+}
+
+func Test_Session__should_decrypt_out_of_order(t *testing.T) {
+	alice, bob := testSessions(t)
+
+	h1, c1, err := alice.Encrypt([]byte("first"), nil)
+	assert.NoError(t, err)
+	h2, c2, err := alice.Encrypt([]byte("second"), nil)
+	assert.NoError(t, err)
+
+	plaintext, err := bob.Decrypt(h2, c2, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", string(plaintext))
+
+	plaintext, err = bob.Decrypt(h1, c1, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", string(plaintext))
+}
+
+func Test_Session__should_forget_used_skipped_keys(t *testing.T) {
+	alice, bob := testSessions(t)
+
+	h1, c1, err := alice.Encrypt([]byte("first"), nil)
+	assert.NoError(t, err)
+	h2, c2, err := alice.Encrypt([]byte("second"), nil)
+	assert.NoError(t, err)
+
+	_, err = bob.Decrypt(h2, c2, nil)
+	assert.NoError(t, err)
+	assert.Len(t, bob.skippedOrder, 1)
+
+	_, err = bob.Decrypt(h1, c1, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, bob.skipped)
+	assert.Empty(t, bob.skippedOrder)
+}
+
+func Test_Session__should_not_change_on_forged_message(t *testing.T) {
+	alice, bob := testSessions(t)
+
+	header, ciphertext, err := alice.Encrypt([]byte("hello"), []byte("id"))
+	assert.NoError(t, err)
+
+	forged := append([]byte{}, ciphertext...)
+	forged[0] ^= 0xff
+	_, err = bob.Decrypt(header, forged, []byte("id"))
+	assert.Error(t, err)
+
+	_, err = bob.Decrypt(header, ciphertext, []byte("another id"))
+	assert.Error(t, err)
+
+	plaintext, err := bob.Decrypt(header, ciphertext, []byte("id"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(plaintext))
+}
+
+func Test_Session__should_reject_another_prekey(t *testing.T) {
+	alicePreKey, bobPreKey := testKeyPair(t), testKeyPair(t)
+
+	alice, ephemeral, err := Initiate(alicePreKey, bobPreKey.Public)
+	assert.NoError(t, err)
+
+	mallory, err := Respond(bobPreKey, testKeyPair(t).Public, ephemeral)
+	assert.NoError(t, err)
+
+	header, ciphertext, err := alice.Encrypt([]byte("hello"), nil)
+	assert.NoError(t, err)
+
+	_, err = mallory.Decrypt(header, ciphertext, nil)
+	assert.Error(t, err)
+}
+
+//
+// helpers
+//
+
+func testSessions(t *testing.T) (*Session, *Session) {
+	alicePreKey, bobPreKey := testKeyPair(t), testKeyPair(t)
+
+	alice, ephemeral, err := Initiate(alicePreKey, bobPreKey.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob, err := Respond(bobPreKey, alicePreKey.Public, ephemeral)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the responder can send only after the first message.
+	assertSent(t, alice, bob, "hi")
+
+	return alice, bob
+}
+
+func testKeyPair(t *testing.T) *KeyPair {
+	kp, err := NewKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kp
+}
+
+func assertSent(t *testing.T, from *Session, to *Session, text string) {
+	header, ciphertext, err := from.Encrypt([]byte(text), nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.NotContains(t, string(ciphertext), text)
+
+	plaintext, err := to.Decrypt(header, ciphertext, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, text, string(plaintext))
+	}
+}
@@ -0,0 +1,72 @@
+package messages
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ngalayko/p2p/instance/messages/proto/chat"
+	"github.com/ngalayko/p2p/instance/peers"
+)
+
+// SendRead notifies the peer that its message was read.
+func (h *Handler) SendRead(ctx context.Context, peerID string, messageID string) error {
+	to, err := h.getPeer(peerID)
+	if err != nil {
+		return fmt.Errorf("error getting peer: %s", err)
+	}
+
+	return h.sendReceipt(ctx, to, &chat.Message{
+		Payload: &chat.Message_Read{
+			Read: &chat.Receipt{
+				MessageID: messageID,
+			},
+		},
+	})
+}
+
+func (h *Handler) sendDelivered(ctx context.Context, to *peers.Peer, messageID string) error {
+	return h.sendReceipt(ctx, to, &chat.Message{
+		Payload: &chat.Message_Delivered{
+			Delivered: &chat.Receipt{
+				MessageID: messageID,
+			},
+		},
+	})
+}
+
+// sendReceipt sends a receipt. Receipts are not queued, if a receipt is lost
+// the message stays in the previous status.
+func (h *Handler) sendReceipt(ctx context.Context, to *peers.Peer, receipt *chat.Message) error {
+	msg, err := h.makeMessage(receipt)
+	if err != nil {
+		return fmt.Errorf("error making receipt: %s", err)
+	}
+
+	if err := h.sendMessage(ctx, to, msg); err != nil {
+		return fmt.Errorf("error sending receipt: %s", err)
+	}
+
+	return nil
+}
+
+// handleReceipt updates a status of the message sent to the peer or to a group
+// the peer is a member of.
+func (h *Handler) handleReceipt(peerID string, messageID string, status Status) {
+	r, err := h.store.Get(messageID)
+	if err != nil {
+		h.logger.Error("receipt for unknown message %s from %s", messageID, peerID)
+		return
+	}
+
+	if r.GroupID != "" {
+		if !h.isMember(r.GroupID, peerID) {
+			h.logger.Error("receipt for message %s from %s, who is not a member of %s", messageID, peerID, r.GroupID)
+			return
+		}
+	} else if r.ToID != peerID {
+		h.logger.Error("receipt for message %s from %s, but it was sent to %s", messageID, peerID, r.ToID)
+		return
+	}
+
+	h.setStatus(messageID, status)
+}
@@ -1,7 +1,14 @@
 package messages
 
 import (
+	"context"
 	"io"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/ngalayko/p2p/instance/messages/proto/chat"
+	"github.com/ngalayko/p2p/instance/peers"
 )
 
 func (h *Handler) listenStream(s stream, peerID string) {
@@ -10,14 +17,15 @@ func (h *Handler) listenStream(s stream, peerID string) {
 
 	for {
 		msg, err := s.Recv()
-		switch err {
-		case nil, io.EOF:
-		default:
-			continue
-		}
-
-		if msg == nil {
-			continue
+		if err != nil {
+			switch {
+			case err == io.EOF, status.Code(err) == codes.Canceled:
+				h.logger.Info("%s closed the stream", peerID)
+			default:
+				h.logger.Error("stream with %s failed: %s", peerID, err)
+			}
+			h.dropStream(peerID, s)
+			return
 		}
 
 		h.logger.Info("new message from %s", peerID)
@@ -28,11 +36,101 @@ func (h *Handler) listenStream(s stream, peerID string) {
 			continue
 		}
 
-		h.received <- fromProto(peer, h.self, msg)
+		h.self.KnownPeers.Seen(peer.ID)
+
+		msg, err = h.open(peer, msg)
+		if err != nil {
+			h.logger.Error("can't decrypt a message from %s: %s", peerID, err)
+			continue
+		}
+
+		h.handle(peer, msg)
 	}
 }
 
-// Received returns a channel with new messages.
-func (h *Handler) Received() <-chan *Message {
-	return h.received
+// handle handles a decrypted message from the peer.
+func (h *Handler) handle(peer *peers.Peer, msg *chat.Message) {
+	switch payload := msg.Payload.(type) {
+	case *chat.Message_Delivered:
+		h.handleReceipt(peer.ID, payload.Delivered.MessageID, StatusDelivered)
+	case *chat.Message_Read:
+		h.handleReceipt(peer.ID, payload.Read.MessageID, StatusRead)
+	case *chat.Message_GroupUpdate:
+		h.handleGroupUpdate(peer, payload.GroupUpdate)
+	case *chat.Message_GroupLeave:
+		h.handleGroupLeave(peer, payload.GroupLeave.GroupID)
+	case *chat.Message_File:
+		h.handleFileChunk(peer, msg, payload.File)
+	case *chat.Message_FileResume:
+		h.handleFileResume(peer, payload.FileResume)
+	case *chat.Message_Envelope:
+		h.handleEnvelope(peer, payload.Envelope)
+	case *chat.Message_Typing:
+		h.handleTyping(peer, msg.GroupID, payload.Typing)
+	case *chat.Message_Presence:
+		h.handlePresence(peer, payload.Presence)
+	case *chat.Message_Edit:
+		h.handleEdit(peer, payload.Edit)
+	case *chat.Message_Delete:
+		h.handleDelete(peer, payload.Delete)
+	case *chat.Message_Reaction:
+		h.handleReaction(peer, msg.GroupID, payload.Reaction)
+	case *chat.Message_Gossip:
+		h.handleGossip(peer, payload.Gossip)
+	default:
+		h.receive(peer, msg)
+	}
+}
+
+func (h *Handler) receive(peer *peers.Peer, msg *chat.Message) {
+	received := fromProto(peer, h.self, msg)
+	if msg.GroupID != "" {
+		group, err := h.getGroup(msg.GroupID)
+		if err != nil || !group.has(peer.ID) {
+			h.logger.Error("message %s from %s to unknown group %s", msg.ID, peer.ID, msg.GroupID)
+			return
+		}
+		received.To = nil
+		received.Group = group
+	}
+
+	record := received.toRecord(h.self)
+
+	if err := h.checkReplyTo(record); err != nil {
+		h.logger.Error("message %s from %s replies to %s: %s", msg.ID, peer.ID, msg.ReplyTo.MessageID, err)
+		return
+	}
+	if record.ReplyTo != nil {
+		received.ReplyTo = &ReplyTo{
+			MessageID: record.ReplyTo.MessageID,
+			FromID:    record.ReplyTo.FromID,
+			Quote:     record.ReplyTo.Quote,
+		}
+	}
+
+	// ids are chosen by senders, so a known id is either a retry of the
+	// sender, or an attempt to overwrite another message. Messages to self
+	// are stored as sent before they are received.
+	if stored, err := h.store.Get(record.ID); err == nil && peer.ID != h.self.ID {
+		if stored.ChatID != record.ChatID || stored.FromID != record.FromID {
+			h.logger.Error("message %s from %s reuses an id of another message", record.ID, peer.ID)
+			return
+		}
+
+		// the receipt was lost, so the sender retries.
+		if err := h.sendDelivered(context.Background(), peer, record.ID); err != nil {
+			h.logger.Error("can't acknowledge message %s: %s", record.ID, err)
+		}
+		return
+	}
+
+	if err := h.store.Save(record); err != nil {
+		h.logger.Error("can't store received message %s: %s", received.ID, err)
+	}
+
+	if err := h.sendDelivered(context.Background(), peer, received.ID); err != nil {
+		h.logger.Error("can't acknowledge message %s: %s", received.ID, err)
+	}
+
+	h.updates.Publish(&MessageReceived{Message: received})
 }
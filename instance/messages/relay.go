@@ -0,0 +1,306 @@
+package messages
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/ngalayko/p2p/instance/messages/proto/chat"
+	"github.com/ngalayko/p2p/instance/messages/proto/greeter"
+	"github.com/ngalayko/p2p/instance/peers"
+)
+
+const (
+	// maxHops is the maximum number of relays an envelope can pass.
+	maxHops = 4
+	// seenTTL is how long ids of passed envelopes are kept to drop duplicates.
+	seenTTL = 10 * time.Minute
+)
+
+// relay sends a message to the peer through peers with live streams. A session
+// with the peer is started with a greeting sent the same way, the message is
+// not sent until the peer answers.
+func (h *Handler) relay(ctx context.Context, to *peers.Peer, msg *chat.Message) error {
+	if !h.hasPreKey(to.ID) {
+		if err := h.relayGreeting(ctx, to.ID, false); err != nil {
+			return err
+		}
+		return fmt.Errorf("greeting %s through relays", to.ID)
+	}
+
+	sealed, err := h.seal(to, msg)
+	if err != nil {
+		return err
+	}
+
+	return h.forward(ctx, &chat.Envelope{
+		ID:       msg.ID,
+		FromID:   h.self.ID,
+		ToID:     to.ID,
+		HopLimit: maxHops,
+		Content: &chat.Envelope_Message{
+			Message: sealed,
+		},
+	})
+}
+
+// relayGreeting sends self with the prekey to the peer through relays.
+func (h *Handler) relayGreeting(ctx context.Context, toID string, reply bool) error {
+	selfProto, err := h.Greeting()
+	if err != nil {
+		return err
+	}
+	selfProto.KnownPeers = nil
+
+	greeting, err := proto.Marshal(selfProto)
+	if err != nil {
+		return fmt.Errorf("can't marshal greeting: %s", err)
+	}
+
+	id, err := h.newID()
+	if err != nil {
+		return err
+	}
+
+	env := &chat.Envelope{
+		ID:       id,
+		FromID:   h.self.ID,
+		ToID:     toID,
+		HopLimit: maxHops,
+	}
+	if reply {
+		env.Content = &chat.Envelope_GreetingReply{GreetingReply: greeting}
+	} else {
+		env.Content = &chat.Envelope_Greeting{Greeting: greeting}
+	}
+
+	h.logger.Info("greeting %s through relays", toID)
+
+	return h.forward(ctx, env)
+}
+
+// forward sends the envelope to the recipient if there is a live stream to it,
+// or to every other peer with a live stream that the envelope hasn't passed.
+func (h *Handler) forward(ctx context.Context, env *chat.Envelope) error {
+	env.Route = append(env.Route, h.self.ID)
+	h.seen(env.ID)
+
+	relays := h.relays(env)
+	if len(relays) == 0 {
+		return fmt.Errorf("no relays to %s", env.ToID)
+	}
+
+	sent := false
+	for _, relay := range relays {
+		id, err := h.newID()
+		if err != nil {
+			return err
+		}
+
+		msg := &chat.Message{
+			ID: id,
+			Payload: &chat.Message_Envelope{
+				Envelope: env,
+			},
+		}
+		if err := h.sendDirect(ctx, relay, msg); err != nil {
+			h.logger.Debug("can't relay an envelope to %s through %s: %s", env.ToID, relay.ID, err)
+			continue
+		}
+		sent = true
+	}
+
+	if !sent {
+		return fmt.Errorf("can't relay to %s", env.ToID)
+	}
+	return nil
+}
+
+// relays returns peers to send the envelope to.
+func (h *Handler) relays(env *chat.Envelope) []*peers.Peer {
+	passed := make(map[string]bool, len(env.Route))
+	for _, id := range env.Route {
+		passed[id] = true
+	}
+
+	h.relayGuard.Lock()
+	route, hasRoute := h.routes[env.ToID]
+	h.relayGuard.Unlock()
+
+	h.streamsGuard.RLock()
+	_, toTarget := h.streams[env.ToID]
+	_, toRoute := h.streams[route]
+	ids := make([]string, 0, len(h.streams))
+	for id := range h.streams {
+		ids = append(ids, id)
+	}
+	h.streamsGuard.RUnlock()
+
+	switch {
+	case toTarget:
+		ids = []string{env.ToID}
+	case env.HopLimit == 0:
+		// the next relay would drop it.
+		return nil
+	case hasRoute && toRoute && !passed[route]:
+		ids = []string{route}
+	}
+
+	relays := make([]*peers.Peer, 0, len(ids))
+	for _, id := range ids {
+		if passed[id] || id == h.self.ID {
+			continue
+		}
+
+		peer, err := h.getPeer(id)
+		if err != nil {
+			continue
+		}
+		relays = append(relays, peer)
+	}
+	return relays
+}
+
+// handleEnvelope forwards the envelope or handles it if it is sent to self.
+// hop is the peer the envelope is received from.
+func (h *Handler) handleEnvelope(hop *peers.Peer, env *chat.Envelope) {
+	if h.seen(env.ID) {
+		h.logger.Debug("dropped a duplicate envelope %s", env.ID)
+		return
+	}
+
+	for _, id := range env.Route {
+		if id == h.self.ID {
+			h.logger.Debug("dropped a looped envelope %s", env.ID)
+			return
+		}
+	}
+
+	if env.ToID != h.self.ID {
+		if env.HopLimit == 0 {
+			h.logger.Debug("dropped an envelope %s to %s over the hop limit", env.ID, env.ToID)
+			return
+		}
+		env.HopLimit--
+
+		if err := h.forward(context.Background(), env); err != nil {
+			h.logger.Error("can't relay an envelope from %s to %s: %s", env.FromID, env.ToID, err)
+		}
+		return
+	}
+
+	switch content := env.Content.(type) {
+	case *chat.Envelope_Greeting:
+		h.handleRelayedGreeting(env.FromID, content.Greeting, false)
+	case *chat.Envelope_GreetingReply:
+		h.handleRelayedGreeting(env.FromID, content.GreetingReply, true)
+	case *chat.Envelope_Message:
+		h.handleRelayedMessage(hop, env.FromID, content.Message)
+	}
+}
+
+func (h *Handler) handleRelayedMessage(hop *peers.Peer, fromID string, msg *chat.Message) {
+	from, err := h.getPeer(fromID)
+	if err != nil {
+		h.logger.Error("relayed message from unknown peer: %s", err)
+		return
+	}
+
+	msg, err = h.open(from, msg)
+	if err != nil {
+		h.logger.Error("can't decrypt a relayed message from %s: %s", fromID, err)
+		return
+	}
+
+	if _, ok := msg.Payload.(*chat.Message_Envelope); ok {
+		h.logger.Error("relayed message from %s is an envelope", fromID)
+		return
+	}
+
+	// FromID and the route are chosen by the sender, only a message sealed
+	// with the session of the peer proves it's sent by the peer. Answers are
+	// sent back the same way.
+	h.relayGuard.Lock()
+	h.routes[fromID] = hop.ID
+	h.relayGuard.Unlock()
+
+	h.logger.Info("new relayed message from %s", fromID)
+
+	h.self.KnownPeers.Seen(fromID)
+
+	h.handle(from, msg)
+}
+
+func (h *Handler) handleRelayedGreeting(fromID string, greeting []byte, reply bool) {
+	grpcPeer := &greeter.Peer{}
+	if err := proto.Unmarshal(greeting, grpcPeer); err != nil {
+		h.logger.Error("invalid relayed greeting from %s: %s", fromID, err)
+		return
+	}
+
+	if grpcPeer.ID != fromID {
+		h.logger.Error("%s sent a relayed greeting as %s", fromID, grpcPeer.ID)
+		return
+	}
+
+	if err := h.verifyPublicCrt(grpcPeer.ID, grpcPeer.PublicKey); err != nil {
+		h.logger.Error("invalid certificate of %s: %s", fromID, err)
+		return
+	}
+
+	// ids of passed envelopes are forgotten after seenTTL, so a relay can
+	// inject an old greeting again. It is dropped as its prekey is older than
+	// the known one.
+	if err := h.savePreKey(grpcPeer.ID, grpcPeer.PublicKey, grpcPeer.PreKey); err != nil {
+		h.logger.Error("invalid prekey of %s: %s", fromID, err)
+		return
+	}
+
+	peer, err := grpcPeer.MarshalPeer()
+	if err != nil {
+		h.logger.Error("invalid relayed greeting from %s: %s", fromID, err)
+		return
+	}
+	h.self.KnownPeers.Add(peer)
+
+	h.logger.Info("greeted %s through relays", fromID)
+
+	if !reply {
+		if err := h.relayGreeting(context.Background(), fromID, true); err != nil {
+			h.logger.Error("can't answer a relayed greeting from %s: %s", fromID, err)
+		}
+	}
+
+	// messages waiting for the session can be sent now.
+	h.queue.reset()
+}
+
+// seen marks the envelope as passed and returns true if it was passed before.
+func (h *Handler) seen(id string) bool {
+	h.relayGuard.Lock()
+	defer h.relayGuard.Unlock()
+
+	now := time.Now()
+	if now.Sub(h.seenPruned) > seenTTL {
+		for seenID, at := range h.seenEnvelopes {
+			if now.Sub(at) > seenTTL {
+				delete(h.seenEnvelopes, seenID)
+			}
+		}
+		h.seenPruned = now
+	}
+
+	_, ok := h.seenEnvelopes[id]
+	h.seenEnvelopes[id] = now
+	return ok
+}
+
+func (h *Handler) hasPreKey(peerID string) bool {
+	h.sessionsGuard.Lock()
+	defer h.sessionsGuard.Unlock()
+
+	_, ok := h.preKeys[peerID]
+	return ok
+}
@@ -0,0 +1,79 @@
+package messages
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ngalayko/p2p/instance/messages/proto/chat"
+	"github.com/ngalayko/p2p/instance/store"
+)
+
+// maxQuoteLen is the maximum number of characters of a quoted message.
+const maxQuoteLen = 140
+
+// ReplyText sends a text message as a reply to the message, in the same chat.
+// Returns the sent message.
+func (h *Handler) ReplyText(ctx context.Context, text string, replyToID string) (*Message, error) {
+	r, err := h.store.Get(replyToID)
+	if err != nil {
+		return nil, fmt.Errorf("unknown message %s", replyToID)
+	}
+
+	if r.Deleted {
+		return nil, fmt.Errorf("message %s is deleted", replyToID)
+	}
+
+	msg, err := h.makeText(text)
+	if err != nil {
+		return nil, fmt.Errorf("error making message: %s", err)
+	}
+	msg.ReplyTo = &chat.ReplyTo{
+		MessageID: r.ID,
+		FromID:    r.FromID,
+		Quote:     quoteRecord(r),
+	}
+
+	if r.GroupID != "" {
+		return h.sendGroupText(ctx, msg, r.GroupID)
+	}
+	return h.sendText(ctx, msg, r.ChatID)
+}
+
+// checkReplyTo quotes the stored parent of the received reply, as the quote
+// and its author are chosen by the sender. Returns an error if the parent is
+// in another chat. Parents that are not stored keep the quote of the sender.
+func (h *Handler) checkReplyTo(r *store.Message) error {
+	if r.ReplyTo == nil {
+		return nil
+	}
+
+	parent, err := h.store.Get(r.ReplyTo.MessageID)
+	if err != nil {
+		return nil
+	}
+
+	if parent.ChatID != r.ChatID {
+		return fmt.Errorf("message %s is in another chat", parent.ID)
+	}
+
+	r.ReplyTo.FromID = parent.FromID
+	r.ReplyTo.Quote = quoteRecord(parent)
+	return nil
+}
+
+// quoteRecord returns a quote of the stored message.
+func quoteRecord(r *store.Message) string {
+	if r.File != nil {
+		return quote(r.File.Name)
+	}
+	return quote(r.Text)
+}
+
+// quote returns the beginning of the text that fits into a quote.
+func quote(text string) string {
+	runes := []rune(text)
+	if len(runes) <= maxQuoteLen {
+		return text
+	}
+	return string(runes[:maxQuoteLen-1]) + "…"
+}
@@ -0,0 +1,21 @@
+package messages
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_quote__should_keep_short_text(t *testing.T) {
+	assert.Equal(t, "short", quote("short"))
+}
+
+func Test_quote__should_cut_long_text(t *testing.T) {
+	q := quote(strings.Repeat("я", 2*maxQuoteLen))
+
+	assert.True(t, utf8.ValidString(q))
+	assert.Equal(t, maxQuoteLen, utf8.RuneCountInString(q))
+	assert.True(t, strings.HasSuffix(q, "…"))
+}
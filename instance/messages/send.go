@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/hex"
 	"fmt"
-	"math/rand"
 	"time"
 
 	"github.com/golang/protobuf/ptypes/timestamp"
@@ -16,33 +15,85 @@ import (
 	"github.com/ngalayko/p2p/instance/peers"
 )
 
-// Sent returns a channel with sent messages.
-func (h *Handler) Sent() <-chan *Message {
-	return h.sent
+// SendText sends a text message. If the peer is not reachable, the message
+// is queued until it can be delivered. Returns the sent message.
+func (h *Handler) SendText(ctx context.Context, text string, toID string) (*Message, error) {
+	msg, err := h.makeText(text)
+	if err != nil {
+		return nil, fmt.Errorf("error making message: %s", err)
+	}
+
+	return h.sendText(ctx, msg, toID)
 }
 
-// SendText sends a text message.
-func (h *Handler) SendText(ctx context.Context, text string, toID string) error {
-	msg, err := makeText(h.r, text)
+// sendText stores the text message and delivers it to the peer.
+func (h *Handler) sendText(ctx context.Context, msg *chat.Message, toID string) (*Message, error) {
+	to, err := h.getPeer(toID)
 	if err != nil {
-		return fmt.Errorf("error making message: %s", err)
+		return nil, fmt.Errorf("error getting peer: %s", err)
 	}
 
-	to, err := h.getPeer(toID)
+	sent := fromProto(h.self, to, msg)
+	sent.Status = StatusQueued
+
+	// stored before sending, so receipts can find the message.
+	if err := h.store.Save(sent.toRecord(h.self)); err != nil {
+		h.logger.Error("can't store sent message %s: %s", sent.ID, err)
+	}
+
+	if h.deliver(ctx, to.ID, msg) {
+		if r, _ := h.saveStatus(sent.ID, StatusSent); r != nil {
+			sent.Status = Status(r.Status)
+		}
+	}
+
+	h.updates.Publish(&MessageSent{Message: sent})
+
+	return sent, nil
+}
+
+// deliver sends a message to the peer, or queues it if the peer is not reachable.
+// Returns true if the message is sent right away.
+func (h *Handler) deliver(ctx context.Context, peerID string, msg *chat.Message) bool {
+	// messages to the same peer are delivered in order.
+	if h.queue.has(peerID) {
+		h.queue.push(peerID, msg)
+		return false
+	}
+
+	to, err := h.getPeer(peerID)
 	if err != nil {
-		return fmt.Errorf("error getting peer: %s", err)
+		h.logger.Error("can't send a message to %s, queueing: %s", peerID, err)
+		h.queue.push(peerID, msg)
+		return false
 	}
 
 	if err := h.sendMessage(ctx, to, msg); err != nil {
-		return fmt.Errorf("error sending a text: %s", err)
+		h.logger.Error("can't send a message to %s, queueing: %s", peerID, err)
+		h.queue.push(peerID, msg)
+		return false
 	}
 
-	h.sent <- fromProto(h.self, to, msg)
+	return true
+}
 
+// sendMessage sends a message to the peer directly, or through relays if
+// the peer is not reachable.
+func (h *Handler) sendMessage(ctx context.Context, to *peers.Peer, msg *chat.Message) error {
+	err := h.sendDirect(ctx, to, msg)
+	if err == nil || to.ID == h.self.ID {
+		return err
+	}
+
+	if relayErr := h.relay(ctx, to, msg); relayErr != nil {
+		return fmt.Errorf("%s, can't relay: %s", err, relayErr)
+	}
+
+	h.logger.Info("relayed a message to %s", to.ID)
 	return nil
 }
 
-func (h *Handler) sendMessage(ctx context.Context, to *peers.Peer, msg *chat.Message) error {
+func (h *Handler) sendDirect(ctx context.Context, to *peers.Peer, msg *chat.Message) error {
 	md := metadata.New(map[string]string{
 		chat.HeaderPeerID: h.self.ID,
 	})
@@ -53,7 +104,13 @@ func (h *Handler) sendMessage(ctx context.Context, to *peers.Peer, msg *chat.Mes
 		return err
 	}
 
-	sendErr := stream.Send(msg)
+	// sealed right before sending, the peer prekey is known after greeting.
+	sealed, err := h.seal(to, msg)
+	if err != nil {
+		return err
+	}
+
+	sendErr := stream.Send(sealed)
 	s, _ := status.FromError(sendErr)
 	switch s.Code() {
 	case codes.OK:
@@ -61,26 +118,48 @@ func (h *Handler) sendMessage(ctx context.Context, to *peers.Peer, msg *chat.Mes
 		return nil
 	case codes.Unavailable, codes.Canceled, codes.DeadlineExceeded:
 		h.logger.Error("client (%s) terminated connection", to.ID)
+		h.dropStream(to.ID, stream)
 		return sendErr
 	default:
-		h.logger.Error("failed to send to client (%s): %s", to.ID, err)
+		h.logger.Error("failed to send to client (%s): %s", to.ID, sendErr)
+		h.dropStream(to.ID, stream)
 		return sendErr
 	}
 }
 
-func makeText(r *rand.Rand, text string) (*chat.Message, error) {
-	idBytes := make([]byte, idLen)
-	_, err := r.Read(idBytes)
-	if err != nil {
-		return nil, fmt.Errorf("error reading random bytes: %s", err)
-	}
-	return &chat.Message{
-		ID: hex.EncodeToString(idBytes),
-		Timestamp: &timestamp.Timestamp{
-			Seconds: time.Now().Unix(),
-		},
+func (h *Handler) makeText(text string) (*chat.Message, error) {
+	return h.makeMessage(&chat.Message{
 		Payload: &chat.Message_Text{
 			Text: text,
 		},
-	}, nil
+	})
+}
+
+// makeMessage sets a random id and the current time to the message.
+func (h *Handler) makeMessage(msg *chat.Message) (*chat.Message, error) {
+	id, err := h.newID()
+	if err != nil {
+		return nil, err
+	}
+
+	msg.ID = id
+	msg.Timestamp = &timestamp.Timestamp{
+		Seconds: time.Now().Unix(),
+	}
+	return msg, nil
+}
+
+// newID returns a random id for a message or a group.
+func (h *Handler) newID() (string, error) {
+	idBytes := make([]byte, idLen)
+
+	h.rGuard.Lock()
+	_, err := h.r.Read(idBytes)
+	h.rGuard.Unlock()
+
+	if err != nil {
+		return "", fmt.Errorf("error reading random bytes: %s", err)
+	}
+
+	return hex.EncodeToString(idBytes), nil
 }
@@ -2,9 +2,15 @@ package server
 
 import (
 	"context"
+	"crypto/x509"
 	"fmt"
+	"net"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	grpc_peer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 
 	"github.com/ngalayko/p2p/instance/messages/proto/chat"
 	"github.com/ngalayko/p2p/instance/messages/proto/greeter"
@@ -16,24 +22,32 @@ import (
 type Server struct {
 	logger *logger.Logger
 
-	self      *peers.Peer
-	selfProto *greeter.Peer
+	self   *peers.Peer
+	preKey *greeter.PreKey
+
+	checkKey   func(string, *x509.Certificate) error
+	savePreKey func(string, []byte, *greeter.PreKey) error
 
 	newStreams chan *Stream
 }
 
-// New is server constructor.
+// New is server constructor. checkKey is called with a verified certificate
+// of every connected peer and can reject it. savePreKey is called with a prekey
+// of every greeted peer.
 func New(
 	log *logger.Logger,
 	self *peers.Peer,
+	preKey *greeter.PreKey,
+	checkKey func(peerID string, crt *x509.Certificate) error,
+	savePreKey func(peerID string, publicCrt []byte, preKey *greeter.PreKey) error,
 ) *Server {
-	selfProto := &greeter.Peer{}
-	_ = selfProto.UnmarshalPeer(self)
 	return &Server{
 		logger:     log.Prefix("grpc-server"),
 		newStreams: make(chan *Stream),
-		selfProto:  selfProto,
 		self:       self,
+		preKey:     preKey,
+		checkKey:   checkKey,
+		savePreKey: savePreKey,
 	}
 }
 
@@ -49,27 +63,99 @@ func (s *Server) Stream(srv chat.Chat_StreamServer) error {
 
 	peerID := md[chat.HeaderPeerID][0]
 
+	if err := s.verifyPeer(srv.Context(), peerID); err != nil {
+		s.logger.Error("rejected stream from %s: %s", peerID, err)
+		return status.Errorf(codes.Unauthenticated, "invalid certificate: %s", err)
+	}
+
 	s.logger.Info("%s connected", peerID)
 	defer s.logger.Info("%s disconnected", peerID)
 
-	s.newStreams <- newStream(srv, peerID)
+	stream := newStream(srv, peerID)
+	s.newStreams <- stream
 
-	<-srv.Context().Done()
+	select {
+	case <-srv.Context().Done():
+	case <-stream.closed:
+	}
 
 	return nil
 }
 
+// verifyPeer checks that the client certificate key matches the peer id.
+func (s *Server) verifyPeer(ctx context.Context, peerID string) error {
+	p, ok := grpc_peer.FromContext(ctx)
+	if !ok {
+		return fmt.Errorf("peer info missing")
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return fmt.Errorf("not a TLS connection")
+	}
+
+	if len(tlsInfo.State.PeerCertificates) == 0 {
+		return fmt.Errorf("client certificate missing")
+	}
+
+	crt := tlsInfo.State.PeerCertificates[0]
+	if err := peers.VerifyCertificate(peerID, crt); err != nil {
+		return err
+	}
+
+	return s.checkKey(peerID, crt)
+}
+
+func (s *Server) verifyPublicCrt(peerID string, publicCrt []byte) error {
+	crt, err := peers.ParsePublicCrt(publicCrt)
+	if err != nil {
+		return err
+	}
+
+	if err := peers.VerifyCertificate(peerID, crt); err != nil {
+		return err
+	}
+
+	return s.checkKey(peerID, crt)
+}
+
 // Greet implements greeter.Greeter.
 func (s *Server) Greet(ctx context.Context, peer *greeter.Peer) (*greeter.Peer, error) {
+	if err := s.verifyPublicCrt(peer.ID, peer.PublicKey); err != nil {
+		s.logger.Error("rejected greeting from %s: %s", peer.ID, err)
+		return nil, status.Errorf(codes.Unauthenticated, "invalid certificate: %s", err)
+	}
+
+	if err := s.savePreKey(peer.ID, peer.PublicKey, peer.PreKey); err != nil {
+		s.logger.Error("rejected greeting from %s: %s", peer.ID, err)
+		return nil, status.Errorf(codes.InvalidArgument, "invalid prekey: %s", err)
+	}
+
 	p, err := peer.MarshalPeer()
 	if err != nil {
 		return nil, fmt.Errorf("invalid peer: %s", err)
 	}
+
+	// the peer is reachable at the address it greets from, even if it
+	// doesn't know its own addresses.
+	if grpcPeer, ok := grpc_peer.FromContext(ctx); ok {
+		if addr, ok := grpcPeer.Addr.(*net.TCPAddr); ok {
+			p.Addrs.Add(addr.IP)
+		}
+	}
 	s.self.KnownPeers.Add(p)
 
 	s.logger.Info("greeted %s", peer.ID)
 
-	return s.selfProto, nil
+	// self is sent with the currently known peers, so the greeting peer can
+	// find them too.
+	selfProto := &greeter.Peer{}
+	if err := selfProto.UnmarshalPeer(s.self); err != nil {
+		return nil, fmt.Errorf("can't unmarshal self: %s", err)
+	}
+	selfProto.PreKey = s.preKey
+
+	return selfProto, nil
 }
 
 // Streams returns channel with new streams.
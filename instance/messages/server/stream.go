@@ -1,6 +1,8 @@
 package server
 
 import (
+	"sync"
+
 	"github.com/ngalayko/p2p/instance/messages/proto/chat"
 )
 
@@ -9,6 +11,10 @@ type Stream struct {
 	chat.Chat_StreamServer
 
 	PeerID string
+
+	closeOnce *sync.Once
+	closed    chan struct{}
+	sendGuard *sync.Mutex
 }
 
 func newStream(
@@ -18,7 +24,27 @@ func newStream(
 	s := &Stream{
 		Chat_StreamServer: srv,
 		PeerID:            peerID,
+		closeOnce:         &sync.Once{},
+		closed:            make(chan struct{}),
+		sendGuard:         &sync.Mutex{},
 	}
 
 	return s
 }
+
+// Send sends a message to the stream. gRPC streams can't be sent to
+// concurrently.
+func (s *Stream) Send(msg *chat.Message) error {
+	s.sendGuard.Lock()
+	defer s.sendGuard.Unlock()
+
+	return s.Chat_StreamServer.Send(msg)
+}
+
+// Close ends the stream.
+func (s *Stream) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	return nil
+}
@@ -0,0 +1,314 @@
+package messages
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/ngalayko/p2p/instance/messages/proto/chat"
+	"github.com/ngalayko/p2p/instance/messages/proto/greeter"
+	"github.com/ngalayko/p2p/instance/messages/ratchet"
+	"github.com/ngalayko/p2p/instance/peers"
+)
+
+// maxSessions is the maximum number of sessions kept per peer.
+const maxSessions = 4
+
+var preKeyPrefix = []byte("p2p prekey")
+
+// session is an encrypted session with a peer.
+type session struct {
+	*ratchet.Session
+
+	id []byte
+	// remotePreKey is the prekey of the peer the session was started with.
+	remotePreKey []byte
+	// init is sent with every message until the peer answers in the session.
+	init *chat.SessionInit
+}
+
+// peerSessions are sessions with a single peer. Messages are sent in the current
+// session, the rest are kept to decrypt messages the peer sent before switching.
+type peerSessions struct {
+	current *session
+	byID    map[string]*session
+	order   []string
+}
+
+func (ps *peerSessions) add(s *session) {
+	ps.byID[string(s.id)] = s
+	ps.order = append(ps.order, string(s.id))
+
+	// the oldest sessions are dropped, but never the current one.
+	for len(ps.order) > maxSessions {
+		for i, id := range ps.order {
+			if ps.byID[id] != ps.current {
+				delete(ps.byID, id)
+				ps.order = append(ps.order[:i], ps.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// newPreKey returns a prekey for the process lifetime, signed with the peer key
+// together with the time it is created at. Sessions can't be decrypted with
+// the peer key alone.
+func newPreKey(self *peers.Peer, createdAt time.Time) (*ratchet.KeyPair, *greeter.PreKey, error) {
+	preKey, err := ratchet.NewKeyPair()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signer, ok := self.Certificate.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("certificate key can't sign")
+	}
+
+	signed := &greeter.PreKey{
+		Key:       preKey.Public,
+		CreatedAt: createdAt.UnixNano(),
+	}
+
+	digest := sha256.Sum256(preKeySigned(signed))
+	signed.Signature, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't sign prekey: %s", err)
+	}
+
+	return preKey, signed, nil
+}
+
+// savePreKey verifies a prekey signature with the peer certificate and keeps it
+// to start sessions with the peer. A prekey created before the kept one is
+// rejected, so a replayed greeting can't bring back an old prekey.
+func (h *Handler) savePreKey(peerID string, publicCrt []byte, preKey *greeter.PreKey) error {
+	if preKey == nil {
+		return fmt.Errorf("prekey missing")
+	}
+
+	crt, err := peers.ParsePublicCrt(publicCrt)
+	if err != nil {
+		return err
+	}
+
+	if err := crt.CheckSignature(x509.SHA256WithRSA, preKeySigned(preKey), preKey.Signature); err != nil {
+		return fmt.Errorf("invalid prekey signature: %s", err)
+	}
+
+	h.sessionsGuard.Lock()
+	defer h.sessionsGuard.Unlock()
+
+	if known, ok := h.preKeys[peerID]; ok && !bytes.Equal(known.Key, preKey.Key) && known.CreatedAt >= preKey.CreatedAt {
+		return fmt.Errorf("prekey is older than the known one")
+	}
+
+	h.preKeys[peerID] = preKey
+	return nil
+}
+
+// preKeySigned returns the signed data of the prekey: the prefix, the
+// creation time and the key.
+func preKeySigned(preKey *greeter.PreKey) []byte {
+	createdAt := make([]byte, 8)
+	binary.BigEndian.PutUint64(createdAt, uint64(preKey.CreatedAt))
+
+	signed := append([]byte{}, preKeyPrefix...)
+	signed = append(signed, createdAt...)
+	return append(signed, preKey.Key...)
+}
+
+// seal encrypts the message for the peer. Messages to self are not encrypted.
+func (h *Handler) seal(to *peers.Peer, msg *chat.Message) (*chat.Message, error) {
+	if to.ID == h.self.ID {
+		return msg, nil
+	}
+
+	plaintext, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("can't marshal message: %s", err)
+	}
+
+	h.sessionsGuard.Lock()
+	defer h.sessionsGuard.Unlock()
+
+	s, err := h.sendingSession(to.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	header, ciphertext, err := s.Encrypt(plaintext, []byte(msg.ID))
+	if err != nil {
+		return nil, fmt.Errorf("can't encrypt message: %s", err)
+	}
+
+	return &chat.Message{
+		ID: msg.ID,
+		Payload: &chat.Message_Encrypted{
+			Encrypted: &chat.Encrypted{
+				SessionID:     s.id,
+				Init:          s.init,
+				RatchetKey:    header.RatchetKey,
+				PreviousCount: header.PreviousCount,
+				Count:         header.Count,
+				Ciphertext:    ciphertext,
+			},
+		},
+	}, nil
+}
+
+// sendingSession returns the current session with the peer, or starts a new one
+// if the peer has a new prekey. Must be called under sessionsGuard.
+func (h *Handler) sendingSession(peerID string) (*session, error) {
+	known, ok := h.preKeys[peerID]
+	if !ok {
+		return nil, fmt.Errorf("no prekey of %s", peerID)
+	}
+	remotePreKey := known.Key
+
+	ps := h.peerSessions(peerID)
+	if ps.current != nil && bytes.Equal(ps.current.remotePreKey, remotePreKey) {
+		return ps.current, nil
+	}
+
+	rs, ephemeral, err := ratchet.Initiate(h.preKey, remotePreKey)
+	if err != nil {
+		return nil, fmt.Errorf("can't start session: %s", err)
+	}
+
+	s := &session{
+		Session:      rs,
+		id:           ephemeral,
+		remotePreKey: remotePreKey,
+		init: &chat.SessionInit{
+			PreKey:       h.preKey.Public,
+			RemotePreKey: remotePreKey,
+		},
+	}
+	ps.add(s)
+	ps.current = s
+
+	h.logger.Info("started a session with %s", peerID)
+
+	return s, nil
+}
+
+// open decrypts a message from the peer. Only messages from self can be
+// not encrypted.
+func (h *Handler) open(from *peers.Peer, msg *chat.Message) (*chat.Message, error) {
+	payload, ok := msg.Payload.(*chat.Message_Encrypted)
+	if from.ID == h.self.ID && !ok {
+		return msg, nil
+	}
+	if !ok {
+		return nil, fmt.Errorf("message is not encrypted")
+	}
+	encrypted := payload.Encrypted
+
+	plaintext, err := h.decrypt(from.ID, msg.ID, encrypted)
+	if err != nil {
+		return nil, err
+	}
+
+	opened := &chat.Message{}
+	if err := proto.Unmarshal(plaintext, opened); err != nil {
+		return nil, fmt.Errorf("can't unmarshal message: %s", err)
+	}
+
+	if opened.ID != msg.ID {
+		return nil, fmt.Errorf("message id mismatch")
+	}
+	if _, ok := opened.Payload.(*chat.Message_Encrypted); ok {
+		return nil, fmt.Errorf("nested encrypted message")
+	}
+
+	return opened, nil
+}
+
+func (h *Handler) decrypt(peerID string, messageID string, encrypted *chat.Encrypted) ([]byte, error) {
+	h.sessionsGuard.Lock()
+	defer h.sessionsGuard.Unlock()
+
+	ps := h.peerSessions(peerID)
+
+	s, found := ps.byID[string(encrypted.SessionID)]
+	if !found {
+		var err error
+		s, err = h.respondSession(peerID, encrypted)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	plaintext, err := s.Decrypt(&ratchet.Header{
+		RatchetKey:    encrypted.RatchetKey,
+		PreviousCount: encrypted.PreviousCount,
+		Count:         encrypted.Count,
+	}, encrypted.Ciphertext, []byte(messageID))
+	if err != nil {
+		return nil, err
+	}
+
+	// the peer answered, no need to send init anymore.
+	s.init = nil
+
+	if !found {
+		ps.add(s)
+		// both peers could start a session at the same time, the one
+		// started by the peer with the lower id wins.
+		if ps.current == nil || ps.current.init == nil || peerID < h.self.ID {
+			ps.current = s
+		}
+		h.logger.Info("%s started a session", peerID)
+	}
+
+	return plaintext, nil
+}
+
+// respondSession accepts a new session started by the peer. Must be called under sessionsGuard.
+func (h *Handler) respondSession(peerID string, encrypted *chat.Encrypted) (*session, error) {
+	init := encrypted.Init
+	if init == nil {
+		return nil, fmt.Errorf("unknown session")
+	}
+
+	if !bytes.Equal(init.RemotePreKey, h.preKey.Public) {
+		return nil, fmt.Errorf("session is started with an old prekey")
+	}
+
+	known, ok := h.preKeys[peerID]
+	if !ok || !bytes.Equal(init.PreKey, known.Key) {
+		return nil, fmt.Errorf("session is started with an unknown prekey of %s", peerID)
+	}
+	remotePreKey := known.Key
+
+	rs, err := ratchet.Respond(h.preKey, remotePreKey, encrypted.SessionID)
+	if err != nil {
+		return nil, fmt.Errorf("can't accept session: %s", err)
+	}
+
+	return &session{
+		Session:      rs,
+		id:           encrypted.SessionID,
+		remotePreKey: remotePreKey,
+	}, nil
+}
+
+// peerSessions must be called under sessionsGuard.
+func (h *Handler) peerSessions(peerID string) *peerSessions {
+	ps, ok := h.sessions[peerID]
+	if !ok {
+		ps = &peerSessions{
+			byID: map[string]*session{},
+		}
+		h.sessions[peerID] = ps
+	}
+	return ps
+}
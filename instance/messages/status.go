@@ -0,0 +1,53 @@
+package messages
+
+import (
+	"github.com/ngalayko/p2p/instance/store"
+)
+
+// statusRank orders statuses, so a message status never goes back.
+var statusRank = map[Status]int{
+	StatusQueued:    1,
+	StatusSent:      2,
+	StatusFailed:    2,
+	StatusDelivered: 3,
+	StatusRead:      4,
+}
+
+// saveStatus stores a new status of the message, unless the message already has
+// the same or a later one. Returns the stored message and true if it was updated.
+func (h *Handler) saveStatus(messageID string, status Status) (*store.Message, bool) {
+	h.statusGuard.Lock()
+	defer h.statusGuard.Unlock()
+
+	r, err := h.store.Get(messageID)
+	if err != nil {
+		// not every message is stored, e.g. group updates.
+		h.logger.Debug("can't get message %s: %s", messageID, err)
+		return nil, false
+	}
+
+	if statusRank[Status(r.Status)] >= statusRank[status] {
+		return r, false
+	}
+
+	r.Status = string(status)
+	if err := h.store.Save(r); err != nil {
+		h.logger.Error("can't store message %s status: %s", messageID, err)
+	}
+
+	return r, true
+}
+
+// setStatus updates a delivery status of the message and reports it.
+func (h *Handler) setStatus(messageID string, status Status) {
+	r, updated := h.saveStatus(messageID, status)
+	if !updated {
+		return
+	}
+
+	h.updates.Publish(&StatusUpdate{
+		MessageID: messageID,
+		PeerID:    r.ChatID,
+		Status:    status,
+	})
+}
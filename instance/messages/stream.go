@@ -1,9 +1,214 @@
 package messages
 
-import "github.com/ngalayko/p2p/instance/messages/proto/chat"
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/ngalayko/p2p/instance/messages/proto/chat"
+	"github.com/ngalayko/p2p/instance/peers"
+)
+
+const (
+	// keepaliveInterval is how often idle connections are pinged to detect
+	// peers that are gone without closing them.
+	keepaliveInterval = 30 * time.Second
+	keepaliveTimeout  = 10 * time.Second
+
+	minReconnectInterval = time.Second
+	maxReconnectInterval = time.Minute
+)
 
 // stream used to communicate with another peer.
 type stream interface {
 	Send(*chat.Message) error
 	Recv() (*chat.Message, error)
+	Close() error
+}
+
+// reconnect is a schedule of attempts to open a stream to a disconnected peer.
+type reconnect struct {
+	attempts    int
+	nextAttempt time.Time
+}
+
+func (r *reconnect) backoff() {
+	interval := minReconnectInterval << uint(r.attempts)
+	if interval > maxReconnectInterval || interval <= 0 {
+		interval = maxReconnectInterval
+	}
+	interval = interval/2 + time.Duration(rand.Int63n(int64(interval/2)))
+
+	r.attempts++
+	r.nextAttempt = time.Now().Add(interval)
+}
+
+// Connected returns true if there is a live stream with the peer.
+func (h *Handler) Connected(peerID string) bool {
+	h.streamsGuard.RLock()
+	defer h.streamsGuard.RUnlock()
+
+	_, ok := h.streams[peerID]
+	return ok
+}
+
+// addStream keeps the stream as the one to send messages to the peer, and
+// listens to it until it is closed.
+func (h *Handler) addStream(peerID string, s stream) {
+	h.streamsGuard.Lock()
+	if h.stopped {
+		h.streamsGuard.Unlock()
+		_ = s.Close()
+		return
+	}
+	_, connected := h.streams[peerID]
+	h.streams[peerID] = s
+	delete(h.reconnects, peerID)
+	if !connected {
+		h.updates.Publish(&ConnectionChanged{
+			PeerID:    peerID,
+			Connected: true,
+		})
+	}
+	h.streamsGuard.Unlock()
+
+	go h.listenStream(s, peerID)
+
+	// peers assume others are active.
+	if !connected && peerID != h.self.ID && h.Presence(h.self.ID) != PresenceActive {
+		go func() {
+			if err := h.sendPresence(context.Background(), peerID); err != nil {
+				h.logger.Debug("can't send presence to %s: %s", peerID, err)
+			}
+		}()
+	}
+}
+
+// dropStream closes the stream to the peer, so the next message opens a new one.
+func (h *Handler) dropStream(peerID string, s stream) {
+	h.streamsGuard.Lock()
+	if h.streams[peerID] == s {
+		delete(h.streams, peerID)
+		if peerID != h.self.ID {
+			r := &reconnect{}
+			r.backoff()
+			h.reconnects[peerID] = r
+		}
+		h.updates.Publish(&ConnectionChanged{
+			PeerID:    peerID,
+			Connected: false,
+		})
+	}
+	h.streamsGuard.Unlock()
+
+	if err := s.Close(); err != nil {
+		h.logger.Debug("can't close a stream to %s: %s", peerID, err)
+	}
+}
+
+// closeStreams closes all streams without reconnecting, new streams are
+// closed right away.
+func (h *Handler) closeStreams() {
+	h.streamsGuard.Lock()
+	h.stopped = true
+	streams := h.streams
+	h.streams = map[string]stream{}
+	h.reconnects = map[string]*reconnect{}
+	for peerID := range streams {
+		h.updates.Publish(&ConnectionChanged{
+			PeerID:    peerID,
+			Connected: false,
+		})
+	}
+	h.streamsGuard.Unlock()
+
+	for _, s := range streams {
+		_ = s.Close()
+	}
+}
+
+// watchStreams reconnects to disconnected peers with a backoff, and right away
+// when a peer is discovered again.
+func (h *Handler) watchStreams(ctx context.Context) {
+	ticker := time.NewTicker(minReconnectInterval)
+	defer ticker.Stop()
+
+	peerEvents := h.self.KnownPeers.Subscribe(peerEventsBuffer)
+	defer func() {
+		peerEvents.Close()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-peerEvents.Events():
+			if !ok {
+				peerEvents = h.self.KnownPeers.Subscribe(peerEventsBuffer)
+				continue
+			}
+			switch e := e.(type) {
+			case *peers.PeerAdded:
+				h.rediscovered(e.Peer.ID)
+			case *peers.PeerChanged:
+				if e.Status == peers.StatusOnline {
+					h.rediscovered(e.Peer.ID)
+				}
+			case *peers.PeerRemoved:
+				h.streamsGuard.Lock()
+				delete(h.reconnects, e.Peer.ID)
+				h.streamsGuard.Unlock()
+			}
+			h.reconnect(ctx)
+		case <-ticker.C:
+			h.reconnect(ctx)
+		}
+	}
+}
+
+// rediscovered schedules a reconnect to the peer right away, if it is disconnected.
+func (h *Handler) rediscovered(peerID string) {
+	h.streamsGuard.Lock()
+	defer h.streamsGuard.Unlock()
+
+	if r, ok := h.reconnects[peerID]; ok {
+		r.attempts = 0
+		r.nextAttempt = time.Now()
+	}
+}
+
+// reconnect opens streams to disconnected peers that are due.
+func (h *Handler) reconnect(ctx context.Context) {
+	now := time.Now()
+
+	h.streamsGuard.RLock()
+	due := make([]string, 0, len(h.reconnects))
+	for peerID, r := range h.reconnects {
+		if !r.nextAttempt.After(now) {
+			due = append(due, peerID)
+		}
+	}
+	h.streamsGuard.RUnlock()
+
+	for _, peerID := range due {
+		peer, err := h.getPeer(peerID)
+		if err != nil {
+			h.streamsGuard.Lock()
+			delete(h.reconnects, peerID)
+			h.streamsGuard.Unlock()
+			continue
+		}
+
+		h.logger.Debug("reconnecting to %s", peerID)
+
+		if _, err := h.getStream(ctx, peer); err != nil {
+			h.logger.Debug("can't reconnect to %s: %s", peerID, err)
+
+			h.streamsGuard.Lock()
+			if r, ok := h.reconnects[peerID]; ok {
+				r.backoff()
+			}
+			h.streamsGuard.Unlock()
+		}
+	}
 }
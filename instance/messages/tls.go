@@ -0,0 +1,72 @@
+package messages
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"google.golang.org/grpc/credentials"
+
+	"github.com/ngalayko/p2p/instance/peers"
+)
+
+// serverCredentials require a client certificate. Certificates are
+// self-signed, so the server only checks that it is valid. Its key is
+// matched with the peer id by the server when a stream is opened.
+func serverCredentials(self *peers.Peer) credentials.TransportCredentials {
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{*self.Certificate},
+		ClientAuth:   tls.RequireAnyClientCert,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			crt, err := leafCertificate(rawCerts)
+			if err != nil {
+				return err
+			}
+
+			fingerprint, err := peers.Fingerprint(crt.PublicKey)
+			if err != nil {
+				return err
+			}
+
+			return peers.VerifyCertificate(fingerprint, crt)
+		},
+	})
+}
+
+// clientCredentials present self certificate and accept only a server
+// with a key that matches the peer id and passes checkKey.
+func clientCredentials(
+	self *peers.Peer,
+	peerID string,
+	checkKey func(string, *x509.Certificate) error,
+) credentials.TransportCredentials {
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{*self.Certificate},
+		// there is no CA, the server certificate is checked against the peer id below.
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			crt, err := leafCertificate(rawCerts)
+			if err != nil {
+				return err
+			}
+
+			if err := peers.VerifyCertificate(peerID, crt); err != nil {
+				return err
+			}
+
+			return checkKey(peerID, crt)
+		},
+	})
+}
+
+func leafCertificate(rawCerts [][]byte) (*x509.Certificate, error) {
+	if len(rawCerts) == 0 {
+		return nil, fmt.Errorf("no certificate")
+	}
+
+	crt, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return nil, fmt.Errorf("can't parse certificate: %s", err)
+	}
+	return crt, nil
+}
@@ -0,0 +1,296 @@
+package peers
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"github.com/ngalayko/p2p/instance/peers/proto/beacon"
+)
+
+const (
+	// beaconVersion is a version of beacons this peer sends.
+	beaconVersion = 1
+
+	nonceLen = 16
+
+	// maxAnnouncementAge is how far an announcement time can be from the
+	// local time, to tolerate clock skew between hosts.
+	maxAnnouncementAge = time.Minute
+)
+
+// Capabilities are features a peer announces to others.
+const (
+	CapabilityFiles  = "files"
+	CapabilityGroups = "groups"
+	CapabilityRelay  = "relay"
+)
+
+// capabilities are features of this version.
+var capabilities = []string{
+	CapabilityFiles,
+	CapabilityGroups,
+	CapabilityRelay,
+}
+
+// HasCapability returns true if the peer announced the capability.
+func (p *Peer) HasCapability(capability string) bool {
+	for _, c := range p.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Announce returns a signed beacon of the peer for discoveries. Every beacon
+// has a new nonce, so it must be created for every broadcast.
+func (p *Peer) Announce() ([]byte, error) {
+	return p.announce(time.Now())
+}
+
+func (p *Peer) announce(at time.Time) ([]byte, error) {
+	if p.key == nil || p.Certificate == nil {
+		return nil, fmt.Errorf("private key is unknown")
+	}
+
+	signer, ok := p.key.Private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key can't sign")
+	}
+
+	fingerprint, err := KeyFingerprint(p.key.Public)
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprintBytes, err := hex.DecodeString(fingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode fingerprint: %s", err)
+	}
+
+	b := &beacon.Beacon{
+		Version:        beaconVersion,
+		ID:             p.ID,
+		Name:           p.Name,
+		Port:           uint32(p.Port),
+		InsecurePort:   uint32(p.InsecurePort),
+		UIPort:         uint32(p.UIPort),
+		KeyFingerprint: fingerprintBytes,
+		Capabilities:   p.Capabilities,
+		Timestamp:      at.UnixNano(),
+		Nonce:          make([]byte, nonceLen),
+	}
+	if _, err := rand.Read(b.Nonce); err != nil {
+		return nil, fmt.Errorf("can't generate nonce: %s", err)
+	}
+
+	beaconBytes, err := proto.Marshal(b)
+	if err != nil {
+		return nil, fmt.Errorf("can't marshal beacon: %s", err)
+	}
+
+	digest := sha256.Sum256(beaconBytes)
+	signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("can't sign beacon: %s", err)
+	}
+
+	return proto.Marshal(&beacon.Signed{
+		Beacon:      beaconBytes,
+		Certificate: p.Certificate.Certificate[0],
+		Signature:   signature,
+	})
+}
+
+// Announcements verifies announcements of other peers, and drops replays of
+// the ones it has already seen.
+type Announcements struct {
+	guard     *sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+// NewAnnouncements is an announcements constructor.
+func NewAnnouncements() *Announcements {
+	return &Announcements{
+		guard:     &sync.Mutex{},
+		seen:      map[string]time.Time{},
+		lastPrune: time.Now(),
+	}
+}
+
+// Verify returns the announced peer if the announcement is signed by the key
+// of the peer, is recent and is not a replay. Both beacons and json
+// announcements of older versions are accepted.
+func (aa *Announcements) Verify(data []byte) (*Peer, error) {
+	if bytes.HasPrefix(data, []byte("{")) {
+		return aa.verifyJSON(data)
+	}
+	return aa.verifyBeacon(data)
+}
+
+func (aa *Announcements) verifyBeacon(data []byte) (*Peer, error) {
+	signed := &beacon.Signed{}
+	if err := proto.Unmarshal(data, signed); err != nil {
+		return nil, fmt.Errorf("can't unmarshal beacon: %s", err)
+	}
+
+	b := &beacon.Beacon{}
+	if err := proto.Unmarshal(signed.Beacon, b); err != nil {
+		return nil, fmt.Errorf("can't unmarshal beacon: %s", err)
+	}
+
+	if b.Version == 0 {
+		return nil, fmt.Errorf("beacon version is missing")
+	}
+
+	now := time.Now()
+	if err := checkFresh(b.Timestamp, b.Nonce, now); err != nil {
+		return nil, err
+	}
+
+	crt, err := x509.ParseCertificate(signed.Certificate)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse certificate: %s", err)
+	}
+
+	if err := VerifyCertificate(b.ID, crt); err != nil {
+		return nil, err
+	}
+
+	fingerprint, err := KeyFingerprint(crt.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if hex.EncodeToString(b.KeyFingerprint) != fingerprint {
+		return nil, fmt.Errorf("key fingerprint does not match the certificate")
+	}
+
+	if err := crt.CheckSignature(x509.SHA256WithRSA, signed.Beacon, signed.Signature); err != nil {
+		return nil, fmt.Errorf("invalid signature: %s", err)
+	}
+
+	if !aa.remember(hex.EncodeToString(b.Nonce), now) {
+		return nil, fmt.Errorf("beacon is replayed")
+	}
+
+	peer := NewBlank()
+	peer.ID = b.ID
+	peer.Name = b.Name
+	peer.Port = int(b.Port)
+	peer.InsecurePort = int(b.InsecurePort)
+	peer.UIPort = int(b.UIPort)
+	peer.Capabilities = b.Capabilities
+	peer.PublicCrt = pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: crt.Raw,
+	})
+	return peer, nil
+}
+
+// announcement is a json peer info signed with the peer key, peers sent it
+// before beacons.
+type announcement struct {
+	Peer      json.RawMessage `json:"peer"`
+	PublicCrt []byte          `json:"public_crt"`
+	Timestamp int64           `json:"timestamp"`
+	Nonce     []byte          `json:"nonce"`
+	Signature []byte          `json:"signature"`
+}
+
+// signed returns bytes covered by the signature.
+func (a *announcement) signed() []byte {
+	data := make([]byte, 8, 8+len(a.Nonce)+len(a.Peer))
+	binary.BigEndian.PutUint64(data, uint64(a.Timestamp))
+	data = append(data, a.Nonce...)
+	return append(data, a.Peer...)
+}
+
+func (aa *Announcements) verifyJSON(data []byte) (*Peer, error) {
+	a := &announcement{}
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, fmt.Errorf("can't unmarshal announcement: %s", err)
+	}
+
+	now := time.Now()
+	if err := checkFresh(a.Timestamp, a.Nonce, now); err != nil {
+		return nil, err
+	}
+
+	peer := &Peer{}
+	if err := peer.Unmarshal(a.Peer); err != nil {
+		return nil, fmt.Errorf("can't unmarshal peer: %s", err)
+	}
+
+	crt, err := ParsePublicCrt(a.PublicCrt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := VerifyCertificate(peer.ID, crt); err != nil {
+		return nil, err
+	}
+
+	if err := crt.CheckSignature(x509.SHA256WithRSA, a.signed(), a.Signature); err != nil {
+		return nil, fmt.Errorf("invalid signature: %s", err)
+	}
+
+	if !aa.remember(hex.EncodeToString(a.Nonce), now) {
+		return nil, fmt.Errorf("announcement is replayed")
+	}
+
+	peer.PublicCrt = a.PublicCrt
+	return peer, nil
+}
+
+// checkFresh returns an error if the announcement can't be told apart from
+// a replay.
+func checkFresh(timestamp int64, nonce []byte, now time.Time) error {
+	if len(nonce) != nonceLen {
+		return fmt.Errorf("invalid nonce")
+	}
+
+	at := time.Unix(0, timestamp)
+	if at.Before(now.Add(-maxAnnouncementAge)) || at.After(now.Add(maxAnnouncementAge)) {
+		return fmt.Errorf("announcement time %s is too far from now", at.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// remember returns false if the nonce is already seen. Nonces are remembered
+// only for valid announcements, so forged ones can't fill the memory.
+func (aa *Announcements) remember(nonce string, now time.Time) bool {
+	aa.guard.Lock()
+	defer aa.guard.Unlock()
+
+	// an announcement is too old after twice its age, so its nonce can be
+	// forgotten.
+	if now.Sub(aa.lastPrune) > maxAnnouncementAge {
+		for n, seenAt := range aa.seen {
+			if now.Sub(seenAt) > 2*maxAnnouncementAge {
+				delete(aa.seen, n)
+			}
+		}
+		aa.lastPrune = now
+	}
+
+	if _, seen := aa.seen[nonce]; seen {
+		return false
+	}
+
+	aa.seen[nonce] = now
+	return true
+}
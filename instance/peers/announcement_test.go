@@ -0,0 +1,165 @@
+package peers
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ngalayko/p2p/instance/peers/proto/beacon"
+)
+
+func Test_Announcements__should_verify_beacon(t *testing.T) {
+	p := newTestPeer(t)
+
+	data, err := p.Announce()
+	assert.NoError(t, err)
+
+	announced, err := NewAnnouncements().Verify(data)
+	assert.NoError(t, err)
+
+	assert.Equal(t, p.ID, announced.ID)
+	assert.Equal(t, p.Name, announced.Name)
+	assert.Equal(t, p.Port, announced.Port)
+	assert.Equal(t, p.InsecurePort, announced.InsecurePort)
+	assert.Equal(t, p.UIPort, announced.UIPort)
+	assert.Equal(t, p.PublicCrt, announced.PublicCrt)
+	assert.True(t, announced.HasCapability(CapabilityRelay))
+}
+
+func Test_Announcements__should_not_grow_with_known_peers(t *testing.T) {
+	p := newTestPeer(t)
+
+	data, err := p.Announce()
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		p.KnownPeers.Add(newTestPeer(t))
+	}
+
+	dataWithPeers, err := p.Announce()
+	assert.NoError(t, err)
+
+	assert.Equal(t, len(data), len(dataWithPeers))
+}
+
+func Test_Announcements__should_verify_json_announcement(t *testing.T) {
+	p := newTestPeer(t)
+
+	announced, err := NewAnnouncements().Verify(announceJSON(t, p))
+	assert.NoError(t, err)
+
+	assert.Equal(t, p.ID, announced.ID)
+	assert.Equal(t, p.Name, announced.Name)
+	assert.Equal(t, p.Port, announced.Port)
+	assert.Equal(t, p.PublicCrt, announced.PublicCrt)
+}
+
+func Test_Announcements__should_drop_replays(t *testing.T) {
+	p := newTestPeer(t)
+	aa := NewAnnouncements()
+
+	data, err := p.Announce()
+	assert.NoError(t, err)
+
+	_, err = aa.Verify(data)
+	assert.NoError(t, err)
+
+	_, err = aa.Verify(data)
+	assert.Error(t, err)
+}
+
+func Test_Announcements__should_drop_old_beacons(t *testing.T) {
+	p := newTestPeer(t)
+
+	data, err := p.announce(time.Now().Add(-2 * maxAnnouncementAge))
+	assert.NoError(t, err)
+
+	_, err = NewAnnouncements().Verify(data)
+	assert.Error(t, err)
+}
+
+func Test_Announcements__should_drop_changed_beacons(t *testing.T) {
+	p := newTestPeer(t)
+
+	data, err := p.Announce()
+	assert.NoError(t, err)
+
+	signed := &beacon.Signed{}
+	assert.NoError(t, proto.Unmarshal(data, signed))
+
+	b := &beacon.Beacon{}
+	assert.NoError(t, proto.Unmarshal(signed.Beacon, b))
+
+	b.Name = "someone else"
+
+	signed.Beacon, err = proto.Marshal(b)
+	assert.NoError(t, err)
+
+	data, err = proto.Marshal(signed)
+	assert.NoError(t, err)
+
+	_, err = NewAnnouncements().Verify(data)
+	assert.Error(t, err)
+}
+
+func Test_Announcements__should_drop_beacons_of_other_peers(t *testing.T) {
+	p := newTestPeer(t)
+	other := newTestPeer(t)
+
+	// signed by the other peer, but claims the id.
+	other.ID = p.ID
+
+	data, err := other.Announce()
+	assert.NoError(t, err)
+
+	_, err = NewAnnouncements().Verify(data)
+	assert.Error(t, err)
+
+	// signed by the other peer with the certificate of the peer.
+	other.Certificate = p.Certificate
+
+	data, err = other.Announce()
+	assert.NoError(t, err)
+
+	_, err = NewAnnouncements().Verify(data)
+	assert.Error(t, err)
+}
+
+//
+// helpers
+//
+
+// announceJSON returns an announcement the way peers sent it before beacons.
+func announceJSON(t *testing.T, p *Peer) []byte {
+	peerBytes, err := json.Marshal(&Peer{
+		ID:           p.ID,
+		Name:         p.Name,
+		Port:         p.Port,
+		InsecurePort: p.InsecurePort,
+		UIPort:       p.UIPort,
+	})
+	assert.NoError(t, err)
+
+	a := &announcement{
+		Peer:      peerBytes,
+		PublicCrt: p.PublicCrt,
+		Timestamp: time.Now().UnixNano(),
+		Nonce:     make([]byte, nonceLen),
+	}
+	_, err = rand.Read(a.Nonce)
+	assert.NoError(t, err)
+
+	digest := sha256.Sum256(a.signed())
+	a.Signature, err = p.key.Private.(crypto.Signer).Sign(rand.Reader, digest[:], crypto.SHA256)
+	assert.NoError(t, err)
+
+	data, err := json.Marshal(a)
+	assert.NoError(t, err)
+	return data
+}
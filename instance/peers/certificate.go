@@ -1,45 +1,60 @@
 package peers
 
 import (
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	x509pkix "crypto/x509/pkix"
 	"fmt"
-	"math/rand"
+	"math/big"
 	"time"
 
 	"github.com/square/certstrap/pkix"
 )
 
-func generateCertificate(peer *Peer, r *rand.Rand, keySize int, expires time.Time) ([]byte, *tls.Certificate, error) {
-	key, err := pkix.CreateRSAKey(keySize)
+func generateCertificate(peer *Peer, key *pkix.Key, expires time.Time) (*pkix.Certificate, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
 	if err != nil {
-		return nil, nil, err
-	}
-
-	crt, err := pkix.CreateCertificateAuthority(
-		key,
-		"",
-		expires,
-		"",
-		"",
-		"",
-		"",
-		peer.ID,
-	)
+		return nil, fmt.Errorf("can't generate serial number: %s", err)
+	}
+
+	// peer id is used as a server name, so it must be in SANs.
+	template := &x509.Certificate{
+		SerialNumber: serialNumber,
+		Subject: x509pkix.Name{
+			CommonName: peer.ID,
+		},
+		DNSNames:              []string{peer.ID},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              expires,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public, key.Private)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error generating .crt: %s", err)
+		return nil, fmt.Errorf("error generating .crt: %s", err)
 	}
 
+	return pkix.NewCertificateFromDER(der), nil
+}
+
+func setCertificate(peer *Peer, key *pkix.Key, crt *pkix.Certificate) error {
 	tlsCert, err := makeTLSCertificate(key, crt)
 	if err != nil {
-		return nil, nil, fmt.Errorf("can't make TLS certificate: %s", err)
+		return fmt.Errorf("can't make TLS certificate: %s", err)
 	}
 
 	publicCrt, err := crt.Export()
 	if err != nil {
-		return nil, nil, fmt.Errorf("can't export public .crt: %s", err)
+		return fmt.Errorf("can't export public .crt: %s", err)
 	}
 
-	return publicCrt, tlsCert, nil
+	peer.PublicCrt = publicCrt
+	peer.Certificate = tlsCert
+	return nil
 }
 
 func makeTLSCertificate(key *pkix.Key, crt *pkix.Certificate) (*tls.Certificate, error) {
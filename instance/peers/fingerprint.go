@@ -0,0 +1,80 @@
+package peers
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"time"
+)
+
+// Fingerprint returns a short fingerprint of the public key. Peer ID is a fingerprint
+// of its key, so a peer can't claim an ID without the private key.
+func Fingerprint(pub crypto.PublicKey) (string, error) {
+	fingerprint, err := KeyFingerprint(pub)
+	if err != nil {
+		return "", err
+	}
+	return fingerprint[:2*idLen], nil
+}
+
+// KeyFingerprint returns a full SHA-256 fingerprint of the public key.
+func KeyFingerprint(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("can't marshal public key: %s", err)
+	}
+
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// VerifyCertificate returns an error if the certificate is not valid now,
+// or if its key does not match the peer id.
+func VerifyCertificate(peerID string, crt *x509.Certificate) error {
+	now := time.Now()
+	if now.Before(crt.NotBefore) || now.After(crt.NotAfter) {
+		return fmt.Errorf("certificate is not valid at %s", now.Format(time.RFC3339))
+	}
+
+	if err := crt.CheckSignatureFrom(crt); err != nil {
+		return fmt.Errorf("certificate is not self-signed: %s", err)
+	}
+
+	fingerprint, err := Fingerprint(crt.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	if fingerprint != peerID {
+		return fmt.Errorf("certificate key %s does not match peer id %s", fingerprint, peerID)
+	}
+
+	return nil
+}
+
+// VerifyPublicCrt parses a PEM encoded certificate and verifies it with VerifyCertificate.
+func VerifyPublicCrt(peerID string, publicCrt []byte) error {
+	crt, err := ParsePublicCrt(publicCrt)
+	if err != nil {
+		return err
+	}
+
+	return VerifyCertificate(peerID, crt)
+}
+
+// ParsePublicCrt parses a PEM encoded certificate.
+func ParsePublicCrt(publicCrt []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(publicCrt)
+	if block == nil {
+		return nil, fmt.Errorf("can't decode certificate")
+	}
+
+	crt, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse certificate: %s", err)
+	}
+	return crt, nil
+}
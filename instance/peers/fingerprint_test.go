@@ -0,0 +1,26 @@
+package peers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_VerifyPublicCrt__should_accept_own_certificate(t *testing.T) {
+	p := newTestPeer(t)
+
+	assert.NoError(t, VerifyPublicCrt(p.ID, p.PublicCrt))
+}
+
+func Test_VerifyPublicCrt__should_reject_another_id(t *testing.T) {
+	p := newTestPeer(t)
+	another := newTestPeer(t)
+
+	assert.Error(t, VerifyPublicCrt(another.ID, p.PublicCrt))
+}
+
+func Test_VerifyPublicCrt__should_reject_invalid_certificate(t *testing.T) {
+	p := newTestPeer(t)
+
+	assert.Error(t, VerifyPublicCrt(p.ID, []byte("not a certificate")))
+}
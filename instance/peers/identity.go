@@ -0,0 +1,157 @@
+package peers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/square/certstrap/pkix"
+)
+
+const (
+	identityFile    = "identity.json"
+	keyFile         = "peer.key"
+	certificateFile = "peer.crt"
+)
+
+// identity is a part of the peer that stays the same between restarts.
+type identity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// Load reads peer identity from the dataDir. If there is no identity yet,
+// a new peer is created and stored there.
+func Load(
+	r *rand.Rand,
+	dataDir string,
+	port int,
+	insecurePort int,
+	uiPort int,
+	keySize int,
+) (*Peer, error) {
+	if _, err := os.Stat(filepath.Join(dataDir, identityFile)); os.IsNotExist(err) {
+		p, err := New(r, port, insecurePort, uiPort, keySize)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.Save(dataDir); err != nil {
+			return nil, fmt.Errorf("can't store identity: %s", err)
+		}
+
+		return p, nil
+	}
+
+	identityBytes, err := ioutil.ReadFile(filepath.Join(dataDir, identityFile))
+	if err != nil {
+		return nil, fmt.Errorf("can't read identity: %s", err)
+	}
+
+	id := &identity{}
+	if err := json.Unmarshal(identityBytes, id); err != nil {
+		return nil, fmt.Errorf("can't unmarshal identity: %s", err)
+	}
+
+	keyBytes, err := ioutil.ReadFile(filepath.Join(dataDir, keyFile))
+	if err != nil {
+		return nil, fmt.Errorf("can't read private key: %s", err)
+	}
+
+	key, err := pkix.NewKeyFromPrivateKeyPEM(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse private key: %s", err)
+	}
+
+	crtBytes, err := ioutil.ReadFile(filepath.Join(dataDir, certificateFile))
+	if err != nil {
+		return nil, fmt.Errorf("can't read certificate: %s", err)
+	}
+
+	crt, err := pkix.NewCertificateFromPEM(crtBytes)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse certificate: %s", err)
+	}
+
+	p := NewBlank()
+	p.ID = id.ID
+	p.Name = id.Name
+	p.Port = port
+	p.InsecurePort = insecurePort
+	p.UIPort = uiPort
+	p.Capabilities = capabilities
+	p.key = key
+
+	fingerprint, err := Fingerprint(key.Public)
+	if err != nil {
+		return nil, err
+	}
+
+	// identities stored before ids were derived from keys get a new id
+	// and a certificate for it.
+	renew := crt.GetExpirationDuration() <= 0 || p.ID != fingerprint
+	if renew {
+		p.ID = fingerprint
+
+		// the key stays the same, so other peers still recognise us.
+		crt, err = generateCertificate(p, key, time.Now().AddDate(1, 0, 0))
+		if err != nil {
+			return nil, fmt.Errorf("can't renew certificate: %s", err)
+		}
+	}
+
+	if err := setCertificate(p, key, crt); err != nil {
+		return nil, err
+	}
+
+	if renew {
+		if err := p.Save(dataDir); err != nil {
+			return nil, fmt.Errorf("can't store renewed certificate: %s", err)
+		}
+	}
+
+	return p, nil
+}
+
+// Save writes peer identity to the dataDir.
+func (p *Peer) Save(dataDir string) error {
+	if p.key == nil {
+		return fmt.Errorf("private key is unknown")
+	}
+
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return fmt.Errorf("can't create %s: %s", dataDir, err)
+	}
+
+	keyBytes, err := p.key.ExportPrivate()
+	if err != nil {
+		return fmt.Errorf("can't export private key: %s", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dataDir, keyFile), keyBytes, 0600); err != nil {
+		return fmt.Errorf("can't write private key: %s", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dataDir, certificateFile), p.PublicCrt, 0644); err != nil {
+		return fmt.Errorf("can't write certificate: %s", err)
+	}
+
+	identityBytes, err := json.Marshal(&identity{
+		ID:   p.ID,
+		Name: p.Name,
+	})
+	if err != nil {
+		return fmt.Errorf("can't marshal identity: %s", err)
+	}
+
+	// identity is written last, so a half-written data dir is never loaded.
+	if err := ioutil.WriteFile(filepath.Join(dataDir, identityFile), identityBytes, 0600); err != nil {
+		return fmt.Errorf("can't write identity: %s", err)
+	}
+
+	return nil
+}
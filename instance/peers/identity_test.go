@@ -0,0 +1,74 @@
+package peers
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Load__should_create_a_new_identity(t *testing.T) {
+	dataDir := testDataDir(t)
+	defer os.RemoveAll(dataDir)
+
+	p, err := Load(rand.New(rand.NewSource(time.Now().Unix())), dataDir, 1, 2, 3, 512)
+	assert.NoError(t, err)
+
+	assert.NotEmpty(t, p.ID)
+	assert.NotEmpty(t, p.Name)
+	assert.NotEmpty(t, p.PublicCrt)
+	assert.NotNil(t, p.Certificate)
+}
+
+func Test_Load__should_load_the_same_identity(t *testing.T) {
+	dataDir := testDataDir(t)
+	defer os.RemoveAll(dataDir)
+
+	first, err := Load(rand.New(rand.NewSource(1)), dataDir, 1, 2, 3, 512)
+	assert.NoError(t, err)
+
+	second, err := Load(rand.New(rand.NewSource(2)), dataDir, 4, 5, 6, 512)
+	assert.NoError(t, err)
+
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, first.Name, second.Name)
+	assert.Equal(t, first.PublicCrt, second.PublicCrt)
+	assert.Equal(t, 4, second.Port)
+	assert.Equal(t, 5, second.InsecurePort)
+	assert.Equal(t, 6, second.UIPort)
+}
+
+func Test_Load__should_derive_id_from_key(t *testing.T) {
+	dataDir := testDataDir(t)
+	defer os.RemoveAll(dataDir)
+
+	first, err := Load(rand.New(rand.NewSource(1)), dataDir, 1, 2, 3, 512)
+	assert.NoError(t, err)
+
+	// an identity with a random id, as stored by older versions.
+	err = ioutil.WriteFile(filepath.Join(dataDir, identityFile), []byte(`{"id":"0123456789abcdef","name":"test"}`), 0600)
+	assert.NoError(t, err)
+
+	second, err := Load(rand.New(rand.NewSource(2)), dataDir, 1, 2, 3, 512)
+	assert.NoError(t, err)
+
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, "test", second.Name)
+	assert.NoError(t, VerifyPublicCrt(second.ID, second.PublicCrt))
+}
+
+//
+// helpers
+//
+
+func testDataDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "peers")
+	if err != nil {
+		t.Fatalf("can't create data dir: %s", err)
+	}
+	return dir
+}
@@ -1,15 +1,18 @@
 package peers
 
 import (
+	"bytes"
 	"crypto/tls"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math/rand"
 	"time"
+
+	"github.com/square/certstrap/pkix"
 )
 
-const idLen = 8
+// idLen is a length of a key fingerprint used as a peer id.
+const idLen = 16
 
 // Peer is an instance of the same app.
 type Peer struct {
@@ -20,12 +23,16 @@ type Peer struct {
 	InsecurePort int `json:"insecure_port"`
 	UIPort       int `json:"ui_port"`
 
+	Capabilities []string `json:"capabilities,omitempty"`
+
 	KnownPeers *peersList `json:"known_peers"`
 
 	Addrs *addrsList `json:"-"`
 
 	PublicCrt   []byte           `json:"-"`
 	Certificate *tls.Certificate `json:"-"`
+
+	key *pkix.Key
 }
 
 // New is a peer constructor.
@@ -36,27 +43,36 @@ func New(
 	uiPort int,
 	keySize int,
 ) (*Peer, error) {
-	idBytes := make([]byte, idLen)
-	_, err := r.Read(idBytes)
-	if err != nil {
-		return nil, fmt.Errorf("error reading random bytes: %s", err)
-	}
-
 	p := &Peer{
-		ID:           hex.EncodeToString(idBytes),
 		Name:         newName(r),
 		KnownPeers:   newPeersList(),
 		Addrs:        newAddrsList(),
 		Port:         port,
 		UIPort:       uiPort,
 		InsecurePort: insecurePort,
+		Capabilities: capabilities,
+	}
+
+	var err error
+	p.key, err = pkix.CreateRSAKey(keySize)
+	if err != nil {
+		return nil, fmt.Errorf("can't generate private key: %s", err)
+	}
+
+	p.ID, err = Fingerprint(p.key.Public)
+	if err != nil {
+		return nil, err
 	}
 
-	p.PublicCrt, p.Certificate, err = generateCertificate(p, r, keySize, time.Now().AddDate(1, 0, 0))
+	crt, err := generateCertificate(p, p.key, time.Now().AddDate(1, 0, 0))
 	if err != nil {
 		return nil, fmt.Errorf("can't generate CA certificate: %s", err)
 	}
 
+	if err := setCertificate(p, p.key, crt); err != nil {
+		return nil, err
+	}
+
 	return p, nil
 }
 
@@ -79,3 +95,43 @@ func (p *Peer) Unmarshal(data []byte) error {
 	p.KnownPeers = newPeersList()
 	return json.Unmarshal(data, p)
 }
+
+// merge returns a copy of the peer with new addresses, ports and the public
+// certificate of the other one, for example after it restarted. Returns false
+// if nothing is new.
+func (p *Peer) merge(other *Peer) (*Peer, bool) {
+	merged := *p
+	changed := false
+
+	if other.Addrs != nil {
+		if merged.Addrs == nil {
+			merged.Addrs = newAddrsList()
+		}
+		for _, ip := range other.Addrs.Map() {
+			if merged.Addrs.Add(ip) {
+				changed = true
+			}
+		}
+	}
+
+	for _, port := range []struct {
+		known *int
+		other int
+	}{
+		{&merged.Port, other.Port},
+		{&merged.InsecurePort, other.InsecurePort},
+		{&merged.UIPort, other.UIPort},
+	} {
+		if port.other != 0 && port.other != *port.known {
+			*port.known = port.other
+			changed = true
+		}
+	}
+
+	if len(other.PublicCrt) > 0 && !bytes.Equal(other.PublicCrt, merged.PublicCrt) {
+		merged.PublicCrt = other.PublicCrt
+		changed = true
+	}
+
+	return &merged, changed
+}
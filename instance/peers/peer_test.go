@@ -3,7 +3,6 @@ package peers
 import (
 	"encoding/json"
 	"math/rand"
-	"strconv"
 	"testing"
 	"time"
 
@@ -31,7 +30,7 @@ func newTestPeer(t *testing.T) *Peer {
 	defer func() {
 		port += 2
 	}()
-	p, err := New(r, getNextPort(), getNextPort(), 512)
+	p, err := New(r, getNextPort(), getNextPort(), getNextPort(), 512)
 	if err != nil {
 		t.Fatalf("can't create a test peer: %s", err)
 	}
@@ -40,7 +39,7 @@ func newTestPeer(t *testing.T) *Peer {
 
 var port = 1000
 
-func getNextPort() string {
+func getNextPort() int {
 	port++
-	return strconv.Itoa(port)
+	return port
 }
@@ -3,20 +3,76 @@ package peers
 import (
 	"encoding/json"
 	"sync"
+	"time"
+
+	"github.com/ngalayko/p2p/instance/events"
+)
+
+// Status is a liveness status of a peer.
+type Status string
+
+// Known statuses.
+const (
+	StatusOnline  Status = "online"
+	StatusAway    Status = "away"
+	StatusOffline Status = "offline"
 )
 
+// Timeouts are durations since a peer was seen the last time, after which
+// it becomes away, offline, and is removed.
+type Timeouts struct {
+	Away    time.Duration
+	Offline time.Duration
+	Remove  time.Duration
+}
+
+// Event is a change of the list: PeerAdded, PeerChanged or PeerRemoved.
+type Event = events.Event
+
+// PeerAdded is sent when a new peer is added to the list.
+type PeerAdded struct {
+	Peer     *Peer
+	Status   Status
+	LastSeen time.Time
+}
+
+// PeerChanged is sent when a status of a peer changes.
+type PeerChanged struct {
+	Peer     *Peer
+	Status   Status
+	LastSeen time.Time
+}
+
+// PeerRemoved is sent when a peer is removed from the list.
+type PeerRemoved struct {
+	Peer     *Peer
+	LastSeen time.Time
+}
+
+// Subscription receives events of the list in the order they happen. When
+// its channel is closed, the subscriber should subscribe again and take the
+// current peers from Map.
+type Subscription = events.Subscription
+
+type liveness struct {
+	status   Status
+	lastSeen time.Time
+}
+
 // peer is a list of peers.
 type peersList struct {
-	guard   *sync.RWMutex
-	byID    map[string]*Peer
-	updated chan bool
+	guard    *sync.RWMutex
+	byID     map[string]*Peer
+	liveness map[string]*liveness
+	events   *events.Broadcaster
 }
 
 func newPeersList() *peersList {
 	return &peersList{
-		guard:   &sync.RWMutex{},
-		byID:    map[string]*Peer{},
-		updated: make(chan bool),
+		guard:    &sync.RWMutex{},
+		byID:     map[string]*Peer{},
+		liveness: map[string]*liveness{},
+		events:   events.New(),
 	}
 }
 
@@ -27,33 +83,154 @@ func (p peersList) MarshalJSON() ([]byte, error) {
 	return json.Marshal(p.byID)
 }
 
-// Updated returns a chan that closes every time list gets updated.
-func (p *peersList) Updated() <-chan bool {
-	p.guard.RLock()
-	defer p.guard.RUnlock()
-	return p.updated
+// Subscribe returns a subscription to events of the list with a buffer of the size.
+func (p *peersList) Subscribe(buffer int) *Subscription {
+	return p.events.Subscribe(buffer)
 }
 
-// Add adds a new peer to list.
+// Add adds a new peer to list, or marks a known one as seen and merges in its
+// new addresses and ports.
 func (p *peersList) Add(peer *Peer) {
 	p.guard.Lock()
 	defer p.guard.Unlock()
 
-	if _, ok := p.byID[peer.ID]; ok {
+	if known, ok := p.byID[peer.ID]; ok {
+		merged, changed := known.merge(peer)
+		if !changed {
+			p.seen(peer.ID, time.Now())
+			return
+		}
+
+		p.byID[peer.ID] = merged
+		l := p.liveness[peer.ID]
+		l.status = StatusOnline
+		l.lastSeen = time.Now()
+		p.changed(peer.ID)
 		return
 	}
 
 	p.byID[peer.ID] = peer
+	p.liveness[peer.ID] = &liveness{
+		status:   StatusOnline,
+		lastSeen: time.Now(),
+	}
 
-	close(p.updated)
-	p.updated = make(chan bool)
+	p.publish(&PeerAdded{
+		Peer:     peer,
+		Status:   StatusOnline,
+		LastSeen: p.liveness[peer.ID].lastSeen,
+	})
+}
+
+// Seen marks a known peer as seen now.
+func (p *peersList) Seen(id string) {
+	p.guard.Lock()
+	defer p.guard.Unlock()
 
-	return
+	p.seen(id, time.Now())
 }
 
-// Map returns peers map.
+// Remove removes a peer from the list.
+func (p *peersList) Remove(id string) {
+	p.guard.Lock()
+	defer p.guard.Unlock()
+
+	p.remove(id)
+}
+
+// Status returns a status of the peer and when it was seen the last time.
+func (p *peersList) Status(id string) (Status, time.Time, bool) {
+	p.guard.RLock()
+	defer p.guard.RUnlock()
+
+	l, ok := p.liveness[id]
+	if !ok {
+		return "", time.Time{}, false
+	}
+	return l.status, l.lastSeen, true
+}
+
+// Expire updates statuses of peers that were not seen for a while, and
+// removes the ones that were not seen longer than the remove timeout.
+func (p *peersList) Expire(now time.Time, timeouts Timeouts) {
+	p.guard.Lock()
+	defer p.guard.Unlock()
+
+	for id, l := range p.liveness {
+		since := now.Sub(l.lastSeen)
+
+		status := StatusOnline
+		switch {
+		case since > timeouts.Remove:
+			p.remove(id)
+			continue
+		case since > timeouts.Offline:
+			status = StatusOffline
+		case since > timeouts.Away:
+			status = StatusAway
+		}
+
+		if status != l.status {
+			l.status = status
+			p.changed(id)
+		}
+	}
+}
+
+// Map returns a copy of peers map.
 func (p *peersList) Map() map[string]*Peer {
 	p.guard.RLock()
 	defer p.guard.RUnlock()
-	return p.byID
+
+	m := make(map[string]*Peer, len(p.byID))
+	for id, peer := range p.byID {
+		m[id] = peer
+	}
+	return m
+}
+
+// seen must be called under guard.
+func (p *peersList) seen(id string, now time.Time) {
+	l, ok := p.liveness[id]
+	if !ok {
+		return
+	}
+
+	l.lastSeen = now
+	if l.status != StatusOnline {
+		l.status = StatusOnline
+		p.changed(id)
+	}
+}
+
+// remove must be called under guard.
+func (p *peersList) remove(id string) {
+	peer, ok := p.byID[id]
+	if !ok {
+		return
+	}
+
+	l := p.liveness[id]
+	delete(p.byID, id)
+	delete(p.liveness, id)
+
+	p.publish(&PeerRemoved{
+		Peer:     peer,
+		LastSeen: l.lastSeen,
+	})
+}
+
+// changed must be called under guard.
+func (p *peersList) changed(id string) {
+	l := p.liveness[id]
+	p.publish(&PeerChanged{
+		Peer:     p.byID[id],
+		Status:   l.status,
+		LastSeen: l.lastSeen,
+	})
+}
+
+// publish must be called under guard, so events are in the order of changes.
+func (p *peersList) publish(e Event) {
+	p.events.Publish(e)
 }
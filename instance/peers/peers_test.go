@@ -0,0 +1,228 @@
+package peers
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testTimeouts = Timeouts{
+	Away:    time.Minute,
+	Offline: 2 * time.Minute,
+	Remove:  3 * time.Minute,
+}
+
+func Test_peersList__should_notify_about_added_peer(t *testing.T) {
+	l := newPeersList()
+
+	s := l.Subscribe(1)
+	l.Add(&Peer{ID: "peer"})
+
+	e, ok := waitEvent(t, s).(*PeerAdded)
+	if assert.True(t, ok) {
+		assert.Equal(t, "peer", e.Peer.ID)
+		assert.Equal(t, StatusOnline, e.Status)
+	}
+}
+
+func Test_peersList__should_expire_peers(t *testing.T) {
+	l := newPeersList()
+	l.Add(&Peer{ID: "peer"})
+	_, lastSeen, _ := l.Status("peer")
+
+	s := l.Subscribe(3)
+
+	l.Expire(lastSeen.Add(testTimeouts.Away+time.Second), testTimeouts)
+	l.Expire(lastSeen.Add(testTimeouts.Offline+time.Second), testTimeouts)
+	l.Expire(lastSeen.Add(testTimeouts.Remove+time.Second), testTimeouts)
+
+	for _, status := range []Status{StatusAway, StatusOffline} {
+		e, ok := waitEvent(t, s).(*PeerChanged)
+		if assert.True(t, ok) {
+			assert.Equal(t, status, e.Status)
+			assert.Equal(t, lastSeen, e.LastSeen)
+		}
+	}
+
+	e, ok := waitEvent(t, s).(*PeerRemoved)
+	if assert.True(t, ok) {
+		assert.Equal(t, "peer", e.Peer.ID)
+	}
+
+	_, _, found := l.Status("peer")
+	assert.False(t, found)
+	assert.Empty(t, l.Map())
+}
+
+func Test_peersList__should_bring_seen_peer_online(t *testing.T) {
+	l := newPeersList()
+	l.Add(&Peer{ID: "peer"})
+	_, lastSeen, _ := l.Status("peer")
+
+	l.Expire(lastSeen.Add(testTimeouts.Away+time.Second), testTimeouts)
+
+	s := l.Subscribe(1)
+	l.Seen("peer")
+
+	e, ok := waitEvent(t, s).(*PeerChanged)
+	if assert.True(t, ok) {
+		assert.Equal(t, StatusOnline, e.Status)
+	}
+}
+
+func Test_peersList__should_merge_new_addresses_and_ports_of_a_known_peer(t *testing.T) {
+	l := newPeersList()
+
+	first := &Peer{ID: "peer", Port: 1000, UIPort: 1001, Addrs: newAddrsList()}
+	first.Addrs.Add(net.ParseIP("10.0.0.1"))
+	l.Add(first)
+
+	s := l.Subscribe(1)
+
+	restarted := &Peer{ID: "peer", Port: 2000, UIPort: 2001, Addrs: newAddrsList()}
+	restarted.Addrs.Add(net.ParseIP("10.0.0.2"))
+	l.Add(restarted)
+
+	e, ok := waitEvent(t, s).(*PeerChanged)
+	if assert.True(t, ok) {
+		assert.Equal(t, StatusOnline, e.Status)
+		assert.Equal(t, 2000, e.Peer.Port)
+		assert.Equal(t, 2001, e.Peer.UIPort)
+		assert.Len(t, e.Peer.Addrs.Map(), 2)
+	}
+	assert.Equal(t, 2000, l.Map()["peer"].Port)
+	assert.Equal(t, 1000, first.Port)
+}
+
+func Test_peersList__should_not_notify_without_changes(t *testing.T) {
+	l := newPeersList()
+	l.Add(&Peer{ID: "peer"})
+
+	s := l.Subscribe(1)
+	l.Add(&Peer{ID: "peer"})
+	l.Seen("peer")
+	l.Seen("unknown")
+	l.Remove("unknown")
+	l.Expire(time.Now(), testTimeouts)
+
+	select {
+	case e := <-s.Events():
+		t.Fatalf("unexpected event: %+v", e)
+	default:
+	}
+}
+
+func Test_peersList__should_close_a_slow_subscription(t *testing.T) {
+	l := newPeersList()
+
+	slow := l.Subscribe(1)
+	fast := l.Subscribe(2)
+
+	l.Add(&Peer{ID: "first"})
+	l.Add(&Peer{ID: "second"})
+
+	<-slow.Events()
+	_, ok := <-slow.Events()
+	assert.False(t, ok, "subscription must be closed")
+
+	<-fast.Events()
+	<-fast.Events()
+	fast.Close()
+	fast.Close()
+
+	_, ok = <-fast.Events()
+	assert.False(t, ok, "subscription must be closed")
+}
+
+func Test_peersList__should_deliver_ordered_events_to_every_subscriber(t *testing.T) {
+	l := newPeersList()
+
+	const (
+		subscribers = 5
+		writers     = 10
+		perWriter   = 20
+	)
+
+	ss := make([]*Subscription, 0, subscribers)
+	for i := 0; i < subscribers; i++ {
+		ss = append(ss, l.Subscribe(2*writers*perWriter))
+	}
+
+	wg := &sync.WaitGroup{}
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				id := fmt.Sprintf("%d-%d", w, i)
+				l.Add(&Peer{ID: id})
+				for range l.Map() {
+				}
+				l.Remove(id)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for _, s := range ss {
+		s.Close()
+
+		added := map[string]bool{}
+		removed := 0
+		for e := range s.Events() {
+			switch e := e.(type) {
+			case *PeerAdded:
+				added[e.Peer.ID] = true
+			case *PeerRemoved:
+				assert.True(t, added[e.Peer.ID], "removed before added")
+				removed++
+			}
+		}
+		assert.Len(t, added, writers*perWriter)
+		assert.Equal(t, writers*perWriter, removed)
+	}
+}
+
+func Test_peersList__should_be_safe_to_iterate_while_changing(t *testing.T) {
+	l := newPeersList()
+
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			id := fmt.Sprintf("%d", i)
+			l.Add(&Peer{ID: id})
+			l.Expire(time.Now().Add(testTimeouts.Remove+time.Second), testTimeouts)
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		for id, p := range l.Map() {
+			assert.Equal(t, id, p.ID)
+		}
+	}
+}
+
+//
+// helpers
+//
+
+func waitEvent(t *testing.T, s *Subscription) Event {
+	select {
+	case e := <-s.Events():
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event")
+		return nil
+	}
+}
@@ -0,0 +1,223 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: beacon.proto
+
+package beacon
+
+import (
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+// Beacon announces a peer to discoveries. Everything else about the peer is
+// fetched with a greeting.
+type Beacon struct {
+	// Version is a version of the format, receivers ignore unknown fields
+	// of newer versions.
+	Version      uint32 `protobuf:"varint,1,opt,name=Version,proto3" json:"Version,omitempty"`
+	ID           string `protobuf:"bytes,2,opt,name=ID,proto3" json:"ID,omitempty"`
+	Name         string `protobuf:"bytes,3,opt,name=Name,proto3" json:"Name,omitempty"`
+	Port         uint32 `protobuf:"varint,4,opt,name=Port,proto3" json:"Port,omitempty"`
+	InsecurePort uint32 `protobuf:"varint,5,opt,name=InsecurePort,proto3" json:"InsecurePort,omitempty"`
+	UIPort       uint32 `protobuf:"varint,6,opt,name=UIPort,proto3" json:"UIPort,omitempty"`
+	// KeyFingerprint is a SHA-256 of the peer public key.
+	KeyFingerprint []byte   `protobuf:"bytes,7,opt,name=KeyFingerprint,proto3" json:"KeyFingerprint,omitempty"`
+	Capabilities   []string `protobuf:"bytes,8,rep,name=Capabilities,proto3" json:"Capabilities,omitempty"`
+	// Timestamp and Nonce make every beacon unique, so it can't be replayed.
+	Timestamp            int64    `protobuf:"varint,9,opt,name=Timestamp,proto3" json:"Timestamp,omitempty"`
+	Nonce                []byte   `protobuf:"bytes,10,opt,name=Nonce,proto3" json:"Nonce,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Beacon) Reset()         { *m = Beacon{} }
+func (m *Beacon) String() string { return proto.CompactTextString(m) }
+func (*Beacon) ProtoMessage()    {}
+func (*Beacon) Descriptor() ([]byte, []int) {
+	return fileDescriptor_462ed80dff13319c, []int{0}
+}
+
+func (m *Beacon) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Beacon.Unmarshal(m, b)
+}
+func (m *Beacon) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Beacon.Marshal(b, m, deterministic)
+}
+func (m *Beacon) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Beacon.Merge(m, src)
+}
+func (m *Beacon) XXX_Size() int {
+	return xxx_messageInfo_Beacon.Size(m)
+}
+func (m *Beacon) XXX_DiscardUnknown() {
+	xxx_messageInfo_Beacon.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Beacon proto.InternalMessageInfo
+
+func (m *Beacon) GetVersion() uint32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *Beacon) GetID() string {
+	if m != nil {
+		return m.ID
+	}
+	return ""
+}
+
+func (m *Beacon) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Beacon) GetPort() uint32 {
+	if m != nil {
+		return m.Port
+	}
+	return 0
+}
+
+func (m *Beacon) GetInsecurePort() uint32 {
+	if m != nil {
+		return m.InsecurePort
+	}
+	return 0
+}
+
+func (m *Beacon) GetUIPort() uint32 {
+	if m != nil {
+		return m.UIPort
+	}
+	return 0
+}
+
+func (m *Beacon) GetKeyFingerprint() []byte {
+	if m != nil {
+		return m.KeyFingerprint
+	}
+	return nil
+}
+
+func (m *Beacon) GetCapabilities() []string {
+	if m != nil {
+		return m.Capabilities
+	}
+	return nil
+}
+
+func (m *Beacon) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *Beacon) GetNonce() []byte {
+	if m != nil {
+		return m.Nonce
+	}
+	return nil
+}
+
+// Signed is an encoded beacon signed with the peer key.
+type Signed struct {
+	Beacon []byte `protobuf:"bytes,1,opt,name=Beacon,proto3" json:"Beacon,omitempty"`
+	// Certificate is a DER encoded peer certificate.
+	Certificate          []byte   `protobuf:"bytes,2,opt,name=Certificate,proto3" json:"Certificate,omitempty"`
+	Signature            []byte   `protobuf:"bytes,3,opt,name=Signature,proto3" json:"Signature,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Signed) Reset()         { *m = Signed{} }
+func (m *Signed) String() string { return proto.CompactTextString(m) }
+func (*Signed) ProtoMessage()    {}
+func (*Signed) Descriptor() ([]byte, []int) {
+	return fileDescriptor_462ed80dff13319c, []int{1}
+}
+
+func (m *Signed) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Signed.Unmarshal(m, b)
+}
+func (m *Signed) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Signed.Marshal(b, m, deterministic)
+}
+func (m *Signed) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Signed.Merge(m, src)
+}
+func (m *Signed) XXX_Size() int {
+	return xxx_messageInfo_Signed.Size(m)
+}
+func (m *Signed) XXX_DiscardUnknown() {
+	xxx_messageInfo_Signed.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Signed proto.InternalMessageInfo
+
+func (m *Signed) GetBeacon() []byte {
+	if m != nil {
+		return m.Beacon
+	}
+	return nil
+}
+
+func (m *Signed) GetCertificate() []byte {
+	if m != nil {
+		return m.Certificate
+	}
+	return nil
+}
+
+func (m *Signed) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterType((*Beacon)(nil), "beacon.Beacon")
+	proto.RegisterType((*Signed)(nil), "beacon.Signed")
+}
+
+func init() { proto.RegisterFile("beacon.proto", fileDescriptor_462ed80dff13319c) }
+
+var fileDescriptor_462ed80dff13319c = []byte{
+	// 269 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x5c, 0x91, 0xcf, 0x6a, 0xf3, 0x30,
+	0x10, 0xc4, 0xb1, 0x93, 0x28, 0x9f, 0xf7, 0x53, 0x73, 0x58, 0x4a, 0xd0, 0xa1, 0x07, 0x91, 0x43,
+	0xf1, 0xa9, 0x97, 0xbe, 0x41, 0x13, 0x0a, 0xa6, 0x10, 0x8a, 0xfa, 0xe7, 0x5c, 0xd9, 0xdd, 0x06,
+	0x41, 0x2d, 0x19, 0x59, 0x39, 0xf4, 0x45, 0xfa, 0xbc, 0xc5, 0xeb, 0x84, 0x34, 0xbd, 0xcd, 0xfc,
+	0x06, 0x69, 0x60, 0x16, 0x64, 0x4d, 0xb6, 0x09, 0xfe, 0xa6, 0x8b, 0x21, 0x05, 0x14, 0xa3, 0x5b,
+	0x7d, 0xe7, 0x20, 0xee, 0x58, 0xa2, 0x82, 0xf9, 0x2b, 0xc5, 0xde, 0x05, 0xaf, 0x32, 0x9d, 0x95,
+	0x17, 0xe6, 0x68, 0x71, 0x01, 0x79, 0xb5, 0x51, 0xb9, 0xce, 0xca, 0xc2, 0xe4, 0xd5, 0x06, 0x11,
+	0xa6, 0x5b, 0xdb, 0x92, 0x9a, 0x30, 0x61, 0x3d, 0xb0, 0xc7, 0x10, 0x93, 0x9a, 0xf2, 0x53, 0xd6,
+	0xb8, 0x02, 0x59, 0xf9, 0x9e, 0x9a, 0x7d, 0x24, 0xce, 0x66, 0x9c, 0x9d, 0x31, 0x5c, 0x82, 0x78,
+	0xa9, 0x38, 0x15, 0x9c, 0x1e, 0x1c, 0x5e, 0xc3, 0xe2, 0x81, 0xbe, 0xee, 0x9d, 0xdf, 0x51, 0xec,
+	0xa2, 0xf3, 0x49, 0xcd, 0x75, 0x56, 0x4a, 0xf3, 0x87, 0x0e, 0x1d, 0x6b, 0xdb, 0xd9, 0xda, 0x7d,
+	0xba, 0xe4, 0xa8, 0x57, 0xff, 0xf4, 0xa4, 0x2c, 0xcc, 0x19, 0xc3, 0x2b, 0x28, 0x9e, 0x5d, 0x4b,
+	0x7d, 0xb2, 0x6d, 0xa7, 0x0a, 0x9d, 0x95, 0x13, 0x73, 0x02, 0x78, 0x09, 0xb3, 0x6d, 0xf0, 0x0d,
+	0x29, 0xe0, 0x82, 0xd1, 0xac, 0xde, 0x40, 0x3c, 0xb9, 0x9d, 0xa7, 0x77, 0x5c, 0x1e, 0x17, 0xe2,
+	0x59, 0xa4, 0x39, 0x38, 0xd4, 0xf0, 0x7f, 0x4d, 0x31, 0xb9, 0x0f, 0xd7, 0xd8, 0x44, 0x3c, 0x8f,
+	0x34, 0xbf, 0xd1, 0xd0, 0x3b, 0xfc, 0x61, 0xd3, 0x3e, 0x8e, 0x63, 0x49, 0x73, 0x02, 0xb5, 0xe0,
+	0x4b, 0xdc, 0xfe, 0x0c, 0x00, 0x1e, 0x87, 0xf5, 0x0f, 0x99, 0x01, 0x00, 0x00,
+}
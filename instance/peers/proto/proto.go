@@ -0,0 +1,3 @@
+package proto
+
+//go:generate protoc -I ./ --go_out=./beacon ./beacon.proto
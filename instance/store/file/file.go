@@ -0,0 +1,165 @@
+package file
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ngalayko/p2p/instance/store"
+	"github.com/ngalayko/p2p/instance/store/memory"
+)
+
+const (
+	messagesFile = "messages.log"
+	groupsFile   = "groups.log"
+	keysFile     = "keys.log"
+)
+
+// Store keeps messages, groups and keys in append only files in a directory.
+// Every saved item is written as a json line, the latest line with the same id wins.
+type Store struct {
+	*memory.Store
+
+	messages *appendLog
+	groups   *appendLog
+	keys     *appendLog
+}
+
+// New opens a file store in the dir and loads everything from it.
+func New(dir string) (*Store, error) {
+	s := &Store{
+		Store: memory.New(),
+	}
+
+	var err error
+	s.messages, err = openLog(filepath.Join(dir, messagesFile), func(line []byte) error {
+		m := &store.Message{}
+		if err := json.Unmarshal(line, m); err != nil {
+			return err
+		}
+		return s.Store.Save(m)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.groups, err = openLog(filepath.Join(dir, groupsFile), func(line []byte) error {
+		g := &store.Group{}
+		if err := json.Unmarshal(line, g); err != nil {
+			return err
+		}
+		return s.Store.SaveGroup(g)
+	})
+	if err != nil {
+		s.messages.Close()
+		return nil, err
+	}
+
+	s.keys, err = openLog(filepath.Join(dir, keysFile), func(line []byte) error {
+		k := &store.Key{}
+		if err := json.Unmarshal(line, k); err != nil {
+			return err
+		}
+		return s.Store.SaveKey(k)
+	})
+	if err != nil {
+		s.messages.Close()
+		s.groups.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Save implements store.Store.
+func (s *Store) Save(m *store.Message) error {
+	// saved in memory first, so rejected messages are not written.
+	if err := s.Store.Save(m); err != nil {
+		return err
+	}
+	if err := s.messages.Append(m); err != nil {
+		return fmt.Errorf("can't write message: %s", err)
+	}
+	return nil
+}
+
+// SaveGroup implements store.Store.
+func (s *Store) SaveGroup(g *store.Group) error {
+	if err := s.groups.Append(g); err != nil {
+		return fmt.Errorf("can't write group: %s", err)
+	}
+	return s.Store.SaveGroup(g)
+}
+
+// SaveKey implements store.Store.
+func (s *Store) SaveKey(k *store.Key) error {
+	if err := s.keys.Append(k); err != nil {
+		return fmt.Errorf("can't write key: %s", err)
+	}
+	return s.Store.SaveKey(k)
+}
+
+// Close closes the underlying files.
+func (s *Store) Close() error {
+	var err error
+	for _, l := range []*appendLog{s.messages, s.groups, s.keys} {
+		if closeErr := l.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// appendLog is a file of json lines.
+type appendLog struct {
+	guard *sync.Mutex
+	file  *os.File
+}
+
+// openLog opens a log and calls load for every line in it.
+func openLog(path string, load func([]byte) error) (*appendLog, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("can't open %s: %s", path, err)
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		// the last line could be partially written on a crash.
+		_ = load(scanner.Bytes())
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("can't read %s: %s", path, err)
+	}
+
+	return &appendLog{
+		guard: &sync.Mutex{},
+		file:  f,
+	}, nil
+}
+
+// Append writes v as a json line.
+func (l *appendLog) Append(v interface{}) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("can't marshal: %s", err)
+	}
+
+	l.guard.Lock()
+	defer l.guard.Unlock()
+
+	_, err = l.file.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file.
+func (l *appendLog) Close() error {
+	l.guard.Lock()
+	defer l.guard.Unlock()
+	return l.file.Close()
+}
@@ -0,0 +1,94 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ngalayko/p2p/instance/store"
+)
+
+func Test_New__should_load_saved_messages(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := New(dir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.Save(&store.Message{ID: "1", ChatID: "chat", Text: "first"}))
+	assert.NoError(t, s.Save(&store.Message{ID: "2", ChatID: "chat", Text: "second"}))
+	assert.NoError(t, s.Save(&store.Message{ID: "1", ChatID: "chat", Text: "edited"}))
+	assert.NoError(t, s.Close())
+
+	s, err = New(dir)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	mm, err := s.History("chat", "", 10)
+	assert.NoError(t, err)
+
+	if assert.Len(t, mm, 2) {
+		assert.Equal(t, "edited", mm[0].Text)
+		assert.Equal(t, "second", mm[1].Text)
+	}
+}
+
+func Test_New__should_load_saved_groups(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := New(dir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.SaveGroup(&store.Group{ID: "1", Name: "first", Members: []*store.Member{{ID: "a"}}}))
+	assert.NoError(t, s.SaveGroup(&store.Group{ID: "1", Name: "first", Members: []*store.Member{{ID: "a"}, {ID: "b"}}}))
+	assert.NoError(t, s.Close())
+
+	s, err = New(dir)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	gg, err := s.Groups()
+	assert.NoError(t, err)
+
+	if assert.Len(t, gg, 1) {
+		assert.Len(t, gg[0].Members, 2)
+	}
+}
+
+func Test_New__should_load_saved_keys(t *testing.T) {
+	dir := testDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := New(dir)
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.SaveKey(&store.Key{PeerID: "a", Fingerprint: "first"}))
+	assert.NoError(t, s.SaveKey(&store.Key{PeerID: "a", Fingerprint: "first", Verified: true}))
+	assert.NoError(t, s.Close())
+
+	s, err = New(dir)
+	assert.NoError(t, err)
+	defer s.Close()
+
+	kk, err := s.Keys()
+	assert.NoError(t, err)
+
+	if assert.Len(t, kk, 1) {
+		assert.True(t, kk[0].Verified)
+	}
+}
+
+//
+// helpers
+//
+
+func testDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatalf("can't create a temp dir: %s", err)
+	}
+	return dir
+}
@@ -0,0 +1,190 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/ngalayko/p2p/instance/store"
+)
+
+// Store keeps messages in memory.
+type Store struct {
+	guard  *sync.RWMutex
+	byID   map[string]*store.Message
+	byChat map[string][]*store.Message
+
+	groupsGuard *sync.RWMutex
+	groups      map[string]*store.Group
+
+	keysGuard *sync.RWMutex
+	keys      map[string]*store.Key
+}
+
+// New is an in memory store constructor.
+func New() *Store {
+	return &Store{
+		guard:  &sync.RWMutex{},
+		byID:   map[string]*store.Message{},
+		byChat: map[string][]*store.Message{},
+
+		groupsGuard: &sync.RWMutex{},
+		groups:      map[string]*store.Group{},
+
+		keysGuard: &sync.RWMutex{},
+		keys:      map[string]*store.Key{},
+	}
+}
+
+// Save implements store.Store.
+func (s *Store) Save(m *store.Message) error {
+	s.guard.Lock()
+	defer s.guard.Unlock()
+
+	copied := *m
+
+	known, ok := s.byID[m.ID]
+	if !ok {
+		s.byID[m.ID] = &copied
+		s.byChat[m.ChatID] = append(s.byChat[m.ChatID], &copied)
+		return nil
+	}
+
+	if known.ChatID != m.ChatID {
+		return fmt.Errorf("message %s is in %s, not in %s", m.ID, known.ChatID, m.ChatID)
+	}
+
+	chat := s.byChat[m.ChatID]
+	for i := range chat {
+		if chat[i].ID == m.ID {
+			chat[i] = &copied
+		}
+	}
+	s.byID[m.ID] = &copied
+	return nil
+}
+
+// Get implements store.Store.
+func (s *Store) Get(id string) (*store.Message, error) {
+	s.guard.RLock()
+	defer s.guard.RUnlock()
+
+	m, ok := s.byID[id]
+	if !ok {
+		return nil, fmt.Errorf("message %s not found", id)
+	}
+
+	copied := *m
+	return &copied, nil
+}
+
+// History implements store.Store.
+func (s *Store) History(chatID string, beforeID string, limit int) ([]*store.Message, error) {
+	s.guard.RLock()
+	defer s.guard.RUnlock()
+
+	chat := s.byChat[chatID]
+
+	end := len(chat)
+	if beforeID != "" {
+		end = -1
+		for i := range chat {
+			if chat[i].ID == beforeID {
+				end = i
+				break
+			}
+		}
+		if end == -1 {
+			return nil, fmt.Errorf("message %s not found in %s", beforeID, chatID)
+		}
+	}
+
+	start := end - limit
+	if start < 0 {
+		start = 0
+	}
+
+	result := make([]*store.Message, 0, end-start)
+	for _, m := range chat[start:end] {
+		copied := *m
+		result = append(result, &copied)
+	}
+	return result, nil
+}
+
+// WithStatus implements store.Store.
+func (s *Store) WithStatus(status string) ([]*store.Message, error) {
+	s.guard.RLock()
+	defer s.guard.RUnlock()
+
+	result := []*store.Message{}
+	for _, chat := range s.byChat {
+		for _, m := range chat {
+			if m.Status != status {
+				continue
+			}
+			copied := *m
+			result = append(result, &copied)
+		}
+	}
+
+	// messages of a chat are in order already, so it is kept for equal times.
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Timestamp.Before(result[j].Timestamp)
+	})
+	return result, nil
+}
+
+// SaveGroup implements store.Store.
+func (s *Store) SaveGroup(g *store.Group) error {
+	s.groupsGuard.Lock()
+	defer s.groupsGuard.Unlock()
+
+	s.groups[g.ID] = copyGroup(g)
+	return nil
+}
+
+// Groups implements store.Store.
+func (s *Store) Groups() ([]*store.Group, error) {
+	s.groupsGuard.RLock()
+	defer s.groupsGuard.RUnlock()
+
+	result := make([]*store.Group, 0, len(s.groups))
+	for _, g := range s.groups {
+		result = append(result, copyGroup(g))
+	}
+	return result, nil
+}
+
+// SaveKey implements store.Store.
+func (s *Store) SaveKey(k *store.Key) error {
+	s.keysGuard.Lock()
+	defer s.keysGuard.Unlock()
+
+	copied := *k
+	s.keys[k.PeerID] = &copied
+	return nil
+}
+
+// Keys implements store.Store.
+func (s *Store) Keys() ([]*store.Key, error) {
+	s.keysGuard.RLock()
+	defer s.keysGuard.RUnlock()
+
+	result := make([]*store.Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		copied := *k
+		result = append(result, &copied)
+	}
+	return result, nil
+}
+
+func copyGroup(g *store.Group) *store.Group {
+	copied := *g
+	copied.Members = make([]*store.Member, 0, len(g.Members))
+	for _, m := range g.Members {
+		member := *m
+		copied.Members = append(copied.Members, &member)
+	}
+	return &copied
+}
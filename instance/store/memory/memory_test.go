@@ -0,0 +1,131 @@
+package memory
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ngalayko/p2p/instance/store"
+)
+
+func Test_History__should_return_latest_messages(t *testing.T) {
+	s := testStore(t, 10)
+
+	mm, err := s.History("chat", "", 3)
+	assert.NoError(t, err)
+
+	if assert.Len(t, mm, 3) {
+		assert.Equal(t, "7", mm[0].ID)
+		assert.Equal(t, "8", mm[1].ID)
+		assert.Equal(t, "9", mm[2].ID)
+	}
+}
+
+func Test_History__should_return_messages_before(t *testing.T) {
+	s := testStore(t, 10)
+
+	mm, err := s.History("chat", "2", 5)
+	assert.NoError(t, err)
+
+	if assert.Len(t, mm, 2) {
+		assert.Equal(t, "0", mm[0].ID)
+		assert.Equal(t, "1", mm[1].ID)
+	}
+}
+
+func Test_History__should_return_empty_unknown_chat(t *testing.T) {
+	s := testStore(t, 10)
+
+	mm, err := s.History("unknown", "", 5)
+	assert.NoError(t, err)
+	assert.Empty(t, mm)
+}
+
+func Test_Save__should_replace_message(t *testing.T) {
+	s := testStore(t, 1)
+
+	assert.NoError(t, s.Save(&store.Message{
+		ID:     "0",
+		ChatID: "chat",
+		Text:   "updated",
+	}))
+
+	mm, err := s.History("chat", "", 5)
+	assert.NoError(t, err)
+
+	if assert.Len(t, mm, 1) {
+		assert.Equal(t, "updated", mm[0].Text)
+	}
+}
+
+func Test_Save__should_not_move_message_to_another_chat(t *testing.T) {
+	s := testStore(t, 1)
+
+	assert.Error(t, s.Save(&store.Message{
+		ID:     "0",
+		ChatID: "other",
+		Text:   "moved",
+	}))
+
+	mm, err := s.History("other", "", 5)
+	assert.NoError(t, err)
+	assert.Empty(t, mm)
+
+	m, err := s.Get("0")
+	assert.NoError(t, err)
+	assert.Equal(t, "chat", m.ChatID)
+}
+
+func Test_Get__should_return_message(t *testing.T) {
+	s := testStore(t, 3)
+
+	m, err := s.Get("1")
+	assert.NoError(t, err)
+	assert.Equal(t, "text 1", m.Text)
+
+	_, err = s.Get("unknown")
+	assert.Error(t, err)
+}
+
+func Test_WithStatus__should_return_messages_with_the_status_in_order(t *testing.T) {
+	s := testStore(t, 5)
+
+	now := time.Now()
+	for _, m := range []*store.Message{
+		{ID: "1", ChatID: "chat", Timestamp: now, Status: "queued"},
+		{ID: "3", ChatID: "chat", Timestamp: now, Status: "queued"},
+		{ID: "other", ChatID: "other", Timestamp: now.Add(-time.Minute), Status: "queued"},
+	} {
+		assert.NoError(t, s.Save(m))
+	}
+
+	mm, err := s.WithStatus("queued")
+	assert.NoError(t, err)
+	if assert.Len(t, mm, 3) {
+		assert.Equal(t, "other", mm[0].ID)
+		assert.Equal(t, "1", mm[1].ID)
+		assert.Equal(t, "3", mm[2].ID)
+	}
+}
+
+//
+// helpers
+//
+
+func testStore(t *testing.T, n int) *Store {
+	s := New()
+	for i := 0; i < n; i++ {
+		err := s.Save(&store.Message{
+			ID:        fmt.Sprint(i),
+			ChatID:    "chat",
+			Timestamp: time.Now(),
+			Text:      fmt.Sprintf("text %d", i),
+		})
+		if err != nil {
+			t.Fatalf("can't save a message: %s", err)
+		}
+	}
+	return s
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"time"
+)
+
+// Store persists messages, groups and known keys of peers.
+type Store interface {
+	// Save stores a message, or replaces a stored one with the same id. A
+	// stored message can't be moved to another chat.
+	Save(*Message) error
+	// Get returns a message by id.
+	Get(id string) (*Message, error)
+	// History returns up to limit messages of the chat sent before the message with beforeID,
+	// from the oldest to the newest. If beforeID is empty, the latest messages are returned.
+	History(chatID string, beforeID string, limit int) ([]*Message, error)
+	// WithStatus returns messages with the status, from the oldest to the newest.
+	WithStatus(status string) ([]*Message, error)
+
+	// SaveGroup stores a group, or replaces a stored one with the same id.
+	SaveGroup(*Group) error
+	// Groups returns all stored groups.
+	Groups() ([]*Group, error)
+
+	// SaveKey stores a key, or replaces a stored one of the same peer.
+	SaveKey(*Key) error
+	// Keys returns all stored keys.
+	Keys() ([]*Key, error)
+}
+
+// Message is a stored message.
+type Message struct {
+	ID        string    `json:"id"`
+	ChatID    string    `json:"chat_id"`
+	FromID    string    `json:"from_id"`
+	ToID      string    `json:"to_id"`
+	GroupID   string    `json:"group_id,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Type      string    `json:"type"`
+	Text      string    `json:"text"`
+	Status    string    `json:"status,omitempty"`
+	File      *File     `json:"file,omitempty"`
+
+	ReplyTo   *ReplyTo    `json:"reply_to,omitempty"`
+	EditedAt  *time.Time  `json:"edited_at,omitempty"`
+	Deleted   bool        `json:"deleted,omitempty"`
+	Reactions []*Reaction `json:"reactions,omitempty"`
+}
+
+// ReplyTo references a message the stored one replies to.
+type ReplyTo struct {
+	MessageID string `json:"message_id"`
+	FromID    string `json:"from_id"`
+	Quote     string `json:"quote"`
+}
+
+// Reaction is an emoji reaction of a peer to a message.
+type Reaction struct {
+	PeerID string `json:"peer_id"`
+	Emoji  string `json:"emoji"`
+}
+
+// File is metadata of a file message. Contents are stored separately.
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	MIME   string `json:"mime"`
+	SHA256 string `json:"sha256"`
+}
+
+// Group is a stored group.
+type Group struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Members []*Member `json:"members"`
+	Left    bool      `json:"left,omitempty"`
+}
+
+// Key is a pinned key fingerprint of a peer.
+type Key struct {
+	PeerID      string    `json:"peer_id"`
+	Fingerprint string    `json:"fingerprint"`
+	Verified    bool      `json:"verified,omitempty"`
+	PinnedAt    time.Time `json:"pinned_at"`
+}
+
+// Member is a member of a group.
+type Member struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}